@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	accessLogPath   = flag.String("access-log", "", "write an access log to this file (\"-\" for stdout)")
+	accessLogFormat = flag.String("access-log-format", "combined", "access log format: common or combined")
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLog writes one Common or Combined Log Format line per request.
+type accessLog struct {
+	mu       sync.Mutex
+	out      io.Writer
+	combined bool
+}
+
+// openAccessLog returns the access log middleware configured by the flags,
+// or nil when access logging is disabled.
+func openAccessLog() (Middleware, error) {
+	if *accessLogPath == "" {
+		return nil, nil
+	}
+
+	var combined bool
+	switch *accessLogFormat {
+	case "common":
+	case "combined":
+		combined = true
+	default:
+		return nil, fmt.Errorf("invalid access log format %q", *accessLogFormat)
+	}
+
+	var out io.Writer = os.Stdout
+	if *accessLogPath != "-" {
+		f, err := os.OpenFile(*accessLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	l := &accessLog{out: out, combined: combined}
+	return l.middleware, nil
+}
+
+func (l *accessLog) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		l.write(r, rec, start)
+	})
+}
+
+func (l *accessLog) write(r *http.Request, rec *responseRecorder, start time.Time) {
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+
+	size := "-"
+	if rec.bytes > 0 {
+		size = strconv.Itoa(rec.bytes)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		clientIP(r),
+		user,
+		start.Format(clfTimeFormat),
+		r.Method+" "+r.RequestURI+" "+r.Proto,
+		rec.status,
+		size,
+	)
+	if l.combined {
+		line += fmt.Sprintf(" %q %q", dashIfEmpty(r.Referer()), dashIfEmpty(r.UserAgent()))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.out, line)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	acmeCacheDir  = flag.String("acme-cache", "acme-cache", "directory ACME account keys and certificates are cached in")
+	acmeDirectory = flag.String("acme-directory", acme.LetsEncryptURL, "directory URL of the ACME CA, such as an internal step-ca")
+	acmeEmail     = flag.String("acme-email", "", "contact email registered with the ACME CA")
+	acmeHTTPAddr  = flag.String("acme-http-addr", "", "address to answer ACME HTTP-01 challenges on, such as :80; TLS-ALPN-01 challenges are answered by the server itself")
+)
+
+var acmeHosts stringList
+
+func init() {
+	flag.Var(&acmeHosts, "acme-host", "host name to obtain a certificate for from the ACME CA; enables HTTPS with automatic certificates instead of -tls-cert (repeatable)")
+}
+
+// acmeManager obtains and renews the server certificates; it is nil when
+// ACME is disabled.
+var acmeManager *autocert.Manager
+
+// initACME returns the TLS configuration serving certificates obtained for
+// the -acme-host names.
+func initACME() (*tls.Config, error) {
+	if *tlsCertFile != "" {
+		return nil, errors.New("-acme-host and -tls-cert are mutually exclusive")
+	}
+
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(*acmeCacheDir),
+		HostPolicy: autocert.HostWhitelist(acmeHosts...),
+		Email:      *acmeEmail,
+		Client:     &acme.Client{DirectoryURL: *acmeDirectory},
+	}
+
+	config := acmeManager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, nil
+}
+
+// startACMEChallengeServer answers HTTP-01 challenges on -acme-http-addr,
+// redirecting other requests to HTTPS.
+func startACMEChallengeServer() {
+	if acmeManager == nil || *acmeHTTPAddr == "" {
+		return
+	}
+
+	go func() {
+		slog.Info("starting ACME challenge server", "addr", *acmeHTTPAddr)
+		if err := http.ListenAndServe(*acmeHTTPAddr, acmeManager.HTTPHandler(nil)); err != nil {
+			slog.Error("ACME challenge server stopped", "error", err)
+		}
+	}()
+}
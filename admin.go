@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var adminTokenFile = flag.String("admin-token-file", "", "file holding the bearer token of the admin API served under /admin/ (disabled when empty)")
+
+var adminToken []byte
+
+// initAdmin loads the admin API token.
+func initAdmin() error {
+	if *adminTokenFile == "" {
+		return nil
+	}
+
+	token, err := os.ReadFile(*adminTokenFile)
+	if err != nil {
+		return err
+	}
+	adminToken = bytes.TrimSpace(token)
+	if len(adminToken) == 0 {
+		return fmt.Errorf("admin token file %s is empty", *adminTokenFile)
+	}
+
+	return nil
+}
+
+// registerAdminRoutes adds the admin API to r when an admin token is
+// configured.
+func registerAdminRoutes(r *mux.Router) {
+	if adminToken == nil {
+		return
+	}
+
+	admin := r.PathPrefix("/admin/").Subrouter()
+	admin.Use(adminAuthMiddleware)
+	admin.HandleFunc("/repositories", handleAdminRepositories).Methods("GET")
+	admin.HandleFunc("/repositories/{name:.+}/tags", handleAdminTags).Methods("GET")
+	admin.HandleFunc("/blobs", handleAdminBlobs).Methods("GET")
+	admin.HandleFunc("/cache", handleAdminCache).Methods("GET")
+	admin.HandleFunc("/cache", handleAdminPurgeCache).Methods("DELETE")
+	admin.HandleFunc("/repositories/{name:.+}", handleAdminDeleteRepository).Methods("DELETE")
+	admin.HandleFunc("/export", handleAdminExport).Methods("GET")
+	admin.HandleFunc("/import", handleAdminImport).Methods("POST")
+	admin.HandleFunc("/snapshot", handleAdminSnapshot).Methods("GET")
+	admin.HandleFunc("/snapshot", handleAdminRestore).Methods("POST")
+	registerFaultRoutes(admin)
+	registerCounterRoutes(admin)
+	registerScenarioRoutes(admin)
+	registerTagHistoryRoutes(admin)
+	registerSoftDeleteRoutes(admin)
+	registerQuotaRoutes(admin)
+	registerIdentityUsageRoutes(admin)
+	registerDedupRoutes(admin)
+	registerArtifactHubRoutes(admin)
+}
+
+// adminAuthMiddleware requires the admin token as a bearer token.
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), adminToken) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="virtual-helm admin"`)
+			writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "authentication required", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// AdminTag describes a generated, pushed or imported tag of a repository.
+type AdminTag struct {
+	Tag    string   `json:"tag"`
+	Digest string   `json:"digest"`
+	Size   int      `json:"size"`
+	Layers []string `json:"layers"`
+	// Source is generated, pushed or imported.
+	Source string `json:"source"`
+}
+
+// AdminRepository describes a repository with generated, pushed or imported
+// tags.
+type AdminRepository struct {
+	Name string     `json:"name"`
+	Tags []AdminTag `json:"tags"`
+}
+
+// adminRepositories groups the cached manifests and the pushed and imported
+// tags by repository.
+func adminRepositories() map[string]*AdminRepository {
+	repos := make(map[string]*AdminRepository)
+	add := func(key string, manifest *Manifest, source string) {
+		name, tag, _ := strings.Cut(key, ":")
+		repo := repos[name]
+		if repo == nil {
+			repo = &AdminRepository{Name: name}
+			repos[name] = repo
+		}
+
+		size := manifest.Config.Size
+		layers := make([]string, 0, len(manifest.Layers))
+		for _, l := range manifest.Layers {
+			size += l.Size
+			layers = append(layers, l.Digest)
+		}
+		repo.Tags = append(repo.Tags, AdminTag{Tag: tag, Digest: manifest.digest, Size: size, Layers: layers, Source: source})
+	}
+
+	for key, manifest := range generated.Entries() {
+		add(key, manifest, "generated")
+	}
+	pushed.RLock()
+	for key, manifest := range pushed.byRef {
+		if !strings.HasSuffix(key, ":"+manifest.digest) {
+			add(key, manifest, "pushed")
+		}
+	}
+	pushed.RUnlock()
+	imported.RLock()
+	for key, manifest := range imported.byRef {
+		if !strings.HasSuffix(key, ":"+manifest.digest) {
+			add(key, manifest, "imported")
+		}
+	}
+	imported.RUnlock()
+
+	for _, repo := range repos {
+		sort.Slice(repo.Tags, func(i, j int) bool { return repo.Tags[i].Tag < repo.Tags[j].Tag })
+	}
+
+	return repos
+}
+
+func handleAdminRepositories(w http.ResponseWriter, r *http.Request) {
+	repos := adminRepositories()
+	list := make([]*AdminRepository, 0, len(repos))
+	for _, repo := range repos {
+		list = append(list, repo)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	writeAdminJSON(w, list)
+}
+
+func handleAdminTags(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	repo, ok := adminRepositories()[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, "repository name not known to registry", name)
+		return
+	}
+
+	writeAdminJSON(w, repo.Tags)
+}
+
+func handleAdminBlobs(w http.ResponseWriter, r *http.Request) {
+	count, size := store.Stats()
+	writeAdminJSON(w, map[string]int{"count": count, "size": size})
+}
+
+func handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, generated.Stats())
+}
+
+// PurgeResult reports what a purge removed.
+type PurgeResult struct {
+	Evicted      int `json:"evicted"`
+	BlobsDeleted int `json:"blobsDeleted"`
+}
+
+func handleAdminPurgeCache(w http.ResponseWriter, r *http.Request) {
+	n := generated.Purge()
+	logger(r.Context()).Info("purged generation cache", "evicted", n)
+	writeAdminJSON(w, PurgeResult{Evicted: n})
+}
+
+// handleAdminDeleteRepository evicts the cached charts of a repository and,
+// with ?blobs=true, deletes their blobs and attached artifacts.
+func handleAdminDeleteRepository(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	result, err := purgeRepository(name, r.URL.Query().Get("blobs") == "true")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+
+	logger(r.Context()).Info("purged repository", "name", name, "evicted", result.Evicted, "blobs_deleted", result.BlobsDeleted)
+	audit(r, AuditEvent{Action: "delete", User: "admin", Repository: name, Status: http.StatusOK})
+	writeAdminJSON(w, result)
+}
+
+// purgeRepository evicts the cached charts of a repository and, with
+// deleteBlobs, deletes their blobs and attached artifacts. Blobs still
+// referenced by any other manifest, including restorable deleted ones, are
+// kept.
+func purgeRepository(name string, deleteBlobs bool) (PurgeResult, error) {
+	var manifests []*Manifest
+	for key, manifest := range generated.Entries() {
+		if strings.HasPrefix(key, name+":") {
+			manifests = append(manifests, manifest)
+		}
+	}
+
+	result := PurgeResult{Evicted: generated.RemoveRepository(name)}
+	if !deleteBlobs {
+		return result, nil
+	}
+
+	manifests = append(manifests, removeReferrers(name)...)
+
+	inUse := blobsInUse()
+	trash.Lock()
+	for _, entry := range trash.byKey {
+		useBlobs(inUse, entry.manifest)
+	}
+	trash.Unlock()
+
+	deleted := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, digest := range manifestBlobs(manifest) {
+			if inUse[digest] || deleted[digest] {
+				continue
+			}
+			if err := store.Delete(digest); err != nil {
+				return result, err
+			}
+			deleted[digest] = true
+		}
+	}
+	result.BlobsDeleted = len(deleted)
+
+	return result, nil
+}
+
+// blobsInUse returns the digests of the blobs referenced by the generated,
+// pushed, imported and proxied manifests and by every referrer. Deleted
+// manifests that can still be restored are left to callers, which may hold
+// the trash lock.
+func blobsInUse() map[string]bool {
+	inUse := make(map[string]bool)
+	for _, manifest := range generated.Entries() {
+		useBlobs(inUse, manifest)
+	}
+	pushed.RLock()
+	for _, manifest := range pushed.byRef {
+		useBlobs(inUse, manifest)
+	}
+	pushed.RUnlock()
+	imported.RLock()
+	for _, manifest := range imported.byRef {
+		useBlobs(inUse, manifest)
+	}
+	imported.RUnlock()
+	proxied.RLock()
+	for _, p := range proxied.byRef {
+		useBlobs(inUse, p.manifest)
+	}
+	proxied.RUnlock()
+	referrers.RLock()
+	for _, list := range referrers.bySubject {
+		for _, manifest := range list {
+			useBlobs(inUse, manifest)
+		}
+	}
+	referrers.RUnlock()
+
+	return inUse
+}
+
+// useBlobs marks the blobs of a manifest as in use.
+func useBlobs(inUse map[string]bool, manifest *Manifest) {
+	for _, digest := range manifestBlobs(manifest) {
+		inUse[digest] = true
+	}
+}
+
+// manifestBlobs returns the digests of the blobs a manifest references.
+func manifestBlobs(manifest *Manifest) []string {
+	digests := []string{manifest.Config.Digest}
+	for _, l := range manifest.Layers {
+		digests = append(digests, l.Digest)
+	}
+
+	return digests
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var manifestAnnotationFlags stringList
+
+func init() {
+	flag.Var(&manifestAnnotationFlags, "manifest-annotation", "annotation added to every generated manifest, as key=value (repeatable)")
+}
+
+var manifestAnnotations map[string]string
+
+// initManifestAnnotations parses the -manifest-annotation flags.
+func initManifestAnnotations() error {
+	manifestAnnotations = make(map[string]string)
+	for _, f := range manifestAnnotationFlags {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid manifest annotation %q: expected key=value", f)
+		}
+		manifestAnnotations[key] = value
+	}
+
+	return nil
+}
+
+// annotationsFor returns the annotations of the manifest for chart. Like helm
+// push, the chart's own annotations are copied and the standard OCI
+// annotations derived from Chart.yaml are set on top; -manifest-annotation
+// values take precedence over both.
+func annotationsFor(chart Chart, created time.Time) map[string]string {
+	annotations := make(map[string]string, len(chart.Annotations)+len(manifestAnnotations)+7)
+	for k, v := range chart.Annotations {
+		annotations[k] = v
+	}
+
+	annotations["org.opencontainers.image.title"] = chart.Name
+	annotations["org.opencontainers.image.version"] = chart.Version
+	annotations["org.opencontainers.image.created"] = created.UTC().Format(time.RFC3339)
+	if chart.Description != "" {
+		annotations["org.opencontainers.image.description"] = chart.Description
+	}
+	if chart.Home != "" {
+		annotations["org.opencontainers.image.url"] = chart.Home
+	}
+	if len(chart.Sources) > 0 {
+		annotations["org.opencontainers.image.source"] = chart.Sources[0]
+	}
+	if len(chart.Maintainers) > 0 {
+		authors := make([]string, 0, len(chart.Maintainers))
+		for _, m := range chart.Maintainers {
+			if m.Email != "" {
+				authors = append(authors, fmt.Sprintf("%s (%s)", m.Name, m.Email))
+			} else {
+				authors = append(authors, m.Name)
+			}
+		}
+		annotations["org.opencontainers.image.authors"] = strings.Join(authors, ", ")
+	}
+
+	for k, v := range manifestAnnotations {
+		annotations[k] = v
+	}
+
+	return annotations
+}
+
+// createdTime is the creation time recorded for name:reference generated at
+// now. Whenever the chart does not depend on the generation time, as with
+// -stable-digests or a pinned -app-version, it is fixed too, so the
+// manifest digest stays the same for the same name:reference.
+func createdTime(name string, reference string, now time.Time) time.Time {
+	if _, ok := fixedAppVersion(name, reference); ok {
+		return chartEpoch
+	}
+
+	return now
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var appImageFlags stringList
+
+var appImageTTL = flag.Duration("app-image-ttl", 5*time.Minute, "how long the latest tag of an -app-image is used before its registry is asked again")
+
+func init() {
+	flag.Var(&appImageFlags, "app-image", "container image whose latest tag matching a pattern becomes the appVersion and the image.repository and image.tag values of generated charts, as [pattern=]registry/repository[:tag-pattern], such as apps/*=registry-1.docker.io/library/nginx:1.*; the latest tag is the highest semver version, or the last in lexical order when none is one, and credentials come from the Docker config (repeatable, later flags override earlier ones)")
+}
+
+// appImageRule resolves the latest tag of an image for the repositories
+// matching pattern.
+type appImageRule struct {
+	pattern    string
+	image      string
+	repository string
+	tagPattern string
+	client     *registryClient
+}
+
+var appImageRules []appImageRule
+
+// appImageTags caches the latest tag of images by image:tag-pattern.
+var appImageTags = struct {
+	sync.Mutex
+	byImage map[string]appImageTag
+}{byImage: make(map[string]appImageTag)}
+
+type appImageTag struct {
+	tag     string
+	fetched time.Time
+}
+
+// initAppImages parses the -app-image flags.
+func initAppImages() error {
+	for _, f := range appImageFlags {
+		pattern, image, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, image = "", f
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid app image %q: %w", f, err)
+		}
+
+		// A scheme, such as http:// for a local registry, only applies to
+		// the client.
+		scheme := ""
+		if i := strings.Index(image, "://"); i >= 0 {
+			scheme, image = image[:i+3], image[i+3:]
+		}
+		tagPattern := "*"
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			image, tagPattern = image[:i], image[i+1:]
+		}
+		host, repository, ok := strings.Cut(image, "/")
+		if !ok || !validName(repository) {
+			return fmt.Errorf("invalid app image %q: expected [pattern=]registry/repository[:tag-pattern]", f)
+		}
+		if _, err := path.Match(tagPattern, ""); err != nil {
+			return fmt.Errorf("invalid app image %q: %w", f, err)
+		}
+
+		username, password, err := dockerCredentials(host)
+		if err != nil {
+			return err
+		}
+		client, err := newRegistryClient(scheme+host, username, "")
+		if err != nil {
+			return err
+		}
+		client.password = password
+
+		appImageRules = append(appImageRules, appImageRule{pattern: pattern, image: image, repository: repository, tagPattern: tagPattern, client: client})
+	}
+
+	return nil
+}
+
+// appImageFor returns the image configured for name and its latest tag. A
+// failure to list the tags is logged and the last tag found, if any, is
+// used.
+func appImageFor(name string) (string, string, bool) {
+	var rule *appImageRule
+	for i := range appImageRules {
+		if appImageRules[i].pattern != "" {
+			if ok, _ := path.Match(appImageRules[i].pattern, name); !ok {
+				continue
+			}
+		}
+		rule = &appImageRules[i]
+	}
+	if rule == nil {
+		return "", "", false
+	}
+
+	key := rule.image + ":" + rule.tagPattern
+	appImageTags.Lock()
+	cached, ok := appImageTags.byImage[key]
+	appImageTags.Unlock()
+	if ok && time.Since(cached.fetched) < *appImageTTL {
+		return rule.image, cached.tag, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tag, err := rule.latestTag(ctx)
+	if err != nil {
+		slog.Warn("failed to resolve the latest app image tag", "image", rule.image, "pattern", rule.tagPattern, "error", err)
+		return rule.image, cached.tag, ok
+	}
+
+	appImageTags.Lock()
+	appImageTags.byImage[key] = appImageTag{tag: tag, fetched: time.Now()}
+	appImageTags.Unlock()
+
+	return rule.image, tag, true
+}
+
+// latestTag returns the highest semver tag of the image matching the tag
+// pattern or, when none is a version, the last matching tag in lexical
+// order.
+func (rule *appImageRule) latestTag(ctx context.Context) (string, error) {
+	tags, err := rule.client.listTags(ctx, rule.repository)
+	if err != nil {
+		return "", err
+	}
+
+	var matching []string
+	var latest *semver.Version
+	var latestTag string
+	for _, tag := range tags {
+		if ok, _ := path.Match(rule.tagPattern, tag); !ok {
+			continue
+		}
+		matching = append(matching, tag)
+		if v, err := semver.NewVersion(tag); err == nil && (latest == nil || v.GreaterThan(latest)) {
+			latest, latestTag = v, tag
+		}
+	}
+	if latestTag != "" {
+		return latestTag, nil
+	}
+	if len(matching) == 0 {
+		return "", fmt.Errorf("no tag of %s matches %q", rule.image, rule.tagPattern)
+	}
+	sort.Strings(matching)
+
+	return matching[len(matching)-1], nil
+}
+
+// injectImageValues sets image.repository and image.tag in the chart's
+// values.yaml to the image configured for name, if any.
+func injectImageValues(out *GeneratedChart, name string) error {
+	image, tag, ok := appImageFor(name)
+	if !ok {
+		return nil
+	}
+
+	return updateValues(out, func(values map[string]interface{}) {
+		imageValues, _ := values["image"].(map[string]interface{})
+		if imageValues == nil {
+			imageValues = make(map[string]interface{})
+		}
+		imageValues["repository"] = image
+		imageValues["tag"] = tag
+		values["image"] = imageValues
+	})
+}
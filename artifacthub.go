@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"sigs.k8s.io/yaml"
+)
+
+var artifactHubRepositoryIDFlags, artifactHubOwnerFlags stringList
+
+func init() {
+	flag.Var(&artifactHubRepositoryIDFlags, "artifacthub-repository-id", "Artifact Hub repository ID published in the artifacthub-repo.yml of the -helm-repo namespaces matching a pattern, as [pattern=]id, for the verified publisher badge; the top-level namespace is \".\" and all namespaces match without a pattern (repeatable, later flags override earlier ones)")
+	flag.Var(&artifactHubOwnerFlags, "artifacthub-owner", "owner published in the artifacthub-repo.yml of the -helm-repo namespaces, as \"Name <email>\", allowing them to claim the repositories in Artifact Hub (repeatable)")
+}
+
+// ArtifactHubRepositoryID publishes an Artifact Hub repository ID for the
+// namespaces matching Pattern.
+type ArtifactHubRepositoryID struct {
+	Pattern      string `json:"pattern"`
+	RepositoryID string `json:"repositoryID"`
+}
+
+// artifactHubRepositoryIDs holds the -artifacthub-repository-id flags and
+// those set with the admin API, in order.
+var artifactHubRepositoryIDs struct {
+	sync.RWMutex
+	rules []ArtifactHubRepositoryID
+}
+
+// artifactHubOwner is an owner of artifacthub-repo.yml.
+type artifactHubOwner struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+var artifactHubOwners []artifactHubOwner
+
+// initArtifactHub parses the -artifacthub-repository-id and
+// -artifacthub-owner flags.
+func initArtifactHub() error {
+	for _, f := range artifactHubRepositoryIDFlags {
+		pattern, id, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, id = "", f
+		}
+		rule := ArtifactHubRepositoryID{Pattern: pattern, RepositoryID: id}
+		if err := validateArtifactHubRepositoryID(rule); err != nil {
+			return fmt.Errorf("invalid -artifacthub-repository-id %q: %w", f, err)
+		}
+		artifactHubRepositoryIDs.rules = append(artifactHubRepositoryIDs.rules, rule)
+	}
+
+	for _, f := range artifactHubOwnerFlags {
+		addr, err := mail.ParseAddress(f)
+		if err != nil {
+			return fmt.Errorf("invalid -artifacthub-owner %q: expected \"Name <email>\"", f)
+		}
+		artifactHubOwners = append(artifactHubOwners, artifactHubOwner{Name: addr.Name, Email: addr.Address})
+	}
+
+	return nil
+}
+
+func validateArtifactHubRepositoryID(rule ArtifactHubRepositoryID) error {
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(rule.RepositoryID); err != nil {
+		return fmt.Errorf("repository ID %q is not a UUID", rule.RepositoryID)
+	}
+
+	return nil
+}
+
+// artifactHubRepositoryIDFor returns the repository ID of the last rule
+// matching namespace.
+func artifactHubRepositoryIDFor(namespace string) string {
+	artifactHubRepositoryIDs.RLock()
+	defer artifactHubRepositoryIDs.RUnlock()
+
+	id := ""
+	for _, rule := range artifactHubRepositoryIDs.rules {
+		if rule.Pattern != "" {
+			if ok, _ := path.Match(rule.Pattern, namespace); !ok {
+				continue
+			}
+		}
+		id = rule.RepositoryID
+	}
+
+	return id
+}
+
+// artifactHubRepo is the artifacthub-repo.yml Artifact Hub reads from the
+// root of a Helm repository.
+type artifactHubRepo struct {
+	RepositoryID string             `json:"repositoryID,omitempty"`
+	Owners       []artifactHubOwner `json:"owners,omitempty"`
+}
+
+// handleArtifactHubRepo serves the artifacthub-repo.yml of a -helm-repo
+// namespace. Artifact Hub grants the verified publisher badge once the
+// repositoryID matches the ID it assigned the repository.
+func handleArtifactHubRepo(w http.ResponseWriter, r *http.Request) {
+	namespace := mux.Vars(r)["namespace"]
+	if namespace == "" {
+		namespace = "."
+	}
+
+	repo := artifactHubRepo{RepositoryID: artifactHubRepositoryIDFor(namespace), Owners: artifactHubOwners}
+	if repo.RepositoryID == "" && len(repo.Owners) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := yaml.Marshal(repo)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write(data)
+}
+
+// registerArtifactHubRoutes lets the repository IDs be set once Artifact Hub
+// has assigned them, without restarting.
+func registerArtifactHubRoutes(admin *mux.Router) {
+	if !*helmRepo {
+		return
+	}
+
+	admin.HandleFunc("/artifacthub/repository-ids", handleAdminArtifactHubRepositoryIDs).Methods("GET")
+	admin.HandleFunc("/artifacthub/repository-ids", handleAdminSetArtifactHubRepositoryID).Methods("PUT")
+}
+
+func handleAdminArtifactHubRepositoryIDs(w http.ResponseWriter, r *http.Request) {
+	artifactHubRepositoryIDs.RLock()
+	rules := append([]ArtifactHubRepositoryID{}, artifactHubRepositoryIDs.rules...)
+	artifactHubRepositoryIDs.RUnlock()
+
+	writeAdminJSON(w, rules)
+}
+
+// handleAdminSetArtifactHubRepositoryID sets the repository ID of a pattern,
+// given as ArtifactHubRepositoryID JSON, replacing any rule for the same
+// pattern. An empty repositoryID removes the rule.
+func handleAdminSetArtifactHubRepositoryID(w http.ResponseWriter, r *http.Request) {
+	var rule ArtifactHubRepositoryID
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknown, "invalid repository ID: "+err.Error(), nil)
+		return
+	}
+	if rule.RepositoryID != "" {
+		if err := validateArtifactHubRepositoryID(rule); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeUnknown, err.Error(), nil)
+			return
+		}
+	}
+
+	artifactHubRepositoryIDs.Lock()
+	var rules []ArtifactHubRepositoryID
+	for _, existing := range artifactHubRepositoryIDs.rules {
+		if existing.Pattern != rule.Pattern {
+			rules = append(rules, existing)
+		}
+	}
+	if rule.RepositoryID != "" {
+		rules = append(rules, rule)
+	}
+	artifactHubRepositoryIDs.rules = rules
+	artifactHubRepositoryIDs.Unlock()
+
+	logger(r.Context()).Info("set Artifact Hub repository ID", "pattern", rule.Pattern, "repository_id", rule.RepositoryID)
+	writeAdminJSON(w, rule)
+}
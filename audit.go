@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var auditLogPath = flag.String("audit-log", "", "append a JSON line for every pull, push, delete and authentication failure to this file (\"-\" for stdout)")
+
+// AuditEvent is a line of the audit log.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	User       string    `json:"user,omitempty"`
+	Client     string    `json:"client"`
+	Repository string    `json:"repository,omitempty"`
+	Reference  string    `json:"reference,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+}
+
+// auditLog is where audit events are written; it is nil when auditing is
+// disabled.
+var auditLog *struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// initAuditLog opens the -audit-log for appending.
+func initAuditLog() error {
+	if *auditLogPath == "" {
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if *auditLogPath != "-" {
+		f, err := os.OpenFile(*auditLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	auditLog = &struct {
+		mu  sync.Mutex
+		enc *json.Encoder
+	}{enc: json.NewEncoder(out)}
+	return nil
+}
+
+// audit writes an event for a request to the audit log, if enabled.
+func audit(r *http.Request, event AuditEvent) {
+	if auditLog == nil {
+		return
+	}
+
+	event.Time = now().UTC()
+	event.Client = clientIP(r)
+	event.RequestID = requestID(r.Context())
+	if event.Repository == "" {
+		event.Repository, _ = requestRepository(r)
+	}
+
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	if err := auditLog.enc.Encode(event); err != nil {
+		logger(r.Context()).Error("writing audit log failed", "error", err)
+	}
+}
+
+// auditFailure records a request refused for its credentials. The user is
+// whoever the request claimed to be, if known.
+func auditFailure(r *http.Request, user string, status int, reason string) {
+	if user == "" {
+		user, _, _ = r.BasicAuth()
+	}
+	audit(r, AuditEvent{Action: "auth_failure", User: user, Status: status, Reason: reason})
+}
+
+// operationsMiddleware records the pulls, pushes and deletes of the registry
+// API with the identity that performed them in the audit log and sends them as
+// notifications. It must run inside authMiddleware.
+func operationsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := repositoryPathRegexp.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		action := "pull"
+		switch r.Method {
+		case http.MethodPut, http.MethodPost, http.MethodPatch:
+			action = "push"
+		case http.MethodDelete:
+			action = "delete"
+		}
+
+		event := AuditEvent{Action: action, Repository: match[1], Status: rec.status}
+		if id, ok := identity(r.Context()); ok {
+			event.User = id.Name
+		}
+
+		// The router has not matched the request yet, so take the
+		// reference or digest from the last path segment.
+		last := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		switch match[2] {
+		case "manifests":
+			event.Reference = last
+			event.Digest = rec.Header().Get("Docker-Content-Digest")
+		case "blobs":
+			if strings.HasPrefix(last, "sha256:") {
+				event.Digest = last
+			} else if d := r.URL.Query().Get("digest"); d != "" {
+				event.Digest = d
+			}
+		case "referrers":
+			event.Digest = last
+		}
+
+		audit(r, event)
+
+		if rec.status < 200 || rec.status > 299 || r.Method == http.MethodHead || r.Method == http.MethodPost {
+			return
+		}
+		target := EventTarget{Repository: event.Repository, Digest: event.Digest}
+		switch match[2] {
+		case "manifests":
+			if !strings.HasPrefix(event.Reference, "sha256:") {
+				target.Tag = event.Reference
+			}
+		case "blobs":
+		default:
+			return
+		}
+		if action == "push" {
+			target.MediaType = r.Header.Get("Content-Type")
+			target.Size = r.ContentLength
+		} else {
+			target.MediaType = rec.Header().Get("Content-Type")
+			target.Size = responseSize(rec)
+		}
+		notify(r, action, target)
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var htpasswdFile = flag.String("htpasswd", "", "htpasswd file of bcrypt hashed credentials required by HTTP Basic auth (authentication disabled when empty)")
+
+const authRealm = "virtual-helm"
+
+// htpasswd holds the bcrypt hashes of the -htpasswd users by name.
+var htpasswd map[string][]byte
+
+// dummyHash is compared against for unknown users.
+var dummyHash []byte
+
+// initAuth loads the -htpasswd credentials.
+func initAuth() error {
+	if *htpasswdFile == "" {
+		return nil
+	}
+
+	var err error
+	htpasswd, err = loadHtpasswd(*htpasswdFile)
+	if err != nil {
+		return err
+	}
+
+	dummyHash, err = bcrypt.GenerateFromPassword([]byte(authRealm), bcrypt.DefaultCost)
+	return err
+}
+
+// loadHtpasswd reads the bcrypt hashes of an htpasswd file.
+func loadHtpasswd(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", file, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported (htpasswd -B)", file, line)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("htpasswd file %s has no users", file)
+	}
+
+	return users, nil
+}
+
+// htpasswdEnabled reports whether -htpasswd credentials are loaded. The
+// users are replaced on reload, so they are read under reloadMu.
+func htpasswdEnabled() bool {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+
+	return htpasswd != nil
+}
+
+// authEnabled reports whether requests must authenticate.
+func authEnabled() bool {
+	return htpasswdEnabled() || tokenAuthEnabled() || oidcProvider != nil || clientCertAuthEnabled()
+}
+
+// Identity is the authenticated user of a request.
+type Identity struct {
+	Name string
+	// Access is what the bearer token of the request grants; it is nil when
+	// the credentials are not limited to particular repositories.
+	Access []ResourceActions
+}
+
+type identityKey struct{}
+
+// identity returns the authenticated user of a request, if any.
+func identity(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// publicPath reports whether a path is served without registry
+// authentication: health and metrics endpoints, the token service and the
+// admin API, which check their own credentials.
+func publicPath(p string) bool {
+	switch p {
+	case "/healthz", "/readyz", "/metrics", "/version", "/token":
+		return true
+	}
+
+	return strings.HasPrefix(p, "/admin/")
+}
+
+// checkBasicAuth validates the Basic credentials of a request.
+func checkBasicAuth(r *http.Request) (*Identity, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	reloadMu.RLock()
+	hash, known := htpasswd[user]
+	reloadMu.RUnlock()
+	if !known {
+		// Compare anyway so unknown users take as long as wrong passwords.
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, false
+	}
+
+	return &Identity{Name: user}, true
+}
+
+// authMiddleware requires valid credentials for every non-public path,
+// challenging clients without them: bearer tokens when -token-realm is set,
+// Basic credentials otherwise. OIDC tokens are accepted as bearer tokens
+// whenever -oidc-issuer is set, and verified client certificates take
+// precedence over all other credentials. Authenticated requests are then
+// authorized against their token's access and the -policy.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hasBearer := strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+		id, hasCert := checkClientCert(r)
+		switch {
+		case hasCert:
+		case tokenAuthEnabled() || (oidcProvider != nil && (hasBearer || !htpasswdEnabled())):
+			var err error
+			id, err = checkToken(r)
+			if err != nil {
+				bearerErr := ""
+				if hasBearer {
+					bearerErr = "invalid_token"
+					logger(r.Context()).Debug("rejected bearer token", "error", err)
+					auditFailure(r, "", http.StatusUnauthorized, "invalid bearer token")
+				}
+				if tokenAuthEnabled() {
+					bearerChallenge(w, r, bearerErr)
+				} else {
+					oidcChallenge(w, bearerErr)
+				}
+				return
+			}
+		default:
+			var ok bool
+			id, ok = checkBasicAuth(r)
+			if !ok {
+				if r.Header.Get("Authorization") != "" {
+					auditFailure(r, "", http.StatusUnauthorized, "invalid credentials")
+				}
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+				writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "authentication required", nil)
+				return
+			}
+		}
+
+		if !authorize(w, r, id) {
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identityKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// checkToken validates the bearer token of a request as a registry token,
+// then as an OIDC token.
+func checkToken(r *http.Request) (*Identity, error) {
+	if !tokenAuthEnabled() {
+		return checkOIDCToken(r)
+	}
+
+	id, err := checkBearerToken(r)
+	if err != nil && oidcProvider != nil {
+		if oidcID, oidcErr := checkOIDCToken(r); oidcErr == nil {
+			return oidcID, nil
+		}
+	}
+
+	return id, err
+}
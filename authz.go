@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+var policyFile = flag.String("policy", "", "file of rules granting users actions on repositories, one \"user pattern actions\" per line such as \"alice charts/* pull,push\", where user * matches everyone and action * every action; when set, anything not granted is denied")
+
+// policyRule grants a user actions on the repositories matching pattern.
+type policyRule struct {
+	user    string
+	pattern string
+	actions []string
+}
+
+// policy holds the -policy rules; it is nil when every authenticated user may
+// do anything their credentials allow.
+var policy []policyRule
+
+// initPolicy loads the -policy rules.
+func initPolicy() error {
+	if *policyFile == "" {
+		return nil
+	}
+	if !authEnabled() {
+		return errors.New("-policy requires authentication, such as -htpasswd")
+	}
+
+	var err error
+	policy, err = loadPolicy(*policyFile)
+	return err
+}
+
+// loadPolicy reads the rules of a policy file.
+func loadPolicy(file string) ([]policyRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := []policyRule{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected user pattern actions", file, line)
+		}
+		if _, err := path.Match(fields[1], ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		rules = append(rules, policyRule{user: fields[0], pattern: fields[1], actions: strings.Split(fields[2], ",")})
+	}
+
+	return rules, scanner.Err()
+}
+
+// policyAllows returns the requested actions on a resource the -policy, or
+// the -tenant-policy of its tenant, grants user.
+func policyAllows(user string, requested ResourceActions) []string {
+	reloadMu.RLock()
+	rules := policy
+	reloadMu.RUnlock()
+	if requested.Type == "repository" {
+		if t := tenantOf(requested.Name); t != nil && t.policy != nil {
+			rules = t.policy
+		}
+	}
+	if rules == nil {
+		return requested.Actions
+	}
+	if requested.Type != "repository" {
+		return nil
+	}
+
+	var allowed []string
+	for _, action := range requested.Actions {
+		for _, rule := range rules {
+			if (rule.user == "*" || rule.user == user) && grants(rule.pattern, rule.actions, requested.Name, action) {
+				allowed = append(allowed, action)
+				break
+			}
+		}
+	}
+
+	return allowed
+}
+
+// grants reports whether actions on repositories matching pattern include
+// action on name.
+func grants(pattern string, actions []string, name string, action string) bool {
+	if ok, _ := path.Match(pattern, name); !ok {
+		return false
+	}
+
+	return containsString(actions, action) || containsString(actions, "*")
+}
+
+// authorized reports whether id may perform actions on the repository name,
+// as allowed by both its credentials and the -policy.
+func authorized(id *Identity, name string, actions []string) bool {
+	for _, action := range actions {
+		if id.Access != nil && !accessGrants(id.Access, name, action) {
+			return false
+		}
+	}
+
+	allowed := policyAllows(id.Name, ResourceActions{Type: "repository", Name: name, Actions: actions})
+	return len(allowed) == len(actions)
+}
+
+// accessGrants reports whether the access of a token includes action on the
+// repository name.
+func accessGrants(access []ResourceActions, name string, action string) bool {
+	for _, ra := range access {
+		if ra.Type == "repository" && grants(ra.Name, ra.Actions, name, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize rejects a request its identity may not perform on the
+// repository it names with 403 DENIED, reporting whether it was allowed.
+func authorize(w http.ResponseWriter, r *http.Request, id *Identity) bool {
+	name, ok := requestRepository(r)
+	if !ok {
+		return true
+	}
+
+	return authorizeRepository(w, r, id, name, requestActions(r))
+}
+
+// authorizeRequest is authorize for handlers that only learn the repository
+// of a request from its body or by resolving it, such as ChartMuseum
+// uploads. Requests without an identity are allowed, as authentication is
+// disabled.
+func authorizeRequest(w http.ResponseWriter, r *http.Request, name string, actions ...string) bool {
+	id, ok := identity(r.Context())
+	if !ok {
+		return true
+	}
+
+	return authorizeRepository(w, r, id, name, actions)
+}
+
+// mayPull reports whether the user of a request may pull name, for listings.
+func mayPull(ctx context.Context, name string) bool {
+	id, ok := identity(ctx)
+	return !ok || authorized(id, name, []string{"pull"})
+}
+
+func authorizeRepository(w http.ResponseWriter, r *http.Request, id *Identity, name string, actions []string) bool {
+	if authorized(id, name, actions) {
+		return true
+	}
+
+	logger(r.Context()).Info("denied request", "user", id.Name, "repository", name, "actions", actions)
+	auditFailure(r, id.Name, http.StatusForbidden, "denied "+strings.Join(actions, ","))
+	writeError(w, http.StatusForbidden, ErrCodeDenied, "requested access to the resource is denied", map[string]string{"repository": name, "actions": strings.Join(actions, ",")})
+	return false
+}
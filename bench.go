@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchStats summarizes the requests of one kind made by bench.
+type BenchStats struct {
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	Bytes    int64         `json:"bytes"`
+	P50      time.Duration `json:"p50"`
+	P90      time.Duration `json:"p90"`
+	P99      time.Duration `json:"p99"`
+	Max      time.Duration `json:"max"`
+}
+
+// BenchResult is the report of a bench run.
+type BenchResult struct {
+	Target      string                `json:"target"`
+	Concurrency int                   `json:"concurrency"`
+	Duration    time.Duration         `json:"duration"`
+	Pulls       int64                 `json:"pulls"`
+	PullsPerSec float64               `json:"pullsPerSec"`
+	BytesPerSec float64               `json:"bytesPerSec"`
+	Requests    map[string]BenchStats `json:"requests"`
+}
+
+// benchSample is the outcome of a single request.
+type benchSample struct {
+	kind    string
+	latency time.Duration
+	bytes   int
+	err     error
+}
+
+// runBench implements `virtual-helm bench [flags] <name>:<tag>...`.
+func runBench(args []string) error {
+	target := flag.String("target", "", "registry to benchmark, as oci://host; a server is started in-process from the serve flags when empty")
+	username := flag.String("username", "", "username for the target registry; the Docker config's credentials for it are used when empty")
+	passwordFile := flag.String("password-file", "", "file holding the password or token for the target registry")
+	plainHTTP := flag.Bool("plain-http", false, "talk plain HTTP to the target registry")
+	concurrency := flag.Int("concurrency", 10, "number of concurrent pullers")
+	duration := flag.Duration("duration", 10*time.Second, "how long to pull for")
+	maxPulls := flag.Int64("pulls", 0, "stop after this many chart pulls (no limit when 0)")
+	manifestsOnly := flag.Bool("manifests-only", false, "only pull manifests, not their blobs")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: virtual-helm bench [flags] <name>:<tag>...\n\nPulls the charts from a registry, or an in-process server configured by the flags of serve, with concurrent pullers and reports latency percentiles and throughput.")
+		flag.PrintDefaults()
+	}
+
+	// Request logs of an in-process server would drown the report.
+	if f := flag.Lookup("log-level"); f != nil {
+		f.DefValue = "warn"
+		f.Value.Set("warn")
+	}
+
+	positional, err := parseCommandLine(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || *concurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var charts []chartRef
+	for _, arg := range positional {
+		i := strings.LastIndex(arg, ":")
+		if i <= 0 || i == len(arg)-1 {
+			return fmt.Errorf("invalid chart %q: expected name:tag", arg)
+		}
+		charts = append(charts, chartRef{Name: arg[:i], Reference: arg[i+1:]})
+	}
+
+	var client *registryClient
+	if *target == "" {
+		base, stop, err := startBenchServer()
+		if err != nil {
+			return err
+		}
+		defer stop()
+		if client, err = newRegistryClient(base, "", ""); err != nil {
+			return err
+		}
+	} else {
+		host, ok := strings.CutPrefix(*target, "oci://")
+		if !ok {
+			return fmt.Errorf("invalid target %q: expected oci://host", *target)
+		}
+		scheme := "https://"
+		if *plainHTTP {
+			scheme = "http://"
+		}
+		if client, err = newRegistryClient(scheme+host, *username, *passwordFile); err != nil {
+			return err
+		}
+		if *username == "" {
+			if client.username, client.password, err = dockerCredentials(host); err != nil {
+				return err
+			}
+		}
+	}
+	client.client.Transport = &http.Transport{MaxIdleConnsPerHost: *concurrency}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	samples := make(chan benchSample, *concurrency)
+	// started counts the pulls begun, for -pulls, and pulls those completed.
+	var started, pulls atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := i; ctx.Err() == nil; n += *concurrency {
+				if *maxPulls > 0 && started.Add(1) > *maxPulls {
+					return
+				}
+				if benchPull(ctx, client, charts[n%len(charts)], !*manifestsOnly, samples) {
+					pulls.Add(1)
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(samples)
+	}()
+
+	latencies := make(map[string][]time.Duration)
+	result := BenchResult{Target: client.host(), Concurrency: *concurrency, Requests: make(map[string]BenchStats)}
+	var total int64
+	for s := range samples {
+		stats := result.Requests[s.kind]
+		stats.Requests++
+		if s.err != nil {
+			stats.Errors++
+			slog.Debug("bench request failed", "kind", s.kind, "error", s.err)
+		} else {
+			stats.Bytes += int64(s.bytes)
+			total += int64(s.bytes)
+			latencies[s.kind] = append(latencies[s.kind], s.latency)
+		}
+		result.Requests[s.kind] = stats
+	}
+	result.Duration = time.Since(start)
+	result.Pulls = pulls.Load()
+	result.PullsPerSec = float64(result.Pulls) / result.Duration.Seconds()
+	result.BytesPerSec = float64(total) / result.Duration.Seconds()
+
+	for kind, l := range latencies {
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		stats := result.Requests[kind]
+		stats.P50, stats.P90, stats.P99, stats.Max = percentile(l, 50), percentile(l, 90), percentile(l, 99), l[len(l)-1]
+		result.Requests[kind] = stats
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	fmt.Printf("%s: %d pulls in %s with %d pullers, %.1f pulls/s, %.1f MiB/s\n", result.Target, result.Pulls, result.Duration.Round(time.Millisecond), result.Concurrency, result.PullsPerSec, result.BytesPerSec/(1<<20))
+	for _, kind := range []string{"manifest", "blob"} {
+		stats, ok := result.Requests[kind]
+		if !ok {
+			continue
+		}
+		fmt.Printf("%-8s %7d requests %5d errors  p50 %-10s p90 %-10s p99 %-10s max %s\n", kind, stats.Requests, stats.Errors, stats.P50.Round(time.Microsecond), stats.P90.Round(time.Microsecond), stats.P99.Round(time.Microsecond), stats.Max.Round(time.Microsecond))
+	}
+	return nil
+}
+
+// benchPull pulls the manifest of ref and, with blobs, the blobs it
+// references, reporting every request to samples and whether the pull
+// completed. Requests cut short by the end of the run are not reported.
+func benchPull(ctx context.Context, client *registryClient, ref chartRef, blobs bool, samples chan<- benchSample) bool {
+	report := func(s benchSample) bool {
+		if ctx.Err() != nil && errors.Is(s.err, ctx.Err()) {
+			return false
+		}
+		samples <- s
+		return s.err == nil
+	}
+
+	start := time.Now()
+	data, err := client.getManifest(ctx, ref.Name, ref.Reference)
+	if !report(benchSample{kind: "manifest", latency: time.Since(start), bytes: len(data), err: err}) {
+		return false
+	}
+	if !blobs {
+		return true
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return report(benchSample{kind: "manifest", err: err})
+	}
+	for _, digest := range manifestBlobs(&manifest) {
+		start := time.Now()
+		blob, err := client.getBlob(ctx, ref.Name, digest)
+		if !report(benchSample{kind: "blob", latency: time.Since(start), bytes: len(blob), err: err}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// startBenchServer serves the registry configured by the serve flags on a
+// loopback port and returns its URL and a function stopping it.
+func startBenchServer() (string, func(), error) {
+	if err := initGeneration(); err != nil {
+		return "", nil, err
+	}
+	handler, err := newHandler()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+
+	return "http://" + ln.Addr().String(), func() { srv.Close() }, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/list"
+	"flag"
+	"strings"
+	"sync"
+)
+
+var (
+	noCache   = flag.Bool("no-cache", false, "regenerate charts on every pull by tag instead of caching them; the generated manifests are still kept for pulls by digest")
+	cacheSize = flag.Int("cache-size", 1000, "maximum number of generated charts kept in the cache")
+)
+
+// chartCache is an LRU cache of generated manifests keyed by name:reference.
+// The entries are also indexed by name:digest, so that a manifest a tag
+// resolved to can be pulled by digest.
+type chartCache struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[string]*list.Element
+	byDigest map[string]*list.Element
+	order    *list.List
+	hits     int
+	misses   int
+}
+
+type cacheEntry struct {
+	key      string
+	manifest *Manifest
+}
+
+var generated = newChartCache(1000)
+
+func newChartCache(size int) *chartCache {
+	return &chartCache{
+		size:     size,
+		entries:  make(map[string]*list.Element),
+		byDigest: make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func initCache() {
+	generated = newChartCache(*cacheSize)
+}
+
+func cacheKey(name string, reference string) string {
+	return name + ":" + reference
+}
+
+func (c *chartCache) Get(key string) (*Manifest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		cacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	c.hits++
+	cacheRequests.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).manifest, true
+}
+
+// GetDigest returns the cached manifest of name with digest, whichever tag
+// it was generated for.
+func (c *chartCache) GetDigest(name string, digest string) (*Manifest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.byDigest[cacheKey(name, digest)]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).manifest, true
+}
+
+func (c *chartCache) Add(key string, manifest *Manifest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, _, _ := strings.Cut(key, ":")
+	if el, ok := c.entries[key]; ok {
+		c.unindex(el)
+		el.Value.(*cacheEntry).manifest = manifest
+		c.byDigest[cacheKey(name, manifest.digest)] = el
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&cacheEntry{key: key, manifest: manifest})
+	c.entries[key] = el
+	c.byDigest[cacheKey(name, manifest.digest)] = el
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove evicts the entry el; the caller holds c.mu.
+func (c *chartCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.unindex(el)
+}
+
+// unindex removes the digest of the entry el from the index, unless another
+// entry with the same digest was indexed since; the caller holds c.mu.
+func (c *chartCache) unindex(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	name, _, _ := strings.Cut(entry.key, ":")
+	key := cacheKey(name, entry.manifest.digest)
+	if c.byDigest[key] == el {
+		delete(c.byDigest, key)
+	}
+}
+
+// RemoveRepository evicts every cached reference of the named repository and
+// returns how many entries were removed.
+func (c *chartCache) RemoveRepository(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, name+":") {
+			c.remove(el)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Purge evicts every entry and returns how many were removed.
+func (c *chartCache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := len(c.entries)
+	c.entries = make(map[string]*list.Element)
+	c.byDigest = make(map[string]*list.Element)
+	c.order.Init()
+	return removed
+}
+
+// Keys returns the name:reference keys of the cached entries.
+func (c *chartCache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// CacheStats summarizes the cache for the admin API.
+type CacheStats struct {
+	Entries int `json:"entries"`
+	Size    int `json:"size"`
+	Hits    int `json:"hits"`
+	Misses  int `json:"misses"`
+}
+
+func (c *chartCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{Entries: len(c.entries), Size: c.size, Hits: c.hits, Misses: c.misses}
+}
+
+// Entries returns the cached manifests by name:reference key.
+func (c *chartCache) Entries() map[string]*Manifest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make(map[string]*Manifest, len(c.entries))
+	for key, el := range c.entries {
+		entries[key] = el.Value.(*cacheEntry).manifest
+	}
+
+	return entries
+}
+
+func (c *chartCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	tagManifestCacheControl    = flag.String("cache-control-tag-manifests", "", "Cache-Control of manifests pulled by tag, such as \"public, max-age=60\"; tags can move, so keep max-age short (none when empty)")
+	digestManifestCacheControl = flag.String("cache-control-digest-manifests", "", "Cache-Control of manifests pulled by digest, which never change, such as \"public, max-age=31536000, immutable\" (none when empty)")
+	blobCacheControl           = flag.String("cache-control-blobs", "", "Cache-Control of blobs and chart archives, such as \"public, max-age=31536000, immutable\" (none when empty)")
+)
+
+// cachePolicy is a Cache-Control value and the max-age it sets, from which
+// Expires is derived for HTTP/1.0 caches.
+type cachePolicy struct {
+	cacheControl string
+	maxAge       time.Duration
+	hasMaxAge    bool
+}
+
+var tagManifestCache, digestManifestCache, blobCache cachePolicy
+
+// initCacheControl parses the -cache-control flags.
+func initCacheControl() error {
+	for _, p := range []struct {
+		flag   string
+		value  string
+		policy *cachePolicy
+	}{
+		{"cache-control-tag-manifests", *tagManifestCacheControl, &tagManifestCache},
+		{"cache-control-digest-manifests", *digestManifestCacheControl, &digestManifestCache},
+		{"cache-control-blobs", *blobCacheControl, &blobCache},
+	} {
+		policy, err := parseCachePolicy(p.value)
+		if err != nil {
+			return fmt.Errorf("invalid -%s %q: %w", p.flag, p.value, err)
+		}
+		*p.policy = policy
+	}
+
+	return nil
+}
+
+// parseCachePolicy parses a Cache-Control value.
+func parseCachePolicy(value string) (cachePolicy, error) {
+	policy := cachePolicy{cacheControl: strings.TrimSpace(value)}
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(arg)
+		if err != nil || seconds < 0 {
+			return policy, fmt.Errorf("invalid max-age %q", arg)
+		}
+		policy.maxAge = time.Duration(seconds) * time.Second
+		policy.hasMaxAge = true
+	}
+
+	return policy, nil
+}
+
+// manifestCachePolicy returns the cache policy of the manifest reference
+// refers to.
+func manifestCachePolicy(reference string) cachePolicy {
+	if strings.HasPrefix(reference, "sha256:") {
+		return digestManifestCache
+	}
+
+	return tagManifestCache
+}
+
+// setCacheHeaders sets the Cache-Control and Expires headers of policy, if
+// any. It is only called for content found, so that caches do not keep
+// errors.
+func setCacheHeaders(w http.ResponseWriter, policy cachePolicy) {
+	if policy.cacheControl == "" {
+		return
+	}
+
+	w.Header().Set("Cache-Control", policy.cacheControl)
+	if policy.hasMaxAge {
+		w.Header().Set("Expires", now().Add(policy.maxAge).UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var catalogFlags stringList
+
+func init() {
+	flag.Var(&catalogFlags, "list-chart", "chart to list in catalogs such as the Helm repository index, as name:reference (repeatable)")
+}
+
+// chartRef identifies a servable chart by repository name and reference.
+type chartRef struct {
+	Name      string
+	Reference string
+}
+
+// chartLister is implemented by generators that can enumerate the charts
+// they serve.
+type chartLister interface {
+	listCharts() []chartRef
+}
+
+// knownCharts returns every chart that can be listed: those the generators
+// enumerate, those configured with -list-chart, pushed and imported ones and
+// those generated so far.
+func knownCharts() []chartRef {
+	seen := make(map[chartRef]bool)
+	var refs []chartRef
+	add := func(ref chartRef) {
+		if !seen[ref] && servableName(ref.Name) {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	for _, ref := range pushedCharts() {
+		add(ref)
+	}
+
+	for _, ref := range importedCharts() {
+		add(ref)
+	}
+
+	for name, g := range generators {
+		if *conformance {
+			// Nothing is generated, so there is nothing to list.
+			break
+		}
+		// Listed charts must be routed to the generator listing them.
+		if lister, ok := g.(chartLister); ok {
+			for _, ref := range lister.listCharts() {
+				if generatorNameFor(ref.Name) == name {
+					add(ref)
+				}
+			}
+		}
+	}
+
+	for _, f := range catalogFlags {
+		// Validated by initCatalog.
+		name, reference, _ := strings.Cut(f, ":")
+		add(chartRef{Name: name, Reference: reference})
+	}
+
+	for _, key := range generated.Keys() {
+		name, reference, _ := strings.Cut(key, ":")
+		add(chartRef{Name: name, Reference: reference})
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Name != refs[j].Name {
+			return refs[i].Name < refs[j].Name
+		}
+		return refs[i].Reference < refs[j].Reference
+	})
+
+	return refs
+}
+
+// visibleCharts returns the known charts the user of a request may pull, so
+// catalogs only list the repositories of tenants and policies they can use.
+func visibleCharts(ctx context.Context) []chartRef {
+	var visible []chartRef
+	for _, ref := range knownCharts() {
+		if mayPull(ctx, ref.Name) {
+			visible = append(visible, ref)
+		}
+	}
+
+	return visible
+}
+
+// describeChart resolves ref and returns its manifest and Chart.yaml
+// metadata.
+func describeChart(ctx context.Context, ref chartRef) (*Manifest, Chart, error) {
+	var chart Chart
+
+	manifest, err := resolveManifest(ctx, ref.Name, ref.Reference)
+	if err != nil {
+		return nil, chart, err
+	}
+
+	config, ok, err := store.Get(manifest.Config.Digest)
+	if err != nil {
+		return nil, chart, err
+	}
+	if !ok {
+		return nil, chart, fmt.Errorf("config blob %s missing from store", manifest.Config.Digest)
+	}
+	if err := json.Unmarshal(config, &chart); err != nil {
+		return nil, chart, err
+	}
+
+	return manifest, chart, nil
+}
+
+// initCatalog validates the -list-chart flags.
+func initCatalog() error {
+	for _, f := range catalogFlags {
+		name, reference, ok := strings.Cut(f, ":")
+		if !ok || !validName(name) || reference == "" {
+			return fmt.Errorf("invalid chart %q: expected name:reference", f)
+		}
+	}
+
+	return nil
+}
+
+// listCharts lists every chart directory under -chart-dir at the version in
+// its Chart.yaml.
+func (dirGenerator) listCharts() []chartRef {
+	if *chartDir == "" {
+		return nil
+	}
+
+	var refs []chartRef
+	filepath.WalkDir(*chartDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "Chart.yaml" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(*chartDir, filepath.Dir(p))
+		if err != nil || rel == "." {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		var chart Chart
+		if err := yaml.Unmarshal(data, &chart); err != nil || chart.Version == "" {
+			return nil
+		}
+
+		refs = append(refs, chartRef{Name: filepath.ToSlash(rel), Reference: chart.Version})
+		// Charts nested in a chart are its subcharts.
+		return filepath.SkipDir
+	})
+
+	return refs
+}
+
+// listCharts lists every VirtualChart at its declared versions, or the
+// default version when any reference is served.
+func (g *crdGenerator) listCharts() []chartRef {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var refs []chartRef
+	for _, vc := range g.byKey {
+		versions := vc.Spec.Versions
+		if len(versions) == 0 {
+			versions = []string{*defaultChartVersion}
+		}
+		for _, v := range versions {
+			refs = append(refs, chartRef{Name: vc.repository(), Reference: v})
+		}
+	}
+
+	return refs
+}
+
+// listCharts lists every repository assembled from ConfigMaps and Secrets at
+// the default version.
+func (g *configMapGenerator) listCharts() []chartRef {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var refs []chartRef
+	for _, byKey := range g.objects {
+		for _, o := range byKey {
+			refs = append(refs, chartRef{Name: o.repository(), Reference: *defaultChartVersion})
+		}
+	}
+
+	return refs
+}
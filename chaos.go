@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	chaosPercent  = flag.Float64("chaos-percent", 0, "percentage of registry requests that fail at random, in one of the -chaos-modes (disabled when 0)")
+	chaosStatuses = flag.String("chaos-status", "500,502,503", "comma-separated statuses random failures of the status mode respond with")
+	chaosModes    = flag.String("chaos-modes", "status", "comma-separated ways random failures happen: status responds with a -chaos-status, reset sends half the body and resets the connection, truncate sends half the body and closes the connection")
+)
+
+var (
+	chaosStatusList []int
+	chaosModeList   []string
+)
+
+// initChaos parses the chaos mode flags.
+func initChaos() error {
+	if *chaosPercent == 0 {
+		return nil
+	}
+	if *chaosPercent < 0 || *chaosPercent > 100 {
+		return fmt.Errorf("invalid -chaos-percent %v: expected 0 to 100", *chaosPercent)
+	}
+
+	for _, s := range strings.Split(*chaosStatuses, ",") {
+		status, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || status < 400 || status > 599 {
+			return fmt.Errorf("invalid -chaos-status %q: expected error statuses", *chaosStatuses)
+		}
+		chaosStatusList = append(chaosStatusList, status)
+	}
+
+	for _, mode := range strings.Split(*chaosModes, ",") {
+		mode = strings.TrimSpace(mode)
+		switch mode {
+		case "status", "reset", "truncate":
+			chaosModeList = append(chaosModeList, mode)
+		default:
+			return fmt.Errorf("invalid -chaos-modes %q: expected status, reset or truncate", *chaosModes)
+		}
+	}
+
+	return nil
+}
+
+// chaosMiddleware fails -chaos-percent of the registry requests.
+func chaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := requestEndpoint(r); !ok || random.Float64()*100 >= *chaosPercent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mode := chaosModeList[random.IntN(len(chaosModeList))]
+		logger(r.Context()).Info("injecting random failure", "mode", mode)
+		if mode == "status" {
+			status := chaosStatusList[random.IntN(len(chaosStatusList))]
+			writeError(w, status, ErrCodeUnknown, "injected random failure", nil)
+			return
+		}
+
+		next.ServeHTTP(&brokenWriter{ResponseWriter: w, reset: mode == "reset"}, r)
+	})
+}
+
+// brokenWriter passes on half of the response body and then aborts the
+// connection, either closing it or, with reset, resetting it.
+type brokenWriter struct {
+	http.ResponseWriter
+	reset bool
+	// remaining is how much more of the body is passed on once started.
+	remaining int
+	started   bool
+}
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		size := len(p)
+		if length, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+			size = length
+		}
+		w.remaining = size / 2
+	}
+
+	if len(p) <= w.remaining {
+		w.remaining -= len(p)
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.ResponseWriter.Write(p[:w.remaining])
+	http.NewResponseController(w.ResponseWriter).Flush()
+	w.abort()
+	return w.remaining, http.ErrAbortHandler
+}
+
+// abort drops the connection. Resets hijack the connection to close it
+// without lingering, which sends a TCP RST; connections that cannot be
+// hijacked, such as HTTP/2 streams, are aborted instead.
+func (w *brokenWriter) abort() {
+	if w.reset {
+		conn, _, err := http.NewResponseController(w.ResponseWriter).Hijack()
+		if err == nil {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+		}
+	}
+
+	panic(http.ErrAbortHandler)
+}
+
+func (w *brokenWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
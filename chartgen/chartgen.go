@@ -0,0 +1,69 @@
+// Package chartgen defines the interface between virtual-helm and chart
+// generators, so generators can be built outside the server, for example as
+// Go plugins loaded with -plugin-dir.
+package chartgen
+
+import "context"
+
+// Chart is the Chart.yaml metadata of a generated chart.
+type Chart struct {
+	ApiVersion  string `json:"apiVersion"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion"`
+
+	Home         string            `json:"home,omitempty"`
+	Sources      []string          `json:"sources,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Maintainers  []*Maintainer     `json:"maintainers,omitempty"`
+	Icon         string            `json:"icon,omitempty"`
+	KubeVersion  string            `json:"kubeVersion,omitempty"`
+	Deprecated   bool              `json:"deprecated,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Dependencies []*Dependency     `json:"dependencies,omitempty"`
+}
+
+// Maintainer is an entry of the maintainers list in Chart.yaml.
+type Maintainer struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Dependency is an entry of the dependencies list in Chart.yaml and
+// Chart.lock.
+type Dependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Repository string `json:"repository"`
+}
+
+// Request identifies the chart a client asked for.
+type Request struct {
+	Name      string
+	Reference string
+	// Parameters holds values encoded in the pulled tag; Reference is then
+	// the tag with the parameters stripped.
+	Parameters map[string]string
+}
+
+// File is a single file in a generated chart archive.
+type File struct {
+	Name string
+	Data []byte
+	Mode int64
+}
+
+// Result is the output of a Generator: the Chart.yaml metadata served as the
+// config blob and the files packaged into the content layer.
+type Result struct {
+	Chart Chart
+	Files []File
+}
+
+// Generator produces charts on demand.
+type Generator interface {
+	Generate(ctx context.Context, req Request) (*Result, error)
+}
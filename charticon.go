@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var chartIconBaseURL = flag.String("chart-icon-base-url", "", "URL clients reach this server at, such as https://charts.example.com, for the icon URLs of -chart-icon in Chart.yaml")
+
+var chartIconFlags stringList
+
+func init() {
+	flag.Var(&chartIconFlags, "chart-icon", "PNG, SVG, JPEG, GIF or WebP icon bundled into generated charts and referenced from the icon field of their Chart.yaml, as [pattern=]file; applies to all charts without a pattern (repeatable, later icons override earlier ones)")
+}
+
+// iconExtensions are the icon formats -chart-icon accepts.
+var iconExtensions = map[string]bool{".png": true, ".svg": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true}
+
+// chartIcon is an icon served under /icons/ by the digest of its content,
+// so its URL stays the same for as long as the icon does.
+type chartIcon struct {
+	file string
+	data []byte
+}
+
+type chartIconRule struct {
+	pattern string
+	icon    *chartIcon
+}
+
+var (
+	chartIconRules []chartIconRule
+	chartIcons     = make(map[string]*chartIcon)
+)
+
+// initChartIcons parses the -chart-icon flags and loads their files.
+func initChartIcons() error {
+	for _, f := range chartIconFlags {
+		pattern, file, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, file = "", f
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid chart icon %q: %w", f, err)
+		}
+		ext := strings.ToLower(filepath.Ext(file))
+		if !iconExtensions[ext] {
+			return fmt.Errorf("invalid chart icon %q: expected a .png, .svg, .jpg, .gif or .webp file", f)
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("invalid chart icon %q: %w", f, err)
+		}
+		sum := sha256.Sum256(data)
+		icon := &chartIcon{file: hex.EncodeToString(sum[:]) + ext, data: data}
+		chartIcons[icon.file] = icon
+		chartIconRules = append(chartIconRules, chartIconRule{pattern: pattern, icon: icon})
+	}
+
+	if len(chartIconRules) > 0 && *chartIconBaseURL == "" {
+		return fmt.Errorf("-chart-icon requires -chart-icon-base-url")
+	}
+
+	return nil
+}
+
+// chartIconFor returns the icon of the last -chart-icon matching name.
+func chartIconFor(name string) *chartIcon {
+	var icon *chartIcon
+	for _, rule := range chartIconRules {
+		if ok, _ := path.Match(rule.pattern, name); ok || rule.pattern == "" {
+			icon = rule.icon
+		}
+	}
+
+	return icon
+}
+
+// iconURL returns the URL icon is served at.
+func iconURL(icon *chartIcon) string {
+	return strings.TrimSuffix(*chartIconBaseURL, "/") + "/icons/" + icon.file
+}
+
+// applyChartIcon points the icon field of chart at the -chart-icon of name.
+func applyChartIcon(chart *Chart, name string) {
+	if icon := chartIconFor(name); icon != nil {
+		chart.Icon = iconURL(icon)
+	}
+}
+
+// addChartIcon bundles the -chart-icon of name into out, next to its
+// Chart.yaml, when the chart references it.
+func addChartIcon(out *GeneratedChart, name string) {
+	icon := chartIconFor(name)
+	if icon == nil || out.Chart.Icon != iconURL(icon) {
+		return
+	}
+
+	out.Files = append(out.Files, ChartFile{Name: chartFilePrefix(out) + "icon" + path.Ext(icon.file), Data: icon.data})
+}
+
+// registerChartIconRoutes serves the -chart-icon files.
+func registerChartIconRoutes(r *mux.Router) {
+	if len(chartIcons) == 0 {
+		return
+	}
+
+	r.HandleFunc("/icons/{file}", handleChartIcon).Methods("GET", "HEAD")
+}
+
+func handleChartIcon(w http.ResponseWriter, r *http.Request) {
+	icon, ok := chartIcons[mux.Vars(r)["file"]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(icon.file)))
+	// The URL changes with the icon.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(icon.data)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/yaml"
+)
+
+var chartMetadataFlags stringList
+
+func init() {
+	flag.Var(&chartMetadataFlags, "chart-metadata", "YAML file of Chart.yaml fields (description, home, sources, keywords, maintainers, icon, kubeVersion, deprecated, annotations) for generated charts, as [pattern=]file; applies to all charts without a pattern (repeatable, later files override earlier ones)")
+}
+
+// chartMetadataFields are the Chart.yaml fields -chart-metadata may set.
+var chartMetadataFields = map[string]bool{
+	"description": true,
+	"home":        true,
+	"sources":     true,
+	"keywords":    true,
+	"maintainers": true,
+	"icon":        true,
+	"kubeVersion": true,
+	"deprecated":  true,
+	"annotations": true,
+}
+
+type chartMetadataRule struct {
+	pattern  string
+	metadata []byte
+}
+
+var chartMetadataRules []chartMetadataRule
+
+// initChartMetadata parses the -chart-metadata flags and loads their files.
+func initChartMetadata() error {
+	for _, f := range chartMetadataFlags {
+		pattern, file, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, file = "", f
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid chart metadata %q: %w", f, err)
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("invalid chart metadata %q: %w", f, err)
+		}
+
+		var fields map[string]interface{}
+		if err := yaml.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("invalid chart metadata %s: %w", file, err)
+		}
+		for field := range fields {
+			if !chartMetadataFields[field] {
+				return fmt.Errorf("invalid chart metadata %s: unsupported field %q", file, field)
+			}
+		}
+
+		metadata, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		// Unmarshal once up front so type errors surface at startup.
+		var chart Chart
+		if err := json.Unmarshal(metadata, &chart); err != nil {
+			return fmt.Errorf("invalid chart metadata %s: %w", file, err)
+		}
+		if chart.KubeVersion != "" {
+			if _, err := semver.NewConstraint(chart.KubeVersion); err != nil {
+				return fmt.Errorf("invalid chart metadata %s: kubeVersion: %w", file, err)
+			}
+		}
+
+		chartMetadataRules = append(chartMetadataRules, chartMetadataRule{pattern: pattern, metadata: metadata})
+	}
+
+	return nil
+}
+
+// applyChartMetadata sets the -chart-metadata fields configured for name on
+// chart.
+func applyChartMetadata(chart *Chart, name string) {
+	for _, rule := range chartMetadataRules {
+		if rule.pattern != "" {
+			if ok, _ := path.Match(rule.pattern, name); !ok {
+				continue
+			}
+		}
+
+		// Validated by initChartMetadata.
+		_ = json.Unmarshal(rule.metadata, chart)
+	}
+}
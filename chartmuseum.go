@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"sigs.k8s.io/yaml"
+)
+
+var chartMuseumAPI = flag.Bool("chartmuseum-api", false, "serve the ChartMuseum API under /api/charts; uploaded charts are served over OCI by the \"upload\" generator")
+
+func init() {
+	generators["upload"] = uploadedCharts
+}
+
+// uploadedChart is a chart archive uploaded through the ChartMuseum API.
+type uploadedChart struct {
+	chart   Chart
+	digest  string
+	files   []ChartFile
+	created time.Time
+}
+
+// uploadGenerator serves uploaded charts by name and version.
+type uploadGenerator struct {
+	mu sync.RWMutex
+	// charts holds uploads by chart name, then version.
+	charts map[string]map[string]*uploadedChart
+}
+
+var uploadedCharts = &uploadGenerator{charts: make(map[string]map[string]*uploadedChart)}
+
+func (g *uploadGenerator) lookup(name string, reference string) (*uploadedChart, bool, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	versions, ok := g.charts[name]
+	if !ok {
+		return nil, false, false
+	}
+	if version, ok := referenceVersion(reference); ok {
+		reference = version
+	}
+
+	upload, ok := versions[reference]
+	return upload, true, ok
+}
+
+func (g *uploadGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	upload, known, ok := g.lookup(req.Name, req.Reference)
+	if !known {
+		return nil, errChartNotFound
+	}
+	if !ok {
+		return nil, errReferenceNotFound
+	}
+
+	return &GeneratedChart{Chart: upload.chart, Files: upload.files}, nil
+}
+
+func (g *uploadGenerator) listCharts() []chartRef {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var refs []chartRef
+	for name, versions := range g.charts {
+		for version := range versions {
+			refs = append(refs, chartRef{Name: name, Reference: version})
+		}
+	}
+
+	return refs
+}
+
+var errChartExists = errors.New("file already exists")
+
+// add stores an uploaded archive parsed by parseChartArchive, refusing to
+// replace an existing version unless force is set.
+func (g *uploadGenerator) add(upload *uploadedChart, archive []byte, force bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	versions := g.charts[upload.chart.Name]
+	if versions == nil {
+		versions = make(map[string]*uploadedChart)
+		g.charts[upload.chart.Name] = versions
+	}
+	if _, ok := versions[upload.chart.Version]; ok && !force {
+		return errChartExists
+	}
+
+	if err := store.Put(upload.digest, archive); err != nil {
+		return err
+	}
+	versions[upload.chart.Version] = upload
+	generated.RemoveRepository(upload.chart.Name)
+
+	return nil
+}
+
+// remove deletes an uploaded version and reports whether it existed.
+func (g *uploadGenerator) remove(name string, version string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.charts[name][version]; !ok {
+		return false
+	}
+
+	delete(g.charts[name], version)
+	if len(g.charts[name]) == 0 {
+		delete(g.charts, name)
+	}
+	generated.RemoveRepository(name)
+
+	return true
+}
+
+// parseChartArchive reads the files and Chart.yaml of a packaged chart.
+func parseChartArchive(archive []byte) (*uploadedChart, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart archive: %w", err)
+	}
+	files, err := readTarFiles(gz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart archive: %w", err)
+	}
+
+	upload := &uploadedChart{
+		digest:  fmt.Sprintf("sha256:%x", sha256.Sum256(archive)),
+		files:   files,
+		created: now().UTC(),
+	}
+	for _, f := range files {
+		if dir, file := path.Split(f.Name); file == "Chart.yaml" && strings.Count(dir, "/") == 1 {
+			if err := yaml.Unmarshal(f.Data, &upload.chart); err != nil {
+				return nil, fmt.Errorf("invalid Chart.yaml: %w", err)
+			}
+			break
+		}
+	}
+	if upload.chart.Name == "" || upload.chart.Version == "" {
+		return nil, errors.New("chart archive has no Chart.yaml with a name and version")
+	}
+	if !validName(upload.chart.Name) || strings.Contains(upload.chart.Name, "/") {
+		return nil, fmt.Errorf("invalid chart name %q", upload.chart.Name)
+	}
+
+	return upload, nil
+}
+
+// museumChartVersion is a chart version as described by the ChartMuseum API.
+type museumChartVersion struct {
+	Chart
+	URLs    []string  `json:"urls"`
+	Created time.Time `json:"created"`
+	Digest  string    `json:"digest"`
+}
+
+func (u *uploadedChart) museumVersion() museumChartVersion {
+	return museumChartVersion{
+		Chart:   u.chart,
+		URLs:    []string{"charts/" + helmArchiveName(u.chart.Name, u.chart.Version)},
+		Created: u.created,
+		Digest:  strings.TrimPrefix(u.digest, "sha256:"),
+	}
+}
+
+// registerChartMuseumRoutes adds the ChartMuseum API to r when
+// -chartmuseum-api is set.
+func registerChartMuseumRoutes(r *mux.Router) {
+	if !*chartMuseumAPI {
+		return
+	}
+
+	r.HandleFunc("/api/charts", handleMuseumListCharts).Methods("GET")
+	r.HandleFunc("/api/charts", handleMuseumUpload).Methods("POST")
+	r.HandleFunc("/api/charts/{name}", handleMuseumListVersions).Methods("GET", "HEAD")
+	r.HandleFunc("/api/charts/{name}/{version}", handleMuseumGetVersion).Methods("GET", "HEAD")
+	r.HandleFunc("/api/charts/{name}/{version}", handleMuseumDelete).Methods("DELETE")
+	r.HandleFunc("/index.yaml", handleMuseumIndex).Methods("GET", "HEAD")
+	r.HandleFunc("/charts/{file}", handleMuseumDownload).Methods("GET", "HEAD")
+}
+
+// writeMuseumJSON writes v in the ChartMuseum API's plain JSON responses.
+func writeMuseumJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeMuseumError(w http.ResponseWriter, status int, message string) {
+	writeMuseumJSON(w, status, map[string]string{"error": message})
+}
+
+func (g *uploadGenerator) versions(name string) []museumChartVersion {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	versions := make([]museumChartVersion, 0, len(g.charts[name]))
+	for _, u := range g.charts[name] {
+		versions = append(versions, u.museumVersion())
+	}
+	// Newest first, as ChartMuseum lists them.
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Created.After(versions[j].Created) })
+
+	return versions
+}
+
+func handleMuseumListCharts(w http.ResponseWriter, r *http.Request) {
+	uploadedCharts.mu.RLock()
+	names := make([]string, 0, len(uploadedCharts.charts))
+	for name := range uploadedCharts.charts {
+		if mayPull(r.Context(), name) {
+			names = append(names, name)
+		}
+	}
+	uploadedCharts.mu.RUnlock()
+
+	charts := make(map[string][]museumChartVersion, len(names))
+	for _, name := range names {
+		charts[name] = uploadedCharts.versions(name)
+	}
+
+	writeMuseumJSON(w, http.StatusOK, charts)
+}
+
+// handleMuseumIndex serves the Helm repository index of the uploaded charts.
+func handleMuseumIndex(w http.ResponseWriter, r *http.Request) {
+	index := helmIndex{
+		APIVersion: "v1",
+		Entries:    make(map[string][]*helmIndexEntry),
+		Generated:  now().UTC(),
+	}
+	for _, ref := range uploadedCharts.listCharts() {
+		if !mayPull(r.Context(), ref.Name) {
+			continue
+		}
+		upload, _, ok := uploadedCharts.lookup(ref.Name, ref.Reference)
+		if !ok {
+			continue
+		}
+		v := upload.museumVersion()
+		index.Entries[ref.Name] = append(index.Entries[ref.Name], &helmIndexEntry{
+			Chart:   v.Chart,
+			URLs:    v.URLs,
+			Digest:  v.Digest,
+			Created: v.Created,
+		})
+	}
+
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		writeMuseumError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write(data)
+}
+
+func handleMuseumListVersions(w http.ResponseWriter, r *http.Request) {
+	versions := uploadedCharts.versions(mux.Vars(r)["name"])
+	if len(versions) == 0 {
+		writeMuseumError(w, http.StatusNotFound, "chart not found")
+		return
+	}
+
+	writeMuseumJSON(w, http.StatusOK, versions)
+}
+
+func handleMuseumGetVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	version := vars["version"]
+	if version == "latest" {
+		versions := uploadedCharts.versions(vars["name"])
+		if len(versions) == 0 {
+			writeMuseumError(w, http.StatusNotFound, "chart not found")
+			return
+		}
+		writeMuseumJSON(w, http.StatusOK, versions[0])
+		return
+	}
+
+	upload, _, ok := uploadedCharts.lookup(vars["name"], version)
+	if !ok {
+		writeMuseumError(w, http.StatusNotFound, "improper constraint: "+version)
+		return
+	}
+
+	writeMuseumJSON(w, http.StatusOK, upload.museumVersion())
+}
+
+func handleMuseumDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !uploadedCharts.remove(vars["name"], vars["version"]) {
+		writeMuseumError(w, http.StatusNotFound, "chart not found")
+		return
+	}
+
+	writeMuseumJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+// handleMuseumUpload accepts a chart archive either as the request body or
+// as the "chart" field of a multipart form, like ChartMuseum.
+func handleMuseumUpload(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	archive := body
+	if mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		var err error
+		if archive, err = multipartFile(body, params["boundary"], "chart"); err != nil {
+			writeMuseumError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	upload, err := parseChartArchive(archive)
+	if err != nil {
+		writeMuseumError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeRequest(w, r, upload.chart.Name, "pull", "push") {
+		return
+	}
+
+	_, force := r.URL.Query()["force"]
+	if err := uploadedCharts.add(upload, archive, force); err != nil {
+		if errors.Is(err, errChartExists) {
+			writeMuseumError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeMuseumError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeMuseumJSON(w, http.StatusCreated, map[string]bool{"saved": true})
+}
+
+// multipartFile returns the content of the named field of a multipart body.
+func multipartFile(body []byte, boundary string, field string) ([]byte, error) {
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing %s field", field)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field {
+			return io.ReadAll(part)
+		}
+	}
+}
+
+// handleMuseumDownload serves uploaded archives as they were uploaded, from
+// /charts/<name>-<version>.tgz.
+func handleMuseumDownload(w http.ResponseWriter, r *http.Request) {
+	file, ok := strings.CutSuffix(mux.Vars(r)["file"], ".tgz")
+	if !ok {
+		writeMuseumError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	// Versions may contain dashes too, so try every split point.
+	for i := strings.Index(file, "-"); i >= 0; i = nextIndex(file, "-", i) {
+		if upload, _, ok := uploadedCharts.lookup(file[:i], file[i+1:]); ok {
+			if !authorizeRequest(w, r, upload.chart.Name, "pull") {
+				return
+			}
+			w.Header().Set("Content-Type", "application/gzip")
+			writeBlob(w, r, upload.chart.Name, upload.digest)
+			return
+		}
+	}
+
+	writeMuseumError(w, http.StatusNotFound, "file not found")
+}
+
+// nextIndex returns the index of the next sep in s after i, or -1.
+func nextIndex(s string, sep string, i int) int {
+	j := strings.Index(s[i+1:], sep)
+	if j < 0 {
+		return -1
+	}
+
+	return i + 1 + j
+}
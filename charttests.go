@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var chartTestFlags stringList
+
+func init() {
+	flag.Var(&chartTestFlags, "chart-tests", "include a test pod with the helm.sh/hook: test annotation in charts of the default generator, for exercising `helm test`, as [pattern=]true|false; applies to all repositories without a pattern (repeatable, later flags override earlier ones)")
+}
+
+type chartTestRule struct {
+	pattern string
+	enabled bool
+}
+
+var chartTestRules []chartTestRule
+
+// initChartTests parses the -chart-tests flags.
+func initChartTests() error {
+	for _, f := range chartTestFlags {
+		pattern, value, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, value = "", f
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid chart tests %q: %w", f, err)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid chart tests %q: expected [pattern=]true|false", f)
+		}
+
+		chartTestRules = append(chartTestRules, chartTestRule{pattern: pattern, enabled: enabled})
+	}
+
+	return nil
+}
+
+// chartTestsEnabled reports whether the charts of name include a test pod.
+func chartTestsEnabled(name string) bool {
+	enabled := false
+	for _, rule := range chartTestRules {
+		if rule.pattern != "" {
+			if ok, _ := path.Match(rule.pattern, name); !ok {
+				continue
+			}
+		}
+		enabled = rule.enabled
+	}
+
+	return enabled
+}
+
+// withChartTests adds the test pod and its values to the files of the
+// default chart.
+func withChartTests(files []ChartFile) []ChartFile {
+	for i, f := range files {
+		if f.Name == "values.yaml" {
+			files[i].Data = append(f.Data[:len(f.Data):len(f.Data)], defaultTestValues...)
+		}
+	}
+
+	return append(files, ChartFile{Name: "templates/tests/test.yaml", Data: []byte(defaultTest)})
+}
+
+const defaultTestValues = `
+# The pod run by helm test; a non-zero exitCode makes the test fail.
+test:
+  image: busybox
+  exitCode: 0
+`
+
+const defaultTest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: "{{ include "chart.fullname" . }}-test"
+  labels:
+    {{- include "chart.labels" . | nindent 4 }}
+  annotations:
+    "helm.sh/hook": test
+    "helm.sh/hook-delete-policy": before-hook-creation
+spec:
+  restartPolicy: Never
+  containers:
+    - name: test
+      image: {{ .Values.test.image }}
+      command:
+        - sh
+        - -c
+        - echo "testing {{ .Release.Name }}"; exit {{ .Values.test.exitCode | int }}
+`
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	randv2 "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	deterministic     = flag.Bool("deterministic", false, "make responses byte-for-byte reproducible for golden-file tests: the clock stands still at -deterministic-time, UUIDs, signature randomness, -chaos-percent failures and -fault garbage come from -deterministic-seed, and digests are stable as with -stable-digests")
+	deterministicTime = flag.String("deterministic-time", "2000-01-01T00:00:00Z", "RFC 3339 time the clock stands at with -deterministic")
+	deterministicSeed = flag.Int64("deterministic-seed", 1, "seed of the UUIDs and randomness with -deterministic")
+)
+
+// now is the clock of everything the server records or returns, such as
+// timestamps in index.yaml, tokens and events. Durations are measured with
+// the real clock. Embedders and tests may replace it.
+var now = time.Now
+
+// newUUID returns a new UUID, such as an upload session or request ID.
+// Embedders and tests may replace it.
+var newUUID = uuid.NewString
+
+// randReader is the randomness signatures, random failures and corrupted
+// bodies are made with. Embedders and tests may replace it.
+var randReader io.Reader = rand.Reader
+
+// random draws the random numbers of -chaos-percent from randReader.
+var random = randv2.New(readerSource{})
+
+// readerSource is a math/rand source reading from randReader.
+type readerSource struct{}
+
+func (readerSource) Uint64() uint64 {
+	var b [8]byte
+	io.ReadFull(randReader, b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// initDeterministic fixes the clock, UUIDs and randomness for -deterministic.
+func initDeterministic() error {
+	if !*deterministic {
+		return nil
+	}
+
+	fixed, err := time.Parse(time.RFC3339, *deterministicTime)
+	if err != nil {
+		return fmt.Errorf("invalid -deterministic-time: %w", err)
+	}
+	now = func() time.Time { return fixed }
+
+	seeded := &lockedReader{r: mathrand.New(mathrand.NewSource(*deterministicSeed))}
+	randReader = seeded
+	newUUID = func() string {
+		return uuid.Must(uuid.NewRandomFromReader(seeded)).String()
+	}
+
+	*stableDigests = true
+	return nil
+}
+
+// lockedReader serializes reads from a reader that is not safe for
+// concurrent use.
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.r.Read(p)
+}
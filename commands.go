@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// commands are the subcommands run instead of the server when named as the
+// first argument.
+var commands = map[string]func(args []string) error{
+	"serve":    runServe,
+	"generate": runGenerate,
+	"push":     runPush,
+	"digest":   runDigest,
+	"bench":    runBench,
+	"version":  runVersion,
+	"purge":    runPurge,
+	"export":   runExport,
+	"import":   runImport,
+	"snapshot": runSnapshot,
+	"restore":  runRestore,
+}
+
+// adminClient calls the admin API of a running server.
+type adminClient struct {
+	server string
+	token  string
+}
+
+// adminClientFlags registers the flags locating the admin API on fs.
+func adminClientFlags(fs *flag.FlagSet) func() (*adminClient, error) {
+	server := fs.String("server", "http://localhost:5000", "base URL of the virtual-helm server")
+	tokenFile := fs.String("admin-token-file", "", "file holding the admin API bearer token")
+
+	return func() (*adminClient, error) {
+		if *tokenFile == "" {
+			return nil, fmt.Errorf("-admin-token-file is required")
+		}
+		token, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return &adminClient{server: strings.TrimSuffix(*server, "/"), token: string(bytes.TrimSpace(token))}, nil
+	}
+}
+
+// do sends an admin API request and decodes the JSON response into out, or
+// copies the response to out when it is an io.Writer.
+func (c *adminClient) do(method string, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, e.Errors[0].Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case io.Writer:
+		_, err := io.Copy(out, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runPurge implements `virtual-helm purge [flags] [repository]`.
+func runPurge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: virtual-helm purge [flags] [repository]\n\nEvicts the generation cache of a running server, or only the charts of a repository.")
+		fs.PrintDefaults()
+	}
+	client := adminClientFlags(fs)
+	blobs := fs.Bool("blobs", false, "also delete the blobs of the repository")
+	fs.Parse(args)
+
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var result PurgeResult
+	switch fs.NArg() {
+	case 0:
+		if *blobs {
+			return fmt.Errorf("-blobs requires a repository")
+		}
+		err = c.do("DELETE", "/admin/cache", nil, &result)
+	case 1:
+		path := "/admin/repositories/" + fs.Arg(0)
+		if *blobs {
+			path += "?" + url.Values{"blobs": {"true"}}.Encode()
+		}
+		err = c.do("DELETE", path, nil, &result)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("evicted %d cached charts, deleted %d blobs\n", result.Evicted, result.BlobsDeleted)
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// notModified sets the ETag of content with digest and, when the
+// If-None-Match of r names it, writes 304 Not Modified and returns true, so
+// caches and clients checking freshness skip the body.
+func notModified(w http.ResponseWriter, r *http.Request, digest string) bool {
+	etag := `"` + digest + `"`
+	w.Header().Set("ETag", etag)
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		// Weak and strong comparison are the same for content by digest.
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
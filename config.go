@@ -0,0 +1,230 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.yaml.in/yaml/v3"
+)
+
+var configFile = flag.String("config", "", "YAML file of options, keyed by flag name; nested maps join their keys with -, so tls: {cert: ...} sets -tls-cert, lists set repeatable flags, and a repositories list configures per-repository options. Flags given on the command line take precedence, and the file over VIRTUAL_HELM_* environment variables")
+
+// repositoryOptions are the keys of a repositories entry and the pattern
+// flags they set for the entry's pattern.
+var repositoryOptions = map[string]func(pattern string, value string) (string, string){
+	"generator":       func(p, v string) (string, string) { return "generator", p + "=" + v },
+	"upstream":        func(p, v string) (string, string) { return "generator", p + "=" + upstreamRoutePrefix + v },
+	"chart-metadata":  func(p, v string) (string, string) { return "chart-metadata", p + "=" + v },
+	"chart-icon":      func(p, v string) (string, string) { return "chart-icon", p + "=" + v },
+	"chart-tests":     func(p, v string) (string, string) { return "chart-tests", p + "=" + v },
+	"crds":            func(p, v string) (string, string) { return "crds", p + "=" + v },
+	"dependency":      func(p, v string) (string, string) { return "dependency", p + "=" + v },
+	"immutable-tags":  func(p, v string) (string, string) { return "immutable-tags", p + "=" + v },
+	"quota-bytes":     func(p, v string) (string, string) { return "quota-bytes", p + "=" + v },
+	"quota-manifests": func(p, v string) (string, string) { return "quota-manifests", p + "=" + v },
+}
+
+// loadConfig completes the command line with the -config file and then the
+// VIRTUAL_HELM_* environment, so options are taken from flags first, then the
+// file, then the environment. It must run right after flag.Parse.
+func loadConfig() error {
+	commandLineFlags = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+
+	if *configFile == "" {
+		*configFile = os.Getenv(envPrefix + "CONFIG")
+	}
+
+	fromFile, err := loadConfigFile(flag.CommandLine, false)
+	if err != nil {
+		return err
+	}
+
+	return loadEnv(flag.CommandLine, fromFile)
+}
+
+// commandLineFlags holds the names of the flags given on the command line.
+var commandLineFlags map[string]bool
+
+// loadConfigFile applies the -config file to every flag of fs not given on
+// the command line and returns the names of the flags it set. With partial,
+// fs may hold only some of the options and the others are skipped.
+func loadConfigFile(fs *flag.FlagSet, partial bool) (map[string]bool, error) {
+	c := &configLoader{fs: fs, partial: partial, applied: make(map[string]bool)}
+	if *configFile == "" {
+		return c.applied, nil
+	}
+
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", *configFile, err)
+	}
+	if len(doc.Content) == 0 {
+		return c.applied, nil
+	}
+
+	if err := c.options(doc.Content[0], ""); err != nil {
+		return nil, err
+	}
+
+	return c.applied, nil
+}
+
+// configLoader sets flags from the nodes of a config file.
+type configLoader struct {
+	fs      *flag.FlagSet
+	partial bool
+	// applied holds the names of the flags set so far.
+	applied map[string]bool
+}
+
+// errorf returns an error pointing at the line of node.
+func (c *configLoader) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", *configFile, node.Line, fmt.Sprintf(format, args...))
+}
+
+// options sets the flags of a mapping whose keys are prefixed by prefix.
+func (c *configLoader) options(node *yaml.Node, prefix string) error {
+	if node.Kind != yaml.MappingNode {
+		return c.errorf(node, "expected a mapping of options")
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := prefix + key.Value
+
+		if name == "config" {
+			return c.errorf(key, "config files cannot include other config files")
+		}
+		if name == "repositories" {
+			if err := c.repositories(value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f := c.fs.Lookup(name)
+		if f == nil {
+			if c.partial && flag.Lookup(name) != nil {
+				continue
+			}
+			if value.Kind == yaml.MappingNode {
+				if err := c.options(value, name+"-"); err != nil {
+					return err
+				}
+				continue
+			}
+			return c.errorf(key, "unknown option %q", name)
+		}
+
+		if err := c.set(f, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set gives a flag the scalar or, for repeatable flags, list value of node.
+func (c *configLoader) set(f *flag.Flag, node *yaml.Node) error {
+	if commandLineFlags[f.Name] {
+		return nil
+	}
+
+	_, repeatable := f.Value.(*stringList)
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return c.setValue(f.Name, node)
+	case yaml.SequenceNode:
+		if !repeatable {
+			return c.errorf(node, "option %q takes a single value, not a list", f.Name)
+		}
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return c.errorf(item, "option %q expects a list of values", f.Name)
+			}
+			if err := c.setValue(f.Name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return c.errorf(node, "option %q expects a value", f.Name)
+	}
+}
+
+func (c *configLoader) setValue(name string, node *yaml.Node) error {
+	if err := c.fs.Set(name, node.Value); err != nil {
+		return c.errorf(node, "invalid value %q for option %q: %v", node.Value, name, err)
+	}
+	c.applied[name] = true
+
+	return nil
+}
+
+// repositories sets the pattern flags of a list of per-repository entries:
+//
+//	repositories:
+//	  - pattern: apps/*
+//	    generator: dir
+//	    chart-metadata: apps.yaml
+func (c *configLoader) repositories(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return c.errorf(node, "repositories expects a list of entries with a pattern")
+	}
+
+	for _, entry := range node.Content {
+		if entry.Kind != yaml.MappingNode {
+			return c.errorf(entry, "repositories entries must be mappings")
+		}
+
+		var pattern string
+		for i := 0; i < len(entry.Content); i += 2 {
+			if entry.Content[i].Value == "pattern" {
+				pattern = entry.Content[i+1].Value
+			}
+		}
+		if pattern == "" {
+			return c.errorf(entry, "repositories entry has no pattern")
+		}
+
+		for i := 0; i < len(entry.Content); i += 2 {
+			key, value := entry.Content[i], entry.Content[i+1]
+			if key.Value == "pattern" {
+				continue
+			}
+			option, ok := repositoryOptions[key.Value]
+			if !ok {
+				return c.errorf(key, "unknown repository option %q; expected one of generator, upstream, chart-metadata, chart-icon, chart-tests, crds, dependency, immutable-tags, quota-bytes or quota-manifests", key.Value)
+			}
+
+			values := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				values = value.Content
+			}
+			for _, v := range values {
+				if v.Kind != yaml.ScalarNode {
+					return c.errorf(v, "repository option %q expects a value", key.Value)
+				}
+				name, flagValue := option(pattern, v.Value)
+				if commandLineFlags[name] || (c.partial && c.fs.Lookup(name) == nil) {
+					continue
+				}
+				if err := c.fs.Set(name, flagValue); err != nil {
+					return c.errorf(v, "invalid value %q for repository option %q: %v", v.Value, key.Value, err)
+				}
+				c.applied[name] = true
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"path"
+	"sort"
+	"sync"
+)
+
+var (
+	watchConfigMaps   = flag.Bool("watch-configmaps", false, "serve charts whose files are stored in labeled ConfigMaps and Secrets through the \"configmap\" generator")
+	configMapSelector = flag.String("configmap-selector", "virtualhelm.io/chart=true", "label selector for ConfigMaps and Secrets holding chart files")
+)
+
+// Annotations on chart source ConfigMaps and Secrets.
+const (
+	// annotationRepository names the repository the files belong to,
+	// defaulting to the object name.
+	annotationRepository = "virtualhelm.io/repository"
+	// annotationDirectory is the chart directory the object's keys are
+	// placed in, e.g. "templates", since keys cannot contain slashes.
+	annotationDirectory = "virtualhelm.io/directory"
+)
+
+// chartSourceObject is a ConfigMap or Secret holding chart files.
+type chartSourceObject struct {
+	Metadata struct {
+		kubeObjectMeta
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	// Data holds ConfigMap data as strings. Secret data is base64 encoded
+	// like ConfigMap binaryData, so it is decoded into BinaryData instead.
+	Data       map[string]string `json:"data"`
+	BinaryData map[string][]byte `json:"binaryData"`
+}
+
+func (o *chartSourceObject) repository() string {
+	if repo := o.Metadata.Annotations[annotationRepository]; repo != "" {
+		return repo
+	}
+
+	return o.Metadata.Name
+}
+
+func (o *chartSourceObject) files() []ChartFile {
+	dir := o.Metadata.Annotations[annotationDirectory]
+
+	var files []ChartFile
+	for key, value := range o.Data {
+		files = append(files, ChartFile{Name: path.Join(dir, key), Data: []byte(value)})
+	}
+	for key, value := range o.BinaryData {
+		files = append(files, ChartFile{Name: path.Join(dir, key), Data: value})
+	}
+
+	return files
+}
+
+func init() {
+	generators["configmap"] = configMapCharts
+}
+
+var configMapCharts = &configMapGenerator{objects: make(map[string]map[string]*chartSourceObject)}
+
+// configMapGenerator serves charts assembled from every watched ConfigMap
+// and Secret annotated with the requested repository.
+type configMapGenerator struct {
+	mu sync.RWMutex
+	// objects holds the watched objects by kind, then namespace/name.
+	objects map[string]map[string]*chartSourceObject
+}
+
+func (g *configMapGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	var files []ChartFile
+
+	g.mu.RLock()
+	for _, byKey := range g.objects {
+		for _, o := range byKey {
+			if o.repository() == req.Name {
+				files = append(files, o.files()...)
+			}
+		}
+	}
+	g.mu.RUnlock()
+
+	if len(files) == 0 {
+		return nil, errChartNotFound
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	files, err := withChartYaml(files, defaultChart(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// syncKind returns the informer callbacks maintaining objects of one kind.
+func (g *configMapGenerator) syncKind(kind string) (func([]json.RawMessage), func(kubeWatchEvent)) {
+	set := func(items []json.RawMessage) {
+		byKey := make(map[string]*chartSourceObject)
+		for _, raw := range items {
+			if o := decodeChartSourceObject(kind, raw); o != nil {
+				byKey[o.Metadata.Namespace+"/"+o.Metadata.Name] = o
+			}
+		}
+
+		g.mu.Lock()
+		g.objects[kind] = byKey
+		g.mu.Unlock()
+
+		n := generated.Purge()
+		slog.Info("synced chart source objects", "kind", kind, "objects", len(byKey), "evicted", n)
+	}
+
+	apply := func(e kubeWatchEvent) {
+		o := decodeChartSourceObject(kind, e.Object)
+		if o == nil {
+			return
+		}
+
+		key := o.Metadata.Namespace + "/" + o.Metadata.Name
+		g.mu.Lock()
+		byKey := g.objects[kind]
+		if byKey == nil {
+			byKey = make(map[string]*chartSourceObject)
+			g.objects[kind] = byKey
+		}
+		if old, ok := byKey[key]; ok {
+			generated.RemoveRepository(old.repository())
+		}
+		if e.Type == "DELETED" {
+			delete(byKey, key)
+		} else {
+			byKey[key] = o
+		}
+		g.mu.Unlock()
+
+		generated.RemoveRepository(o.repository())
+		slog.Info("chart source object changed", "kind", kind, "event", e.Type, "object", key, "repository", o.repository())
+	}
+
+	return set, apply
+}
+
+func decodeChartSourceObject(kind string, raw json.RawMessage) *chartSourceObject {
+	var o chartSourceObject
+	if err := json.Unmarshal(raw, &o); err != nil {
+		slog.Warn("ignoring invalid chart source object", "kind", kind, "error", err)
+		return nil
+	}
+
+	if kind == "secrets" {
+		var secret struct {
+			Data map[string][]byte `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &secret); err != nil {
+			slog.Warn("ignoring invalid chart source object", "kind", kind, "error", err)
+			return nil
+		}
+		o.Data = nil
+		o.BinaryData = secret.Data
+	}
+
+	return &o
+}
+
+// startConfigMapWatch follows labeled ConfigMaps and Secrets in the
+// background.
+func startConfigMapWatch(ctx context.Context) error {
+	if !*watchConfigMaps {
+		return nil
+	}
+
+	client, err := newKubeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range []string{"configmaps", "secrets"} {
+		set, apply := configMapCharts.syncKind(resource)
+		go client.informer(ctx, client.resourcePath("", "v1", resource), *configMapSelector, set, apply)
+	}
+
+	return nil
+}
@@ -0,0 +1,952 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: controlpb/control.proto
+
+package controlpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ListGeneratorsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListGeneratorsRequest) Reset() {
+	*x = ListGeneratorsRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListGeneratorsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListGeneratorsRequest) ProtoMessage() {}
+
+func (x *ListGeneratorsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListGeneratorsRequest.ProtoReflect.Descriptor instead.
+func (*ListGeneratorsRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{0}
+}
+
+type ListGeneratorsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Generators are the names routes can assign.
+	Generators []string `protobuf:"bytes,1,rep,name=generators,proto3" json:"generators,omitempty"`
+	// Routes are checked in order; the first matching one wins.
+	Routes        []*GeneratorRoute `protobuf:"bytes,2,rep,name=routes,proto3" json:"routes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListGeneratorsResponse) Reset() {
+	*x = ListGeneratorsResponse{}
+	mi := &file_controlpb_control_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListGeneratorsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListGeneratorsResponse) ProtoMessage() {}
+
+func (x *ListGeneratorsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListGeneratorsResponse.ProtoReflect.Descriptor instead.
+func (*ListGeneratorsResponse) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListGeneratorsResponse) GetGenerators() []string {
+	if x != nil {
+		return x.Generators
+	}
+	return nil
+}
+
+func (x *ListGeneratorsResponse) GetRoutes() []*GeneratorRoute {
+	if x != nil {
+		return x.Routes
+	}
+	return nil
+}
+
+// GeneratorRoute assigns the repositories matching a pattern to a
+// generator, or to an upstream registry as upstream:name.
+type GeneratorRoute struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pattern       string                 `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	Generator     string                 `protobuf:"bytes,2,opt,name=generator,proto3" json:"generator,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GeneratorRoute) Reset() {
+	*x = GeneratorRoute{}
+	mi := &file_controlpb_control_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GeneratorRoute) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GeneratorRoute) ProtoMessage() {}
+
+func (x *GeneratorRoute) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GeneratorRoute.ProtoReflect.Descriptor instead.
+func (*GeneratorRoute) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GeneratorRoute) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *GeneratorRoute) GetGenerator() string {
+	if x != nil {
+		return x.Generator
+	}
+	return ""
+}
+
+type SetGeneratorRoutesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Routes        []*GeneratorRoute      `protobuf:"bytes,1,rep,name=routes,proto3" json:"routes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetGeneratorRoutesRequest) Reset() {
+	*x = SetGeneratorRoutesRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetGeneratorRoutesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetGeneratorRoutesRequest) ProtoMessage() {}
+
+func (x *SetGeneratorRoutesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetGeneratorRoutesRequest.ProtoReflect.Descriptor instead.
+func (*SetGeneratorRoutesRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SetGeneratorRoutesRequest) GetRoutes() []*GeneratorRoute {
+	if x != nil {
+		return x.Routes
+	}
+	return nil
+}
+
+type InvalidateCacheRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Repository is the repository to evict, or empty for all of them.
+	Repository string `protobuf:"bytes,1,opt,name=repository,proto3" json:"repository,omitempty"`
+	// Blobs also deletes the blobs of the repository that no other cached
+	// chart references.
+	Blobs         bool `protobuf:"varint,2,opt,name=blobs,proto3" json:"blobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvalidateCacheRequest) Reset() {
+	*x = InvalidateCacheRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvalidateCacheRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidateCacheRequest) ProtoMessage() {}
+
+func (x *InvalidateCacheRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidateCacheRequest.ProtoReflect.Descriptor instead.
+func (*InvalidateCacheRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *InvalidateCacheRequest) GetRepository() string {
+	if x != nil {
+		return x.Repository
+	}
+	return ""
+}
+
+func (x *InvalidateCacheRequest) GetBlobs() bool {
+	if x != nil {
+		return x.Blobs
+	}
+	return false
+}
+
+type InvalidateCacheResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Evicted       int32                  `protobuf:"varint,1,opt,name=evicted,proto3" json:"evicted,omitempty"`
+	BlobsDeleted  int32                  `protobuf:"varint,2,opt,name=blobs_deleted,json=blobsDeleted,proto3" json:"blobs_deleted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvalidateCacheResponse) Reset() {
+	*x = InvalidateCacheResponse{}
+	mi := &file_controlpb_control_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvalidateCacheResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidateCacheResponse) ProtoMessage() {}
+
+func (x *InvalidateCacheResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidateCacheResponse.ProtoReflect.Descriptor instead.
+func (*InvalidateCacheResponse) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *InvalidateCacheResponse) GetEvicted() int32 {
+	if x != nil {
+		return x.Evicted
+	}
+	return 0
+}
+
+func (x *InvalidateCacheResponse) GetBlobsDeleted() int32 {
+	if x != nil {
+		return x.BlobsDeleted
+	}
+	return 0
+}
+
+// Fault fails or corrupts the responses to matching requests.
+type Fault struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Method is an HTTP method, or * or empty for all.
+	Method string `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	// Endpoint is manifest, blob, upload, tags, referrers, catalog or *.
+	Endpoint string `protobuf:"bytes,2,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	// Repository is a pattern of the repositories to match.
+	Repository string `protobuf:"bytes,3,opt,name=repository,proto3" json:"repository,omitempty"`
+	// Status is the HTTP status to answer with.
+	Status int32 `protobuf:"varint,4,opt,name=status,proto3" json:"status,omitempty"`
+	// Corrupt is flip to flip a bit of the body or garbage to replace it with
+	// random bytes of the same length.
+	Corrupt string `protobuf:"bytes,5,opt,name=corrupt,proto3" json:"corrupt,omitempty"`
+	// From and to are the first and last matching request, counted from 1,
+	// that fail; to is 0 for no limit.
+	From int32 `protobuf:"varint,6,opt,name=from,proto3" json:"from,omitempty"`
+	To   int32 `protobuf:"varint,7,opt,name=to,proto3" json:"to,omitempty"`
+	// Matched counts the requests the rule matched.
+	Matched       int32 `protobuf:"varint,8,opt,name=matched,proto3" json:"matched,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Fault) Reset() {
+	*x = Fault{}
+	mi := &file_controlpb_control_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Fault) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fault) ProtoMessage() {}
+
+func (x *Fault) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fault.ProtoReflect.Descriptor instead.
+func (*Fault) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Fault) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *Fault) GetEndpoint() string {
+	if x != nil {
+		return x.Endpoint
+	}
+	return ""
+}
+
+func (x *Fault) GetRepository() string {
+	if x != nil {
+		return x.Repository
+	}
+	return ""
+}
+
+func (x *Fault) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *Fault) GetCorrupt() string {
+	if x != nil {
+		return x.Corrupt
+	}
+	return ""
+}
+
+func (x *Fault) GetFrom() int32 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *Fault) GetTo() int32 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+func (x *Fault) GetMatched() int32 {
+	if x != nil {
+		return x.Matched
+	}
+	return 0
+}
+
+type ListFaultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListFaultsRequest) Reset() {
+	*x = ListFaultsRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListFaultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListFaultsRequest) ProtoMessage() {}
+
+func (x *ListFaultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListFaultsRequest.ProtoReflect.Descriptor instead.
+func (*ListFaultsRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{7}
+}
+
+type ListFaultsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Faults        []*Fault               `protobuf:"bytes,1,rep,name=faults,proto3" json:"faults,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListFaultsResponse) Reset() {
+	*x = ListFaultsResponse{}
+	mi := &file_controlpb_control_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListFaultsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListFaultsResponse) ProtoMessage() {}
+
+func (x *ListFaultsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListFaultsResponse.ProtoReflect.Descriptor instead.
+func (*ListFaultsResponse) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListFaultsResponse) GetFaults() []*Fault {
+	if x != nil {
+		return x.Faults
+	}
+	return nil
+}
+
+type AddFaultRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Fault         *Fault                 `protobuf:"bytes,1,opt,name=fault,proto3" json:"fault,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddFaultRequest) Reset() {
+	*x = AddFaultRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddFaultRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddFaultRequest) ProtoMessage() {}
+
+func (x *AddFaultRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddFaultRequest.ProtoReflect.Descriptor instead.
+func (*AddFaultRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AddFaultRequest) GetFault() *Fault {
+	if x != nil {
+		return x.Fault
+	}
+	return nil
+}
+
+type ClearFaultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearFaultsRequest) Reset() {
+	*x = ClearFaultsRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearFaultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearFaultsRequest) ProtoMessage() {}
+
+func (x *ClearFaultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearFaultsRequest.ProtoReflect.Descriptor instead.
+func (*ClearFaultsRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{10}
+}
+
+type ClearFaultsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Removed       int32                  `protobuf:"varint,1,opt,name=removed,proto3" json:"removed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearFaultsResponse) Reset() {
+	*x = ClearFaultsResponse{}
+	mi := &file_controlpb_control_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearFaultsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearFaultsResponse) ProtoMessage() {}
+
+func (x *ClearFaultsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearFaultsResponse.ProtoReflect.Descriptor instead.
+func (*ClearFaultsResponse) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ClearFaultsResponse) GetRemoved() int32 {
+	if x != nil {
+		return x.Removed
+	}
+	return 0
+}
+
+type StreamRequestsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Repository is a pattern of the repositories to send requests for, or
+	// empty for every request, including those outside the registry API.
+	Repository    string `protobuf:"bytes,1,opt,name=repository,proto3" json:"repository,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamRequestsRequest) Reset() {
+	*x = StreamRequestsRequest{}
+	mi := &file_controlpb_control_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamRequestsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRequestsRequest) ProtoMessage() {}
+
+func (x *StreamRequestsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRequestsRequest.ProtoReflect.Descriptor instead.
+func (*StreamRequestsRequest) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *StreamRequestsRequest) GetRepository() string {
+	if x != nil {
+		return x.Repository
+	}
+	return ""
+}
+
+// RequestEvent describes a served request.
+type RequestEvent struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Time      *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	RequestId string                 `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Method    string                 `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	Path      string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	// Endpoint and repository are set for requests to the registry API.
+	Endpoint      string               `protobuf:"bytes,5,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	Repository    string               `protobuf:"bytes,6,opt,name=repository,proto3" json:"repository,omitempty"`
+	Client        string               `protobuf:"bytes,7,opt,name=client,proto3" json:"client,omitempty"`
+	Status        int32                `protobuf:"varint,8,opt,name=status,proto3" json:"status,omitempty"`
+	Bytes         int64                `protobuf:"varint,9,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Duration      *durationpb.Duration `protobuf:"bytes,10,opt,name=duration,proto3" json:"duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestEvent) Reset() {
+	*x = RequestEvent{}
+	mi := &file_controlpb_control_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestEvent) ProtoMessage() {}
+
+func (x *RequestEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_controlpb_control_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestEvent.ProtoReflect.Descriptor instead.
+func (*RequestEvent) Descriptor() ([]byte, []int) {
+	return file_controlpb_control_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RequestEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *RequestEvent) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *RequestEvent) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *RequestEvent) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *RequestEvent) GetEndpoint() string {
+	if x != nil {
+		return x.Endpoint
+	}
+	return ""
+}
+
+func (x *RequestEvent) GetRepository() string {
+	if x != nil {
+		return x.Repository
+	}
+	return ""
+}
+
+func (x *RequestEvent) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *RequestEvent) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *RequestEvent) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *RequestEvent) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+var File_controlpb_control_proto protoreflect.FileDescriptor
+
+const file_controlpb_control_proto_rawDesc = "" +
+	"\n" +
+	"\x17controlpb/control.proto\x12\x16virtualhelm.control.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x17\n" +
+	"\x15ListGeneratorsRequest\"x\n" +
+	"\x16ListGeneratorsResponse\x12\x1e\n" +
+	"\n" +
+	"generators\x18\x01 \x03(\tR\n" +
+	"generators\x12>\n" +
+	"\x06routes\x18\x02 \x03(\v2&.virtualhelm.control.v1.GeneratorRouteR\x06routes\"H\n" +
+	"\x0eGeneratorRoute\x12\x18\n" +
+	"\apattern\x18\x01 \x01(\tR\apattern\x12\x1c\n" +
+	"\tgenerator\x18\x02 \x01(\tR\tgenerator\"[\n" +
+	"\x19SetGeneratorRoutesRequest\x12>\n" +
+	"\x06routes\x18\x01 \x03(\v2&.virtualhelm.control.v1.GeneratorRouteR\x06routes\"N\n" +
+	"\x16InvalidateCacheRequest\x12\x1e\n" +
+	"\n" +
+	"repository\x18\x01 \x01(\tR\n" +
+	"repository\x12\x14\n" +
+	"\x05blobs\x18\x02 \x01(\bR\x05blobs\"X\n" +
+	"\x17InvalidateCacheResponse\x12\x18\n" +
+	"\aevicted\x18\x01 \x01(\x05R\aevicted\x12#\n" +
+	"\rblobs_deleted\x18\x02 \x01(\x05R\fblobsDeleted\"\xcb\x01\n" +
+	"\x05Fault\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x1a\n" +
+	"\bendpoint\x18\x02 \x01(\tR\bendpoint\x12\x1e\n" +
+	"\n" +
+	"repository\x18\x03 \x01(\tR\n" +
+	"repository\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\x05R\x06status\x12\x18\n" +
+	"\acorrupt\x18\x05 \x01(\tR\acorrupt\x12\x12\n" +
+	"\x04from\x18\x06 \x01(\x05R\x04from\x12\x0e\n" +
+	"\x02to\x18\a \x01(\x05R\x02to\x12\x18\n" +
+	"\amatched\x18\b \x01(\x05R\amatched\"\x13\n" +
+	"\x11ListFaultsRequest\"K\n" +
+	"\x12ListFaultsResponse\x125\n" +
+	"\x06faults\x18\x01 \x03(\v2\x1d.virtualhelm.control.v1.FaultR\x06faults\"F\n" +
+	"\x0fAddFaultRequest\x123\n" +
+	"\x05fault\x18\x01 \x01(\v2\x1d.virtualhelm.control.v1.FaultR\x05fault\"\x14\n" +
+	"\x12ClearFaultsRequest\"/\n" +
+	"\x13ClearFaultsResponse\x12\x18\n" +
+	"\aremoved\x18\x01 \x01(\x05R\aremoved\"7\n" +
+	"\x15StreamRequestsRequest\x12\x1e\n" +
+	"\n" +
+	"repository\x18\x01 \x01(\tR\n" +
+	"repository\"\xc2\x02\n" +
+	"\fRequestEvent\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x02 \x01(\tR\trequestId\x12\x16\n" +
+	"\x06method\x18\x03 \x01(\tR\x06method\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12\x1a\n" +
+	"\bendpoint\x18\x05 \x01(\tR\bendpoint\x12\x1e\n" +
+	"\n" +
+	"repository\x18\x06 \x01(\tR\n" +
+	"repository\x12\x16\n" +
+	"\x06client\x18\a \x01(\tR\x06client\x12\x16\n" +
+	"\x06status\x18\b \x01(\x05R\x06status\x12\x14\n" +
+	"\x05bytes\x18\t \x01(\x03R\x05bytes\x125\n" +
+	"\bduration\x18\n" +
+	" \x01(\v2\x19.google.protobuf.DurationR\bduration2\xf1\x05\n" +
+	"\aControl\x12o\n" +
+	"\x0eListGenerators\x12-.virtualhelm.control.v1.ListGeneratorsRequest\x1a..virtualhelm.control.v1.ListGeneratorsResponse\x12w\n" +
+	"\x12SetGeneratorRoutes\x121.virtualhelm.control.v1.SetGeneratorRoutesRequest\x1a..virtualhelm.control.v1.ListGeneratorsResponse\x12r\n" +
+	"\x0fInvalidateCache\x12..virtualhelm.control.v1.InvalidateCacheRequest\x1a/.virtualhelm.control.v1.InvalidateCacheResponse\x12c\n" +
+	"\n" +
+	"ListFaults\x12).virtualhelm.control.v1.ListFaultsRequest\x1a*.virtualhelm.control.v1.ListFaultsResponse\x12R\n" +
+	"\bAddFault\x12'.virtualhelm.control.v1.AddFaultRequest\x1a\x1d.virtualhelm.control.v1.Fault\x12f\n" +
+	"\vClearFaults\x12*.virtualhelm.control.v1.ClearFaultsRequest\x1a+.virtualhelm.control.v1.ClearFaultsResponse\x12g\n" +
+	"\x0eStreamRequests\x12-.virtualhelm.control.v1.StreamRequestsRequest\x1a$.virtualhelm.control.v1.RequestEvent0\x01B.Z,github.com/cdelautour/virutal-helm/controlpbb\x06proto3"
+
+var (
+	file_controlpb_control_proto_rawDescOnce sync.Once
+	file_controlpb_control_proto_rawDescData []byte
+)
+
+func file_controlpb_control_proto_rawDescGZIP() []byte {
+	file_controlpb_control_proto_rawDescOnce.Do(func() {
+		file_controlpb_control_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_controlpb_control_proto_rawDesc), len(file_controlpb_control_proto_rawDesc)))
+	})
+	return file_controlpb_control_proto_rawDescData
+}
+
+var file_controlpb_control_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_controlpb_control_proto_goTypes = []any{
+	(*ListGeneratorsRequest)(nil),     // 0: virtualhelm.control.v1.ListGeneratorsRequest
+	(*ListGeneratorsResponse)(nil),    // 1: virtualhelm.control.v1.ListGeneratorsResponse
+	(*GeneratorRoute)(nil),            // 2: virtualhelm.control.v1.GeneratorRoute
+	(*SetGeneratorRoutesRequest)(nil), // 3: virtualhelm.control.v1.SetGeneratorRoutesRequest
+	(*InvalidateCacheRequest)(nil),    // 4: virtualhelm.control.v1.InvalidateCacheRequest
+	(*InvalidateCacheResponse)(nil),   // 5: virtualhelm.control.v1.InvalidateCacheResponse
+	(*Fault)(nil),                     // 6: virtualhelm.control.v1.Fault
+	(*ListFaultsRequest)(nil),         // 7: virtualhelm.control.v1.ListFaultsRequest
+	(*ListFaultsResponse)(nil),        // 8: virtualhelm.control.v1.ListFaultsResponse
+	(*AddFaultRequest)(nil),           // 9: virtualhelm.control.v1.AddFaultRequest
+	(*ClearFaultsRequest)(nil),        // 10: virtualhelm.control.v1.ClearFaultsRequest
+	(*ClearFaultsResponse)(nil),       // 11: virtualhelm.control.v1.ClearFaultsResponse
+	(*StreamRequestsRequest)(nil),     // 12: virtualhelm.control.v1.StreamRequestsRequest
+	(*RequestEvent)(nil),              // 13: virtualhelm.control.v1.RequestEvent
+	(*timestamppb.Timestamp)(nil),     // 14: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),       // 15: google.protobuf.Duration
+}
+var file_controlpb_control_proto_depIdxs = []int32{
+	2,  // 0: virtualhelm.control.v1.ListGeneratorsResponse.routes:type_name -> virtualhelm.control.v1.GeneratorRoute
+	2,  // 1: virtualhelm.control.v1.SetGeneratorRoutesRequest.routes:type_name -> virtualhelm.control.v1.GeneratorRoute
+	6,  // 2: virtualhelm.control.v1.ListFaultsResponse.faults:type_name -> virtualhelm.control.v1.Fault
+	6,  // 3: virtualhelm.control.v1.AddFaultRequest.fault:type_name -> virtualhelm.control.v1.Fault
+	14, // 4: virtualhelm.control.v1.RequestEvent.time:type_name -> google.protobuf.Timestamp
+	15, // 5: virtualhelm.control.v1.RequestEvent.duration:type_name -> google.protobuf.Duration
+	0,  // 6: virtualhelm.control.v1.Control.ListGenerators:input_type -> virtualhelm.control.v1.ListGeneratorsRequest
+	3,  // 7: virtualhelm.control.v1.Control.SetGeneratorRoutes:input_type -> virtualhelm.control.v1.SetGeneratorRoutesRequest
+	4,  // 8: virtualhelm.control.v1.Control.InvalidateCache:input_type -> virtualhelm.control.v1.InvalidateCacheRequest
+	7,  // 9: virtualhelm.control.v1.Control.ListFaults:input_type -> virtualhelm.control.v1.ListFaultsRequest
+	9,  // 10: virtualhelm.control.v1.Control.AddFault:input_type -> virtualhelm.control.v1.AddFaultRequest
+	10, // 11: virtualhelm.control.v1.Control.ClearFaults:input_type -> virtualhelm.control.v1.ClearFaultsRequest
+	12, // 12: virtualhelm.control.v1.Control.StreamRequests:input_type -> virtualhelm.control.v1.StreamRequestsRequest
+	1,  // 13: virtualhelm.control.v1.Control.ListGenerators:output_type -> virtualhelm.control.v1.ListGeneratorsResponse
+	1,  // 14: virtualhelm.control.v1.Control.SetGeneratorRoutes:output_type -> virtualhelm.control.v1.ListGeneratorsResponse
+	5,  // 15: virtualhelm.control.v1.Control.InvalidateCache:output_type -> virtualhelm.control.v1.InvalidateCacheResponse
+	8,  // 16: virtualhelm.control.v1.Control.ListFaults:output_type -> virtualhelm.control.v1.ListFaultsResponse
+	6,  // 17: virtualhelm.control.v1.Control.AddFault:output_type -> virtualhelm.control.v1.Fault
+	11, // 18: virtualhelm.control.v1.Control.ClearFaults:output_type -> virtualhelm.control.v1.ClearFaultsResponse
+	13, // 19: virtualhelm.control.v1.Control.StreamRequests:output_type -> virtualhelm.control.v1.RequestEvent
+	13, // [13:20] is the sub-list for method output_type
+	6,  // [6:13] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_controlpb_control_proto_init() }
+func file_controlpb_control_proto_init() {
+	if File_controlpb_control_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_controlpb_control_proto_rawDesc), len(file_controlpb_control_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_controlpb_control_proto_goTypes,
+		DependencyIndexes: file_controlpb_control_proto_depIdxs,
+		MessageInfos:      file_controlpb_control_proto_msgTypes,
+	}.Build()
+	File_controlpb_control_proto = out.File
+	file_controlpb_control_proto_goTypes = nil
+	file_controlpb_control_proto_depIdxs = nil
+}
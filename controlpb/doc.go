@@ -0,0 +1,5 @@
+// Package controlpb is the gRPC control API served by virtual-helm on
+// -grpc-addr, for tools driving the registry programmatically.
+package controlpb
+
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative controlpb/control.proto
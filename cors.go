@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	corsMethods = flag.String("cors-methods", "GET, HEAD, OPTIONS", "methods browsers may use cross-origin")
+	corsHeaders = flag.String("cors-headers", "Authorization, Accept, Content-Type, Range", "request headers browsers may send cross-origin")
+	corsExpose  = flag.String("cors-expose-headers", "Docker-Content-Digest, Docker-Distribution-Api-Version, Location, Link, Content-Range, WWW-Authenticate", "response headers browsers may read cross-origin")
+	corsMaxAge  = flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+)
+
+var corsOrigins stringList
+
+func init() {
+	flag.Var(&corsOrigins, "cors-origin", "origin, such as https://explorer.example.com, that browsers may call the API from, or * for any; enables CORS (repeatable)")
+}
+
+// corsMiddleware adds CORS headers for the -cors-origin origins and answers
+// their preflight requests.
+func corsMiddleware(next http.Handler) http.Handler {
+	anyOrigin := slices.Contains(corsOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(corsOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only listed origins may send credentials.
+		if slices.Contains(corsOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		w.Header().Set("Access-Control-Expose-Headers", *corsExpose)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", *corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", *corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	cosignKey      = flag.String("cosign-key", "", "unencrypted PEM ECDSA or RSA private key used to sign every generated manifest for cosign")
+	cosignRegistry = flag.String("cosign-registry", "localhost:5000", "registry host recorded as the docker-reference of cosign signatures")
+)
+
+const (
+	cosignSignatureMediaType  = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignArtifactType        = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// cosignSigner signs generated manifests; it is nil when -cosign-key is
+// unset.
+var cosignSigner crypto.Signer
+
+// initCosign loads the -cosign-key signing key.
+func initCosign() error {
+	if *cosignKey == "" {
+		return nil
+	}
+
+	signer, err := loadPEMSigner(*cosignKey)
+	if err != nil {
+		return err
+	}
+
+	cosignSigner = signer
+	return nil
+}
+
+// loadPEMSigner reads an unencrypted ECDSA or RSA private key from a PEM
+// file.
+func loadPEMSigner(file string) (crypto.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key in %s", file)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s (encrypted keys must be decrypted first)", block.Type, file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s", key, file)
+	}
+}
+
+// simpleSigning is the payload cosign signs: the manifest digest and the
+// reference it was pulled by.
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// cosignSign signs manifest with the cosign key and stores the signature
+// as a referrer of manifest, also served under the sha256-<hex>.sig tag.
+func cosignSign(name string, manifest *Manifest, created time.Time) error {
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = *cosignRegistry + "/" + name
+	payload.Critical.Image.DockerManifestDigest = manifest.digest
+	payload.Critical.Type = "cosign container image signature"
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	signature, err := cosignSigner.Sign(randReader, sum[:], crypto.SHA256)
+	if err != nil {
+		return err
+	}
+
+	payloadDigest := fmt.Sprintf("sha256:%x", sum)
+	if err := store.Put(payloadDigest, data); err != nil {
+		return err
+	}
+
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"created":      created.UTC().Format(time.RFC3339),
+		"config":       map[string]interface{}{},
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": []string{payloadDigest},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	if err := store.Put(configDigest, config); err != nil {
+		return err
+	}
+
+	return addReferrer(name, manifest, &Manifest{
+		ArtifactType: cosignArtifactType,
+		Config: Config{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    configDigest,
+			Size:      len(config),
+		},
+		Layers: []Layer{{
+			MediaType: cosignSignatureMediaType,
+			Digest:    payloadDigest,
+			Size:      len(data),
+			Annotations: map[string]string{
+				cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+			},
+		}},
+	})
+}
+
+// cosignSignature returns the cosign signature served for name under the
+// sha256-<hex>.sig tag cosign looks signatures up by.
+func cosignSignature(name string, reference string) (*Manifest, bool) {
+	tag, ok := strings.CutPrefix(reference, "sha256-")
+	if !ok {
+		return nil, false
+	}
+	hex, ok := strings.CutSuffix(tag, ".sig")
+	if !ok {
+		return nil, false
+	}
+
+	return referrerOf(name, "sha256:"+hex, cosignArtifactType)
+}
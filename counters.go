@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Counters counts successful pulls, pushes and blob fetches by repository
+// and then by tag, digest or other reference, so tests can assert how a
+// client talked to the registry.
+type Counters struct {
+	Pulls  map[string]map[string]int `json:"pulls"`
+	Pushes map[string]map[string]int `json:"pushes"`
+	Blobs  map[string]map[string]int `json:"blobs"`
+}
+
+var counters = struct {
+	sync.Mutex
+	Counters
+}{Counters: newCounters()}
+
+func newCounters() Counters {
+	return Counters{
+		Pulls:  make(map[string]map[string]int),
+		Pushes: make(map[string]map[string]int),
+		Blobs:  make(map[string]map[string]int),
+	}
+}
+
+func count(m map[string]map[string]int, name string, reference string) {
+	if m[name] == nil {
+		m[name] = make(map[string]int)
+	}
+	m[name][reference]++
+}
+
+// countersMiddleware counts manifest GETs and PUTs and blob GETs that
+// succeed.
+func countersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, name, ok := requestEndpoint(r)
+		if !ok || (endpoint != "manifest" && endpoint != "blob") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		if rec.status < 200 || rec.status > 299 {
+			return
+		}
+
+		reference := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		counters.Lock()
+		defer counters.Unlock()
+		switch {
+		case endpoint == "manifest" && r.Method == http.MethodGet:
+			count(counters.Pulls, name, reference)
+		case endpoint == "manifest" && r.Method == http.MethodPut:
+			count(counters.Pushes, name, reference)
+		case endpoint == "blob" && r.Method == http.MethodGet:
+			count(counters.Blobs, name, reference)
+		}
+	})
+}
+
+// registerCounterRoutes adds the counters to the admin API.
+func registerCounterRoutes(admin *mux.Router) {
+	admin.HandleFunc("/counters", handleAdminCounters).Methods("GET")
+	admin.HandleFunc("/counters", handleAdminResetCounters).Methods("DELETE")
+}
+
+func handleAdminCounters(w http.ResponseWriter, r *http.Request) {
+	counters.Lock()
+	defer counters.Unlock()
+
+	writeAdminJSON(w, counters.Counters)
+}
+
+func handleAdminResetCounters(w http.ResponseWriter, r *http.Request) {
+	counters.Lock()
+	counters.Counters = newCounters()
+	counters.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+var watchVirtualCharts = flag.Bool("watch-virtualcharts", false, "serve charts declared by VirtualChart resources through the \"crd\" generator")
+
+const (
+	virtualChartGroup    = "virtualhelm.io"
+	virtualChartVersion  = "v1alpha1"
+	virtualChartResource = "virtualcharts"
+)
+
+// VirtualChart declares a synthetic chart as a Kubernetes resource. See
+// deploy/virtualchart-crd.yaml for the schema.
+type VirtualChart struct {
+	Metadata kubeObjectMeta   `json:"metadata"`
+	Spec     VirtualChartSpec `json:"spec"`
+}
+
+type VirtualChartSpec struct {
+	// Repository is the repository name the chart is served under,
+	// defaulting to the resource name.
+	Repository string `json:"repository"`
+	// Versions restricts the references that can be pulled; any reference
+	// is served when empty.
+	Versions []string `json:"versions"`
+	// Chart overrides Chart.yaml fields.
+	Chart map[string]interface{} `json:"chart"`
+	// Values is written to values.yaml.
+	Values map[string]interface{} `json:"values"`
+	// Templates holds files written under templates/, by file name.
+	Templates map[string]string `json:"templates"`
+	// Files holds any other chart files, by path relative to the chart root.
+	Files map[string]string `json:"files"`
+}
+
+func (vc *VirtualChart) repository() string {
+	if vc.Spec.Repository != "" {
+		return vc.Spec.Repository
+	}
+
+	return vc.Metadata.Name
+}
+
+func init() {
+	generators["crd"] = crdCharts
+}
+
+// crdCharts indexes the watched VirtualChart resources by repository.
+var crdCharts = &crdGenerator{byKey: make(map[string]*VirtualChart)}
+
+type crdGenerator struct {
+	mu sync.RWMutex
+	// byKey holds charts by namespace/name of their resource.
+	byKey map[string]*VirtualChart
+}
+
+func (g *crdGenerator) lookup(repository string) *VirtualChart {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, vc := range g.byKey {
+		if vc.repository() == repository {
+			return vc
+		}
+	}
+
+	return nil
+}
+
+func (g *crdGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	vc := g.lookup(req.Name)
+	if vc == nil {
+		return nil, errChartNotFound
+	}
+
+	if len(vc.Spec.Versions) > 0 {
+		found := false
+		for _, v := range vc.Spec.Versions {
+			if v == req.Reference {
+				found = true
+			}
+		}
+		if !found {
+			return nil, errReferenceNotFound
+		}
+	}
+
+	chart, err := chartWithOverrides(req, vc.Spec.Chart)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []ChartFile
+	if vc.Spec.Values != nil {
+		values, err := yaml.Marshal(vc.Spec.Values)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ChartFile{Name: "values.yaml", Data: values})
+	}
+	for name, content := range vc.Spec.Templates {
+		files = append(files, ChartFile{Name: "templates/" + name, Data: []byte(content)})
+	}
+	for name, content := range vc.Spec.Files {
+		files = append(files, ChartFile{Name: name, Data: []byte(content)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	files, err = withChartYaml(files, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// set replaces the indexed resources with objects.
+func (g *crdGenerator) set(objects []json.RawMessage) {
+	byKey := make(map[string]*VirtualChart)
+	for _, raw := range objects {
+		if vc := decodeVirtualChart(raw); vc != nil {
+			byKey[vc.Metadata.Namespace+"/"+vc.Metadata.Name] = vc
+		}
+	}
+
+	g.mu.Lock()
+	g.byKey = byKey
+	g.mu.Unlock()
+
+	n := generated.Purge()
+	slog.Info("synced VirtualChart resources", "charts", len(byKey), "evicted", n)
+}
+
+func (g *crdGenerator) apply(e kubeWatchEvent) {
+	vc := decodeVirtualChart(e.Object)
+	if vc == nil {
+		return
+	}
+
+	key := vc.Metadata.Namespace + "/" + vc.Metadata.Name
+	g.mu.Lock()
+	if old, ok := g.byKey[key]; ok {
+		generated.RemoveRepository(old.repository())
+	}
+	if e.Type == "DELETED" {
+		delete(g.byKey, key)
+	} else {
+		g.byKey[key] = vc
+	}
+	g.mu.Unlock()
+
+	generated.RemoveRepository(vc.repository())
+	slog.Info("VirtualChart changed", "event", e.Type, "resource", key, "repository", vc.repository())
+}
+
+func decodeVirtualChart(raw json.RawMessage) *VirtualChart {
+	var vc VirtualChart
+	if err := json.Unmarshal(raw, &vc); err != nil {
+		slog.Warn("ignoring invalid VirtualChart", "error", err)
+		return nil
+	}
+
+	return &vc
+}
+
+// startVirtualChartWatch follows VirtualChart resources in the background.
+func startVirtualChartWatch(ctx context.Context) error {
+	if !*watchVirtualCharts {
+		return nil
+	}
+
+	client, err := newKubeClient()
+	if err != nil {
+		return err
+	}
+
+	path := client.resourcePath(virtualChartGroup, virtualChartVersion, virtualChartResource)
+	go client.informer(ctx, path, "", crdCharts.set, crdCharts.apply)
+	return nil
+}
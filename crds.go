@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var crdDirFlags stringList
+
+func init() {
+	flag.Var(&crdDirFlags, "crds", "add the *.yaml files of a directory to the crds/ directory of charts matching a pattern, as pattern=dir (repeatable)")
+}
+
+type crdDirRule struct {
+	pattern string
+	dir     string
+}
+
+var crdDirRules []crdDirRule
+
+// initCRDDirs parses the -crds flags.
+func initCRDDirs() error {
+	for _, f := range crdDirFlags {
+		pattern, dir, ok := strings.Cut(f, "=")
+		if !ok || dir == "" {
+			return fmt.Errorf("invalid crds directory %q: expected pattern=dir", f)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid crds directory %q: %w", f, err)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid crds directory %q: %s is not a directory", f, dir)
+		}
+
+		crdDirRules = append(crdDirRules, crdDirRule{pattern: pattern, dir: dir})
+	}
+
+	return nil
+}
+
+// addCRDs copies the CRD manifests configured for name into the chart's crds/
+// directory, which Helm installs before rendering any template. The files are
+// read on every generation so edits show up without a restart.
+func addCRDs(out *GeneratedChart, name string) error {
+	for _, rule := range crdDirRules {
+		if ok, _ := path.Match(rule.pattern, name); !ok {
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(rule.dir, "*.yaml"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(matches)
+
+		for _, m := range matches {
+			data, err := os.ReadFile(m)
+			if err != nil {
+				return err
+			}
+			out.Files = append(out.Files, ChartFile{
+				Name: chartFilePrefix(out) + "crds/" + filepath.Base(m),
+				Data: data,
+			})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+var debugAddr = flag.String("debug-addr", "", "serve net/http/pprof handlers on this address (disabled when empty)")
+
+// startDebugServer serves the pprof endpoints on a separate listener so they
+// are never exposed alongside the registry API.
+func startDebugServer() {
+	if *debugAddr == "" {
+		return
+	}
+
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		slog.Info("starting debug server", "addr", *debugAddr)
+		if err := http.ListenAndServe(*debugAddr, m); err != nil {
+			slog.Error("debug server stopped", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// DedupStats compares the blob bytes the manifests of every repository
+// reference with the bytes actually stored. Blobs are stored once by
+// digest, so a layer or config shared by several charts or repositories
+// only takes space once.
+type DedupStats struct {
+	// Manifests counts the manifests of every repository, the same
+	// manifest in two repositories counting twice.
+	Manifests int `json:"manifests"`
+	// Blobs counts the distinct blobs the manifests reference, and
+	// SharedBlobs those referenced from more than one repository.
+	Blobs       int `json:"blobs"`
+	SharedBlobs int `json:"sharedBlobs"`
+	// LogicalBytes is the size of the blobs of every manifest, as if each
+	// stored its own copy, and PhysicalBytes the size of the distinct
+	// blobs.
+	LogicalBytes  int64 `json:"logicalBytes"`
+	PhysicalBytes int64 `json:"physicalBytes"`
+	SavedBytes    int64 `json:"savedBytes"`
+	// Ratio is LogicalBytes over PhysicalBytes.
+	Ratio float64 `json:"ratio"`
+	// StoredBlobs and StoredBytes are what the storage backend holds,
+	// including blobs no manifest references, such as those of unfinished
+	// pushes.
+	StoredBlobs int   `json:"storedBlobs"`
+	StoredBytes int64 `json:"storedBytes"`
+}
+
+// dedupStats computes the DedupStats of the generated, pushed, imported and
+// proxied manifests.
+func dedupStats() DedupStats {
+	// manifests holds every manifest by name@digest, as tags and digests
+	// reference the same manifest.
+	manifests := make(map[string]*Manifest)
+	add := func(key string, manifest *Manifest) {
+		name, _, _ := strings.Cut(key, ":")
+		manifests[referrerKey(name, manifest.digest)] = manifest
+	}
+
+	for key, manifest := range generated.Entries() {
+		add(key, manifest)
+	}
+	pushed.RLock()
+	for key, manifest := range pushed.byRef {
+		add(key, manifest)
+	}
+	pushed.RUnlock()
+	imported.RLock()
+	for key, manifest := range imported.byRef {
+		add(key, manifest)
+	}
+	imported.RUnlock()
+	proxied.RLock()
+	for key, p := range proxied.byRef {
+		add(key, p.manifest)
+	}
+	proxied.RUnlock()
+
+	var stats DedupStats
+	sizes := make(map[string]int)
+	repositories := make(map[string]map[string]bool)
+	for key, manifest := range manifests {
+		name, _, _ := strings.Cut(key, "@")
+		stats.Manifests++
+
+		reference := func(digest string, size int) {
+			if digest == "" {
+				return
+			}
+			stats.LogicalBytes += int64(size)
+			sizes[digest] = size
+			if repositories[digest] == nil {
+				repositories[digest] = make(map[string]bool)
+			}
+			repositories[digest][name] = true
+		}
+		reference(manifest.Config.Digest, manifest.Config.Size)
+		for _, l := range manifest.Layers {
+			reference(l.Digest, l.Size)
+		}
+	}
+
+	for digest, size := range sizes {
+		stats.Blobs++
+		stats.PhysicalBytes += int64(size)
+		if len(repositories[digest]) > 1 {
+			stats.SharedBlobs++
+		}
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.PhysicalBytes
+	if stats.PhysicalBytes > 0 {
+		stats.Ratio = float64(stats.LogicalBytes) / float64(stats.PhysicalBytes)
+	}
+
+	count, size := store.Stats()
+	stats.StoredBlobs, stats.StoredBytes = count, int64(size)
+
+	return stats
+}
+
+func registerDedupRoutes(admin *mux.Router) {
+	admin.HandleFunc("/dedup", handleAdminDedup).Methods("GET")
+}
+
+func handleAdminDedup(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, dedupStats())
+}
@@ -0,0 +1,96 @@
+package main
+
+// defaultChartFiles returns the files of the chart served by the default
+// generator, modelled on the output of `helm create`.
+func defaultChartFiles() []ChartFile {
+	return []ChartFile{
+		{Name: "README.md", Data: []byte(defaultReadme)},
+		{Name: "values.yaml", Data: []byte(defaultValues)},
+		{Name: "templates/_helpers.tpl", Data: []byte(defaultHelpers)},
+		{Name: "templates/deployment.yaml", Data: []byte(defaultDeployment)},
+		{Name: "templates/NOTES.txt", Data: []byte(defaultNotes)},
+	}
+}
+
+const defaultReadme = `Hello helm!
+`
+
+const defaultValues = `replicaCount: 1
+
+image:
+  repository: nginx
+  tag: ""
+  pullPolicy: IfNotPresent
+
+podAnnotations: {}
+
+resources: {}
+`
+
+const defaultHelpers = `{{/*
+Expand the name of the chart.
+*/}}
+{{- define "chart.name" -}}
+{{- default .Chart.Name .Values.nameOverride | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{/*
+Create a default fully qualified app name.
+*/}}
+{{- define "chart.fullname" -}}
+{{- if contains (include "chart.name" .) .Release.Name }}
+{{- .Release.Name | trunc 63 | trimSuffix "-" }}
+{{- else }}
+{{- printf "%s-%s" .Release.Name (include "chart.name" .) | trunc 63 | trimSuffix "-" }}
+{{- end }}
+{{- end }}
+
+{{/*
+Common labels.
+*/}}
+{{- define "chart.labels" -}}
+helm.sh/chart: {{ printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" }}
+{{ include "chart.selectorLabels" . }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
+{{/*
+Selector labels.
+*/}}
+{{- define "chart.selectorLabels" -}}
+app.kubernetes.io/name: {{ include "chart.name" . }}
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+`
+
+const defaultDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "chart.fullname" . }}
+  labels:
+    {{- include "chart.labels" . | nindent 4 }}
+spec:
+  replicas: {{ .Values.replicaCount }}
+  selector:
+    matchLabels:
+      {{- include "chart.selectorLabels" . | nindent 6 }}
+  template:
+    metadata:
+      {{- with .Values.podAnnotations }}
+      annotations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      labels:
+        {{- include "chart.selectorLabels" . | nindent 8 }}
+    spec:
+      containers:
+        - name: {{ .Chart.Name }}
+          image: "{{ .Values.image.repository }}:{{ .Values.image.tag | default "latest" }}"
+          imagePullPolicy: {{ .Values.image.pullPolicy }}
+          resources:
+            {{- toYaml .Values.resources | nindent 12 }}
+`
+
+const defaultNotes = `{{ .Chart.Name }} {{ .Chart.Version }} was generated by virtual-helm and
+installed as release {{ .Release.Name }} in namespace {{ .Release.Namespace }}.
+`
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+var dependencyRegistry = flag.String("dependency-registry", "localhost:5000", "registry host recorded as the repository of bundled dependencies")
+
+var dependencyFlags stringList
+
+func init() {
+	flag.Var(&dependencyFlags, "dependency", "bundle a generated dependency into charts matching a pattern, as pattern=repository@version (repeatable)")
+}
+
+// maxDependencyDepth bounds how deeply dependencies of dependencies are
+// resolved, so misconfigured patterns cannot recurse forever.
+const maxDependencyDepth = 5
+
+type dependencyRule struct {
+	pattern    string
+	repository string
+	version    string
+}
+
+var dependencyRules []dependencyRule
+
+// initDependencyRules parses the -dependency flags.
+func initDependencyRules() error {
+	for _, f := range dependencyFlags {
+		pattern, dep, ok := strings.Cut(f, "=")
+		repository, version, ok2 := strings.Cut(dep, "@")
+		if !ok || !ok2 || repository == "" || version == "" {
+			return fmt.Errorf("invalid dependency %q: expected pattern=repository@version", f)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid dependency %q: %w", f, err)
+		}
+
+		dependencyRules = append(dependencyRules, dependencyRule{pattern: pattern, repository: repository, version: version})
+	}
+
+	return nil
+}
+
+// resolveChart generates name:reference, adds its -crds manifests and bundles
+// the dependencies the -dependency rules declare for it.
+func resolveChart(ctx context.Context, req ChartRequest, depth int) (*GeneratedChart, error) {
+	g := generatorFor(req.Name)
+	if g == nil {
+		return nil, fmt.Errorf("%s is served by an upstream registry: %w", req.Name, errChartNotFound)
+	}
+
+	out, err := g.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := addCRDs(out, req.Name); err != nil {
+		return nil, err
+	}
+
+	var deps []*Dependency
+	for _, rule := range dependencyRules {
+		if ok, _ := path.Match(rule.pattern, req.Name); !ok || rule.repository == req.Name {
+			continue
+		}
+		if depth >= maxDependencyDepth {
+			return nil, fmt.Errorf("dependencies of %s nest deeper than %d levels", req.Name, maxDependencyDepth)
+		}
+
+		dep, err := resolveChart(ctx, ChartRequest{Name: rule.repository, Reference: rule.version}, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("resolving dependency %s@%s: %w", rule.repository, rule.version, err)
+		}
+
+		var archive bytes.Buffer
+		if err := writeChartArchive(ctx, &archive, dep.Files, true); err != nil {
+			return nil, err
+		}
+
+		out.Files = append(out.Files, ChartFile{
+			Name: chartFilePrefix(out) + "charts/" + dep.Chart.Name + "-" + dep.Chart.Version + ".tgz",
+			Data: archive.Bytes(),
+		})
+		deps = append(deps, &Dependency{
+			Name:       dep.Chart.Name,
+			Version:    dep.Chart.Version,
+			Repository: "oci://" + path.Join(*dependencyRegistry, path.Dir(rule.repository)),
+		})
+	}
+
+	if len(deps) > 0 {
+		if err := declareDependencies(out, deps); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// ChartLock is the content of Chart.lock.
+type ChartLock struct {
+	Dependencies []*Dependency `json:"dependencies"`
+	Digest       string        `json:"digest"`
+	Generated    time.Time     `json:"generated"`
+}
+
+// declareDependencies records deps in the chart's metadata, its packaged
+// Chart.yaml and a matching Chart.lock.
+func declareDependencies(out *GeneratedChart, deps []*Dependency) error {
+	out.Chart.Dependencies = append(out.Chart.Dependencies, deps...)
+
+	prefix := chartFilePrefix(out)
+	for i, f := range out.Files {
+		if f.Name == prefix+"Chart.yaml" {
+			data, err := setChartYamlField(f.Data, "dependencies", out.Chart.Dependencies)
+			if err != nil {
+				return err
+			}
+			out.Files[i].Data = data
+		}
+	}
+
+	// Helm's digest covers both the requested and the locked dependencies,
+	// which are identical here.
+	digest, err := json.Marshal([2][]*Dependency{out.Chart.Dependencies, out.Chart.Dependencies})
+	if err != nil {
+		return err
+	}
+
+	lock, err := yaml.Marshal(ChartLock{
+		Dependencies: out.Chart.Dependencies,
+		Digest:       fmt.Sprintf("sha256:%x", sha256.Sum256(digest)),
+		Generated:    chartEpoch,
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Files = append(out.Files, ChartFile{Name: prefix + "Chart.lock", Data: lock})
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// digestLengths holds the hex length of the encoded part of digests by
+// algorithm.
+var digestLengths = map[string]int{
+	"sha256": 64,
+	"sha512": 128,
+}
+
+// validDigest reports whether digest is of the algorithm:hex form with a
+// known algorithm and an encoded part of the right length.
+func validDigest(digest string) bool {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || len(encoded) != digestLengths[algorithm] {
+		return false
+	}
+	for _, c := range encoded {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isDigestReference reports whether a manifest reference is a digest rather
+// than a tag, as tags cannot contain a colon.
+func isDigestReference(reference string) bool {
+	return strings.Contains(reference, ":")
+}
+
+// tagRegexp is the grammar of tags in the distribution spec.
+var tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// validTag reports whether reference is a tag, or with -tag-ranges a semver
+// range, which may use characters tags cannot.
+func validTag(reference string) bool {
+	if tagRegexp.MatchString(reference) {
+		return true
+	}
+	_, ok := rangeTag(reference)
+	return ok && *tagRanges
+}
+
+// digestMiddleware rejects requests with a malformed digest in the path, as
+// a blob or referrers digest or a manifest reference, or in the digest
+// query parameter, with DIGEST_INVALID, and manifest references that are
+// neither a digest nor a tag with MANIFEST_INVALID, before they reach
+// generators.
+func digestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var digests []string
+		vars := mux.Vars(r)
+		if digest, ok := vars["digest"]; ok {
+			digests = append(digests, digest)
+		}
+		if reference, ok := vars["reference"]; isDigestReference(reference) {
+			digests = append(digests, reference)
+		} else if ok && !validTag(reference) {
+			writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, "invalid tag", reference)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/v2/") && r.URL.Query().Has("digest") {
+			digests = append(digests, r.URL.Query().Get("digest"))
+		}
+
+		for _, digest := range digests {
+			if !validDigest(digest) {
+				writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, "invalid digest", digest)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var chartDir = flag.String("chart-dir", "", "root directory of on-disk charts served by the \"dir\" generator, one chart per repository name")
+
+// errChartNotFound is returned by generators that have no chart for the
+// requested repository.
+var errChartNotFound = errors.New("chart not found")
+
+// errReferenceNotFound is returned by generators that know the repository but
+// cannot resolve the requested reference.
+var errReferenceNotFound = errors.New("reference not found")
+
+func init() {
+	generators["dir"] = dirGenerator{}
+}
+
+// dirGenerator packages the chart directory at <chart-dir>/<repository name>.
+type dirGenerator struct{}
+
+func (dirGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	dir, err := chartDirFor(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadChartDir(dir, req)
+}
+
+// chartDirFor maps a repository name to its directory under -chart-dir.
+func chartDirFor(name string) (string, error) {
+	if *chartDir == "" {
+		return "", errors.New("the dir generator requires -chart-dir")
+	}
+
+	root, err := filepath.Abs(*chartDir)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(root, filepath.FromSlash(name))
+	if !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return "", errChartNotFound
+	}
+
+	return dir, nil
+}
+
+// loadChartDir reads a chart directory into a GeneratedChart.
+func loadChartDir(dir string, req ChartRequest) (*GeneratedChart, error) {
+	files, err := readDirFiles(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errChartNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// readDirFiles reads every regular file below dir, named by its slash
+// separated path relative to dir.
+func readDirFiles(dir string) ([]ChartFile, error) {
+	var files []ChartFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, ChartFile{
+			Name: filepath.ToSlash(rel),
+			Data: data,
+			Mode: int64(info.Mode().Perm()),
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+// buildSourceChart turns the files of a chart source tree, with paths
+// relative to the chart root, into a GeneratedChart. The metadata comes from
+// its Chart.yaml and every file is packaged under <chart name>/ as helm
+// expects.
+func buildSourceChart(files []ChartFile, req ChartRequest) (*GeneratedChart, error) {
+	chartYamlIndex := -1
+	for i, f := range files {
+		if f.Name == "Chart.yaml" {
+			chartYamlIndex = i
+		}
+	}
+	if chartYamlIndex < 0 {
+		return nil, errChartNotFound
+	}
+
+	chartYaml := files[chartYamlIndex].Data
+	chart := defaultChart(req)
+	if err := yaml.Unmarshal(chartYaml, &chart); err != nil {
+		return nil, fmt.Errorf("parsing Chart.yaml: %w", err)
+	}
+	if v, ok := referenceVersion(req.Reference); ok && v != chart.Version {
+		var err error
+		chart.Version = v
+		chartYaml, err = setChartYamlField(chartYaml, "version", v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	packaged := make([]ChartFile, len(files))
+	for i, f := range files {
+		if i == chartYamlIndex {
+			f.Data = chartYaml
+		}
+		f.Name = chart.Name + "/" + f.Name
+		packaged[i] = f
+	}
+
+	return &GeneratedChart{Chart: chart, Files: packaged}, nil
+}
+
+// withChartYaml returns files with a Chart.yaml describing chart prepended,
+// unless files already contain one.
+func withChartYaml(files []ChartFile, chart Chart) ([]ChartFile, error) {
+	for _, f := range files {
+		if f.Name == "Chart.yaml" {
+			return files, nil
+		}
+	}
+
+	chartYaml, err := yaml.Marshal(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]ChartFile{{Name: "Chart.yaml", Data: chartYaml}}, files...), nil
+}
+
+// setChartYamlField rewrites a single top-level field of a Chart.yaml,
+// preserving every other field.
+func setChartYamlField(chartYaml []byte, field string, value interface{}) ([]byte, error) {
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(chartYaml, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+
+	fields[field] = value
+	return yaml.Marshal(fields)
+}
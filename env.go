@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variable of every option: -tls-cert is
+// read from VIRTUAL_HELM_TLS_CERT.
+const envPrefix = "VIRTUAL_HELM_"
+
+// envName returns the environment variable of a flag.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnv sets every flag of fs not given on the command line or in the
+// config file from its environment variable. Repeatable flags take a
+// whitespace-separated list.
+func loadEnv(fs *flag.FlagSet, fromFile map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || commandLineFlags[f.Name] || fromFile[f.Name] || f.Name == "config" || err != nil {
+			return
+		}
+
+		values := []string{value}
+		if _, repeatable := f.Value.(*stringList); repeatable {
+			values = strings.Fields(value)
+		}
+		for _, v := range values {
+			if setErr := f.Value.Set(v); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// OCI distribution spec error codes.
+const (
+	ErrCodeBlobUnknown             = "BLOB_UNKNOWN"
+	ErrCodeBlobUploadInvalid       = "BLOB_UPLOAD_INVALID"
+	ErrCodeBlobUploadUnknown       = "BLOB_UPLOAD_UNKNOWN"
+	ErrCodeDenied                  = "DENIED"
+	ErrCodeDigestInvalid           = "DIGEST_INVALID"
+	ErrCodeManifestBlobUnknown     = "MANIFEST_BLOB_UNKNOWN"
+	ErrCodeManifestInvalid         = "MANIFEST_INVALID"
+	ErrCodeManifestUnknown         = "MANIFEST_UNKNOWN"
+	ErrCodeNameInvalid             = "NAME_INVALID"
+	ErrCodeNameUnknown             = "NAME_UNKNOWN"
+	ErrCodePaginationNumberInvalid = "PAGINATION_NUMBER_INVALID"
+	ErrCodeSizeInvalid             = "SIZE_INVALID"
+	ErrCodeTooManyRequests         = "TOOMANYREQUESTS"
+	ErrCodeUnauthorized            = "UNAUTHORIZED"
+	ErrCodeUnavailable             = "UNAVAILABLE"
+	ErrCodeUnknown                 = "UNKNOWN"
+	ErrCodeUnsupported             = "UNSUPPORTED"
+)
+
+type ErrorInfo struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+type ErrorResponse struct {
+	Errors    []ErrorInfo `json:"errors"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string, detail interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+
+	e := json.NewEncoder(w)
+	e.Encode(ErrorResponse{Errors: []ErrorInfo{{
+		Code:    code,
+		Message: message,
+		Detail:  detail,
+	}}, RequestID: w.Header().Get(requestIDHeader)})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ociLayoutRefName = "org.opencontainers.image.ref.name"
+
+// ociLayoutEntry is a manifest to export with the reference it is tagged by,
+// empty for referrers.
+type ociLayoutEntry struct {
+	ref      string
+	manifest *Manifest
+}
+
+// ociLayoutEntries collects the cached, imported and pushed manifests, and
+// the artifacts attached to them, of repository or of every repository when
+// it is empty. Tags are named by reference alone for a single repository and
+// by name:reference otherwise.
+func ociLayoutEntries(repository string) []ociLayoutEntry {
+	var entries []ociLayoutEntry
+	for key, manifest := range generated.Entries() {
+		name, reference, _ := strings.Cut(key, ":")
+		switch {
+		case repository == "":
+			entries = append(entries, ociLayoutEntry{ref: key, manifest: manifest})
+		case name == repository:
+			entries = append(entries, ociLayoutEntry{ref: reference, manifest: manifest})
+		}
+	}
+
+	addTagged := func(byRef map[string]*Manifest) {
+		for key, manifest := range byRef {
+			name, reference, _ := strings.Cut(key, ":")
+			switch {
+			case reference == manifest.digest:
+			case repository == "":
+				entries = append(entries, ociLayoutEntry{ref: key, manifest: manifest})
+			case name == repository:
+				entries = append(entries, ociLayoutEntry{ref: reference, manifest: manifest})
+			}
+		}
+	}
+	imported.RLock()
+	addTagged(imported.byRef)
+	imported.RUnlock()
+	pushed.RLock()
+	addTagged(pushed.byRef)
+	// Manifests only pushed by digest, such as those an index lists, are
+	// added untagged; those with a subject are added as referrers below.
+	tagged := make(map[string]bool)
+	for key, manifest := range pushed.byRef {
+		if name, reference, _ := strings.Cut(key, ":"); reference != manifest.digest {
+			tagged[referrerKey(name, manifest.digest)] = true
+		}
+	}
+	for key, manifest := range pushed.byRef {
+		name, reference, _ := strings.Cut(key, ":")
+		if reference == manifest.digest && manifest.Subject == nil && !tagged[referrerKey(name, manifest.digest)] && (repository == "" || name == repository) {
+			entries = append(entries, ociLayoutEntry{manifest: manifest})
+		}
+	}
+	pushed.RUnlock()
+
+	referrers.RLock()
+	for key, list := range referrers.bySubject {
+		name, _, _ := strings.Cut(key, "@")
+		if repository != "" && name != repository {
+			continue
+		}
+		for _, artifact := range list {
+			entries = append(entries, ociLayoutEntry{manifest: artifact})
+		}
+	}
+	referrers.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ref != entries[j].ref {
+			return entries[i].ref < entries[j].ref
+		}
+		return entries[i].manifest.digest < entries[j].manifest.digest
+	})
+
+	return entries
+}
+
+// ociLayoutWriter writes a tarball in the OCI image layout.
+type ociLayoutWriter struct {
+	tw      *tar.Writer
+	modTime time.Time
+	index   Index
+	written map[string]bool
+}
+
+func newOCILayoutWriter(w io.Writer) (*ociLayoutWriter, error) {
+	lw := &ociLayoutWriter{
+		tw:      tar.NewWriter(w),
+		modTime: now(),
+		index:   Index{SchemaVersion: 2, MediaType: imageIndexMediaType, Manifests: []Layer{}},
+		written: make(map[string]bool),
+	}
+	if *stableDigests {
+		lw.modTime = chartEpoch
+	}
+
+	return lw, lw.file("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+}
+
+// file adds a file to the tarball.
+func (lw *ociLayoutWriter) file(name string, data []byte) error {
+	err := lw.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: lw.modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = lw.tw.Write(data)
+	return err
+}
+
+// blob adds a blob unless it was already added.
+func (lw *ociLayoutWriter) blob(digest string, data []byte) error {
+	if lw.written[digest] {
+		return nil
+	}
+	lw.written[digest] = true
+
+	return lw.file("blobs/"+strings.Replace(digest, ":", "/", 1), data)
+}
+
+// storedBlob adds a blob read from the store.
+func (lw *ociLayoutWriter) storedBlob(digest string) error {
+	if lw.written[digest] {
+		return nil
+	}
+
+	data, ok, err := store.Get(digest)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("blob %s is missing from the store", digest)
+	}
+
+	return lw.blob(digest, data)
+}
+
+// manifest lists manifest in the index, tagged ref unless it is empty, and
+// adds it and its blobs. The manifests an index lists are added separately.
+func (lw *ociLayoutWriter) manifest(ref string, manifest *Manifest) error {
+	descriptor := Layer{
+		MediaType:    manifest.MediaType,
+		ArtifactType: manifest.ArtifactType,
+		Digest:       manifest.digest,
+		Size:         len(manifest.content),
+	}
+	if ref != "" {
+		descriptor.Annotations = map[string]string{ociLayoutRefName: ref}
+	}
+	lw.index.Manifests = append(lw.index.Manifests, descriptor)
+
+	if err := lw.blob(manifest.digest, manifest.content); err != nil {
+		return err
+	}
+	if isIndexMediaType(manifest.MediaType) {
+		return nil
+	}
+	for _, digest := range manifestBlobs(manifest) {
+		if err := lw.storedBlob(digest); err != nil {
+			return fmt.Errorf("manifest %s: %w", manifest.digest, err)
+		}
+	}
+
+	return nil
+}
+
+// Close writes the index and finishes the tarball.
+func (lw *ociLayoutWriter) Close() error {
+	data, err := json.Marshal(lw.index)
+	if err != nil {
+		return err
+	}
+	if err := lw.file("index.json", data); err != nil {
+		return err
+	}
+
+	return lw.tw.Close()
+}
+
+// writeOCILayout writes the manifests of repository, or of every repository,
+// and their blobs to w as a tarball in the OCI image layout.
+func writeOCILayout(w io.Writer, repository string) error {
+	lw, err := newOCILayoutWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range ociLayoutEntries(repository) {
+		if err := lw.manifest(entry.ref, entry.manifest); err != nil {
+			return err
+		}
+	}
+
+	return lw.Close()
+}
+
+// handleAdminExport streams the registry contents as an OCI layout tarball,
+// limited to one repository with ?repository=.
+func handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	repository := r.URL.Query().Get("repository")
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	if err := writeOCILayout(w, repository); err != nil {
+		// The status is already sent, so the truncated tarball is all the
+		// client sees.
+		logger(r.Context()).Error("exporting OCI layout failed", "repository", repository, "error", err)
+	}
+}
+
+// runExport implements `virtual-helm export [flags] [repository]`.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: virtual-helm export [flags] [repository]\n\nWrites the charts held by a running server, or only those of a repository, as an OCI layout tarball.")
+		fs.PrintDefaults()
+	}
+	client := adminClientFlags(fs)
+	output := fs.String("o", "", "file to write the tarball to (standard output when empty)")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	path := "/admin/export"
+	if fs.NArg() == 1 {
+		path += "?" + url.Values{"repository": {fs.Arg(0)}}.Encode()
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	return c.do("GET", path, nil, out)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+var faultFlags stringList
+
+func init() {
+	flag.Var(&faultFlags, "fault", "fail matching requests, as \"method endpoint repository status [requests]\" such as \"GET blob charts/* 500 2\" for the 2nd blob GET, or with a status of flip or garbage to serve the body with a bit flipped or replaced by random bytes instead, where method and endpoint (manifest, blob, upload, tags, referrers, catalog) may be *, the repository is a pattern and requests counts matching requests as n, n-m or n- (every request when omitted; repeatable, the first rule whose requests include a request fails it)")
+}
+
+// RequestMatcher selects registry requests by method, endpoint and
+// repository pattern, each of which may be *.
+type RequestMatcher struct {
+	Method     string `json:"method"`
+	Endpoint   string `json:"endpoint"`
+	Repository string `json:"repository"`
+}
+
+// validate checks the endpoint and pattern, defaulting the method to *.
+func (m *RequestMatcher) validate() error {
+	if !faultEndpoints[m.Endpoint] {
+		return fmt.Errorf("invalid endpoint %q: expected manifest, blob, upload, tags, referrers, catalog or *", m.Endpoint)
+	}
+	if _, err := path.Match(m.Repository, ""); err != nil {
+		return fmt.Errorf("invalid repository pattern %q: %w", m.Repository, err)
+	}
+	m.Method = strings.ToUpper(m.Method)
+	if m.Method == "" {
+		m.Method = "*"
+	}
+
+	return nil
+}
+
+// matches reports whether a request for endpoint of repository name is
+// selected.
+func (m *RequestMatcher) matches(method string, endpoint string, name string) bool {
+	if m.Method != "*" && m.Method != method {
+		return false
+	}
+	if m.Endpoint != "*" && m.Endpoint != endpoint {
+		return false
+	}
+	matched, _ := path.Match(m.Repository, name)
+	return matched || m.Repository == "*"
+}
+
+// FaultRule makes matching requests fail with a status or, with Corrupt,
+// serves them a body that no longer matches its digest.
+type FaultRule struct {
+	RequestMatcher
+	Status int `json:"status,omitempty"`
+	// Corrupt is flip to flip a bit of the body or garbage to replace it
+	// with random bytes of the same length.
+	Corrupt string `json:"corrupt,omitempty"`
+	// From and To are the first and last matching request, counted from 1,
+	// that fail; To is 0 for no limit.
+	From int `json:"from,omitempty"`
+	To   int `json:"to,omitempty"`
+	// Matched counts the requests the rule matched.
+	Matched int `json:"matched"`
+}
+
+// faults holds the injected fault rules, from -fault and the admin API.
+var faults struct {
+	sync.Mutex
+	rules []*FaultRule
+}
+
+// faultEndpoints are the endpoints requests can be matched by.
+var faultEndpoints = map[string]bool{"*": true, "manifest": true, "blob": true, "upload": true, "tags": true, "referrers": true, "catalog": true}
+
+// initFaults parses the -fault rules.
+func initFaults() error {
+	for _, f := range faultFlags {
+		rule, err := parseFaultRule(f)
+		if err != nil {
+			return err
+		}
+		faults.rules = append(faults.rules, rule)
+	}
+
+	return nil
+}
+
+// parseFaultRule parses a "method endpoint repository status [requests]"
+// rule.
+func parseFaultRule(s string) (*FaultRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 && len(fields) != 5 {
+		return nil, fmt.Errorf("invalid fault %q: expected method endpoint repository status [requests]", s)
+	}
+
+	rule := &FaultRule{RequestMatcher: RequestMatcher{Method: fields[0], Endpoint: fields[1], Repository: fields[2]}}
+	var err error
+	if fields[3] == "flip" || fields[3] == "garbage" {
+		rule.Corrupt = fields[3]
+	} else if rule.Status, err = strconv.Atoi(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid fault %q: expected a status, flip or garbage rather than %q", s, fields[3])
+	}
+	if len(fields) == 5 {
+		from, to, ranged := strings.Cut(fields[4], "-")
+		if rule.From, err = strconv.Atoi(from); err != nil {
+			return nil, fmt.Errorf("invalid fault %q: expected requests as n, n-m or n-", s)
+		}
+		rule.To = rule.From
+		if ranged {
+			rule.To = 0
+			if to != "" {
+				if rule.To, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid fault %q: expected requests as n, n-m or n-", s)
+				}
+			}
+		}
+	}
+
+	return rule, validateFaultRule(rule)
+}
+
+func validateFaultRule(rule *FaultRule) error {
+	if err := rule.validate(); err != nil {
+		return fmt.Errorf("invalid fault: %w", err)
+	}
+	switch {
+	case rule.Corrupt != "" && rule.Status != 0:
+		return fmt.Errorf("invalid fault: a rule either fails with a status or corrupts the body")
+	case rule.Corrupt != "" && rule.Corrupt != "flip" && rule.Corrupt != "garbage":
+		return fmt.Errorf("invalid fault corruption %q: expected flip or garbage", rule.Corrupt)
+	case rule.Corrupt == "" && (rule.Status < 400 || rule.Status > 599):
+		return fmt.Errorf("invalid fault status %d: expected an error status", rule.Status)
+	}
+	if rule.From < 0 || rule.To < 0 || (rule.To > 0 && rule.To < rule.From) {
+		return fmt.Errorf("invalid fault requests %d-%d", rule.From, rule.To)
+	}
+
+	return nil
+}
+
+var endpointPathRegexp = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs/uploads|blobs|tags|referrers)/`)
+
+// requestEndpoint returns the endpoint and repository of a registry request.
+func requestEndpoint(r *http.Request) (string, string, bool) {
+	if r.URL.Path == "/v2/_catalog" {
+		return "catalog", "", true
+	}
+
+	match := endpointPathRegexp.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		return "", "", false
+	}
+	endpoint := map[string]string{"manifests": "manifest", "blobs/uploads": "upload", "blobs": "blob", "tags": "tags", "referrers": "referrers"}[match[2]]
+	return endpoint, match[1], true
+}
+
+// injectedFault counts r against every rule it matches and returns the
+// first one whose requests include it.
+func injectedFault(r *http.Request) (FaultRule, bool) {
+	endpoint, name, ok := requestEndpoint(r)
+	if !ok {
+		return FaultRule{}, false
+	}
+
+	faults.Lock()
+	defer faults.Unlock()
+
+	var fault *FaultRule
+	for _, rule := range faults.rules {
+		if !rule.matches(r.Method, endpoint, name) {
+			continue
+		}
+
+		rule.Matched++
+		if fault == nil && rule.Matched >= rule.From && (rule.To == 0 || rule.Matched <= rule.To) {
+			fault = rule
+		}
+	}
+	if fault == nil {
+		return FaultRule{}, false
+	}
+
+	return *fault, true
+}
+
+// faultMiddleware fails requests matching a fault rule.
+func faultMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault, ok := injectedFault(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if fault.Corrupt != "" {
+			logger(r.Context()).Info("injecting corruption", "corrupt", fault.Corrupt)
+			next.ServeHTTP(&corruptingWriter{ResponseWriter: w, garbage: fault.Corrupt == "garbage"}, r)
+			return
+		}
+
+		status := fault.Status
+		logger(r.Context()).Info("injecting fault", "status", status)
+		code := ErrCodeUnknown
+		switch status {
+		case http.StatusTooManyRequests:
+			code = ErrCodeTooManyRequests
+			w.Header().Set("Retry-After", "1")
+		case http.StatusServiceUnavailable:
+			code = ErrCodeUnavailable
+		}
+		writeError(w, status, code, "injected fault", nil)
+	})
+}
+
+// faultsEnabled reports whether fault rules can apply, now or once added
+// through the admin API.
+func faultsEnabled() bool {
+	return len(faults.rules) > 0 || adminToken != nil
+}
+
+// registerFaultRoutes adds the fault rules to the admin API.
+func registerFaultRoutes(admin *mux.Router) {
+	admin.HandleFunc("/faults", handleAdminFaults).Methods("GET")
+	admin.HandleFunc("/faults", handleAdminAddFault).Methods("POST")
+	admin.HandleFunc("/faults", handleAdminClearFaults).Methods("DELETE")
+}
+
+func handleAdminFaults(w http.ResponseWriter, r *http.Request) {
+	faults.Lock()
+	rules := make([]FaultRule, 0, len(faults.rules))
+	for _, rule := range faults.rules {
+		rules = append(rules, *rule)
+	}
+	faults.Unlock()
+
+	writeAdminJSON(w, rules)
+}
+
+// handleAdminAddFault appends a rule, given as FaultRule JSON.
+func handleAdminAddFault(w http.ResponseWriter, r *http.Request) {
+	var rule FaultRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknown, "invalid fault rule: "+err.Error(), nil)
+		return
+	}
+	rule.Matched = 0
+	if err := validateFaultRule(&rule); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+
+	faults.Lock()
+	faults.rules = append(faults.rules, &rule)
+	faults.Unlock()
+
+	logger(r.Context()).Info("added fault rule", "method", rule.Method, "endpoint", rule.Endpoint, "repository", rule.Repository, "status", rule.Status, "corrupt", rule.Corrupt)
+	writeAdminJSON(w, rule)
+}
+
+func handleAdminClearFaults(w http.ResponseWriter, r *http.Request) {
+	faults.Lock()
+	n := len(faults.rules)
+	faults.rules = nil
+	faults.Unlock()
+
+	writeAdminJSON(w, map[string]int{"removed": n})
+}
+
+// corruptingWriter serves a body of the same length that does not match
+// its digest: the first byte of a successful response has a bit flipped,
+// or with garbage every byte is random.
+type corruptingWriter struct {
+	http.ResponseWriter
+	garbage bool
+	status  int
+	flipped bool
+}
+
+func (w *corruptingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *corruptingWriter) Write(p []byte) (int, error) {
+	if w.status >= 300 || len(p) == 0 {
+		return w.ResponseWriter.Write(p)
+	}
+
+	corrupted := make([]byte, len(p))
+	if w.garbage {
+		io.ReadFull(randReader, corrupted)
+	} else {
+		copy(corrupted, p)
+		if !w.flipped {
+			corrupted[0] ^= 1
+			w.flipped = true
+		}
+	}
+
+	return w.ResponseWriter.Write(corrupted)
+}
+
+func (w *corruptingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	storageBackend = flag.String("storage", "memory", "blob storage backend: memory, or filesystem to keep blobs in -storage-dir across restarts")
+	storageDir     = flag.String("storage-dir", "data", "directory of the filesystem storage backend")
+)
+
+// initStore sets up the -storage backend.
+func initStore() error {
+	switch *storageBackend {
+	case "memory":
+		return nil
+	case "filesystem":
+		s, err := newFilesystemStore(*storageDir)
+		if err != nil {
+			return err
+		}
+		store = s
+		return nil
+	default:
+		return fmt.Errorf("invalid -storage %q: expected memory or filesystem", *storageBackend)
+	}
+}
+
+// filesystemStore keeps blobs as files named after their digest, laid out
+// like the blobs of an OCI image layout.
+type filesystemStore struct {
+	dir string
+}
+
+func newFilesystemStore(dir string) (*filesystemStore, error) {
+	s := &filesystemStore{dir: dir}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// path returns the file of a blob, rejecting digests that are not a plain
+// algorithm:hex pair.
+func (s *filesystemStore) path(digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(digest, `/\.`) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+
+	return filepath.Join(s.dir, "blobs", algorithm, hex), nil
+}
+
+func (s *filesystemStore) Put(digest string, blob []byte) error {
+	w, err := s.Writer()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(blob); err != nil {
+		w.Cancel()
+		return err
+	}
+
+	return w.Commit(digest)
+}
+
+func (s *filesystemStore) Get(digest string) ([]byte, bool, error) {
+	p, err := s.path(digest)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	blob, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return blob, true, nil
+}
+
+func (s *filesystemStore) Delete(digest string) error {
+	p, err := s.path(digest)
+	if err != nil {
+		return nil
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Writer writes to a temporary file that Commit renames into place, so
+// readers never see partial blobs.
+func (s *filesystemStore) Writer() (BlobWriter, error) {
+	f, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &filesystemBlobWriter{store: s, f: f}, nil
+}
+
+func (s *filesystemStore) Stats() (count int, size int) {
+	filepath.WalkDir(filepath.Join(s.dir, "blobs"), func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			count++
+			size += int(info.Size())
+		}
+		return nil
+	})
+
+	return count, size
+}
+
+func (s *filesystemStore) Ping() error {
+	_, err := os.Stat(filepath.Join(s.dir, "blobs"))
+	return err
+}
+
+func (s *filesystemStore) Close() error {
+	return nil
+}
+
+type filesystemBlobWriter struct {
+	store *filesystemStore
+	f     *os.File
+}
+
+func (w *filesystemBlobWriter) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+func (w *filesystemBlobWriter) Commit(digest string) error {
+	p, err := w.store.path(digest)
+	if err != nil {
+		w.Cancel()
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	// Blobs are content-addressed, so a blob already stored under digest
+	// is the same and is not written again.
+	if _, err := os.Stat(p); err == nil {
+		return os.Remove(w.f.Name())
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	return os.Rename(w.f.Name(), p)
+}
+
+func (w *filesystemBlobWriter) Cancel() error {
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// runGenerate implements `virtual-helm generate [flags] <name> <reference>`.
+func runGenerate(args []string) error {
+	output := flag.String("o", "-", "file to write the chart archive to, - for stdout")
+	manifestOutput := flag.String("manifest", "", "file to also write the manifest JSON to, - for stdout")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: virtual-helm generate [flags] <name> <reference>\n\nGenerates a chart the way a running server would and writes its archive, without serving anything. The flags of serve configure generation.")
+		flag.PrintDefaults()
+	}
+
+	positional, err := parseCommandLine(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name, reference := positional[0], positional[1]
+
+	manifest, err := generateOffline(name, reference)
+	if err != nil {
+		return err
+	}
+
+	var archive []byte
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == contentMediaType() {
+			blob, ok, err := store.Get(layer.Digest)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("chart content %s is missing from the store", layer.Digest)
+			}
+			archive = blob
+			break
+		}
+	}
+	if archive == nil {
+		return fmt.Errorf("%s:%s has no chart content layer", name, reference)
+	}
+
+	if err := writeOutput(*output, archive); err != nil {
+		return err
+	}
+	if *manifestOutput != "" {
+		return writeOutput(*manifestOutput, append(manifest.content, '\n'))
+	}
+
+	return nil
+}
+
+// parseCommandLine parses the flags of serve and of a command from args,
+// which may interleave flags and positional arguments, after which it loads
+// the config file and environment like serve does. It returns the
+// positional arguments.
+func parseCommandLine(args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flag.CommandLine.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flag.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if err := loadConfig(); err != nil {
+		return nil, err
+	}
+	if err := setupLogging(os.Stderr); err != nil {
+		return nil, err
+	}
+
+	return positional, nil
+}
+
+// generateOffline sets up generation and returns the manifest of
+// name:reference, outside of any server.
+func generateOffline(name string, reference string) (*Manifest, error) {
+	if err := initGeneration(); err != nil {
+		return nil, err
+	}
+
+	return resolveManifest(context.Background(), rewriteName(name), reference)
+}
+
+// writeOutput writes data to a file, or to stdout when file is -.
+func writeOutput(file string, data []byte) error {
+	var w io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// DigestInfo lists the digests of a chart.
+type DigestInfo struct {
+	Manifest string   `json:"manifest"`
+	Config   string   `json:"config"`
+	Layers   []string `json:"layers"`
+}
+
+// runDigest implements `virtual-helm digest [flags] <name>:<reference>`.
+func runDigest(args []string) error {
+	asJSON := flag.Bool("json", false, "print the digests as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: virtual-helm digest [flags] <name>:<reference>\n\nPrints the manifest, config and layer digests a server with the same flags would serve for name:reference, so they can be pinned ahead of time. The flags of serve configure generation; digests are only reproducible with -stable-digests.")
+		flag.PrintDefaults()
+	}
+
+	positional, err := parseCommandLine(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	i := strings.LastIndex(positional[0], ":")
+	if i <= 0 || i == len(positional[0])-1 {
+		return fmt.Errorf("invalid chart %q: expected name:reference", positional[0])
+	}
+	name, reference := positional[0][:i], positional[0][i+1:]
+	if _, ok := fixedAppVersion(name, reference); !ok {
+		slog.Warn("without -stable-digests or a pinned -app-version the manifest digest includes the creation time and changes on every generation")
+	}
+
+	manifest, err := generateOffline(name, reference)
+	if err != nil {
+		return err
+	}
+
+	info := DigestInfo{Manifest: manifest.digest, Config: manifest.Config.Digest}
+	for _, layer := range manifest.Layers {
+		info.Layers = append(info.Layers, layer.Digest)
+	}
+
+	if *asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(info)
+	}
+
+	fmt.Printf("manifest %s\nconfig   %s\n", info.Manifest, info.Config)
+	for i, layer := range manifest.Layers {
+		fmt.Printf("layer    %s %s\n", info.Layers[i], layer.MediaType)
+	}
+	return nil
+}
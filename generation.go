@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/cdelautour/virutal-helm/internal/chartarchive"
+	"github.com/cdelautour/virutal-helm/internal/shared"
+)
+
+var (
+	maxGenerations    = flag.Int("max-generations", 0, "maximum number of chart generations running at once (unlimited when 0)")
+	generationWaitMax = flag.Duration("generation-queue-timeout", 10*time.Second, "how long a request waits for a free generation slot before failing with 503")
+	pinAppVersion     = flag.String("app-version", "", "appVersion for generated charts (defaults to the generation time)")
+	appVersionFromTag = flag.Bool("app-version-from-tag", false, "set appVersion to the pulled tag when it is a valid semver version")
+	stableDigests     = flag.Bool("stable-digests", false, "only change a tag's digest when the chart definition changes, for GitOps controllers that reconcile on digests")
+)
+
+// chartEpoch is the modification time recorded for every file in generated
+// tarballs, so identical charts always produce identical bytes.
+var chartEpoch = chartarchive.Epoch
+
+// appVersionFor returns the appVersion recorded in the chart for
+// name:reference. Unless pinned or taken from an -app-image it is the
+// generation time, which makes the chart digest change on every generation.
+func appVersionFor(name string, reference string) string {
+	if v, ok := fixedAppVersion(name, reference); ok {
+		return v
+	}
+
+	return now().Format(time.RFC822)
+}
+
+// fixedAppVersion returns the appVersion of name:reference when it does not
+// depend on the generation time, so the chart is reproducible.
+func fixedAppVersion(name string, reference string) (string, bool) {
+	if *pinAppVersion != "" {
+		return *pinAppVersion, true
+	}
+
+	if _, tag, ok := appImageFor(name); ok {
+		return tag, true
+	}
+
+	if *appVersionFromTag {
+		if v, ok := referenceVersion(reference); ok {
+			return v, true
+		}
+	}
+
+	if *stableDigests {
+		return definitionFingerprint(name, reference), true
+	}
+
+	return "", false
+}
+
+// defaultChartVersion is used when the pulled reference is not a semver tag.
+var defaultChartVersion = flag.String("chart-version", "0.1.0", "version of generated charts pulled by a tag that is not a semver version")
+
+// chartVersionFor returns the chart version for a pulled reference, so
+// `helm pull --version 2.3.4` receives a chart that claims to be 2.3.4.
+func chartVersionFor(reference string) string {
+	if v, ok := referenceVersion(reference); ok {
+		return v
+	}
+
+	return *defaultChartVersion
+}
+
+// referenceVersion parses reference as a semver tag. Helm replaces the "+"
+// of build metadata with "_" when pushing because "+" is not valid in OCI
+// tags, so the substitution is undone here.
+func referenceVersion(reference string) (string, bool) {
+	v, err := semver.StrictNewVersion(strings.ReplaceAll(reference, "_", "+"))
+	if err != nil {
+		return "", false
+	}
+
+	return v.String(), true
+}
+
+// definitionFingerprint identifies everything a generated chart is derived
+// from, so it changes exactly when the generated content would.
+func definitionFingerprint(name string, reference string) string {
+	h := sha256.New()
+	info := versionInfo()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", info.Version, info.Commit, name, reference)
+	return fmt.Sprintf("%x", h.Sum(nil))[:12]
+}
+
+var errGenerationBusy = errors.New("too many chart generations in progress")
+
+var generationSlots chan struct{}
+
+// generations collapses concurrent generations of the same name:reference,
+// and pulls from upstream registries, into a single execution.
+var generations shared.Group
+
+func initGenerationLimit() {
+	if *maxGenerations > 0 {
+		generationSlots = make(chan struct{}, *maxGenerations)
+	}
+}
+
+// acquireGeneration blocks until a generation slot is free, the queue timeout
+// elapses or ctx is done. The returned function releases the slot.
+func acquireGeneration(ctx context.Context) (func(), error) {
+	if generationSlots == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(*generationWaitMax)
+	defer timer.Stop()
+
+	select {
+	case generationSlots <- struct{}{}:
+		return func() { <-generationSlots }, nil
+	case <-timer.C:
+		return nil, errGenerationBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// generateShared returns the cached manifest for name:reference or generates
+// it, sharing the result with any concurrent request for the same chart.
+func generateShared(ctx context.Context, name string, reference string) (*Manifest, error) {
+	key := cacheKey(name, reference)
+	if !*noCache {
+		if manifest, ok := generated.Get(key); ok {
+			return manifest, nil
+		}
+	}
+
+	return generateFresh(ctx, name, reference)
+}
+
+// generateFresh generates name:reference and replaces any cached manifest,
+// sharing the generation with any concurrent request for the same chart.
+func generateFresh(ctx context.Context, name string, reference string) (*Manifest, error) {
+	key := cacheKey(name, reference)
+	v, err := generations.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		manifest, err := generateChart(ctx, name, reference)
+		if err != nil {
+			return nil, err
+		}
+		// Even with -no-cache, the manifest is kept for pulls by digest.
+		generated.Add(key, manifest)
+		recordGeneratedTag(name, reference)
+		mirrorChart(name, reference, manifest)
+		writeGolden(name, reference, manifest)
+		return manifest, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*Manifest), nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/cdelautour/virutal-helm/chartgen"
+	"github.com/cdelautour/virutal-helm/internal/chartarchive"
+)
+
+// The generator types are defined in the chartgen package so that plugins
+// can implement them.
+type (
+	Chart          = chartgen.Chart
+	ChartRequest   = chartgen.Request
+	ChartFile      = chartgen.File
+	GeneratedChart = chartgen.Result
+	ChartGenerator = chartgen.Generator
+	Dependency     = chartgen.Dependency
+)
+
+// generators holds the generators that repository patterns can be routed to,
+// by name.
+var generators = map[string]ChartGenerator{
+	"default": defaultGenerator{},
+	"readme":  readmeGenerator{},
+}
+
+const defaultGeneratorName = "default"
+
+const defaultDescription = "A dynamically generated chart"
+
+var (
+	chartAPIVersion  = flag.String("chart-api-version", "v2", "apiVersion of generated charts")
+	chartDescription = flag.String("chart-description", defaultDescription, "description of generated charts without -chart-metadata")
+	chartType        = flag.String("chart-type", "application", "type of generated charts")
+)
+
+// generatorRoute maps repository names matching pattern to a generator.
+type generatorRoute struct {
+	pattern   string
+	generator string
+}
+
+var generatorRoutes []generatorRoute
+
+var generatorFlags stringList
+
+func init() {
+	flag.Var(&generatorFlags, "generator", "route repositories matching a pattern to a generator, as pattern=generator, or to an -upstream registry, as pattern=upstream:name (repeatable, first match wins)")
+}
+
+// initGeneratorRoutes parses the -generator flags.
+func initGeneratorRoutes() error {
+	var err error
+	generatorRoutes, err = parseGeneratorRoutes(append(proxyRoutes, generatorFlags...))
+	return err
+}
+
+// parseGeneratorRoutes parses pattern=generator routes.
+func parseGeneratorRoutes(flags stringList) ([]generatorRoute, error) {
+	var routes []generatorRoute
+	for _, f := range flags {
+		pattern, name, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid generator route %q: expected pattern=generator", f)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid generator route %q: %w", f, err)
+		}
+		if upstream, ok := strings.CutPrefix(name, upstreamRoutePrefix); ok {
+			if _, ok := upstreams[upstream]; !ok {
+				return nil, fmt.Errorf("invalid generator route %q: unknown upstream %q", f, upstream)
+			}
+		} else if _, ok := generators[name]; !ok {
+			return nil, fmt.Errorf("invalid generator route %q: unknown generator %q", f, name)
+		}
+
+		routes = append(routes, generatorRoute{pattern: pattern, generator: name})
+	}
+
+	return routes, nil
+}
+
+// generatorFor returns the generator the first matching route assigns to
+// name, falling back to the default generator. It is nil when name is routed
+// to an upstream registry.
+func generatorFor(name string) ChartGenerator {
+	return generators[generatorNameFor(name)]
+}
+
+// generatorNameFor returns the name of the generator serving name.
+func generatorNameFor(name string) string {
+	if route := routeFor(name); route != nil {
+		return route.generator
+	}
+
+	return defaultGeneratorName
+}
+
+// routeFor returns the first route matching name, if any.
+func routeFor(name string) *generatorRoute {
+	reloadMu.RLock()
+	routes := generatorRoutes
+	reloadMu.RUnlock()
+
+	for i, route := range routes {
+		if ok, _ := path.Match(route.pattern, name); ok {
+			return &routes[i]
+		}
+	}
+
+	return nil
+}
+
+// defaultChart returns the Chart.yaml metadata generators start from,
+// including any -chart-metadata configured for the repository. Helm names an
+// OCI chart after the last segment of its repository path.
+func defaultChart(req ChartRequest) Chart {
+	chart := Chart{
+		ApiVersion:  *chartAPIVersion,
+		Name:        path.Base(req.Name),
+		Description: *chartDescription,
+		Type:        *chartType,
+		Version:     chartVersionFor(req.Reference),
+		AppVersion:  appVersionFor(req.Name, req.Reference),
+	}
+	applyChartMetadata(&chart, req.Name)
+	applyChartIcon(&chart, req.Name)
+
+	return chart
+}
+
+// chartWithOverrides returns the default chart metadata for req with the
+// Chart.yaml fields in overrides applied, as returned by scripted generators.
+func chartWithOverrides(req ChartRequest, overrides map[string]interface{}) (Chart, error) {
+	chart := defaultChart(req)
+	if overrides == nil {
+		return chart, nil
+	}
+
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return chart, err
+	}
+	if err := json.Unmarshal(data, &chart); err != nil {
+		return chart, fmt.Errorf("invalid chart metadata: %w", err)
+	}
+
+	return chart, nil
+}
+
+// defaultGenerator serves a small but complete, installable chart.
+type defaultGenerator struct{}
+
+func (defaultGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	files := defaultChartFiles()
+	if chartTestsEnabled(req.Name) {
+		files = withChartTests(files)
+	}
+
+	files, err := withChartYaml(files, defaultChart(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// readmeGenerator serves a chart containing a single README, as the default
+// generator did before it served a complete chart, for the configurations
+// that route to it by name.
+type readmeGenerator struct{}
+
+func (readmeGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	return &GeneratedChart{
+		Chart: defaultChart(req),
+		Files: []ChartFile{{
+			Name: "README.md",
+			Data: []byte("Hello helm!"),
+		}},
+	}, nil
+}
+
+var (
+	gzipLevel          = flag.Int("gzip-level", gzip.DefaultCompression, "gzip compression level of chart archives, from 1 (fastest) to 9 (smallest), 0 to store without compressing or -1 for the default; lower levels save CPU when generating large charts")
+	uncompressedLayers = flag.Bool("uncompressed-layers", false, "serve the content layer of generated charts as a plain tarball, with the -chart-content-media-type minus its +gzip suffix; Helm itself only pulls gzipped charts, and dependencies in charts/ stay gzipped")
+)
+
+// initCompression checks the -gzip-level and -uncompressed-layers.
+func initCompression() error {
+	if *gzipLevel < gzip.DefaultCompression || *gzipLevel > gzip.BestCompression {
+		return fmt.Errorf("invalid -gzip-level %d: expected -1 to 9", *gzipLevel)
+	}
+	if *uncompressedLayers && (*validateCharts || *renderCharts) {
+		return errors.New("-uncompressed-layers cannot be combined with -validate-charts or -render-charts, which load gzipped archives")
+	}
+
+	return nil
+}
+
+// contentMediaType returns the media type of the content layer of generated
+// charts.
+func contentMediaType() string {
+	if *uncompressedLayers {
+		return strings.TrimSuffix(*chartContentMediaType, "+gzip")
+	}
+
+	return *chartContentMediaType
+}
+
+// writeChartArchive streams files as a tarball to w, gzipped unless
+// compress is false.
+func writeChartArchive(ctx context.Context, w io.Writer, files []ChartFile, compress bool) error {
+	_, span := tracer.Start(ctx, "writeChartArchive")
+	defer span.End()
+
+	// Archiving, compressing and hashing large charts is most of the cost
+	// of a generation, so it stops when the request is cancelled.
+	w = contextWriter{ctx: ctx, w: w}
+	if !compress {
+		return chartarchive.WriteTar(tar.NewWriter(w), files)
+	}
+
+	gz := getGzipWriter(w)
+	defer putGzipWriter(gz)
+	if err := chartarchive.WriteTar(tar.NewWriter(gz), files); err != nil {
+		return err
+	}
+
+	// The gzip writer must be closed as well to emit the gzip trailer.
+	return gz.Close()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	gitRepo          = flag.String("git-repo", "", "git repository URL served by the \"git\" generator")
+	gitChartPath     = flag.String("git-chart-path", ".", "chart directory within the git repository; {name} is replaced by the repository name")
+	gitCacheDir      = flag.String("git-cache-dir", "", "directory holding the local mirror of -git-repo (defaults to a temporary directory)")
+	gitFetchInterval = flag.Duration("git-fetch-interval", time.Minute, "how often the git mirror is fetched from -git-repo")
+)
+
+func init() {
+	generators["git"] = &gitGenerator{}
+}
+
+// gitGenerator packages a chart from a local mirror of -git-repo at the
+// branch, tag or commit named by the pulled reference. The mirror is fetched
+// in the background and cached charts are evicted when it changes.
+type gitGenerator struct {
+	mu     sync.RWMutex
+	mirror string
+}
+
+func (g *gitGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.mirror == "" {
+		return nil, errors.New("the git generator requires -git-repo")
+	}
+
+	commit, err := g.git(ctx, "rev-parse", "--verify", "--quiet", "--end-of-options", req.Reference+"^{commit}")
+	if err != nil {
+		return nil, errReferenceNotFound
+	}
+
+	chartPath := path.Clean(strings.ReplaceAll(*gitChartPath, "{name}", req.Name))
+	if chartPath == "." {
+		chartPath = ""
+	}
+
+	treeish := strings.TrimSpace(string(commit)) + ":" + strings.TrimPrefix(chartPath, "/")
+	archive, err := g.git(ctx, "archive", "--format=tar", treeish)
+	if err != nil {
+		return nil, errChartNotFound
+	}
+
+	files, err := readTarFiles(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// start creates or refreshes the mirror and keeps fetching it.
+func (g *gitGenerator) start() error {
+	if *gitRepo == "" {
+		return nil
+	}
+
+	dir := *gitCacheDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "virtual-helm-git-")
+		if err != nil {
+			return err
+		}
+		dir = tmp
+	}
+
+	mirror := filepath.Join(dir, "mirror.git")
+	if _, err := os.Stat(mirror); errors.Is(err, os.ErrNotExist) {
+		out, err := exec.Command("git", "clone", "--mirror", "--quiet", *gitRepo, mirror).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("cloning %s: %w: %s", *gitRepo, err, out)
+		}
+	}
+
+	g.mu.Lock()
+	g.mirror = mirror
+	g.mu.Unlock()
+
+	go func() {
+		for range time.Tick(*gitFetchInterval) {
+			if err := g.fetch(); err != nil {
+				slog.Warn("git fetch failed", "repo", *gitRepo, "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// fetch updates the mirror and purges the generation cache if any ref moved.
+func (g *gitGenerator) fetch() error {
+	ctx := context.Background()
+	before, _ := g.git(ctx, "show-ref")
+
+	g.mu.Lock()
+	out, err := exec.Command("git", "--git-dir", g.mirror, "fetch", "--prune", "--quiet", "origin").CombinedOutput()
+	g.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	after, _ := g.git(ctx, "show-ref")
+	if !bytes.Equal(before, after) {
+		n := generated.Purge()
+		slog.Info("git repository changed, purged generation cache", "repo", *gitRepo, "entries", n)
+	}
+
+	return nil
+}
+
+func (g *gitGenerator) git(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--git-dir", g.mirror}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, stderr.String())
+	}
+
+	return out, nil
+}
+
+// readTarFiles reads the regular files of a tar stream.
+func readTarFiles(r io.Reader) ([]ChartFile, error) {
+	var files []ChartFile
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, ChartFile{
+			Name: strings.TrimPrefix(header.Name, "./"),
+			Data: data,
+			Mode: header.Mode & 0777,
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var goldenDir = flag.String("golden-dir", "", "directory every generated chart is written to, for diffing generated output across versions: the manifest and its blobs as sha256/<hex>, and refs/<name>/<reference> holding the manifest digest; combine with -deterministic so unchanged charts keep their digests (disabled when empty)")
+
+// writeGolden writes a freshly generated chart to -golden-dir. Failures are
+// logged rather than failing the pull.
+func writeGolden(name string, reference string, manifest *Manifest) {
+	if *goldenDir == "" {
+		return
+	}
+
+	if err := writeGoldenFiles(name, reference, manifest); err != nil {
+		slog.Error("writing golden files failed", "name", name, "reference", reference, "dir", *goldenDir, "error", err)
+	}
+}
+
+func writeGoldenFiles(name string, reference string, manifest *Manifest) error {
+	if err := writeGoldenBlob(manifest.digest, manifest.content); err != nil {
+		return err
+	}
+	for _, digest := range manifestBlobs(manifest) {
+		blob, ok, err := store.Get(digest)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("blob %s is missing from the store", digest)
+		}
+		if err := writeGoldenBlob(digest, blob); err != nil {
+			return err
+		}
+	}
+
+	ref := filepath.Join(*goldenDir, "refs", filepath.FromSlash(name), reference)
+	if err := os.MkdirAll(filepath.Dir(ref), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(ref, []byte(manifest.digest+"\n"), 0644)
+}
+
+// writeGoldenBlob writes data as sha256/<hex> unless it is already there.
+func writeGoldenBlob(digest string, data []byte) error {
+	file := filepath.Join(*goldenDir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+	if _, err := os.Stat(file); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0644)
+}
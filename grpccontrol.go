@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/cdelautour/virutal-helm/controlpb"
+)
+
+var grpcAddr = flag.String("grpc-addr", "", "serve the gRPC control API, defined in controlpb/control.proto, on this address; it requires the -admin-token-file token as a bearer token (disabled when empty)")
+
+// startControlServer serves the gRPC control API on a separate listener,
+// like the debug server.
+func startControlServer() error {
+	if *grpcAddr == "" {
+		return nil
+	}
+	if adminToken == nil {
+		return errors.New("-grpc-addr requires -admin-token-file")
+	}
+
+	ln, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkControlToken(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkControlToken(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	controlpb.RegisterControlServer(srv, controlServer{})
+	reflection.Register(srv)
+
+	go func() {
+		slog.Info("starting gRPC control server", "addr", ln.Addr().String())
+		if err := srv.Serve(ln); err != nil {
+			slog.Error("gRPC control server stopped", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// checkControlToken requires the admin token as a bearer token in the
+// authorization metadata.
+func checkControlToken(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), adminToken) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "authentication required")
+}
+
+// controlServer implements the gRPC control API over the same state as the
+// admin API.
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+}
+
+func (controlServer) ListGenerators(ctx context.Context, req *controlpb.ListGeneratorsRequest) (*controlpb.ListGeneratorsResponse, error) {
+	resp := &controlpb.ListGeneratorsResponse{}
+	for name := range generators {
+		resp.Generators = append(resp.Generators, name)
+	}
+	sort.Strings(resp.Generators)
+
+	reloadMu.RLock()
+	for _, route := range generatorRoutes {
+		resp.Routes = append(resp.Routes, &controlpb.GeneratorRoute{Pattern: route.pattern, Generator: route.generator})
+	}
+	reloadMu.RUnlock()
+
+	return resp, nil
+}
+
+// SetGeneratorRoutes replaces the -generator routes, keeping the routes of
+// the -proxy-name patterns ahead of them as a reload does.
+func (s controlServer) SetGeneratorRoutes(ctx context.Context, req *controlpb.SetGeneratorRoutesRequest) (*controlpb.ListGeneratorsResponse, error) {
+	var flags stringList
+	for _, route := range req.Routes {
+		flags = append(flags, route.Pattern+"="+route.Generator)
+	}
+	routes, err := parseGeneratorRoutes(append(proxyRoutes, flags...))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	reloadMu.Lock()
+	generatorRoutes = routes
+	reloadMu.Unlock()
+
+	slog.Info("set generator routes", "routes", []string(flags))
+	return s.ListGenerators(ctx, nil)
+}
+
+func (controlServer) InvalidateCache(ctx context.Context, req *controlpb.InvalidateCacheRequest) (*controlpb.InvalidateCacheResponse, error) {
+	if req.Repository == "" {
+		if req.Blobs {
+			return nil, status.Error(codes.InvalidArgument, "blobs requires a repository")
+		}
+		n := generated.Purge()
+		slog.Info("purged generation cache", "evicted", n)
+		return &controlpb.InvalidateCacheResponse{Evicted: int32(n)}, nil
+	}
+
+	result, err := purgeRepository(req.Repository, req.Blobs)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	slog.Info("purged repository", "name", req.Repository, "evicted", result.Evicted, "blobs_deleted", result.BlobsDeleted)
+	return &controlpb.InvalidateCacheResponse{Evicted: int32(result.Evicted), BlobsDeleted: int32(result.BlobsDeleted)}, nil
+}
+
+func (controlServer) ListFaults(ctx context.Context, req *controlpb.ListFaultsRequest) (*controlpb.ListFaultsResponse, error) {
+	faults.Lock()
+	defer faults.Unlock()
+
+	resp := &controlpb.ListFaultsResponse{}
+	for _, rule := range faults.rules {
+		resp.Faults = append(resp.Faults, faultProto(rule))
+	}
+
+	return resp, nil
+}
+
+func (controlServer) AddFault(ctx context.Context, req *controlpb.AddFaultRequest) (*controlpb.Fault, error) {
+	f := req.GetFault()
+	if f == nil {
+		return nil, status.Error(codes.InvalidArgument, "fault is required")
+	}
+	rule := &FaultRule{
+		RequestMatcher: RequestMatcher{Method: f.Method, Endpoint: f.Endpoint, Repository: f.Repository},
+		Status:         int(f.Status),
+		Corrupt:        f.Corrupt,
+		From:           int(f.From),
+		To:             int(f.To),
+	}
+	if err := validateFaultRule(rule); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	faults.Lock()
+	faults.rules = append(faults.rules, rule)
+	faults.Unlock()
+
+	slog.Info("added fault rule", "method", rule.Method, "endpoint", rule.Endpoint, "repository", rule.Repository, "status", rule.Status, "corrupt", rule.Corrupt)
+	return faultProto(rule), nil
+}
+
+func (controlServer) ClearFaults(ctx context.Context, req *controlpb.ClearFaultsRequest) (*controlpb.ClearFaultsResponse, error) {
+	faults.Lock()
+	n := len(faults.rules)
+	faults.rules = nil
+	faults.Unlock()
+
+	return &controlpb.ClearFaultsResponse{Removed: int32(n)}, nil
+}
+
+// faultProto converts a fault rule; the caller holds the faults lock.
+func faultProto(rule *FaultRule) *controlpb.Fault {
+	return &controlpb.Fault{
+		Method:     rule.Method,
+		Endpoint:   rule.Endpoint,
+		Repository: rule.Repository,
+		Status:     int32(rule.Status),
+		Corrupt:    rule.Corrupt,
+		From:       int32(rule.From),
+		To:         int32(rule.To),
+		Matched:    int32(rule.Matched),
+	}
+}
+
+func (controlServer) StreamRequests(req *controlpb.StreamRequestsRequest, stream grpc.ServerStreamingServer[controlpb.RequestEvent]) error {
+	if _, err := path.Match(req.Repository, ""); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid repository pattern: "+err.Error())
+	}
+
+	events := subscribeRequests(req.Repository)
+	defer unsubscribeRequests(events)
+
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// requestSubscribers are the channels of the StreamRequests calls, with the
+// repository pattern each was called with.
+var requestSubscribers = struct {
+	sync.Mutex
+	byChannel map[chan *controlpb.RequestEvent]string
+}{byChannel: make(map[chan *controlpb.RequestEvent]string)}
+
+func subscribeRequests(pattern string) chan *controlpb.RequestEvent {
+	events := make(chan *controlpb.RequestEvent, 64)
+
+	requestSubscribers.Lock()
+	requestSubscribers.byChannel[events] = pattern
+	requestSubscribers.Unlock()
+
+	return events
+}
+
+func unsubscribeRequests(events chan *controlpb.RequestEvent) {
+	requestSubscribers.Lock()
+	delete(requestSubscribers.byChannel, events)
+	requestSubscribers.Unlock()
+}
+
+// requestEventsMiddleware sends every request to the StreamRequests calls
+// whose repository pattern matches.
+func requestEventsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		requestSubscribers.Lock()
+		defer requestSubscribers.Unlock()
+		if len(requestSubscribers.byChannel) == 0 {
+			return
+		}
+
+		endpoint, name, _ := requestEndpoint(r)
+		event := &controlpb.RequestEvent{
+			Time:       timestamppb.New(start),
+			RequestId:  requestID(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Endpoint:   endpoint,
+			Repository: name,
+			Client:     clientIP(r),
+			Status:     int32(rec.status),
+			Bytes:      int64(rec.bytes),
+			Duration:   durationpb.New(time.Since(start)),
+		}
+		for events, pattern := range requestSubscribers.byChannel {
+			if pattern != "" {
+				if ok, _ := path.Match(pattern, name); !ok || name == "" {
+					continue
+				}
+			}
+			// Slow subscribers miss events rather than holding up requests.
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handleHealthz reports liveness: the process is up and serving requests.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports readiness: the storage backend is reachable and the
+// -pregenerate charts have been generated.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain")
+	if !pregenerated.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("pregenerating charts\n"))
+		return
+	}
+	if err := store.Ping(); err != nil {
+		logger(r.Context()).Warn("readiness check failed", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("storage unavailable: " + err.Error() + "\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
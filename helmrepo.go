@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"sigs.k8s.io/yaml"
+)
+
+var helmRepo = flag.Bool("helm-repo", false, "also serve charts as classic Helm HTTP repositories under /charts/, with an index.yaml per repository namespace")
+
+// helmIndex is the index.yaml of a classic Helm repository.
+type helmIndex struct {
+	APIVersion string                       `json:"apiVersion"`
+	Entries    map[string][]*helmIndexEntry `json:"entries"`
+	Generated  time.Time                    `json:"generated"`
+}
+
+type helmIndexEntry struct {
+	Chart
+	URLs    []string  `json:"urls"`
+	Digest  string    `json:"digest"`
+	Created time.Time `json:"created"`
+}
+
+// registerHelmRepoRoutes adds the classic repository endpoints to r when
+// -helm-repo is set.
+func registerHelmRepoRoutes(r *mux.Router) {
+	if !*helmRepo {
+		return
+	}
+
+	r.HandleFunc("/charts/index.yaml", handleHelmIndex).Methods("GET", "HEAD")
+	r.HandleFunc("/charts/{namespace:.+}/index.yaml", handleHelmIndex).Methods("GET", "HEAD")
+	r.HandleFunc("/charts/artifacthub-repo.yml", handleArtifactHubRepo).Methods("GET", "HEAD")
+	r.HandleFunc("/charts/{namespace:.+}/artifacthub-repo.yml", handleArtifactHubRepo).Methods("GET", "HEAD")
+	r.HandleFunc("/charts/{name:.+}/{file}", handleHelmDownload).Methods("GET", "HEAD")
+}
+
+// handleHelmIndex serves the index of the repositories directly below a
+// namespace, so /charts/apps/index.yaml lists apps/web but not apps/team/api.
+// Helm chart names cannot contain slashes, so the index is keyed by the last
+// segment of each repository name.
+func handleHelmIndex(w http.ResponseWriter, r *http.Request) {
+	namespace := mux.Vars(r)["namespace"]
+	if namespace == "" {
+		namespace = "."
+	}
+
+	index := helmIndex{
+		APIVersion: "v1",
+		Entries:    make(map[string][]*helmIndexEntry),
+		Generated:  now().UTC(),
+	}
+
+	for _, ref := range visibleCharts(r.Context()) {
+		if path.Dir(ref.Name) != namespace {
+			continue
+		}
+
+		entry, err := helmIndexEntryFor(r.Context(), ref)
+		if err != nil {
+			logger(r.Context()).Warn("leaving chart out of index", "name", ref.Name, "reference", ref.Reference, "error", err)
+			continue
+		}
+		index.Entries[entry.Name] = append(index.Entries[entry.Name], entry)
+	}
+
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write(data)
+}
+
+// helmIndexEntryFor generates ref and describes it as an index entry.
+func helmIndexEntryFor(ctx context.Context, ref chartRef) (*helmIndexEntry, error) {
+	manifest, chart, err := describeChart(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &helmIndexEntry{Chart: chart}
+
+	// URLs are relative to the index, which lives in the parent directory
+	// of the repository.
+	entry.URLs = []string{path.Base(ref.Name) + "/" + helmArchiveName(entry.Name, ref.Reference)}
+	entry.Digest = strings.TrimPrefix(manifest.Layers[0].Digest, "sha256:")
+	entry.Created, _ = time.Parse(time.RFC3339, manifest.Annotations["org.opencontainers.image.created"])
+
+	return entry, nil
+}
+
+// helmArchiveName is the file name a chart is downloaded as.
+func helmArchiveName(chart string, reference string) string {
+	return chart + "-" + reference + ".tgz"
+}
+
+// handleHelmDownload serves the chart archive of /charts/<name>/<chart>-<reference>.tgz.
+func handleHelmDownload(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
+		return
+	}
+
+	reference, ok := strings.CutPrefix(mux.Vars(r)["file"], path.Base(name)+"-")
+	if ok {
+		reference, ok = strings.CutSuffix(reference, ".tgz")
+	}
+	if !ok || reference == "" {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "manifest unknown", nil)
+		return
+	}
+
+	manifest, err := resolveManifest(r.Context(), name, reference)
+	if err != nil {
+		writeGenerationError(w, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	if err := writeBlob(w, r, name, manifest.Layers[0].Digest); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var identityQuotaRequestsFlags, identityQuotaBytesFlags stringList
+
+func init() {
+	flag.Var(&identityQuotaRequestsFlags, "identity-quota-requests", "maximum number of requests an authenticated identity may make per UTC day, as [pattern=]count matching the user name; applies to all identities without a pattern, and 0 lifts the quota (repeatable, later flags override earlier ones)")
+	flag.Var(&identityQuotaBytesFlags, "identity-quota-bytes", "maximum number of bytes an authenticated identity may upload and download per UTC day, as [pattern=]bytes matching the user name; applies to all identities without a pattern, and 0 lifts the quota (repeatable, later flags override earlier ones)")
+}
+
+var identityQuotaRequestsRules, identityQuotaBytesRules []quotaRule
+
+// initIdentityQuotas parses the -identity-quota flags.
+func initIdentityQuotas() error {
+	var err error
+	if identityQuotaRequestsRules, err = parseQuotaRules(identityQuotaRequestsFlags); err != nil {
+		return err
+	}
+	identityQuotaBytesRules, err = parseQuotaRules(identityQuotaBytesFlags)
+	return err
+}
+
+// IdentityUsage is what an authenticated identity used on a day.
+type IdentityUsage struct {
+	Identity      string `json:"identity"`
+	Day           string `json:"day"`
+	Requests      int64  `json:"requests"`
+	Bytes         int64  `json:"bytes"`
+	QuotaRequests int64  `json:"quotaRequests,omitempty"`
+	QuotaBytes    int64  `json:"quotaBytes,omitempty"`
+	// Total counts every day since the server started.
+	TotalRequests int64 `json:"totalRequests"`
+	TotalBytes    int64 `json:"totalBytes"`
+}
+
+// identityUsage holds the usage of identities by name.
+var identityUsage = struct {
+	sync.Mutex
+	byName map[string]*IdentityUsage
+}{byName: make(map[string]*IdentityUsage)}
+
+// usageOf returns the usage of name on the current day, starting a new day
+// when it has changed. The caller must hold the identityUsage lock.
+func usageOf(name string, day string) *IdentityUsage {
+	usage, ok := identityUsage.byName[name]
+	if !ok {
+		usage = &IdentityUsage{Identity: name, Day: day}
+		identityUsage.byName[name] = usage
+	}
+	if usage.Day != day {
+		usage.Day, usage.Requests, usage.Bytes = day, 0, 0
+	}
+	usage.QuotaRequests = quotaFor(identityQuotaRequestsRules, name)
+	usage.QuotaBytes = quotaFor(identityQuotaBytesRules, name)
+
+	return usage
+}
+
+// identityQuotaMiddleware counts the requests and bytes of authenticated
+// identities and rejects their requests with 429 once they have used up a
+// daily quota.
+func identityQuotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identity(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t := now().UTC()
+		day := t.Format(time.DateOnly)
+		identityUsage.Lock()
+		usage := usageOf(id.Name, day)
+		exceeded := usage.QuotaRequests > 0 && usage.Requests >= usage.QuotaRequests || usage.QuotaBytes > 0 && usage.Bytes >= usage.QuotaBytes
+		identityUsage.Unlock()
+		if exceeded {
+			// Quotas reset at midnight UTC.
+			midnight := t.Truncate(24 * time.Hour).Add(24 * time.Hour)
+			w.Header().Set("Retry-After", strconv.Itoa(int(midnight.Sub(t).Seconds())+1))
+			writeError(w, http.StatusTooManyRequests, ErrCodeTooManyRequests, "daily quota of identity exceeded", id.Name)
+			return
+		}
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		bytes := int64(rec.bytes) + max(r.ContentLength, 0)
+		identityUsage.Lock()
+		usage = usageOf(id.Name, day)
+		usage.Requests++
+		usage.Bytes += bytes
+		usage.TotalRequests++
+		usage.TotalBytes += bytes
+		identityUsage.Unlock()
+	})
+}
+
+// registerIdentityUsageRoutes adds the usage of identities to the admin API.
+func registerIdentityUsageRoutes(admin *mux.Router) {
+	admin.HandleFunc("/identities", handleAdminIdentities).Methods("GET")
+}
+
+// handleAdminIdentities lists the usage of identities, heaviest first.
+func handleAdminIdentities(w http.ResponseWriter, r *http.Request) {
+	day := now().UTC().Format(time.DateOnly)
+
+	identityUsage.Lock()
+	list := []IdentityUsage{}
+	for name := range identityUsage.byName {
+		list = append(list, *usageOf(name, day))
+	}
+	identityUsage.Unlock()
+
+	slices.SortFunc(list, func(a, b IdentityUsage) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Identity, b.Identity))
+	})
+
+	writeAdminJSON(w, list)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var immutableTagFlags stringList
+
+func init() {
+	flag.Var(&immutableTagFlags, "immutable-tags", "reject with 409 pushes repointing an existing tag, as [pattern=]true|false; applies to all repositories without a pattern (repeatable, later flags override earlier ones)")
+}
+
+type immutableTagRule struct {
+	pattern   string
+	immutable bool
+}
+
+var immutableTagRules []immutableTagRule
+
+// initImmutableTags parses the -immutable-tags flags.
+func initImmutableTags() error {
+	for _, f := range immutableTagFlags {
+		pattern, value, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, value = "", f
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid immutable tags %q: %w", f, err)
+		}
+		immutable, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid immutable tags %q: expected [pattern=]true|false", f)
+		}
+
+		immutableTagRules = append(immutableTagRules, immutableTagRule{pattern: pattern, immutable: immutable})
+	}
+
+	return nil
+}
+
+// tagsImmutable reports whether the tags of name may not be repointed.
+func tagsImmutable(name string) bool {
+	immutable := false
+	for _, rule := range immutableTagRules {
+		if rule.pattern != "" {
+			if ok, _ := path.Match(rule.pattern, name); !ok {
+				continue
+			}
+		}
+		immutable = rule.immutable
+	}
+
+	return immutable
+}
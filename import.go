@@ -0,0 +1,479 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var importFlags stringList
+
+func init() {
+	flag.Var(&importFlags, "import", "OCI layout directory or tarball, or docker save tarball, to load into the registry at startup, as [name=]path; name is the repository of tags that do not name one (repeatable)")
+}
+
+const (
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerConfigMediaType   = "application/vnd.docker.container.image.v1+json"
+	dockerLayerMediaType    = "application/vnd.docker.image.rootfs.diff.tar"
+
+	containerdImageName = "io.containerd.image.name"
+)
+
+// imported holds the manifests loaded from OCI layouts and docker save
+// tarballs by name:reference, with the digest as reference for every
+// manifest.
+var imported = struct {
+	sync.RWMutex
+	byRef map[string]*Manifest
+}{byRef: make(map[string]*Manifest)}
+
+// importedManifest returns the imported manifest name:reference refers to.
+func importedManifest(name string, reference string) (*Manifest, bool) {
+	imported.RLock()
+	defer imported.RUnlock()
+
+	manifest, ok := imported.byRef[cacheKey(name, reference)]
+	return manifest, ok
+}
+
+// importedCharts lists the imported tags.
+func importedCharts() []chartRef {
+	imported.RLock()
+	defer imported.RUnlock()
+
+	var refs []chartRef
+	for key, manifest := range imported.byRef {
+		name, reference, _ := strings.Cut(key, ":")
+		if reference != manifest.digest {
+			refs = append(refs, chartRef{Name: name, Reference: reference})
+		}
+	}
+
+	return refs
+}
+
+// initImports loads the -import layouts and tarballs.
+func initImports() error {
+	for _, f := range importFlags {
+		name, file, ok := strings.Cut(f, "=")
+		if !ok {
+			name, file = "", f
+		}
+		if name != "" && !validName(name) {
+			return fmt.Errorf("invalid import %q: invalid repository name", f)
+		}
+
+		readFile, err := openImport(file)
+		if err != nil {
+			return err
+		}
+		result, err := importImages(readFile, name)
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", file, err)
+		}
+		slog.Info("imported images", "file", file, "manifests", result.Manifests, "blobs", result.Blobs)
+	}
+
+	return nil
+}
+
+// openImport returns a function reading the files of the directory or
+// tarball at file.
+func openImport(file string) (func(name string) ([]byte, error), error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return func(name string) ([]byte, error) {
+			return os.ReadFile(filepath.Join(file, filepath.FromSlash(name)))
+		}, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readTarball(f)
+}
+
+// readTarball reads a possibly gzipped tarball into memory and returns a
+// function reading its files.
+func readTarball(r io.Reader) (func(name string) ([]byte, error), error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(hdr.Name)] = data
+	}
+
+	return func(name string) ([]byte, error) {
+		data, ok := files[path.Clean(name)]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return data, nil
+	}, nil
+}
+
+// ImportResult counts what an import loaded.
+type ImportResult struct {
+	Manifests int `json:"manifests"`
+	Blobs     int `json:"blobs"`
+}
+
+// importImages loads an OCI layout, or failing that a docker save tarball,
+// read through readFile. Tags that do not name a repository are imported
+// into repository.
+func importImages(readFile func(name string) ([]byte, error), repository string) (ImportResult, error) {
+	index, err := readFile("index.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return importDockerSave(readFile, repository)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return importOCILayout(readFile, index, repository)
+}
+
+// importer stores the blobs and manifests of one import.
+type importer struct {
+	readFile func(name string) ([]byte, error)
+	result   ImportResult
+}
+
+// blob reads and stores the blob with digest from the blobs directory.
+func (im *importer) blob(digest string) ([]byte, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+
+	data, err := im.readFile("blobs/sha256/" + hex)
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("sha256:%x", sha256.Sum256(data)) != digest {
+		return nil, fmt.Errorf("blob %s does not match its digest", digest)
+	}
+
+	return data, im.put(digest, data)
+}
+
+func (im *importer) put(digest string, data []byte) error {
+	if err := store.Put(digest, data); err != nil {
+		return err
+	}
+	im.result.Blobs++
+	return nil
+}
+
+// manifest decodes content and stores the blobs it references.
+func (im *importer) manifest(content []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+	switch manifest.MediaType {
+	case manifestMediaType, dockerManifestMediaType:
+	case imageIndexMediaType:
+		return nil, errors.New("nested image indexes are not supported")
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %q", manifest.MediaType)
+	}
+	manifest.content = content
+	manifest.digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+
+	for _, digest := range manifestBlobs(&manifest) {
+		if _, err := im.blob(digest); err != nil {
+			return nil, err
+		}
+	}
+
+	return &manifest, nil
+}
+
+// add serves manifest as name:tag, when tag is set, and name@digest.
+// Manifests with a subject are also listed as its referrers.
+func (im *importer) add(name string, tag string, manifest *Manifest) {
+	imported.Lock()
+	if tag != "" {
+		imported.byRef[cacheKey(name, tag)] = manifest
+	}
+	imported.byRef[cacheKey(name, manifest.digest)] = manifest
+	imported.Unlock()
+
+	if manifest.Subject != nil {
+		storeReferrer(name, manifest)
+	}
+	im.result.Manifests++
+}
+
+// splitImageName splits a tagged image name such as
+// registry.example.com/charts/web:1.0.0 into its repository, without any
+// registry host, and tag.
+func splitImageName(image string) (string, string, bool) {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return "", "", false
+	}
+	name, tag := image[:i], image[i+1:]
+
+	if host, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		name = rest
+	}
+
+	return name, tag, validName(name)
+}
+
+// importOCILayout loads the manifests listed by an OCI layout index.
+// Manifests without a tag, such as signatures, are imported into the
+// repository of their subject.
+func importOCILayout(readFile func(name string) ([]byte, error), indexJSON []byte, repository string) (ImportResult, error) {
+	im := &importer{readFile: readFile}
+
+	var index struct {
+		Manifests []Layer `json:"manifests"`
+	}
+	if err := json.Unmarshal(indexJSON, &index); err != nil {
+		return im.result, fmt.Errorf("parsing index.json: %w", err)
+	}
+
+	repositoryOf := make(map[string]string)
+	var untagged []*Manifest
+	for _, descriptor := range index.Manifests {
+		content, err := im.blob(descriptor.Digest)
+		if err != nil {
+			return im.result, err
+		}
+		manifest, err := im.manifest(content)
+		if err != nil {
+			return im.result, fmt.Errorf("manifest %s: %w", descriptor.Digest, err)
+		}
+
+		ref := descriptor.Annotations[ociLayoutRefName]
+		if ref == "" {
+			ref = descriptor.Annotations[containerdImageName]
+		}
+		if ref == "" {
+			untagged = append(untagged, manifest)
+			continue
+		}
+
+		name, tag, ok := splitImageName(ref)
+		switch {
+		case repository != "" && ok:
+			name = repository
+		case repository != "":
+			name, tag = repository, ref
+		case !ok:
+			return im.result, fmt.Errorf("tag %q does not name a repository; import the layout as name=path", ref)
+		}
+
+		im.add(name, tag, manifest)
+		repositoryOf[manifest.digest] = name
+	}
+
+	for _, manifest := range untagged {
+		name := repository
+		if manifest.Subject != nil && repositoryOf[manifest.Subject.Digest] != "" {
+			name = repositoryOf[manifest.Subject.Digest]
+		}
+		if name == "" {
+			slog.Warn("skipping untagged manifest without a repository", "digest", manifest.digest)
+			continue
+		}
+
+		im.add(name, "", manifest)
+	}
+
+	return im.result, nil
+}
+
+// importDockerSave loads a tarball written by docker save, building a Docker
+// image manifest for each image it lists.
+func importDockerSave(readFile func(name string) ([]byte, error), repository string) (ImportResult, error) {
+	im := &importer{readFile: readFile}
+
+	data, err := readFile("manifest.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return im.result, errors.New("neither an OCI layout nor a docker save tarball")
+	}
+	if err != nil {
+		return im.result, err
+	}
+
+	var images []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := json.Unmarshal(data, &images); err != nil {
+		return im.result, fmt.Errorf("parsing manifest.json: %w", err)
+	}
+
+	for _, image := range images {
+		descriptor := func(mediaType string, file string) (Layer, error) {
+			data, err := readFile(file)
+			if err != nil {
+				return Layer{}, err
+			}
+			digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+			return Layer{MediaType: mediaType, Digest: digest, Size: len(data)}, im.put(digest, data)
+		}
+
+		config, err := descriptor(dockerConfigMediaType, image.Config)
+		if err != nil {
+			return im.result, err
+		}
+		manifest := &Manifest{
+			SchemaVersion: 2,
+			MediaType:     dockerManifestMediaType,
+			Config:        Config{MediaType: config.MediaType, Digest: config.Digest, Size: config.Size},
+			Layers:        []Layer{},
+		}
+		for _, file := range image.Layers {
+			layer, err := descriptor(dockerLayerMediaType, file)
+			if err != nil {
+				return im.result, err
+			}
+			manifest.Layers = append(manifest.Layers, layer)
+		}
+		if err := manifest.encode(); err != nil {
+			return im.result, err
+		}
+
+		for _, repoTag := range image.RepoTags {
+			name, tag, ok := splitImageName(repoTag)
+			if repository != "" {
+				name = repository
+			} else if !ok {
+				return im.result, fmt.Errorf("image %q has an invalid repository name; import the tarball as name=path", repoTag)
+			}
+			im.add(name, tag, manifest)
+		}
+		if len(image.RepoTags) == 0 && repository != "" {
+			im.add(repository, "", manifest)
+		}
+	}
+
+	return im.result, nil
+}
+
+// handleAdminImport loads the OCI layout or docker save tarball in the
+// request body, importing tags that do not name a repository into
+// ?repository=.
+func handleAdminImport(w http.ResponseWriter, r *http.Request) {
+	repository := r.URL.Query().Get("repository")
+	if repository != "" && !validName(repository) {
+		writeError(w, http.StatusBadRequest, ErrCodeNameInvalid, "invalid repository name", repository)
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	readFile, err := readTarball(bytes.NewReader(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "failed to read tarball", err.Error())
+		return
+	}
+
+	result, err := importImages(readFile, repository)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "import failed", err.Error())
+		return
+	}
+
+	logger(r.Context()).Info("imported images", "repository", repository, "manifests", result.Manifests, "blobs", result.Blobs)
+	writeAdminJSON(w, result)
+}
+
+// runImport implements `virtual-helm import [flags] file`.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: virtual-helm import [flags] file\n\nLoads an OCI layout or docker save tarball into a running server.")
+		fs.PrintDefaults()
+	}
+	client := adminClientFlags(fs)
+	repository := fs.String("repository", "", "repository of tags that do not name one")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	path := "/admin/import"
+	if *repository != "" {
+		path += "?" + url.Values{"repository": {*repository}}.Encode()
+	}
+
+	var result ImportResult
+	if err := c.do("POST", path, f, &result); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d manifests, %d blobs\n", result.Manifests, result.Blobs)
+	return nil
+}
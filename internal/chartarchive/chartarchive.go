@@ -0,0 +1,44 @@
+// Package chartarchive writes the files of generated charts as tarballs,
+// for the server and the virtualhelm test server alike, so both serve the
+// same archives for the same files.
+package chartarchive
+
+import (
+	"archive/tar"
+	"time"
+
+	"github.com/cdelautour/virutal-helm/chartgen"
+)
+
+// Epoch is the modification time recorded for every file in chart
+// archives, so archives only depend on their content.
+var Epoch = time.Unix(0, 0).UTC()
+
+// WriteTar writes files to tarball and closes it.
+func WriteTar(tarball *tar.Writer, files []chartgen.File) error {
+	for _, f := range files {
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.Name,
+			Size:     int64(len(f.Data)),
+			Mode:     mode,
+			ModTime:  Epoch,
+			Format:   tar.FormatUSTAR,
+		}
+		if err := tarball.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err := tarball.Write(f.Data); err != nil {
+			return err
+		}
+	}
+
+	// Closing emits the tar footer.
+	return tarball.Close()
+}
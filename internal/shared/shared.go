@@ -0,0 +1,75 @@
+// Package shared collapses concurrent calls for the same work, such as the
+// generations of a chart, into one execution. It is shared by the server and
+// the virtualhelm test server, so both deduplicate work the same way.
+package shared
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Group runs work once for all concurrent calls with the same key. The zero
+// Group is ready to use.
+type Group struct {
+	flight singleflight.Group
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a call in progress and the number of callers waiting for it.
+type call struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// Do runs fn once for all concurrent calls with the same key, like
+// singleflight.Group.Do. A caller whose ctx is done returns right away, and
+// the context fn runs with is cancelled once no caller is left waiting, so
+// the work for clients that all disconnected is abandoned.
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if !ok {
+		// The call keeps the values of the first caller's context, such as
+		// its logger and span, but not its cancellation.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call{ctx: callCtx, cancel: cancel}
+		if g.calls == nil {
+			g.calls = make(map[string]*call)
+		}
+		g.calls[key] = c
+	}
+	c.waiters++
+	g.mu.Unlock()
+	defer g.leave(key, c)
+
+	ch := g.flight.DoChan(key, func() (interface{}, error) {
+		return fn(c.ctx)
+	})
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// leave cancels the call when the last waiting caller leaves it. The call is
+// forgotten, so that a later caller starts a new one rather than joining the
+// cancelled one.
+func (g *Group) leave(key string, c *call) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	c.cancel()
+	delete(g.calls, key)
+	g.flight.Forget(key)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var clientIPHeader = flag.String("client-ip-header", "X-Forwarded-For", "header in which -trusted-proxy addresses report the client address")
+
+var (
+	trustedProxyFlags stringList
+	allowCIDRFlags    stringList
+	denyCIDRFlags     stringList
+)
+
+func init() {
+	flag.Var(&trustedProxyFlags, "trusted-proxy", "address or CIDR range of a reverse proxy whose -client-ip-header is trusted for the client address, and X-Forwarded-Proto and X-Forwarded-Host for the URLs in Location headers (repeatable)")
+	flag.Var(&allowCIDRFlags, "allow-cidr", "address or CIDR range clients must connect from; all addresses are allowed when none is given (repeatable)")
+	flag.Var(&denyCIDRFlags, "deny-cidr", "address or CIDR range clients are refused from, taking precedence over -allow-cidr (repeatable)")
+}
+
+var trustedProxies, allowedClients, deniedClients []netip.Prefix
+
+// initIPFilter parses the -trusted-proxy, -allow-cidr and -deny-cidr ranges.
+func initIPFilter() error {
+	var err error
+	if trustedProxies, err = parsePrefixes(trustedProxyFlags); err != nil {
+		return fmt.Errorf("invalid -trusted-proxy: %w", err)
+	}
+	if allowedClients, err = parsePrefixes(allowCIDRFlags); err != nil {
+		return fmt.Errorf("invalid -allow-cidr: %w", err)
+	}
+	if deniedClients, err = parsePrefixes(denyCIDRFlags); err != nil {
+		return fmt.Errorf("invalid -deny-cidr: %w", err)
+	}
+
+	return nil
+}
+
+// parsePrefixes parses CIDR ranges, taking bare addresses as single-address
+// ranges.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// inPrefixes reports whether the address ip is in one of prefixes.
+func inPrefixes(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedClientIP returns the client address reported by a chain of
+// trusted proxies: the last address of the -client-ip-header that is not a
+// trusted proxy itself.
+func forwardedClientIP(r *http.Request, remote string) string {
+	if len(trustedProxies) == 0 || !inPrefixes(trustedProxies, remote) {
+		return remote
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values(*clientIPHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !inPrefixes(trustedProxies, hop) {
+			return hop
+		}
+		remote = hop
+	}
+
+	return remote
+}
+
+// fromTrustedProxy reports whether r was sent by a -trusted-proxy.
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	return inPrefixes(trustedProxies, host)
+}
+
+// firstForwarded returns the value a forwarding header was given by the
+// first proxy in the chain.
+func firstForwarded(r *http.Request, header string) string {
+	first, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
+
+// ipFilterMiddleware refuses clients outside the -allow-cidr ranges or
+// inside the -deny-cidr ranges.
+func ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if inPrefixes(deniedClients, ip) || (len(allowedClients) > 0 && !inPrefixes(allowedClients, ip)) {
+			logger(r.Context()).Info("refused client address", "client", ip)
+			writeError(w, http.StatusForbidden, ErrCodeDenied, "access from this address is denied", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
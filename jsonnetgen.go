@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-jsonnet"
+	"sigs.k8s.io/yaml"
+)
+
+var jsonnetFile = flag.String("jsonnet-file", "", "Jsonnet program evaluated by the \"jsonnet\" generator")
+
+func init() {
+	generators["jsonnet"] = jsonnetGenerator{}
+}
+
+// jsonnetGenerator evaluates -jsonnet-file as a function of the top-level
+// arguments name, reference and params, which must return
+//
+//	{
+//	  chart: { ... },      // Chart.yaml field overrides
+//	  values: { ... },     // values.yaml
+//	  templates: { ... },  // files under templates/; objects become YAML
+//	  files: { ... },      // other files by path; objects become YAML
+//	}
+//
+// so chart templates and values can be defined as typed data.
+type jsonnetGenerator struct{}
+
+type jsonnetResult struct {
+	Chart     map[string]interface{}     `json:"chart"`
+	Values    map[string]interface{}     `json:"values"`
+	Templates map[string]json.RawMessage `json:"templates"`
+	Files     map[string]json.RawMessage `json:"files"`
+}
+
+func (jsonnetGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	if *jsonnetFile == "" {
+		return nil, errors.New("the jsonnet generator requires -jsonnet-file")
+	}
+
+	params, err := json.Marshal(req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{})
+	vm.TLAVar("name", req.Name)
+	vm.TLAVar("reference", req.Reference)
+	vm.TLACode("params", string(params))
+
+	out, err := vm.EvaluateFile(*jsonnetFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var result jsonnetResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, fmt.Errorf("%s returned an invalid result: %w", *jsonnetFile, err)
+	}
+
+	chart, err := chartWithOverrides(req, result.Chart)
+	if err != nil {
+		return nil, fmt.Errorf("%s returned %w", *jsonnetFile, err)
+	}
+
+	var files []ChartFile
+	if result.Values != nil {
+		values, err := yaml.Marshal(result.Values)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ChartFile{Name: "values.yaml", Data: values})
+	}
+	for name, raw := range result.Templates {
+		data, err := jsonnetFileContent(raw)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		files = append(files, ChartFile{Name: "templates/" + name, Data: data})
+	}
+	for name, raw := range result.Files {
+		data, err := jsonnetFileContent(raw)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", name, err)
+		}
+		files = append(files, ChartFile{Name: name, Data: data})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	files, err = withChartYaml(files, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// jsonnetFileContent returns strings as-is and renders any other value as
+// YAML.
+func jsonnetFileContent(raw json.RawMessage) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s), nil
+	}
+
+	return yaml.JSONToYAML(raw)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	kubeAPIServer = flag.String("kube-api-server", "", "Kubernetes API server URL (defaults to the in-cluster service)")
+	kubeTokenFile = flag.String("kube-token-file", "/var/run/secrets/kubernetes.io/serviceaccount/token", "bearer token used to authenticate to the Kubernetes API")
+	kubeCAFile    = flag.String("kube-ca-file", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt", "CA bundle used to verify the Kubernetes API server")
+	kubeNamespace = flag.String("kube-namespace", "", "namespace to watch Kubernetes resources in (all namespaces when empty)")
+)
+
+// kubeClient is a minimal Kubernetes API client supporting the list and
+// watch calls virtual-helm needs, without pulling in client-go.
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func newKubeClient() (*kubeClient, error) {
+	server := *kubeAPIServer
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster and -kube-api-server is not set")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	token, err := os.ReadFile(*kubeTokenFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if ca, err := os.ReadFile(*kubeCAFile); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = pool
+	}
+
+	return &kubeClient{
+		server: strings.TrimSuffix(server, "/"),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// kubeObjectMeta is the subset of object metadata virtual-helm uses.
+type kubeObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	Labels          map[string]string `json:"labels"`
+	ResourceVersion string            `json:"resourceVersion"`
+}
+
+type kubeList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+type kubeWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// resourcePath returns the collection path for a resource, e.g.
+// resourcePath("", "v1", "configmaps").
+func (c *kubeClient) resourcePath(group, version, resource string) string {
+	prefix := "/apis/" + group + "/" + version
+	if group == "" {
+		prefix = "/api/" + version
+	}
+	if *kubeNamespace != "" {
+		prefix += "/namespaces/" + url.PathEscape(*kubeNamespace)
+	}
+
+	return prefix + "/" + resource
+}
+
+func (c *kubeClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, msg)
+	}
+
+	return resp, nil
+}
+
+// informer follows a resource collection until ctx is done: it lists the
+// collection, then watches it, relisting whenever the watch ends. sync is
+// called with every object on each list and event with each change while
+// watching.
+func (c *kubeClient) informer(ctx context.Context, path string, selector string, sync func([]json.RawMessage), event func(kubeWatchEvent)) {
+	for ctx.Err() == nil {
+		err := c.listAndWatch(ctx, path, selector, sync, event)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("kubernetes watch failed", "path", path, "error", err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (c *kubeClient) listAndWatch(ctx context.Context, path string, selector string, sync func([]json.RawMessage), event func(kubeWatchEvent)) error {
+	query := url.Values{}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+
+	var list kubeList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	sync(list.Items)
+
+	query.Set("watch", "true")
+	query.Set("resourceVersion", list.Metadata.ResourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	resp, err = c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e kubeWatchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+
+		switch e.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			event(e)
+		case "ERROR":
+			return fmt.Errorf("watch error: %s", e.Object)
+		}
+	}
+
+	return scanner.Err()
+}
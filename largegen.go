@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+var (
+	largeChartSize         = flag.Int64("large-chart-size", 64<<20, "total size in bytes of the data files of charts served by the \"large\" generator")
+	largeChartFiles        = flag.Int("large-chart-files", 100, "number of data files of charts served by the \"large\" generator")
+	largeChartDistribution = flag.String("large-chart-distribution", "equal", "how the -large-chart-size is spread over the files of the \"large\" generator: equal, uniform for random sizes, or exponential for a few large files among many small ones")
+	largeChartData         = flag.String("large-chart-data", "random", "content of the data files of the \"large\" generator: random, which does not compress, or compressible text")
+)
+
+func init() {
+	generators["large"] = largeGenerator{}
+}
+
+// initLargeCharts checks the options of the "large" generator.
+func initLargeCharts() error {
+	if *largeChartSize < 0 || *largeChartFiles < 1 {
+		return fmt.Errorf("invalid -large-chart-size %d or -large-chart-files %d", *largeChartSize, *largeChartFiles)
+	}
+	switch *largeChartDistribution {
+	case "equal", "uniform", "exponential":
+	default:
+		return fmt.Errorf("invalid -large-chart-distribution %q: expected equal, uniform or exponential", *largeChartDistribution)
+	}
+	switch *largeChartData {
+	case "random", "compressible":
+	default:
+		return fmt.Errorf("invalid -large-chart-data %q: expected random or compressible", *largeChartData)
+	}
+
+	return nil
+}
+
+// largeGenerator serves charts padded with data files under files/, for
+// load testing clients and network paths with large artifacts. The data is
+// derived from the name and reference, so a chart always has the same
+// digest.
+type largeGenerator struct{}
+
+func (largeGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	seed := sha256.Sum256([]byte(req.Name + ":" + req.Reference))
+	src := rand.NewChaCha8(seed)
+	rng := rand.New(src)
+
+	files := []ChartFile{{Name: "README.md", Data: []byte(largeReadme)}}
+	for i, size := range largeFileSizes(rng) {
+		data := make([]byte, size)
+		if *largeChartData == "compressible" {
+			fillText(rng, data)
+		} else {
+			src.Read(data)
+		}
+		files = append(files, ChartFile{Name: fmt.Sprintf("files/data-%05d.bin", i), Data: data})
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := withChartYaml(files, defaultChart(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+// largeFileSizes splits the -large-chart-size over the -large-chart-files
+// following the -large-chart-distribution.
+func largeFileSizes(rng *rand.Rand) []int64 {
+	weights := make([]float64, *largeChartFiles)
+	var sum float64
+	for i := range weights {
+		switch *largeChartDistribution {
+		case "uniform":
+			weights[i] = rng.Float64()
+		case "exponential":
+			weights[i] = rng.ExpFloat64()
+		default:
+			weights[i] = 1
+		}
+		sum += weights[i]
+	}
+
+	sizes := make([]int64, len(weights))
+	remaining := *largeChartSize
+	for i, w := range weights {
+		sizes[i] = int64(float64(*largeChartSize) * w / sum)
+		remaining -= sizes[i]
+	}
+	// Rounding leaves a few bytes over.
+	sizes[len(sizes)-1] += remaining
+
+	return sizes
+}
+
+var largeWords = strings.Fields("apiVersion kind metadata name namespace labels spec replicas selector template containers image ports resources limits requests memory cpu volume mount")
+
+// fillText fills data with lines of words, which compress about as well as
+// chart templates do.
+func fillText(rng *rand.Rand, data []byte) {
+	var buf bytes.Buffer
+	for buf.Len() < len(data) {
+		for i := rng.IntN(8) + 2; i > 0; i-- {
+			buf.WriteString(largeWords[rng.IntN(len(largeWords))])
+			buf.WriteByte(' ')
+		}
+		buf.WriteByte('\n')
+	}
+	copy(data, buf.Bytes())
+}
+
+const largeReadme = `Hello helm! This chart carries data files under files/ to load test
+registry clients with large charts.
+`
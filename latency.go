@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var latencyFlags stringList
+
+func init() {
+	flag.Var(&latencyFlags, "latency", "delay matching requests, as \"method endpoint repository delay\" such as \"GET blob charts/* 2s\", with the method, endpoint and repository of -fault and a delay that is fixed (2s), uniform in a range (100ms-2s), normal(mean,stddev) or exp(mean) (repeatable, the first matching rule applies)")
+}
+
+// latencyRule delays matching requests by a drawn duration.
+type latencyRule struct {
+	RequestMatcher
+	delay func() time.Duration
+}
+
+var latencyRules []latencyRule
+
+// initLatency parses the -latency rules.
+func initLatency() error {
+	for _, f := range latencyFlags {
+		fields := strings.Fields(f)
+		if len(fields) != 4 {
+			return fmt.Errorf("invalid latency %q: expected method endpoint repository delay", f)
+		}
+
+		rule := latencyRule{RequestMatcher: RequestMatcher{Method: fields[0], Endpoint: fields[1], Repository: fields[2]}}
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("invalid latency %q: %w", f, err)
+		}
+		delay, err := parseDelay(fields[3])
+		if err != nil {
+			return fmt.Errorf("invalid latency %q: %w", f, err)
+		}
+		rule.delay = delay
+
+		latencyRules = append(latencyRules, rule)
+	}
+
+	return nil
+}
+
+// parseDelay parses a fixed delay, a min-max range, normal(mean,stddev) or
+// exp(mean) into a function drawing delays. Negative draws are 0.
+func parseDelay(s string) (func() time.Duration, error) {
+	durations := func(args string, n int) ([]time.Duration, error) {
+		parts := strings.Split(args, ",")
+		if len(parts) != n {
+			return nil, fmt.Errorf("delay %q has the wrong number of durations", s)
+		}
+		ds := make([]time.Duration, n)
+		for i, p := range parts {
+			d, err := time.ParseDuration(strings.TrimSpace(p))
+			if err != nil {
+				return nil, err
+			}
+			ds[i] = d
+		}
+		return ds, nil
+	}
+
+	if args, ok := strings.CutPrefix(s, "normal("); ok && strings.HasSuffix(args, ")") {
+		ds, err := durations(strings.TrimSuffix(args, ")"), 2)
+		if err != nil {
+			return nil, err
+		}
+		return func() time.Duration {
+			return max(0, ds[0]+time.Duration(rand.NormFloat64()*float64(ds[1])))
+		}, nil
+	}
+	if args, ok := strings.CutPrefix(s, "exp("); ok && strings.HasSuffix(args, ")") {
+		ds, err := durations(strings.TrimSuffix(args, ")"), 1)
+		if err != nil {
+			return nil, err
+		}
+		return func() time.Duration {
+			return time.Duration(math.Min(rand.ExpFloat64()*float64(ds[0]), math.MaxInt64))
+		}, nil
+	}
+	if low, high, ok := strings.Cut(s, "-"); ok {
+		ds, err := durations(low+","+high, 2)
+		if err != nil {
+			return nil, err
+		}
+		if ds[1] < ds[0] {
+			return nil, fmt.Errorf("delay range %q ends before it starts", s)
+		}
+		return func() time.Duration {
+			return ds[0] + rand.N(ds[1]-ds[0]+1)
+		}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return func() time.Duration { return d }, nil
+}
+
+// latencyMiddleware delays requests matching a -latency rule, giving up
+// when the client does.
+func latencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if endpoint, name, ok := requestEndpoint(r); ok {
+			for _, rule := range latencyRules {
+				if !rule.matches(r.Method, endpoint, name) {
+					continue
+				}
+
+				timer := time.NewTimer(rule.delay())
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+				break
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var latestPolicyFlags stringList
+
+func init() {
+	flag.Var(&latestPolicyFlags, "latest-policy", "what :latest resolves to unless pushed, as [pattern=]policy: generate for a chart generated like any other tag, highest for the highest known stable semver version, recent for the most recently generated tag, or the digest of a pushed, imported or generated manifest to pin; applies to all repositories without a pattern (repeatable, later flags override earlier ones)")
+}
+
+type latestPolicyRule struct {
+	pattern string
+	policy  string
+}
+
+var latestPolicyRules []latestPolicyRule
+
+// stableVersions matches every version but prereleases, which only satisfy
+// constraints that name one.
+var stableVersions, _ = semver.NewConstraint("*")
+
+// recentTags holds the most recently generated tag of repositories.
+var recentTags = struct {
+	sync.Mutex
+	byName map[string]string
+}{byName: make(map[string]string)}
+
+// initLatestPolicy parses the -latest-policy flags.
+func initLatestPolicy() error {
+	for _, f := range latestPolicyFlags {
+		pattern, policy := "", f
+		// Digests contain a colon but no equals sign.
+		if p, value, ok := strings.Cut(f, "="); ok {
+			pattern, policy = p, value
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid latest policy %q: %w", f, err)
+		}
+		switch {
+		case policy == "generate", policy == "highest", policy == "recent":
+		case validDigest(policy):
+		default:
+			return fmt.Errorf("invalid latest policy %q: expected generate, highest, recent or a digest", f)
+		}
+
+		latestPolicyRules = append(latestPolicyRules, latestPolicyRule{pattern: pattern, policy: policy})
+	}
+
+	return nil
+}
+
+// latestPolicyFor returns the -latest-policy of name.
+func latestPolicyFor(name string) string {
+	policy := "generate"
+	for _, rule := range latestPolicyRules {
+		if rule.pattern != "" {
+			if ok, _ := path.Match(rule.pattern, name); !ok {
+				continue
+			}
+		}
+		policy = rule.policy
+	}
+
+	return policy
+}
+
+// recordGeneratedTag notes that name:reference was generated, for the
+// recent policy.
+func recordGeneratedTag(name string, reference string) {
+	if reference == "latest" || strings.HasPrefix(reference, "sha256:") {
+		return
+	}
+
+	recentTags.Lock()
+	recentTags.byName[name] = reference
+	recentTags.Unlock()
+}
+
+// latestManifest returns the manifest :latest of name resolves to under its
+// -latest-policy, or false when latest is generated like any other tag.
+func latestManifest(ctx context.Context, name string) (*Manifest, bool, error) {
+	var reference string
+	switch policy := latestPolicyFor(name); policy {
+	case "generate":
+		return nil, false, nil
+	case "highest":
+		versions := matchingVersions(ctx, name, stableVersions)
+		if len(versions) == 0 {
+			return nil, false, errReferenceNotFound
+		}
+		reference = versions[0]
+	case "recent":
+		recentTags.Lock()
+		recent, ok := recentTags.byName[name]
+		recentTags.Unlock()
+		if !ok {
+			return nil, false, errReferenceNotFound
+		}
+		reference = recent
+	default:
+		// Generated charts are cached by tag, so a pinned digest is looked
+		// up among them as well as among the stored manifests.
+		if manifest, ok := pushedManifest(name, policy); ok {
+			return manifest, true, nil
+		}
+		if manifest, ok := importedManifest(name, policy); ok {
+			return manifest, true, nil
+		}
+		for key, manifest := range generated.Entries() {
+			if strings.HasPrefix(key, name+":") && manifest.digest == policy {
+				return manifest, true, nil
+			}
+		}
+		return nil, false, errReferenceNotFound
+	}
+
+	manifest, err := resolveManifest(ctx, name, reference)
+	return manifest, err == nil, err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+func init() {
+	generators["library"] = libraryGenerator{}
+}
+
+// libraryGenerator serves library charts: charts of type library that only
+// define named templates for the charts depending on them to include.
+type libraryGenerator struct{}
+
+func (libraryGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	chart := defaultChart(req)
+	chart.Type = "library"
+	if chart.Description == *chartDescription {
+		chart.Description = "A dynamically generated library chart"
+	}
+
+	// Named templates are global to a release, so they are prefixed with
+	// the library name to avoid clashing with those of the parent chart.
+	helpers := strings.ReplaceAll(libraryHelpers, "library.", path.Base(req.Name)+".")
+
+	files, err := withChartYaml([]ChartFile{
+		{Name: "README.md", Data: []byte(libraryReadme)},
+		{Name: "templates/_helpers.tpl", Data: []byte(helpers)},
+	}, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+const libraryReadme = `Hello helm! This is a library chart: add it as a dependency and include
+its named templates.
+`
+
+const libraryHelpers = `{{/*
+Expand the name of the including chart.
+*/}}
+{{- define "library.name" -}}
+{{- default .Chart.Name .Values.nameOverride | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{/*
+Create a default fully qualified app name.
+*/}}
+{{- define "library.fullname" -}}
+{{- if contains (include "library.name" .) .Release.Name }}
+{{- .Release.Name | trunc 63 | trimSuffix "-" }}
+{{- else }}
+{{- printf "%s-%s" .Release.Name (include "library.name" .) | trunc 63 | trimSuffix "-" }}
+{{- end }}
+{{- end }}
+
+{{/*
+Common labels.
+*/}}
+{{- define "library.labels" -}}
+helm.sh/chart: {{ printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" }}
+app.kubernetes.io/name: {{ include "library.name" . }}
+app.kubernetes.io/instance: {{ .Release.Name }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
+{{/*
+A ConfigMap holding .Values.data, named after the including chart.
+*/}}
+{{- define "library.configmap" -}}
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ include "library.fullname" . }}
+  labels:
+    {{- include "library.labels" . | nindent 4 }}
+data:
+  {{- toYaml (.Values.data | default dict) | nindent 2 }}
+{{- end }}
+`
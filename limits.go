@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+)
+
+var maxBodySize = flag.Int64("max-body-size", 512<<20, "maximum size in bytes of an uploaded blob or manifest")
+
+// readBody reads the request body up to the configured size limit. When the
+// body is too large or cannot be read an error response is written and false
+// is returned.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, *maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCodeSizeInvalid, "request body exceeds the maximum size", tooLarge.Limit)
+			return nil, false
+		}
+
+		writeError(w, http.StatusBadRequest, ErrCodeBlobUploadInvalid, "failed to read request body", err.Error())
+		return nil, false
+	}
+
+	return body, true
+}
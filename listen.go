@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var listenAddrs stringList
+
+func init() {
+	flag.Var(&listenAddrs, "listen", "address to serve on, :5000 by default: host:port such as [::1]:5000, http:// or https:// followed by host:port to serve plain HTTP or TLS there regardless of the other listeners, or unix:///path.sock for a Unix domain socket (repeatable)")
+}
+
+// listener is a socket the server accepts connections on.
+type listener struct {
+	net.Listener
+	addr string
+	tls  bool
+}
+
+// listenAll opens the -listen listeners, after any sockets passed by systemd
+// socket activation. Listeners without a scheme serve TLS when it is
+// configured.
+func listenAll() ([]listener, error) {
+	listeners, err := activationListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := listenAddrs
+	if len(addrs) == 0 && len(listeners) == 0 {
+		addrs = stringList{":5000"}
+	}
+
+	for _, addr := range addrs {
+		ln, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	withProxyProtocol(listeners)
+
+	return listeners, nil
+}
+
+// listen opens the listener for a -listen address.
+func listen(addr string) (listener, error) {
+	useTLS := serverTLS != nil
+	network, address := "tcp", addr
+	switch scheme, rest, _ := strings.Cut(addr, "://"); scheme {
+	case "http":
+		useTLS, address = false, rest
+	case "https":
+		if serverTLS == nil {
+			return listener{}, fmt.Errorf("listener %s requires -tls-cert or -acme-host", addr)
+		}
+		useTLS, address = true, rest
+	case "unix":
+		network, address = "unix", rest
+		if err := removeStaleSocket(address); err != nil {
+			return listener{}, err
+		}
+	}
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return listener{}, err
+	}
+
+	return listener{Listener: ln, addr: addr, tls: useTLS}, nil
+}
+
+// activationListeners returns the sockets passed by systemd socket
+// activation as described in sd_listen_fds(3). A FileDescriptorName of http
+// or https chooses plain HTTP or TLS like the scheme of a -listen address.
+func activationListeners() ([]listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Keep the sockets from being inherited again by child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []listener
+	for i := range n {
+		name := "LISTEN_FD_" + strconv.Itoa(sdListenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(sdListenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s: %w", name, err)
+		}
+
+		useTLS := serverTLS != nil
+		switch name {
+		case "http":
+			useTLS = false
+		case "https":
+			if serverTLS == nil {
+				return nil, fmt.Errorf("systemd socket %s requires -tls-cert or -acme-host", name)
+			}
+			useTLS = true
+		}
+		listeners = append(listeners, listener{Listener: ln, addr: "systemd:" + name, tls: useTLS})
+	}
+
+	return listeners, nil
+}
+
+// sdListenFDsStart is the first file descriptor passed by systemd.
+const sdListenFDsStart = 3
+
+// removeStaleSocket removes the socket of a previous run; closing a Unix
+// listener removes it again on shutdown.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return errors.New(path + " exists and is not a socket")
+	}
+
+	return os.Remove(path)
+}
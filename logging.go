@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+var (
+	logLevel  = flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "log output format: text or json")
+)
+
+// setupLogging installs the default slog logger according to the log flags.
+func setupLogging(out io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q", *logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(*logFormat) {
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", *logFormat)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// logger returns the default logger annotated with the request ID carried by
+// ctx, if any.
+func logger(ctx context.Context) *slog.Logger {
+	if id := requestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+
+	return slog.Default()
+}
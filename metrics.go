@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtual_helm_http_requests_total",
+		Help: "HTTP requests by endpoint, method and status code.",
+	}, []string{"endpoint", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "virtual_helm_http_request_duration_seconds",
+		Help:    "HTTP request latency by endpoint and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint", "method"})
+
+	generationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "virtual_helm_chart_generation_duration_seconds",
+		Help:    "Time taken to generate a chart manifest and its blobs, by generator.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"generator"})
+
+	chartPulls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtual_helm_chart_pulls_total",
+		Help: "Manifest pulls by repository and reference, with -chart-metrics.",
+	}, []string{"repository", "reference"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtual_helm_cache_requests_total",
+		Help: "Generation cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "virtual_helm_blob_store_blobs",
+		Help: "Number of blobs held in the blob store.",
+	}, func() float64 {
+		count, _ := store.Stats()
+		return float64(count)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "virtual_helm_blob_store_bytes",
+		Help: "Total size of blobs held in the blob store.",
+	}, func() float64 {
+		_, size := store.Stats()
+		return float64(size)
+	})
+)
+
+var chartMetrics = flag.Bool("chart-metrics", false, "count manifest pulls by repository and reference in virtual_helm_chart_pulls_total, to find popular charts worth pre-generating; every pulled reference adds a series")
+
+// countChartPull records a successful manifest pull with -chart-metrics.
+func countChartPull(name string, reference string) {
+	if *chartMetrics {
+		chartPulls.WithLabelValues(name, reference).Inc()
+	}
+}
+
+// metricsMiddleware records request counts and latencies labelled by the
+// matched route template, so it must be installed on the router itself.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := "unknown"
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				endpoint = tpl
+			}
+		}
+
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		httpRequests.WithLabelValues(endpoint, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(endpoint, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps a handler with cross-cutting behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// chain applies middlewares so the first one listed is the outermost.
+func chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
+
+// responseRecorder captures the status code and number of bytes written so
+// middlewares can report on the response after the handler has run.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		logger(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client", clientIP(r),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					// Deliberately aborted responses are dropped by the server.
+					panic(err)
+				}
+				logger(r.Context()).Error("panic serving request", "method", r.Method, "path", r.URL.Path, "panic", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the client that sent r, as reported by
+// any -trusted-proxy in front of the server.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return forwardedClientIP(r, host)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	mirrorRegistry     = flag.String("mirror-registry", "", "registry, such as harbor.example.com, every generated chart is pushed to (disabled when empty)")
+	mirrorUsername     = flag.String("mirror-username", "", "username for the -mirror-registry registry")
+	mirrorPasswordFile = flag.String("mirror-password-file", "", "file holding the password or token for the -mirror-registry registry")
+	mirrorPrefix       = flag.String("mirror-prefix", "", "repository prefix, such as charts/, prepended to the names charts are pushed under")
+	mirrorTimeout      = flag.Duration("mirror-timeout", time.Minute, "timeout for pushing one chart to -mirror-registry")
+)
+
+// mirrorClient pushes to -mirror-registry; it is nil when mirroring is
+// disabled.
+var mirrorClient *registryClient
+
+// initMirror sets up the -mirror-registry client.
+func initMirror() error {
+	if *mirrorRegistry == "" {
+		return nil
+	}
+	if *mirrorPrefix != "" && !validName(strings.TrimSuffix(*mirrorPrefix, "/")) {
+		return fmt.Errorf("invalid -mirror-prefix %q", *mirrorPrefix)
+	}
+
+	client, err := newRegistryClient(*mirrorRegistry, *mirrorUsername, *mirrorPasswordFile)
+	if err != nil {
+		return err
+	}
+
+	mirrorClient = client
+	return nil
+}
+
+// mirrorChart pushes a freshly generated chart, and the artifacts attached
+// to it, to -mirror-registry in the background.
+func mirrorChart(name string, reference string, manifest *Manifest) {
+	if mirrorClient == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), *mirrorTimeout)
+		defer cancel()
+
+		target := *mirrorPrefix + name
+		if err := pushChart(ctx, mirrorClient, name, target, reference, manifest); err != nil {
+			slog.Error("mirroring chart failed", "name", name, "reference", reference, "registry", mirrorClient.host(), "error", err)
+			return
+		}
+		slog.Info("mirrored chart", "name", name, "reference", reference, "registry", mirrorClient.host(), "repository", target, "digest", manifest.digest)
+	}()
+}
+
+// pushChart pushes manifest of name as target:reference to the registry of
+// client, followed by its referrers. Cosign signatures are also tagged so
+// cosign finds them.
+func pushChart(ctx context.Context, client *registryClient, name string, target string, reference string, manifest *Manifest) error {
+	push := func(reference string, m *Manifest) error {
+		for _, digest := range manifestBlobs(m) {
+			blob, ok, err := store.Get(digest)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("blob %s is missing from the store", digest)
+			}
+			if err := client.pushBlob(ctx, target, digest, blob); err != nil {
+				return err
+			}
+		}
+
+		return client.pushManifest(ctx, target, reference, m)
+	}
+
+	if err := push(reference, manifest); err != nil {
+		return err
+	}
+
+	referrers.RLock()
+	artifacts := append([]*Manifest(nil), referrers.bySubject[referrerKey(name, manifest.digest)]...)
+	referrers.RUnlock()
+
+	for _, artifact := range artifacts {
+		reference := artifact.digest
+		if artifact.ArtifactType == cosignArtifactType {
+			reference = strings.Replace(manifest.digest, ":", "-", 1) + ".sig"
+		}
+		if err := push(reference, artifact); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
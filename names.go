@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"path"
+	"regexp"
+)
+
+// Repository name grammar from the OCI distribution spec.
+var nameRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+var relaxedNames = flag.Bool("relaxed-names", false, "accept any repository name instead of enforcing the distribution spec grammar")
+
+// allowedNames restricts which repositories charts are generated for. Entries
+// are exact names or path.Match patterns; an empty list allows everything.
+var allowedNames stringList
+
+func init() {
+	flag.Var(&allowedNames, "allow-name", "repository name or pattern to serve charts for (repeatable; all names when unset)")
+}
+
+func validName(name string) bool {
+	if *relaxedNames {
+		return name != ""
+	}
+
+	return nameRegexp.MatchString(name)
+}
+
+// servableName reports whether charts may be generated for name: it must
+// belong to a tenant when -tenant is set and match -allow-name.
+func servableName(name string) bool {
+	if tenants != nil && tenantOf(name) == nil {
+		return false
+	}
+	if len(allowedNames) == 0 {
+		return true
+	}
+
+	for _, pattern := range allowedNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
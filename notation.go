@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+var (
+	notationKey  = flag.String("notation-key", "", "unencrypted PEM ECDSA or RSA private key used to sign every generated manifest for notation")
+	notationCert = flag.String("notation-cert", "", "PEM certificate chain of -notation-key, leaf first")
+)
+
+const (
+	notationArtifactType      = "application/vnd.cncf.notary.signature"
+	notationPayloadType       = "application/vnd.cncf.notary.payload.v1+json"
+	notationThumbprintsKey    = "io.cncf.notary.x509chain.thumbprint#S256"
+	notationSigningSchemeKey  = "io.cncf.notary.signingScheme"
+	notationSigningTimeKey    = "io.cncf.notary.signingTime"
+	notationSigningAgentKey   = "io.cncf.notary.signingAgent"
+	jwsEnvelopeMediaType      = "application/jose+json"
+	notationSigningSchemeX509 = "notary.x509"
+)
+
+// notationSigner signs generated manifests with notationChain; it is nil when
+// -notation-key is unset.
+var (
+	notationSigner crypto.Signer
+	notationChain  []*x509.Certificate
+)
+
+// initNotation loads the -notation-key signing key and its certificate chain.
+func initNotation() error {
+	if *notationKey == "" {
+		return nil
+	}
+	if *notationCert == "" {
+		return fmt.Errorf("-notation-key requires -notation-cert")
+	}
+
+	signer, err := loadPEMSigner(*notationKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*notationCert)
+	if err != nil {
+		return err
+	}
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", *notationCert, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("no certificates in %s", *notationCert)
+	}
+
+	type publicKey interface{ Equal(crypto.PublicKey) bool }
+	if !signer.Public().(publicKey).Equal(chain[0].PublicKey) {
+		return fmt.Errorf("the first certificate in %s does not match -notation-key", *notationCert)
+	}
+	if _, _, err := jwsAlgorithm(signer); err != nil {
+		return err
+	}
+
+	notationSigner, notationChain = signer, chain
+	return nil
+}
+
+// jwsAlgorithm returns the JWS algorithm notation requires for key and the
+// hash it signs with.
+func jwsAlgorithm(key crypto.Signer) (string, crypto.Hash, error) {
+	switch key := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return "ES256", crypto.SHA256, nil
+		case 384:
+			return "ES384", crypto.SHA384, nil
+		case 521:
+			return "ES512", crypto.SHA512, nil
+		}
+	case *rsa.PublicKey:
+		switch key.Size() * 8 {
+		case 2048:
+			return "PS256", crypto.SHA256, nil
+		case 3072:
+			return "PS384", crypto.SHA384, nil
+		case 4096:
+			return "PS512", crypto.SHA512, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("unsupported notation signing key: notation accepts P-256, P-384, P-521 and 2048, 3072 or 4096 bit RSA keys")
+}
+
+// jwsEnvelope is a notation signature in JWS JSON serialization.
+type jwsEnvelope struct {
+	Payload   string         `json:"payload"`
+	Protected string         `json:"protected"`
+	Header    jwsUnprotected `json:"header"`
+	Signature string         `json:"signature"`
+}
+
+type jwsUnprotected struct {
+	CertChain    [][]byte `json:"x5c"`
+	SigningAgent string   `json:"io.cncf.notary.signingAgent,omitempty"`
+}
+
+// notationSign attaches a notation signature of manifest as a referrer.
+func notationSign(name string, manifest *Manifest, signed time.Time) error {
+	alg, hash, err := jwsAlgorithm(notationSigner)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"targetArtifact": Layer{
+			MediaType: manifest.MediaType,
+			Digest:    manifest.digest,
+			Size:      len(manifest.content),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	protected, err := json.Marshal(map[string]interface{}{
+		"alg":                    alg,
+		"crit":                   []string{notationSigningSchemeKey},
+		"cty":                    notationPayloadType,
+		notationSigningSchemeKey: notationSigningSchemeX509,
+		notationSigningTimeKey:   signed.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(protected) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := hash.New()
+	h.Write([]byte(signingInput))
+
+	var opts crypto.SignerOpts = hash
+	if _, ok := notationSigner.(*rsa.PrivateKey); ok {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	signature, err := notationSigner.Sign(randReader, h.Sum(nil), opts)
+	if err != nil {
+		return err
+	}
+	if key, ok := notationSigner.Public().(*ecdsa.PublicKey); ok {
+		// JWS encodes ECDSA signatures as fixed size r||s rather than ASN.1.
+		if signature, err = rawECDSASignature(signature, (key.Curve.Params().BitSize+7)/8); err != nil {
+			return err
+		}
+	}
+
+	envelope := jwsEnvelope{
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+		Protected: base64.RawURLEncoding.EncodeToString(protected),
+		Header: jwsUnprotected{
+			SigningAgent: "virtual-helm/" + versionInfo().Version,
+		},
+		Signature: base64.RawURLEncoding.EncodeToString(signature),
+	}
+	thumbprints := make([]string, 0, len(notationChain))
+	for _, cert := range notationChain {
+		envelope.Header.CertChain = append(envelope.Header.CertChain, cert.Raw)
+		thumbprints = append(thumbprints, fmt.Sprintf("%x", sha256.Sum256(cert.Raw)))
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if err := store.Put(digest, data); err != nil {
+		return err
+	}
+
+	chain, err := json.Marshal(thumbprints)
+	if err != nil {
+		return err
+	}
+
+	return addReferrer(name, manifest, &Manifest{
+		ArtifactType: notationArtifactType,
+		Config:       emptyConfig,
+		Layers:       []Layer{{MediaType: jwsEnvelopeMediaType, Digest: digest, Size: len(data)}},
+		Annotations: map[string]string{
+			notationThumbprintsKey:             string(chain),
+			"org.opencontainers.image.created": signed.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// rawECDSASignature converts an ASN.1 ECDSA signature to r||s, each padded to
+// size bytes.
+func rawECDSASignature(der []byte, size int) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+
+	var raw bytes.Buffer
+	raw.Write(sig.R.FillBytes(make([]byte, size)))
+	raw.Write(sig.S.FillBytes(make([]byte, size)))
+	return raw.Bytes(), nil
+}
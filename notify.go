@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	notifySecretFile = flag.String("notify-secret-file", "", "file holding the key notification bodies are signed with, as an HMAC-SHA256 in the X-Virtual-Helm-Signature header")
+	notifyTimeout    = flag.Duration("notify-timeout", 10*time.Second, "timeout of each notification delivery attempt")
+	notifyRetries    = flag.Int("notify-retries", 5, "how often a failed notification delivery is retried, with exponential backoff")
+	notifyQueueSize  = flag.Int("notify-queue", 1000, "events buffered per -notify-url endpoint before new ones are dropped")
+)
+
+var notifyFlags stringList
+
+func init() {
+	flag.Var(&notifyFlags, "notify-url", "URL of a webhook to send registry events to as distribution-style notifications (repeatable)")
+}
+
+// notifySignatureHeader carries the hex HMAC-SHA256 of a notification body.
+const notifySignatureHeader = "X-Virtual-Helm-Signature"
+
+// eventsMediaType is the media type of distribution notification envelopes.
+const eventsMediaType = "application/vnd.docker.distribution.events.v1+json"
+
+// Envelope is the body of a notification, in the format of distribution
+// notifications.
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+// Event is a pull, push or delete of a manifest or blob.
+type Event struct {
+	ID        string       `json:"id"`
+	Timestamp time.Time    `json:"timestamp"`
+	Action    string       `json:"action"`
+	Target    EventTarget  `json:"target"`
+	Request   EventRequest `json:"request"`
+	Actor     EventActor   `json:"actor"`
+	Source    EventSource  `json:"source"`
+}
+
+// EventTarget is the manifest or blob an event is about.
+type EventTarget struct {
+	MediaType  string `json:"mediaType,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Length     int64  `json:"length,omitempty"`
+	Repository string `json:"repository"`
+	URL        string `json:"url,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// EventRequest is the request that caused an event.
+type EventRequest struct {
+	ID        string `json:"id"`
+	Addr      string `json:"addr"`
+	Host      string `json:"host"`
+	Method    string `json:"method"`
+	UserAgent string `json:"useragent"`
+}
+
+// EventActor is the user that caused an event.
+type EventActor struct {
+	Name string `json:"name,omitempty"`
+}
+
+// EventSource is the registry instance that emitted an event.
+type EventSource struct {
+	Addr       string `json:"addr"`
+	InstanceID string `json:"instanceID"`
+}
+
+// notifyEndpoint delivers the events queued for one -notify-url.
+type notifyEndpoint struct {
+	url    string
+	events chan Event
+}
+
+var (
+	notifyEndpoints []*notifyEndpoint
+	notifySecret    []byte
+	notifyClient    *http.Client
+	eventSource     EventSource
+)
+
+// initNotifications starts a delivery loop for every -notify-url.
+func initNotifications() error {
+	if len(notifyFlags) == 0 {
+		return nil
+	}
+
+	if *notifySecretFile != "" {
+		secret, err := os.ReadFile(*notifySecretFile)
+		if err != nil {
+			return err
+		}
+		notifySecret = bytes.TrimSpace(secret)
+	}
+
+	host, _ := os.Hostname()
+	eventSource = EventSource{Addr: host, InstanceID: newUUID()}
+	notifyClient = &http.Client{Timeout: *notifyTimeout}
+
+	for _, f := range notifyFlags {
+		u, err := url.Parse(f)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid notification URL %q: expected an http or https URL", f)
+		}
+
+		endpoint := &notifyEndpoint{url: f, events: make(chan Event, *notifyQueueSize)}
+		notifyEndpoints = append(notifyEndpoints, endpoint)
+		go endpoint.run()
+	}
+
+	return nil
+}
+
+// notify queues an event for the request r for every -notify-url.
+func notify(r *http.Request, action string, target EventTarget) {
+	if notifyEndpoints == nil {
+		return
+	}
+
+	if target.Digest != "" {
+		kind := "blobs"
+		if target.Tag != "" || strings.Contains(target.MediaType, "manifest") {
+			kind = "manifests"
+		}
+		target.URL = baseURL(r) + "/v2/" + target.Repository + "/" + kind + "/" + target.Digest
+	}
+	target.Length = target.Size
+
+	event := Event{
+		ID:        newUUID(),
+		Timestamp: now().UTC(),
+		Action:    action,
+		Target:    target,
+		Request: EventRequest{
+			ID:        requestID(r.Context()),
+			Addr:      clientIP(r),
+			Host:      r.Host,
+			Method:    r.Method,
+			UserAgent: r.UserAgent(),
+		},
+		Source: eventSource,
+	}
+	if id, ok := identity(r.Context()); ok {
+		event.Actor.Name = id.Name
+	}
+
+	for _, endpoint := range notifyEndpoints {
+		select {
+		case endpoint.events <- event:
+		default:
+			logger(r.Context()).Warn("notification queue full, dropping event", "url", endpoint.url, "action", action, "repository", target.Repository)
+		}
+	}
+}
+
+// run delivers queued events one at a time, retrying each with exponential
+// backoff before giving up on it.
+func (e *notifyEndpoint) run() {
+	for event := range e.events {
+		body, err := json.Marshal(Envelope{Events: []Event{event}})
+		if err != nil {
+			slog.Error("encoding notification failed", "error", err)
+			continue
+		}
+
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err = e.deliver(body)
+			if err == nil || attempt >= *notifyRetries {
+				break
+			}
+			slog.Debug("notification delivery failed, retrying", "url", e.url, "attempt", attempt+1, "error", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err != nil {
+			slog.Warn("notification delivery failed", "url", e.url, "event", event.ID, "error", err)
+		}
+	}
+}
+
+// deliver posts a notification body once.
+func (e *notifyEndpoint) deliver(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", eventsMediaType)
+	if notifySecret != nil {
+		mac := hmac.New(sha256.New, notifySecret)
+		mac.Write(body)
+		req.Header.Set(notifySignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", e.url, resp.Status)
+	}
+
+	return nil
+}
+
+// responseSize returns the Content-Length of a response, or the bytes
+// written when it has none.
+func responseSize(rec *responseRecorder) int64 {
+	if n, err := strconv.ParseInt(rec.Header().Get("Content-Length"), 10, 64); err == nil {
+		return n
+	}
+
+	return int64(rec.bytes)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	oidcIssuer        = flag.String("oidc-issuer", "", "issuer URL of an OIDC provider whose ID tokens are accepted as bearer tokens (disabled when empty)")
+	oidcAudience      = flag.String("oidc-audience", "", "audience OIDC tokens must be issued for")
+	oidcUsernameClaim = flag.String("oidc-username-claim", "sub", "claim naming the user of an OIDC token")
+	oidcJWKSRefresh   = flag.Duration("oidc-jwks-refresh", time.Hour, "how often the OIDC provider's signing keys are fetched again")
+)
+
+var oidcGrantFlags stringList
+
+func init() {
+	flag.Var(&oidcGrantFlags, "oidc-grant", "grant OIDC tokens whose claim has a value access to repositories matching a pattern, as claim:value:pattern=actions, e.g. groups:platform:charts/*=pull,push; tokens get full access when no grant is configured (repeatable)")
+}
+
+// oidcGrant gives tokens with a claim value actions on matching repositories.
+type oidcGrant struct {
+	claim   string
+	value   string
+	pattern string
+	actions []string
+}
+
+var oidcGrants []oidcGrant
+
+// oidcProvider verifies tokens against the cached keys of the -oidc-issuer;
+// it is nil when OIDC is disabled.
+var oidcProvider *oidcKeys
+
+// oidcKeys caches the JSON web key set of an OIDC provider.
+type oidcKeys struct {
+	jwksURI string
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// initOIDC discovers the -oidc-issuer and fetches its keys.
+func initOIDC() error {
+	if *oidcIssuer == "" {
+		return nil
+	}
+	if *oidcAudience == "" {
+		return errors.New("-oidc-issuer requires -oidc-audience")
+	}
+
+	for _, f := range oidcGrantFlags {
+		rule, actions, ok := strings.Cut(f, "=")
+		claim, rest, ok2 := strings.Cut(rule, ":")
+		i := strings.LastIndex(rest, ":")
+		if !ok || !ok2 || i < 0 || claim == "" || actions == "" {
+			return fmt.Errorf("invalid OIDC grant %q: expected claim:value:pattern=actions", f)
+		}
+		pattern := rest[i+1:]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid OIDC grant %q: %w", f, err)
+		}
+
+		oidcGrants = append(oidcGrants, oidcGrant{claim: claim, value: rest[:i], pattern: pattern, actions: strings.Split(actions, ",")})
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	discovery := strings.TrimSuffix(*oidcIssuer, "/") + "/.well-known/openid-configuration"
+	resp, err := client.Get(discovery)
+	if err != nil {
+		return fmt.Errorf("discovering OIDC issuer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovering OIDC issuer: %s returned %s", discovery, resp.Status)
+	}
+
+	var config struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return fmt.Errorf("decoding OIDC discovery document: %w", err)
+	}
+	if config.Issuer != *oidcIssuer {
+		return fmt.Errorf("OIDC discovery document is for issuer %q, not %q", config.Issuer, *oidcIssuer)
+	}
+	if config.JWKSURI == "" {
+		return errors.New("OIDC discovery document has no jwks_uri")
+	}
+
+	provider := &oidcKeys{jwksURI: config.JWKSURI, client: client}
+	if err := provider.refresh(context.Background()); err != nil {
+		return err
+	}
+
+	oidcProvider = provider
+	return nil
+}
+
+// refresh fetches the key set.
+func (p *oidcKeys) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.jwksURI, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching OIDC keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching OIDC keys: %s returned %s", p.jwksURI, resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decoding OIDC keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("ignoring OIDC key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.keys = keys
+	p.fetched = time.Now()
+	return nil
+}
+
+// key returns the key with id kid, refreshing the key set when it is stale
+// or the key is unknown, at most once a minute.
+func (p *oidcKeys) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[kid]
+	age := time.Since(p.fetched)
+	if (!ok && age > time.Minute) || age > *oidcJWKSRefresh {
+		if err := p.refresh(ctx); err != nil {
+			slog.Warn("refreshing OIDC keys failed", "error", err)
+		}
+		key, ok = p.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown OIDC key %q", kid)
+	}
+
+	return key, nil
+}
+
+// jsonWebKey is an RSA or EC public key in JWK form.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// checkOIDCToken validates the bearer token of a request as an OIDC ID token
+// and maps its claims to repository access.
+func checkOIDCToken(r *http.Request) (*Identity, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errors.New("no bearer token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return oidcProvider.key(r.Context(), kid)
+	},
+		jwt.WithValidMethods(tokenMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(*oidcIssuer),
+		jwt.WithAudience(*oidcAudience),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := claims[*oidcUsernameClaim].(string)
+	id := &Identity{Name: name}
+	if len(oidcGrants) == 0 {
+		return id, nil
+	}
+
+	id.Access = []ResourceActions{}
+	for _, grant := range oidcGrants {
+		if claimHasValue(claims[grant.claim], grant.value) {
+			id.Access = append(id.Access, ResourceActions{Type: "repository", Name: grant.pattern, Actions: grant.actions})
+		}
+	}
+
+	return id, nil
+}
+
+// claimHasValue reports whether a string claim equals value or a list claim
+// contains it.
+func claimHasValue(claim interface{}, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, v := range claim {
+			if s, ok := v.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// oidcChallenge asks for an OIDC token from the -oidc-issuer.
+func oidcChallenge(w http.ResponseWriter, bearerErr string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", *oidcIssuer)
+	if bearerErr != "" {
+		challenge += fmt.Sprintf(",error=%q", bearerErr)
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "authentication required", nil)
+}
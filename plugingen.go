@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"plugin"
+	"strings"
+)
+
+var pluginDir = flag.String("plugin-dir", "", "directory of Go plugins (*.so built with -buildmode=plugin); each exports a Generator implementing chartgen.Generator and is registered under its file name")
+
+// loadGoPlugins opens every shared object in -plugin-dir and registers the
+// chartgen.Generator it exports as Generator.
+//
+// Go plugins must be built with the same toolchain and the same version of
+// the chartgen package as the server.
+func loadGoPlugins() error {
+	if *pluginDir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(*pluginDir, "*.so"))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), ".so")
+		if _, ok := generators[name]; ok {
+			return fmt.Errorf("plugin %s: generator %q already exists", p, name)
+		}
+
+		plug, err := plugin.Open(p)
+		if err != nil {
+			return fmt.Errorf("opening plugin %s: %w", p, err)
+		}
+
+		sym, err := plug.Lookup("Generator")
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", p, err)
+		}
+
+		var gen ChartGenerator
+		switch v := sym.(type) {
+		case ChartGenerator:
+			gen = v
+		case *ChartGenerator:
+			gen = *v
+		default:
+			return fmt.Errorf("plugin %s: Generator is a %T, not a chartgen.Generator", p, sym)
+		}
+
+		generators[name] = gen
+		slog.Info("loaded generator plugin", "generator", name, "path", p)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Pools for the allocations on the chart generation hot path. tar.Writer has
+// no Reset method and is cheap to allocate, so it is not pooled.
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} {
+			// The -gzip-level is validated before any archive is written.
+			gz, _ := gzip.NewWriterLevel(io.Discard, *gzipLevel)
+			return gz
+		},
+	}
+
+	bufferPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+)
+
+func getGzipWriter(w io.Writer) *gzip.Writer {
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer) {
+	gz.Reset(io.Discard)
+	gzipWriterPool.Put(gz)
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// Very large buffers are dropped rather than pinned in the pool.
+const maxPooledBufferSize = 16 << 20
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	b.Reset()
+	bufferPool.Put(b)
+}
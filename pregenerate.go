@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var pregenerateInterval = flag.Duration("pregenerate-interval", 0, "how often the -pregenerate charts are generated again, replacing the cached manifests (only at startup when 0)")
+
+var pregenerateFlags stringList
+
+func init() {
+	flag.Var(&pregenerateFlags, "pregenerate", "chart to generate at startup, so its first pull is served from the cache, as name:reference (repeatable)")
+}
+
+// pregenerated is set once the -pregenerate charts have been generated at
+// startup; the server is not ready before.
+var pregenerated atomic.Bool
+
+// initPregeneration checks the -pregenerate charts and starts generating
+// them in the background.
+func initPregeneration() error {
+	var refs []chartRef
+	for _, f := range pregenerateFlags {
+		name, reference, ok := strings.Cut(f, ":")
+		if !ok || !validName(name) || reference == "" {
+			return fmt.Errorf("invalid -pregenerate chart %q: expected name:reference", f)
+		}
+		refs = append(refs, chartRef{Name: name, Reference: reference})
+	}
+	if *pregenerateInterval < 0 {
+		return fmt.Errorf("invalid -pregenerate-interval %s", *pregenerateInterval)
+	}
+
+	if len(refs) == 0 {
+		pregenerated.Store(true)
+		return nil
+	}
+
+	go func() {
+		pregenerate(refs, generateShared)
+		pregenerated.Store(true)
+		if *pregenerateInterval == 0 {
+			return
+		}
+
+		for range time.Tick(*pregenerateInterval) {
+			pregenerate(refs, generateFresh)
+		}
+	}()
+
+	return nil
+}
+
+// pregenerate generates refs one after the other with generate, logging
+// the charts that fail.
+func pregenerate(refs []chartRef, generate func(context.Context, string, string) (*Manifest, error)) {
+	for _, ref := range refs {
+		start := time.Now()
+		manifest, err := generate(context.Background(), ref.Name, ref.Reference)
+		if err != nil {
+			slog.Warn("pregeneration failed", "name", ref.Name, "reference", ref.Reference, "error", err)
+			continue
+		}
+		slog.Info("pregenerated chart", "name", ref.Name, "reference", ref.Reference, "digest", manifest.digest, "duration", time.Since(start))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	provenanceKeyring        = flag.String("provenance-keyring", "", "GPG secret keyring (binary or armored) used to sign a provenance file for every generated chart")
+	provenanceKey            = flag.String("provenance-key", "", "name, email or key ID of the signing key in -provenance-keyring (defaults to the first key)")
+	provenancePassphraseFile = flag.String("provenance-passphrase-file", "", "file holding the passphrase of the signing key")
+)
+
+const provenanceMediaType = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+
+// signingKey signs provenance files; it is nil when -provenance-keyring is
+// unset.
+var signingKey *openpgp.Entity
+
+// initProvenance loads the signing key configured by the -provenance-*
+// flags.
+func initProvenance() error {
+	if *provenanceKeyring == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(*provenanceKeyring)
+	if err != nil {
+		return err
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("reading provenance keyring: %w", err)
+	}
+
+	for _, e := range keyring {
+		if e.PrivateKey != nil && matchesKey(e, *provenanceKey) {
+			signingKey = e
+			break
+		}
+	}
+	if signingKey == nil {
+		return fmt.Errorf("no secret key matching %q in %s", *provenanceKey, *provenanceKeyring)
+	}
+
+	if signingKey.PrivateKey.Encrypted {
+		if *provenancePassphraseFile == "" {
+			return fmt.Errorf("signing key %X is encrypted but -provenance-passphrase-file is unset", signingKey.PrimaryKey.KeyId)
+		}
+		passphrase, err := os.ReadFile(*provenancePassphraseFile)
+		if err != nil {
+			return err
+		}
+		if err := signingKey.PrivateKey.Decrypt(bytes.TrimRight(passphrase, "\r\n")); err != nil {
+			return fmt.Errorf("decrypting signing key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// matchesKey reports whether e is the key selected by name: a substring of
+// one of its identities or a suffix of its hex key ID. An empty name matches
+// any key.
+func matchesKey(e *openpgp.Entity, name string) bool {
+	if name == "" {
+		return true
+	}
+	if strings.HasSuffix(fmt.Sprintf("%X", e.PrimaryKey.KeyId), strings.ToUpper(name)) {
+		return true
+	}
+	for id := range e.Identities {
+		if strings.Contains(id, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// provenanceFile returns the signed provenance file of chart whose archive
+// has the hex-encoded sha256 sum, in the format written by helm package
+// --sign: Chart.yaml, a "..." separator and the archive checksum, clear
+// signed.
+func provenanceFile(chart Chart, sum string, created time.Time) ([]byte, error) {
+	metadata, err := yaml.Marshal(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := yaml.Marshal(map[string]map[string]string{
+		"files": {fmt.Sprintf("%s-%s.tgz", chart.Name, chart.Version): "sha256:" + sum},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	w, err := clearsign.Encode(&out, signingKey.PrivateKey, &packet.Config{
+		DefaultHash: crypto.SHA512,
+		Time:        func() time.Time { return created },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Write(metadata)
+	w.Write([]byte("\n...\n"))
+	w.Write(files)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
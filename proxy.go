@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	proxyUpstream     = flag.String("proxy-upstream", "", "registry, such as https://registry-1.docker.io, to pull charts from when no generator knows the repository (disabled when empty)")
+	proxyUsername     = flag.String("proxy-username", "", "username for the -proxy-upstream registry")
+	proxyPasswordFile = flag.String("proxy-password-file", "", "file holding the password or token for the -proxy-upstream registry")
+	proxyTagTTL       = flag.Duration("proxy-tag-ttl", 5*time.Minute, "how long a proxied tag is served before it is checked upstream again; digests never expire")
+)
+
+var (
+	proxyNames                stringList
+	upstreamFlags             stringList
+	upstreamUsernameFlags     stringList
+	upstreamPasswordFileFlags stringList
+)
+
+func init() {
+	flag.Var(&proxyNames, "proxy-name", "repository name or pattern to always pull from -proxy-upstream instead of generating (repeatable)")
+	flag.Var(&upstreamFlags, "upstream", "named upstream registry that -generator routes can send repositories to as pattern=upstream:name, given as name=host[/namespace]; the namespace replaces the literal prefix of the route pattern (repeatable)")
+	flag.Var(&upstreamUsernameFlags, "upstream-username", "username for an -upstream registry, as name=username (repeatable)")
+	flag.Var(&upstreamPasswordFileFlags, "upstream-password-file", "file holding the password or token for an -upstream registry, as name=file (repeatable)")
+}
+
+// proxyUpstreamName is the name -proxy-upstream is registered under.
+const proxyUpstreamName = "proxy"
+
+// upstreamRoutePrefix marks generator routes that send repositories to an
+// upstream registry.
+const upstreamRoutePrefix = "upstream:"
+
+// proxyRoutes are the generator routes of the -proxy-name patterns, which
+// take precedence over the -generator routes.
+var proxyRoutes stringList
+
+// upstream is a registry charts are pulled through from.
+type upstream struct {
+	client *registryClient
+	// namespace is the repository path the upstream's charts are under.
+	namespace string
+}
+
+// upstreams holds the configured upstream registries by name.
+var upstreams = make(map[string]*upstream)
+
+// proxied holds the manifests pulled from upstream registries by local
+// name:reference.
+var proxied = struct {
+	sync.RWMutex
+	byRef map[string]proxiedManifest
+}{byRef: make(map[string]proxiedManifest)}
+
+type proxiedManifest struct {
+	manifest *Manifest
+	fetched  time.Time
+}
+
+// initUpstreams sets up the -upstream and -proxy-upstream registries and
+// routes the -proxy-name repositories to the latter. It must run before
+// initGeneratorRoutes.
+func initUpstreams() error {
+	credentials := func(flags stringList, what string) (map[string]string, error) {
+		values := make(map[string]string)
+		for _, f := range flags {
+			name, value, ok := strings.Cut(f, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid upstream %s %q: expected name=%s", what, f, what)
+			}
+			values[name] = value
+		}
+		return values, nil
+	}
+	usernames, err := credentials(upstreamUsernameFlags, "username")
+	if err != nil {
+		return err
+	}
+	passwordFiles, err := credentials(upstreamPasswordFileFlags, "file")
+	if err != nil {
+		return err
+	}
+
+	add := func(name string, location string, username string, passwordFile string) error {
+		scheme := ""
+		if i := strings.Index(location, "://"); i >= 0 {
+			scheme, location = location[:i+3], location[i+3:]
+		}
+		host, namespace, _ := strings.Cut(strings.TrimSuffix(location, "/"), "/")
+		if namespace != "" && !validName(namespace) {
+			return fmt.Errorf("invalid upstream %s: invalid namespace %q", name, namespace)
+		}
+
+		client, err := newRegistryClient(scheme+host, username, passwordFile)
+		if err != nil {
+			return err
+		}
+
+		upstreams[name] = &upstream{client: client, namespace: namespace}
+		return nil
+	}
+
+	for _, f := range upstreamFlags {
+		name, location, ok := strings.Cut(f, "=")
+		if !ok || name == "" || location == "" {
+			return fmt.Errorf("invalid upstream %q: expected name=host[/namespace]", f)
+		}
+		if _, ok := upstreams[name]; ok || name == proxyUpstreamName {
+			return fmt.Errorf("invalid upstream %q: duplicate name %q", f, name)
+		}
+		if err := add(name, location, usernames[name], passwordFiles[name]); err != nil {
+			return err
+		}
+	}
+	for name := range usernames {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("-upstream-username for unknown upstream %q", name)
+		}
+	}
+	for name := range passwordFiles {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("-upstream-password-file for unknown upstream %q", name)
+		}
+	}
+
+	if *proxyUpstream == "" {
+		return nil
+	}
+	if err := add(proxyUpstreamName, *proxyUpstream, *proxyUsername, *proxyPasswordFile); err != nil {
+		return err
+	}
+	for _, pattern := range proxyNames {
+		proxyRoutes = append(proxyRoutes, pattern+"="+upstreamRoutePrefix+proxyUpstreamName)
+	}
+
+	return nil
+}
+
+// upstreamFor returns the upstream registry name is routed to and the
+// repository name it has there.
+func upstreamFor(name string) (*upstream, string, bool) {
+	route := routeFor(name)
+	if route == nil {
+		return nil, "", false
+	}
+	upstreamName, ok := strings.CutPrefix(route.generator, upstreamRoutePrefix)
+	if !ok {
+		return nil, "", false
+	}
+
+	up := upstreams[upstreamName]
+	return up, up.remoteName(route.pattern, name), true
+}
+
+// remoteName maps a local repository name matched by pattern to its name on
+// the upstream registry, replacing the literal prefix of pattern with the
+// upstream namespace.
+func (up *upstream) remoteName(pattern string, name string) string {
+	if up.namespace == "" {
+		return name
+	}
+
+	prefix := pattern
+	if i := strings.IndexAny(prefix, `*?[\`); i >= 0 {
+		prefix = prefix[:i]
+	}
+	prefix = prefix[:strings.LastIndex(prefix, "/")+1]
+
+	return path.Join(up.namespace, strings.TrimPrefix(name, prefix))
+}
+
+// generateOrProxy generates name:reference, pulling it from an upstream
+// registry instead when a route sends name there, or from -proxy-upstream
+// when no generator knows name. Digests are served from the generated
+// manifests.
+func generateOrProxy(ctx context.Context, name string, reference string) (*Manifest, error) {
+	if up, remote, ok := upstreamFor(name); ok {
+		return proxyManifest(ctx, up, name, remote, reference)
+	}
+
+	// Generators only know tags, so a digest is one a tag was generated as.
+	if isDigestReference(reference) {
+		if manifest, ok := generated.GetDigest(name, reference); ok {
+			return manifest, nil
+		}
+		if up, ok := upstreams[proxyUpstreamName]; ok {
+			manifest, err := proxyManifest(ctx, up, name, up.remoteName("", name), reference)
+			if !errors.Is(err, errChartNotFound) {
+				return manifest, err
+			}
+		}
+		return nil, errReferenceNotFound
+	}
+
+	manifest, err := generateShared(ctx, name, reference)
+	if up, ok := upstreams[proxyUpstreamName]; ok && errors.Is(err, errChartNotFound) {
+		return proxyManifest(ctx, up, name, up.remoteName("", name), reference)
+	}
+
+	return manifest, err
+}
+
+// proxyManifest returns the manifest for remote:reference on up, served as
+// name:reference, and stores its blobs. It is kept in memory until
+// -proxy-tag-ttl passes; a stale tag is still served when the upstream
+// registry cannot be reached.
+func proxyManifest(ctx context.Context, up *upstream, name string, remote string, reference string) (*Manifest, error) {
+	key := cacheKey(name, reference)
+	isDigest := strings.HasPrefix(reference, "sha256:")
+
+	proxied.RLock()
+	cached, ok := proxied.byRef[key]
+	proxied.RUnlock()
+	if ok && (isDigest || time.Since(cached.fetched) < *proxyTagTTL) {
+		return cached.manifest, nil
+	}
+
+	v, err := generations.Do(ctx, "proxy "+key, func(ctx context.Context) (interface{}, error) {
+		return pullManifest(ctx, up, name, remote, reference)
+	})
+	if errors.Is(err, errUpstreamNotFound) {
+		return nil, errChartNotFound
+	}
+	if err != nil {
+		if ok {
+			logger(ctx).Warn("serving stale proxied manifest", "name", name, "reference", reference, "error", err)
+			return cached.manifest, nil
+		}
+		return nil, err
+	}
+
+	return v.(*Manifest), nil
+}
+
+// pullManifest fetches remote:reference and its blobs from up.
+func pullManifest(ctx context.Context, up *upstream, name string, remote string, reference string) (*Manifest, error) {
+	content, err := up.client.getManifest(ctx, remote, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &importer{readFile: func(blob string) ([]byte, error) {
+		return up.client.getBlob(ctx, remote, "sha256:"+path.Base(blob))
+	}}
+	manifest, err := im.manifest(content)
+	if err != nil {
+		return nil, fmt.Errorf("upstream manifest %s:%s: %w", remote, reference, err)
+	}
+	if strings.HasPrefix(reference, "sha256:") && manifest.digest != reference {
+		return nil, fmt.Errorf("upstream manifest %s does not match its digest", reference)
+	}
+
+	now := time.Now()
+	proxied.Lock()
+	proxied.byRef[cacheKey(name, reference)] = proxiedManifest{manifest: manifest, fetched: now}
+	proxied.byRef[cacheKey(name, manifest.digest)] = proxiedManifest{manifest: manifest, fetched: now}
+	proxied.Unlock()
+
+	logger(ctx).Info("pulled manifest from upstream", "name", name, "registry", up.client.host(), "repository", remote, "reference", reference, "digest", manifest.digest, "blobs", im.result.Blobs)
+	return manifest, nil
+}
+
+// ensureProxiedBlob pulls a blob that is missing from the store from the
+// upstream registry name is routed to, or -proxy-upstream.
+func ensureProxiedBlob(ctx context.Context, name string, digest string) error {
+	up, remote, ok := upstreamFor(name)
+	if !ok {
+		if up, ok = upstreams[proxyUpstreamName]; !ok {
+			return nil
+		}
+		remote = up.remoteName("", name)
+	}
+	if _, ok, err := store.Get(digest); ok || err != nil {
+		return err
+	}
+
+	blob, err := up.client.getBlob(ctx, remote, digest)
+	if errors.Is(err, errUpstreamNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return store.Put(digest, blob)
+}
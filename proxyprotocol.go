@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/pires/go-proxyproto"
+)
+
+var proxyProtocol = flag.Bool("proxy-protocol", false, "accept PROXY protocol v1 and v2 headers on TCP listeners, taking client addresses from them; with -trusted-proxy, only those addresses may send headers")
+
+// withProxyProtocol wraps the TCP listeners to read PROXY protocol headers.
+func withProxyProtocol(listeners []listener) {
+	if !*proxyProtocol {
+		return
+	}
+
+	for i, ln := range listeners {
+		if ln.Addr().Network() != "tcp" {
+			continue
+		}
+		listeners[i].Listener = &proxyproto.Listener{
+			Listener:          ln.Listener,
+			ConnPolicy:        proxyProtocolPolicy,
+			ReadHeaderTimeout: *readHeaderTimeout,
+		}
+	}
+}
+
+// proxyProtocolPolicy trusts PROXY headers from -trusted-proxy addresses, or
+// from everyone when none is configured.
+func proxyProtocolPolicy(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+	if len(trustedProxies) == 0 {
+		return proxyproto.USE, nil
+	}
+
+	host, _, err := net.SplitHostPort(opts.Upstream.String())
+	if err != nil || !inPrefixes(trustedProxies, host) {
+		return proxyproto.REJECT, nil
+	}
+
+	return proxyproto.USE, nil
+}
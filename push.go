@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runPush implements `virtual-helm push [flags] <name>:<tag> oci://<registry>/<path>`.
+func runPush(args []string) error {
+	username := flag.String("username", "", "username for the destination registry; the Docker config's credentials for it are used when empty")
+	passwordFile := flag.String("password-file", "", "file holding the password or token for the destination registry")
+	plainHTTP := flag.Bool("plain-http", false, "talk plain HTTP to the destination registry")
+	timeout := flag.Duration("timeout", 5*time.Minute, "timeout for generating and pushing the chart")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: virtual-helm push [flags] <name>:<tag> oci://<registry>/<path>\n\nGenerates a chart and pushes it, and its signatures and attestations, to <registry>/<path>/<chart>, like helm push. The flags of serve configure generation.")
+		flag.PrintDefaults()
+	}
+
+	positional, err := parseCommandLine(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	i := strings.LastIndex(positional[0], ":")
+	if i <= 0 || i == len(positional[0])-1 {
+		return fmt.Errorf("invalid chart %q: expected name:tag", positional[0])
+	}
+	name, tag := positional[0][:i], positional[0][i+1:]
+
+	destination, ok := strings.CutPrefix(positional[1], "oci://")
+	if !ok {
+		return fmt.Errorf("invalid destination %q: expected oci://registry/path", positional[1])
+	}
+	host, repository, _ := strings.Cut(strings.TrimSuffix(destination, "/"), "/")
+	target := strings.TrimPrefix(repository+"/"+path.Base(name), "/")
+	if !validName(target) {
+		return fmt.Errorf("invalid destination repository %q", target)
+	}
+
+	scheme := "https://"
+	if *plainHTTP {
+		scheme = "http://"
+	}
+	client, err := newRegistryClient(scheme+host, *username, *passwordFile)
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		if client.username, client.password, err = dockerCredentials(host); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := generateOffline(name, tag)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := pushChart(ctx, client, rewriteName(name), target, tag, manifest); err != nil {
+		return err
+	}
+
+	fmt.Printf("pushed %s/%s:%s@%s\n", host, target, tag, manifest.digest)
+	return nil
+}
+
+// dockerCredentials returns the credentials the Docker config, as written
+// by docker login and helm registry login, holds for host. They are empty
+// when there is no config or no entry for host.
+func dockerCredentials(host string) (string, string, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", fmt.Errorf("decoding Docker config: %w", err)
+	}
+
+	for _, key := range []string{host, "https://" + host, "http://" + host} {
+		entry, ok := config.Auths[key]
+		if !ok || entry.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("decoding Docker config credentials for %s: %w", host, err)
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return username, password, nil
+	}
+
+	return "", "", nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+var conformance = flag.Bool("conformance", false, "serve only pushed and imported content, without generating or proxying charts, so the registry behaves as the OCI distribution-spec conformance suite expects")
+
+// pushed holds the manifests pushed to the registry by name:reference, with
+// the digest as reference for every manifest.
+var pushed = struct {
+	sync.RWMutex
+	byRef map[string]*Manifest
+}{byRef: make(map[string]*Manifest)}
+
+// pushedManifest returns the pushed manifest name:reference refers to.
+func pushedManifest(name string, reference string) (*Manifest, bool) {
+	pushed.RLock()
+	defer pushed.RUnlock()
+
+	manifest, ok := pushed.byRef[cacheKey(name, reference)]
+	return manifest, ok
+}
+
+// pushedCharts lists the pushed tags.
+func pushedCharts() []chartRef {
+	pushed.RLock()
+	defer pushed.RUnlock()
+
+	var refs []chartRef
+	for key, manifest := range pushed.byRef {
+		name, reference, _ := strings.Cut(key, ":")
+		if reference != manifest.digest {
+			refs = append(refs, chartRef{Name: name, Reference: reference})
+		}
+	}
+
+	return refs
+}
+
+// isIndexMediaType reports whether mediaType is that of a manifest listing
+// other manifests rather than blobs.
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == imageIndexMediaType || mediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+}
+
+// handlePutManifest stores a pushed manifest once the blobs it references
+// are stored, as name@digest and, when the reference is a tag, name:tag. In
+// the repositories of a tenant, the blobs must have been uploaded or mounted
+// by the tenant, so pushing a manifest cannot claim another tenant's blobs.
+func handlePutManifest(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	reference := mux.Vars(r)["reference"]
+	logger(r.Context()).Debug("received manifest", "name", name, "reference", reference, "manifest", string(body))
+
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, "manifest invalid", err.Error())
+		return
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = r.Header.Get("Content-Type")
+	}
+	manifest.content = body
+	manifest.digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+
+	// Manifests are only addressed by their sha256 digest.
+	isDigest := isDigestReference(reference)
+	if isDigest && !strings.HasPrefix(reference, "sha256:") {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, "unsupported digest algorithm", reference)
+		return
+	}
+	if isDigest && reference != manifest.digest {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, "provided digest did not match manifest content", map[string]string{"digest": reference, "actual": manifest.digest})
+		return
+	}
+
+	if !isIndexMediaType(manifest.MediaType) {
+		for _, digest := range manifestBlobs(&manifest) {
+			if _, ok, err := store.Get(digest); !ok || err != nil || !tenantHasBlob(name, digest) {
+				writeError(w, http.StatusBadRequest, ErrCodeManifestBlobUnknown, "blob unknown to registry", digest)
+				return
+			}
+		}
+		if err := claimTenantBlobs(name, &manifest); err != nil {
+			writeError(w, http.StatusForbidden, ErrCodeDenied, err.Error(), name)
+			return
+		}
+	}
+
+	pushed.Lock()
+	if !isDigest {
+		if current, ok := pushed.byRef[cacheKey(name, reference)]; ok && current.digest != manifest.digest && tagsImmutable(name) {
+			pushed.Unlock()
+			writeError(w, http.StatusConflict, ErrCodeDenied, "tag is immutable", map[string]string{"tag": reference, "digest": current.digest})
+			return
+		}
+	}
+	if !checkQuota(w, name, repositoryUsage(name, &manifest), 0) {
+		pushed.Unlock()
+		return
+	}
+	if !isDigest {
+		pushed.byRef[cacheKey(name, reference)] = &manifest
+	}
+	pushed.byRef[cacheKey(name, manifest.digest)] = &manifest
+	pushed.Unlock()
+
+	if !isDigest {
+		recordTag(name, reference, manifest.digest)
+	}
+
+	if manifest.Subject != nil {
+		addPushedReferrer(name, &manifest)
+		w.Header().Set("OCI-Subject", manifest.Subject.Digest)
+	}
+
+	w.Header().Set("Location", baseURL(r)+"/v2/"+name+"/manifests/"+reference)
+	w.Header().Set("Docker-Content-Digest", manifest.digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleDeleteManifest deletes a pushed tag or, by digest, a pushed manifest
+// and every tag pointing at it. They can be restored until the
+// -delete-retention has passed.
+func handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+
+	reference := mux.Vars(r)["reference"]
+	manifest, ok := pushedManifest(name, reference)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "manifest unknown", nil)
+		return
+	}
+
+	isDigest := isDigestReference(reference)
+	var deletedTags []string
+	pushed.Lock()
+	if isDigest {
+		for key, m := range pushed.byRef {
+			n, tag, _ := strings.Cut(key, ":")
+			if n == name && m.digest == manifest.digest {
+				delete(pushed.byRef, key)
+				if tag != m.digest {
+					deletedTags = append(deletedTags, tag)
+				}
+			}
+		}
+	} else {
+		delete(pushed.byRef, cacheKey(name, reference))
+		deletedTags = append(deletedTags, reference)
+	}
+	pushed.Unlock()
+
+	softDelete(name, manifest, deletedTags, isDigest)
+	for _, tag := range deletedTags {
+		recordTag(name, tag, "")
+	}
+
+	if manifest.Subject != nil && isDigest {
+		removePushedReferrer(name, manifest)
+	}
+
+	logger(r.Context()).Info("deleted manifest", "name", name, "reference", reference)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleDeleteBlob deletes a stored blob. Blobs are shared by every
+// repository, so one that any manifest still references is kept and the
+// delete refused.
+func handleDeleteBlob(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+
+	digest := mux.Vars(r)["digest"]
+	if _, ok, err := store.Get(digest); !ok || err != nil || !tenantHasBlob(name, digest) {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, "blob unknown to registry", nil)
+		return
+	}
+	if blobsInUse()[digest] {
+		writeError(w, http.StatusConflict, ErrCodeDenied, "blob is referenced by a manifest", digest)
+		return
+	}
+	if err := store.Delete(digest); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+
+	logger(r.Context()).Info("deleted blob", "name", name, "digest", digest)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// TagList is the response of the tags list API.
+type TagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// handleTagsList lists the known tags of a repository in lexical order,
+// paginated by the n and last query parameters.
+func handleTagsList(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
+		return
+	}
+
+	list := TagList{Name: clientName(r, name), Tags: []string{}}
+	for _, ref := range knownCharts() {
+		if ref.Name == name && !strings.HasPrefix(ref.Reference, "sha256:") {
+			list.Tags = append(list.Tags, ref.Reference)
+		}
+	}
+	sort.Strings(list.Tags)
+
+	query := r.URL.Query()
+	if last := query.Get("last"); last != "" {
+		i := sort.SearchStrings(list.Tags, last)
+		if i < len(list.Tags) && list.Tags[i] == last {
+			i++
+		}
+		list.Tags = list.Tags[i:]
+	}
+	if query.Has("n") {
+		n, err := strconv.Atoi(query.Get("n"))
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodePaginationNumberInvalid, "invalid number of results requested", query.Get("n"))
+			return
+		}
+		if n < len(list.Tags) {
+			list.Tags = list.Tags[:n]
+			if n > 0 {
+				next := url.Values{"n": {strconv.Itoa(n)}, "last": {list.Tags[n-1]}}
+				w.Header().Set("Link", fmt.Sprintf("</v2/%s/tags/list?%s>; rel=\"next\"", list.Name, next.Encode()))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
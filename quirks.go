@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var registryProfileName = flag.String("registry-profile", "", "emulate the quirks of a hosted registry for client compatibility tests: ecr, gcr (also Artifact Registry), ghcr, harbor or dockerhub (none when empty)")
+
+// registryProfile describes how a hosted registry differs from the
+// distribution spec as served here. The profiles approximate the behavior
+// clients are known to trip over rather than reproduce the registries.
+type registryProfile struct {
+	// missing are the endpoints answered with 404 as not implemented.
+	missing []string
+	// service is the service parameter of authentication challenges.
+	service string
+	// scope adds the scope a request needs to its challenges.
+	scope bool
+	// hideMissing answers 404s for repositories and manifests with 401,
+	// so anonymous clients cannot tell missing from private repositories.
+	hideMissing bool
+	// headers are added to every response, and dropped removed from them.
+	headers map[string]string
+	dropped []string
+}
+
+var registryProfiles = map[string]registryProfile{
+	"ecr": {
+		missing: []string{"catalog", "referrers"},
+		service: "ecr.amazonaws.com",
+		headers: map[string]string{"Docker-Distribution-Api-Version": "registry/2.0"},
+		dropped: []string{"OCI-Subject"},
+	},
+	"gcr": {
+		missing: []string{"referrers"},
+		service: "gcr.io",
+		scope:   true,
+		headers: map[string]string{"Docker-Distribution-Api-Version": "registry/2.0"},
+		dropped: []string{"OCI-Subject"},
+	},
+	"ghcr": {
+		missing:     []string{"catalog"},
+		service:     "ghcr.io",
+		scope:       true,
+		hideMissing: true,
+		headers:     map[string]string{"Docker-Distribution-Api-Version": "registry/2.0"},
+	},
+	"harbor": {
+		service: "harbor-registry",
+		scope:   true,
+		headers: map[string]string{"Docker-Distribution-Api-Version": "registry/2.0"},
+	},
+	"dockerhub": {
+		missing:     []string{"catalog", "referrers"},
+		service:     "registry.docker.io",
+		scope:       true,
+		hideMissing: true,
+		headers: map[string]string{
+			"Docker-Distribution-Api-Version": "registry/2.0",
+			"Ratelimit-Limit":                 "100;w=21600",
+			"Ratelimit-Remaining":             "100;w=21600",
+		},
+		dropped: []string{"OCI-Subject"},
+	},
+}
+
+// activeProfile is the -registry-profile; nil when none is emulated.
+var activeProfile *registryProfile
+
+// initRegistryProfile looks up the -registry-profile.
+func initRegistryProfile() error {
+	if *registryProfileName == "" {
+		return nil
+	}
+
+	profile, ok := registryProfiles[*registryProfileName]
+	if !ok {
+		names := make([]string, 0, len(registryProfiles))
+		for name := range registryProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown -registry-profile %q: expected one of %s", *registryProfileName, strings.Join(names, ", "))
+	}
+
+	activeProfile = &profile
+	return nil
+}
+
+// registryProfileMiddleware applies the quirks of the -registry-profile.
+func registryProfileMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range activeProfile.headers {
+			w.Header().Set(name, value)
+		}
+
+		endpoint, _, ok := requestEndpoint(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, missing := range activeProfile.missing {
+			if endpoint == missing {
+				writeError(w, http.StatusNotFound, ErrCodeUnsupported, "the requested endpoint is not supported", nil)
+				return
+			}
+		}
+
+		next.ServeHTTP(&profileWriter{ResponseWriter: w, r: r, endpoint: endpoint}, r)
+	})
+}
+
+// profileWriter rewrites the challenges and 404s of a response.
+type profileWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	endpoint string
+	// discard drops the original body of a replaced response.
+	discard bool
+}
+
+func (w *profileWriter) WriteHeader(status int) {
+	switch {
+	case status == http.StatusUnauthorized:
+		w.rewriteChallenge("")
+	case status == http.StatusNotFound && activeProfile.hideMissing && (w.endpoint == "manifest" || w.endpoint == "tags"):
+		w.discard = true
+		w.Header().Del("Content-Length")
+		if w.Header().Get("WWW-Authenticate") == "" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", baseURL(w.r)+"/token"))
+		}
+		w.rewriteChallenge("insufficient_scope")
+		writeError(w.ResponseWriter, http.StatusUnauthorized, ErrCodeUnauthorized, "authentication required", nil)
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *profileWriter) Write(p []byte) (int, error) {
+	if w.discard {
+		return len(p), nil
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// rewriteChallenge gives the WWW-Authenticate header the service and, for
+// profiles that include it, the scope parameters of the profile.
+func (w *profileWriter) rewriteChallenge(bearerErr string) {
+	header := w.Header().Get("WWW-Authenticate")
+	if header == "" {
+		return
+	}
+
+	scheme, params := parseChallenge(header)
+	challenge := fmt.Sprintf("%s realm=%q,service=%q", scheme, params["realm"], activeProfile.service)
+	scope := params["scope"]
+	if scope == "" {
+		scope = requestScope(w.r)
+	}
+	if activeProfile.scope && scope != "" {
+		challenge += fmt.Sprintf(",scope=%q", scope)
+	}
+	if bearerErr == "" {
+		bearerErr = params["error"]
+	}
+	if bearerErr != "" && scheme == "Bearer" {
+		challenge += fmt.Sprintf(",error=%q", bearerErr)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+func (w *profileWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
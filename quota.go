@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var quotaBytesFlags, quotaManifestsFlags stringList
+
+func init() {
+	flag.Var(&quotaBytesFlags, "quota-bytes", "maximum size in bytes of the manifests and blobs pushed to a repository, as [pattern=]bytes; applies to all repositories without a pattern, and 0 lifts the quota (repeatable, later flags override earlier ones)")
+	flag.Var(&quotaManifestsFlags, "quota-manifests", "maximum number of manifests pushed to a repository, as [pattern=]count; applies to all repositories without a pattern, and 0 lifts the quota (repeatable, later flags override earlier ones)")
+}
+
+type quotaRule struct {
+	pattern string
+	limit   int64
+}
+
+var quotaBytesRules, quotaManifestsRules []quotaRule
+
+// initQuotas parses the -quota-bytes and -quota-manifests flags.
+func initQuotas() error {
+	var err error
+	if quotaBytesRules, err = parseQuotaRules(quotaBytesFlags); err != nil {
+		return err
+	}
+	quotaManifestsRules, err = parseQuotaRules(quotaManifestsFlags)
+	return err
+}
+
+// parseQuotaRules parses [pattern=]limit quotas.
+func parseQuotaRules(flags stringList) ([]quotaRule, error) {
+	var rules []quotaRule
+	for _, f := range flags {
+		pattern, value, ok := strings.Cut(f, "=")
+		if !ok {
+			pattern, value = "", f
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid quota %q: %w", f, err)
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid quota %q: expected [pattern=]limit", f)
+		}
+
+		rules = append(rules, quotaRule{pattern: pattern, limit: limit})
+	}
+
+	return rules, nil
+}
+
+// quotaFor returns the limit rules set for name, 0 when there is none.
+func quotaFor(rules []quotaRule, name string) int64 {
+	var limit int64
+	for _, rule := range rules {
+		if rule.pattern != "" {
+			if ok, _ := path.Match(rule.pattern, name); !ok {
+				continue
+			}
+		}
+		limit = rule.limit
+	}
+
+	return limit
+}
+
+// RepositoryUsage is the storage a repository uses against its quotas.
+type RepositoryUsage struct {
+	Bytes          int64 `json:"bytes"`
+	Manifests      int64 `json:"manifests"`
+	QuotaBytes     int64 `json:"quotaBytes,omitempty"`
+	QuotaManifests int64 `json:"quotaManifests,omitempty"`
+}
+
+// repositoryUsage sums the pushed manifests of name and the blobs they
+// reference, each counted once, with manifest added if not nil. The caller
+// must hold the pushed lock.
+func repositoryUsage(name string, manifest *Manifest) RepositoryUsage {
+	usage := RepositoryUsage{QuotaBytes: quotaFor(quotaBytesRules, name), QuotaManifests: quotaFor(quotaManifestsRules, name)}
+	seen := make(map[string]bool)
+	add := func(m *Manifest) {
+		if seen[m.digest] {
+			return
+		}
+		seen[m.digest] = true
+		usage.Manifests++
+		usage.Bytes += int64(len(m.content))
+
+		if !seen[m.Config.Digest] && m.Config.Digest != "" {
+			seen[m.Config.Digest] = true
+			usage.Bytes += int64(m.Config.Size)
+		}
+		for _, l := range m.Layers {
+			if !seen[l.Digest] {
+				seen[l.Digest] = true
+				usage.Bytes += int64(l.Size)
+			}
+		}
+	}
+
+	for key, m := range pushed.byRef {
+		if n, _, _ := strings.Cut(key, ":"); n == name {
+			add(m)
+		}
+	}
+	if manifest != nil {
+		add(manifest)
+	}
+
+	return usage
+}
+
+// checkQuota writes an error and returns false when storing size more bytes
+// and usage would exceed the quotas of name: 413 for bytes and 429 for
+// manifests.
+func checkQuota(w http.ResponseWriter, name string, usage RepositoryUsage, size int64) bool {
+	switch {
+	case usage.QuotaBytes > 0 && usage.Bytes+size > usage.QuotaBytes:
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodeDenied, "repository storage quota exceeded", map[string]interface{}{"repository": name, "quotaBytes": usage.QuotaBytes, "bytes": usage.Bytes + size})
+		return false
+	case usage.QuotaManifests > 0 && usage.Manifests > usage.QuotaManifests:
+		writeError(w, http.StatusTooManyRequests, ErrCodeDenied, "repository manifest quota exceeded", map[string]interface{}{"repository": name, "quotaManifests": usage.QuotaManifests, "manifests": usage.Manifests})
+		return false
+	}
+
+	return true
+}
+
+// checkUploadQuota writes an error and returns false when an upload of size
+// bytes to name would exceed its storage quota.
+func checkUploadQuota(w http.ResponseWriter, name string, size int) bool {
+	if quotaFor(quotaBytesRules, name) == 0 {
+		return true
+	}
+
+	pushed.RLock()
+	usage := repositoryUsage(name, nil)
+	pushed.RUnlock()
+
+	return checkQuota(w, name, usage, int64(size))
+}
+
+// registerQuotaRoutes adds the usage of repositories to the admin API.
+func registerQuotaRoutes(admin *mux.Router) {
+	admin.HandleFunc("/repositories/{name:.+}/usage", handleAdminRepositoryUsage).Methods("GET")
+}
+
+func handleAdminRepositoryUsage(w http.ResponseWriter, r *http.Request) {
+	pushed.RLock()
+	defer pushed.RUnlock()
+
+	writeAdminJSON(w, repositoryUsage(mux.Vars(r)["name"], nil))
+}
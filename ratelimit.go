@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	rateLimit = flag.Float64("rate-limit", 0, "per-client request rate limit in requests per second, counting the requests of each authenticated user together and the others, as well as failed authentications, by address (disabled when 0)")
+	rateBurst = flag.Int("rate-burst", 20, "per-client burst size allowed above the rate limit")
+)
+
+// Limiters idle for longer than this are forgotten.
+const rateLimiterTTL = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter applies a token bucket per client.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[string]*clientLimiter
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		limit:   rate.Limit(rps),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+	}
+
+	go l.sweep()
+	return l
+}
+
+func (l *rateLimiter) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+func (l *rateLimiter) sweep() {
+	for range time.Tick(rateLimiterTTL) {
+		l.mu.Lock()
+		for key, c := range l.clients {
+			if time.Since(c.lastSeen) > rateLimiterTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservation := l.get(rateLimitKey(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			writeTooManyRequests(w, delay)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authFailureMiddleware limits the requests failing authentication by
+// address. It runs before authentication, which middleware cannot limit by
+// user yet, so that credentials cannot be guessed faster than the rate
+// limit; only the 401 responses are counted.
+func (l *rateLimiter) authFailureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := l.get("ip:" + clientIP(r))
+		if tokens := limiter.Tokens(); tokens < 1 {
+			writeTooManyRequests(w, time.Duration((1-tokens)/float64(l.limit)*float64(time.Second)))
+			return
+		}
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusUnauthorized {
+			limiter.Allow()
+		}
+	})
+}
+
+// writeTooManyRequests rejects a request over the rate limit, telling the
+// client to retry after delay.
+func writeTooManyRequests(w http.ResponseWriter, delay time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, ErrCodeTooManyRequests, "too many requests", nil)
+}
+
+// rateLimitKey identifies the client a request is accounted to: its
+// authenticated user or, for anonymous requests and identities without a
+// name, its address.
+func rateLimitKey(r *http.Request) string {
+	if id, ok := identity(r.Context()); ok && id.Name != "" {
+		return "user:" + id.Name
+	}
+
+	return "ip:" + clientIP(r)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+)
+
+var readOnly = flag.Bool("read-only", false, "reject every POST, PUT, PATCH and DELETE request with 405 while still serving generated and stored content; the token service and admin API stay available")
+
+// readOnlyMiddleware rejects requests that would change the registry.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if r.URL.Path != "/token" && !strings.HasPrefix(r.URL.Path, "/admin/") {
+				w.Header().Set("Allow", "GET, HEAD")
+				writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "registry is read-only", nil)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	recordFile = flag.String("record", "", "file every request and its response are appended to as JSON lines, for -replay (disabled when empty)")
+	replayFile = flag.String("replay", "", "file of -record recordings to answer requests from instead of generating charts; requests are matched by method, path and query, repeated requests get the recorded responses in order and then the last one again")
+)
+
+// Recording is a request and the response it got, one per line of a
+// -record file. Authorization headers are not recorded.
+type Recording struct {
+	Method        string      `json:"method"`
+	URI           string      `json:"uri"`
+	RequestHeader http.Header `json:"requestHeader,omitempty"`
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+}
+
+// recordedRequestHeaders are the request headers kept in recordings.
+var recordedRequestHeaders = []string{"Accept", "Content-Type", "Content-Length", "Range", "User-Agent"}
+
+// openRecording opens the -record file and returns the middleware writing
+// to it; it is nil when recording is disabled.
+func openRecording() (Middleware, error) {
+	if *recordFile == "" {
+		return nil, nil
+	}
+	if *replayFile != "" {
+		return nil, errors.New("-record and -replay cannot be combined")
+	}
+
+	f, err := os.OpenFile(*recordFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(f)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &recordingWriter{responseRecorder: newResponseRecorder(w)}
+			next.ServeHTTP(rec, r)
+
+			recording := Recording{
+				Method:        r.Method,
+				URI:           r.URL.RequestURI(),
+				RequestHeader: http.Header{},
+				Status:        rec.status,
+				Header:        w.Header().Clone(),
+				Body:          rec.body.Bytes(),
+			}
+			for _, name := range recordedRequestHeaders {
+				if values := r.Header.Values(name); len(values) > 0 {
+					recording.RequestHeader[name] = values
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err := enc.Encode(recording); err != nil {
+				logger(r.Context()).Error("recording request failed", "error", err)
+			}
+		})
+	}, nil
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	*responseRecorder
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.responseRecorder.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+// replayHandler answers requests with the recorded responses of -replay.
+type replayHandler struct {
+	mu sync.Mutex
+	// responses holds the recordings not yet replayed by method and URI.
+	responses map[string][]*Recording
+}
+
+// loadReplay reads the -replay recordings; it returns nil when replay is
+// disabled.
+func loadReplay() (*replayHandler, error) {
+	if *replayFile == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(*replayFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &replayHandler{responses: make(map[string][]*Recording)}
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var recording Recording
+			if err := json.Unmarshal(data, &recording); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", *replayFile, line, err)
+			}
+			key := recording.Method + " " + recording.URI
+			h.responses[key] = append(h.responses[key], &recording)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+func (h *replayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.RequestURI()
+
+	h.mu.Lock()
+	queue := h.responses[key]
+	var recording *Recording
+	if len(queue) > 0 {
+		recording = queue[0]
+		if len(queue) > 1 {
+			h.responses[key] = queue[1:]
+		}
+	}
+	h.mu.Unlock()
+
+	if recording == nil {
+		writeError(w, http.StatusNotFound, ErrCodeUnknown, "no recorded response for the request", map[string]string{"method": r.Method, "uri": r.URL.RequestURI()})
+		return
+	}
+
+	for name, values := range recording.Header {
+		if name != requestIDHeader {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(recording.Status)
+	if r.Method != http.MethodHead {
+		w.Write(recording.Body)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const imageIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// emptyConfig is the config descriptor of artifacts that have no config.
+var emptyConfig = Config{
+	MediaType: "application/vnd.oci.empty.v1+json",
+	Digest:    "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+	Size:      2,
+}
+
+// referrers holds the artifacts attached to generated manifests, such as
+// signatures, keyed by repository and subject digest.
+var referrers = struct {
+	sync.RWMutex
+	bySubject map[string][]*Manifest
+}{bySubject: make(map[string][]*Manifest)}
+
+func referrerKey(name string, digest string) string {
+	return name + "@" + digest
+}
+
+// addReferrer completes artifact as a manifest referring to subject and
+// stores it, replacing any earlier artifact of the same type for subject.
+func addReferrer(name string, subject *Manifest, artifact *Manifest) error {
+	if artifact.Config.Digest == emptyConfig.Digest {
+		if err := store.Put(emptyConfig.Digest, []byte("{}")); err != nil {
+			return err
+		}
+	}
+
+	artifact.SchemaVersion = 2
+	artifact.MediaType = manifestMediaType
+	artifact.Subject = &Layer{
+		MediaType: subject.MediaType,
+		Digest:    subject.digest,
+		Size:      len(subject.content),
+	}
+	if err := artifact.encode(); err != nil {
+		return err
+	}
+
+	storeReferrer(name, artifact)
+	return nil
+}
+
+// storeReferrer stores an encoded artifact under its subject, replacing any
+// earlier artifact of the same type for that subject.
+func storeReferrer(name string, artifact *Manifest) {
+	key := referrerKey(name, artifact.Subject.Digest)
+
+	referrers.Lock()
+	defer referrers.Unlock()
+
+	list := referrers.bySubject[key][:0:0]
+	for _, m := range referrers.bySubject[key] {
+		if m.ArtifactType != artifact.ArtifactType {
+			list = append(list, m)
+		}
+	}
+	referrers.bySubject[key] = append(list, artifact)
+}
+
+// addPushedReferrer lists a pushed manifest with a subject among the
+// referrers of the subject. Unlike generated artifacts, pushed ones of the
+// same type do not replace each other.
+func addPushedReferrer(name string, artifact *Manifest) {
+	key := referrerKey(name, artifact.Subject.Digest)
+
+	referrers.Lock()
+	defer referrers.Unlock()
+
+	for _, m := range referrers.bySubject[key] {
+		if m.digest == artifact.digest {
+			return
+		}
+	}
+	referrers.bySubject[key] = append(referrers.bySubject[key], artifact)
+}
+
+// removePushedReferrer drops a deleted manifest from the referrers of its
+// subject.
+func removePushedReferrer(name string, artifact *Manifest) {
+	key := referrerKey(name, artifact.Subject.Digest)
+
+	referrers.Lock()
+	defer referrers.Unlock()
+
+	list := referrers.bySubject[key][:0:0]
+	for _, m := range referrers.bySubject[key] {
+		if m.digest != artifact.digest {
+			list = append(list, m)
+		}
+	}
+	referrers.bySubject[key] = list
+}
+
+// referrerOf returns the artifact of the given type attached to the manifest
+// with digest subject.
+func referrerOf(name string, subject string, artifactType string) (*Manifest, bool) {
+	referrers.RLock()
+	defer referrers.RUnlock()
+
+	for _, m := range referrers.bySubject[referrerKey(name, subject)] {
+		if m.ArtifactType == artifactType {
+			return m, true
+		}
+	}
+
+	return nil, false
+}
+
+// referrerManifest returns the stored artifact of name that reference
+// addresses, either by digest or, for cosign signatures, by tag.
+func referrerManifest(name string, reference string) (*Manifest, bool) {
+	if m, ok := cosignSignature(name, reference); ok {
+		return m, true
+	}
+
+	referrers.RLock()
+	defer referrers.RUnlock()
+
+	for key, list := range referrers.bySubject {
+		if !strings.HasPrefix(key, name+"@") {
+			continue
+		}
+		for _, artifact := range list {
+			if artifact.digest == reference {
+				return artifact, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// removeReferrers drops every artifact attached to manifests of name and
+// returns them.
+func removeReferrers(name string) []*Manifest {
+	referrers.Lock()
+	defer referrers.Unlock()
+
+	var removed []*Manifest
+	for key, list := range referrers.bySubject {
+		if strings.HasPrefix(key, name+"@") {
+			removed = append(removed, list...)
+			delete(referrers.bySubject, key)
+		}
+	}
+
+	return removed
+}
+
+// Index is an OCI image index, as returned by the referrers API.
+type Index struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	MediaType     string  `json:"mediaType"`
+	Manifests     []Layer `json:"manifests"`
+}
+
+// handleGetReferrers implements the OCI referrers API, listing the artifacts
+// attached to a manifest.
+func handleGetReferrers(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
+		return
+	}
+
+	index := Index{SchemaVersion: 2, MediaType: imageIndexMediaType, Manifests: []Layer{}}
+	artifactType := r.URL.Query().Get("artifactType")
+
+	referrers.RLock()
+	for _, m := range referrers.bySubject[referrerKey(name, mux.Vars(r)["digest"])] {
+		// Without an artifactType, the config media type is the type.
+		mType := m.ArtifactType
+		if mType == "" {
+			mType = m.Config.MediaType
+		}
+		if artifactType != "" && artifactType != mType {
+			continue
+		}
+		index.Manifests = append(index.Manifests, Layer{
+			MediaType:    m.MediaType,
+			ArtifactType: mType,
+			Digest:       m.digest,
+			Size:         len(m.content),
+			Annotations:  m.Annotations,
+		})
+	}
+	referrers.RUnlock()
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+
+	w.Header().Set("Content-Type", imageIndexMediaType)
+	json.NewEncoder(w).Encode(index)
+}
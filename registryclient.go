@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// errUpstreamNotFound is returned when an upstream registry has no such
+// manifest or blob.
+var errUpstreamNotFound = errors.New("not found upstream")
+
+// registryClient talks the OCI distribution API to another registry,
+// answering basic and bearer token challenges with its credentials.
+type registryClient struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+
+	mu sync.Mutex
+	// tokens holds bearer tokens by scope.
+	tokens map[string]string
+}
+
+// newRegistryClient returns a client for the registry at rawURL, which may
+// omit the scheme for https. The password is read from passwordFile unless
+// it is empty.
+func newRegistryClient(rawURL string, username string, passwordFile string) (*registryClient, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry URL %q: %w", rawURL, err)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	c := &registryClient{base: base, username: username, client: &http.Client{}, tokens: make(map[string]string)}
+	if passwordFile != "" {
+		password, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		c.password = strings.TrimSpace(string(password))
+	}
+
+	return c, nil
+}
+
+// host is the registry host, as used in image references.
+func (c *registryClient) host() string {
+	return c.base.Host
+}
+
+// do sends a request for a /v2/ path of the registry, authenticating for
+// scope when challenged. body is resent after a challenge, so it is passed
+// as bytes.
+func (c *registryClient) do(ctx context.Context, method string, path string, header http.Header, body []byte, scope string) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		target := path
+		if !strings.Contains(path, "://") {
+			target = c.base.String() + path
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, r)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		c.mu.Lock()
+		token := c.tokens[scope]
+		c.mu.Unlock()
+		switch {
+		case token != "":
+			req.Header.Set("Authorization", "Bearer "+token)
+		case c.username != "":
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		return c.client.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") {
+		return nil, fmt.Errorf("%s %s: unauthorized", method, path)
+	}
+
+	token, err := c.fetchToken(ctx, params, scope)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+
+	return send()
+}
+
+// fetchToken requests a bearer token for scope from the realm of a
+// challenge.
+func (c *registryClient) fetchToken(ctx context.Context, params map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: %s", realm.Host, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+
+	return "", errors.New("token response holds no token")
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and
+// parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+
+	return scheme, params
+}
+
+func pullScope(name string) string {
+	return "repository:" + name + ":pull"
+}
+
+func pushScope(name string) string {
+	return "repository:" + name + ":pull,push"
+}
+
+// upstreamError describes a failed response.
+func upstreamError(resp *http.Response, what string) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s: upstream returned %s: %s", what, resp.Status, bytes.TrimSpace(msg))
+}
+
+// getManifest fetches the image manifest name:reference refers to.
+func (c *registryClient) getManifest(ctx context.Context, name string, reference string) ([]byte, error) {
+	header := http.Header{"Accept": {manifestMediaType + ", " + dockerManifestMediaType}}
+	resp, err := c.do(ctx, http.MethodGet, "/v2/"+name+"/manifests/"+reference, header, nil, pullScope(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errUpstreamNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamError(resp, "fetching manifest "+name+":"+reference)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, *maxBodySize))
+}
+
+// listTags lists the tags of name, following the Link headers of paginated
+// responses.
+func (c *registryClient) listTags(ctx context.Context, name string) ([]string, error) {
+	var tags []string
+	next := "/v2/" + name + "/tags/list"
+	for next != "" {
+		resp, err := c.do(ctx, http.MethodGet, next, nil, nil, pullScope(name))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, errUpstreamNotFound
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, upstreamError(resp, "listing tags of "+name)
+		}
+
+		var list TagList
+		err = json.NewDecoder(io.LimitReader(resp.Body, *maxBodySize)).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s: %w", name, err)
+		}
+		tags = append(tags, list.Tags...)
+
+		// The next page is given as </v2/...?last=...>; rel="next".
+		next = ""
+		if link, _, ok := strings.Cut(resp.Header.Get("Link"), ";"); ok {
+			next = strings.Trim(strings.TrimSpace(link), "<>")
+		}
+	}
+
+	return tags, nil
+}
+
+// getBlob fetches a blob and verifies its digest.
+func (c *registryClient) getBlob(ctx context.Context, name string, digest string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v2/"+name+"/blobs/"+digest, nil, nil, pullScope(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errUpstreamNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamError(resp, "fetching blob "+digest)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, *maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("sha256:%x", sha256.Sum256(data)) != digest {
+		return nil, fmt.Errorf("blob %s from upstream does not match its digest", digest)
+	}
+
+	return data, nil
+}
+
+// pushBlob uploads a blob unless the registry already has it.
+func (c *registryClient) pushBlob(ctx context.Context, name string, digest string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodHead, "/v2/"+name+"/blobs/"+digest, nil, nil, pushScope(name))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = c.do(ctx, http.MethodPost, "/v2/"+name+"/blobs/uploads/", nil, nil, pushScope(name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return upstreamError(resp, "starting upload of "+digest)
+	}
+
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = c.do(ctx, http.MethodPut, location.String(), header, data, pushScope(name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return upstreamError(resp, "uploading "+digest)
+	}
+
+	return nil
+}
+
+// pushManifest uploads manifest as name:reference.
+func (c *registryClient) pushManifest(ctx context.Context, name string, reference string, manifest *Manifest) error {
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = manifestMediaType
+	}
+
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := c.do(ctx, http.MethodPut, "/v2/"+name+"/manifests/"+reference, header, manifest.content, pushScope(name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return upstreamError(resp, "pushing manifest "+name+":"+reference)
+	}
+
+	return nil
+}
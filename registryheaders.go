@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var registryHeadersFlag = flag.String("registry-headers", "", "comma-separated registry identification headers to add to responses, as +name, or drop from them, as -name, overriding the -registry-profile; some clients branch on their presence")
+
+// standardHeaders are the headers -registry-headers toggles, by canonical
+// name, with the value +name adds. Headers without a value describe a
+// particular response, so +name only keeps them from being dropped.
+var standardHeaders = map[string]string{
+	"Docker-Distribution-Api-Version": "registry/2.0",
+	"Docker-Content-Digest":           "",
+	"Oci-Subject":                     "",
+	"Oci-Filters-Applied":             "",
+	"Oci-Chunk-Min-Length":            "",
+	"Oci-Chunk-Max-Length":            "",
+}
+
+// addedHeaders and droppedHeaders are the headers added to and dropped
+// from every response.
+var (
+	addedHeaders   = make(map[string]string)
+	droppedHeaders = make(map[string]bool)
+)
+
+// initRegistryHeaders combines the headers the -registry-profile drops with
+// the -registry-headers. It must run after initRegistryProfile.
+func initRegistryHeaders() error {
+	if activeProfile != nil {
+		for _, name := range activeProfile.dropped {
+			droppedHeaders[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	if *registryHeadersFlag == "" {
+		return nil
+	}
+
+	for _, f := range strings.Split(*registryHeadersFlag, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if f[0] != '+' && f[0] != '-' {
+			return fmt.Errorf("invalid -registry-headers %q: expected +name or -name, got %q", *registryHeadersFlag, f)
+		}
+		name := http.CanonicalHeaderKey(f[1:])
+		value, ok := standardHeaders[name]
+		if !ok {
+			return fmt.Errorf("invalid -registry-headers %q: unknown header %q, expected one of %s", *registryHeadersFlag, f[1:], strings.Join(standardHeaderNames(), ", "))
+		}
+
+		if f[0] == '-' {
+			droppedHeaders[name] = true
+			delete(addedHeaders, name)
+			continue
+		}
+		if value != "" {
+			addedHeaders[name] = value
+		}
+		delete(droppedHeaders, name)
+	}
+
+	return nil
+}
+
+func standardHeaderNames() []string {
+	names := make([]string, 0, len(standardHeaders))
+	for name := range standardHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// registryHeadersEnabled reports whether responses have headers added or
+// dropped.
+func registryHeadersEnabled() bool {
+	return len(addedHeaders) > 0 || len(droppedHeaders) > 0
+}
+
+// registryHeadersMiddleware adds the addedHeaders to responses and drops the
+// droppedHeaders from them, however the handlers set them.
+func registryHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range addedHeaders {
+			w.Header().Set(name, value)
+		}
+
+		next.ServeHTTP(&registryHeadersWriter{ResponseWriter: w}, r)
+	})
+}
+
+// registryHeadersWriter drops the droppedHeaders when the response header is
+// written.
+type registryHeadersWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *registryHeadersWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		for name := range droppedHeaders {
+			w.Header().Del(name)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *registryHeadersWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *registryHeadersWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var configWatch = flag.Bool("config-watch", false, "reload when the -config file changes, as on SIGHUP")
+
+// reloadMu guards the options replaced by a reload against the requests
+// reading them.
+var reloadMu sync.RWMutex
+
+// reloadableOptions are the options a reload applies. Changes to any other
+// option take effect on the next restart.
+var reloadableOptions = []string{"generator", "htpasswd", "policy", "tls-cert", "tls-key", "tls-client-identity"}
+
+// startReloader reloads the configuration on SIGHUP and, with -config-watch,
+// when the config file changes, until ctx is done.
+func startReloader(ctx context.Context) error {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	if *configWatch {
+		if *configFile == "" {
+			return errors.New("-config-watch requires -config")
+		}
+		if err := watchConfigFile(ctx, reload); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		defer signal.Stop(reload)
+		for {
+			select {
+			case <-reload:
+				if err := reloadConfig(); err != nil {
+					slog.Error("reloading configuration failed; keeping the current one", "error", err)
+					continue
+				}
+				slog.Info("reloaded configuration")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchConfigFile sends to reload when the config file is written or
+// replaced. The directory is watched since editors and ConfigMap mounts
+// replace files rather than writing them.
+func watchConfigFile(ctx context.Context, reload chan<- os.Signal) error {
+	file, err := filepath.Abs(*configFile)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// Writes come in bursts, so reload once they settle rather than read a
+	// half-written file.
+	settled := time.AfterFunc(time.Hour, func() {
+		select {
+		case reload <- syscall.SIGHUP:
+		default:
+		}
+	})
+	settled.Stop()
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Name != file && filepath.Base(event.Name) != "..data" {
+					continue
+				}
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+					settled.Reset(100 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("config file watch error", "error", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reloadConfig reads the reloadable options again from the command line,
+// config file and environment, then replaces the credentials, policy, TLS
+// certificate and generator routes they name. Nothing is replaced unless
+// all of them load.
+func reloadConfig() error {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	for _, name := range reloadableOptions {
+		f := flag.Lookup(name)
+		if list, ok := f.Value.(*stringList); ok {
+			var values stringList
+			if commandLineFlags[name] {
+				values = append(values, *list...)
+			}
+			fs.Var(&values, name, f.Usage)
+			continue
+		}
+
+		value := f.DefValue
+		if commandLineFlags[name] {
+			value = f.Value.String()
+		}
+		fs.String(name, value, f.Usage)
+	}
+
+	fromFile, err := loadConfigFile(fs, true)
+	if err != nil {
+		return err
+	}
+	if err := loadEnv(fs, fromFile); err != nil {
+		return err
+	}
+	option := func(name string) flag.Value {
+		return fs.Lookup(name).Value
+	}
+
+	routes, err := parseGeneratorRoutes(append(proxyRoutes, *option("generator").(*stringList)...))
+	if err != nil {
+		return err
+	}
+
+	users := htpasswd
+	if file := option("htpasswd").String(); (file != "") != (htpasswd != nil) {
+		slog.Warn("enabling or disabling -htpasswd requires a restart")
+	} else if file != "" {
+		if users, err = loadHtpasswd(file); err != nil {
+			return err
+		}
+	}
+
+	rules := policy
+	if file := option("policy").String(); (file != "") != (policy != nil) {
+		slog.Warn("enabling or disabling -policy requires a restart")
+	} else if file != "" {
+		if rules, err = loadPolicy(file); err != nil {
+			return err
+		}
+	}
+
+	var cert *tls.Certificate
+	if certFile := option("tls-cert").String(); (certFile != "") != (serverCert.Load() != nil) {
+		slog.Warn("enabling or disabling -tls-cert requires a restart")
+	} else if certFile != "" {
+		loaded, err := tls.LoadX509KeyPair(certFile, option("tls-key").String())
+		if err != nil {
+			return fmt.Errorf("loading -tls-cert: %w", err)
+		}
+		cert = &loaded
+	}
+
+	identities, err := parseClientCertIdentities(*option("tls-client-identity").(*stringList))
+	if err != nil {
+		return err
+	}
+
+	reloadMu.Lock()
+	generatorRoutes = routes
+	htpasswd = users
+	policy = rules
+	clientCertIdentities = identities
+	reloadMu.Unlock()
+	if cert != nil {
+		serverCert.Store(cert)
+	}
+
+	return nil
+}
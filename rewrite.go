@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var rewriteFlags stringList
+
+func init() {
+	flag.Var(&rewriteFlags, "rewrite-name", "rewrite repository names before they are looked up, as from=to; each * in from matches one path segment and fills the * at the same position in to, e.g. *=library/* or library/*=* (repeatable, first match wins)")
+}
+
+// nameRewrite maps repository names matching from to the to template.
+type nameRewrite struct {
+	from *regexp.Regexp
+	to   []string
+}
+
+var nameRewrites []nameRewrite
+
+// initNameRewrites parses the -rewrite-name flags.
+func initNameRewrites() error {
+	for _, f := range rewriteFlags {
+		from, to, ok := strings.Cut(f, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid name rewrite %q: expected from=to", f)
+		}
+		if strings.Count(from, "*") != strings.Count(to, "*") {
+			return fmt.Errorf("invalid name rewrite %q: from and to must have the same number of *", f)
+		}
+
+		parts := strings.Split(from, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		nameRewrites = append(nameRewrites, nameRewrite{
+			from: regexp.MustCompile("^" + strings.Join(parts, "([^/]+)") + "$"),
+			to:   strings.Split(to, "*"),
+		})
+	}
+
+	return nil
+}
+
+// rewriteName applies the first -rewrite-name rule matching name.
+func rewriteName(name string) string {
+	for _, rewrite := range nameRewrites {
+		match := rewrite.from.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		var b strings.Builder
+		for i, part := range rewrite.to {
+			if i > 0 {
+				b.WriteString(match[i])
+			}
+			b.WriteString(part)
+		}
+		return b.String()
+	}
+
+	return name
+}
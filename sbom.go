@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var sbomFormat = flag.String("sbom", "", "attach an SBOM of the files in every generated chart as a referrer: spdx or cyclonedx (disabled when empty)")
+
+const (
+	spdxMediaType      = "application/spdx+json"
+	cycloneDXMediaType = "application/vnd.cyclonedx+json"
+)
+
+// initSBOM validates -sbom.
+func initSBOM() error {
+	switch *sbomFormat {
+	case "", "spdx", "cyclonedx":
+		return nil
+	default:
+		return fmt.Errorf("invalid -sbom %q: expected spdx or cyclonedx", *sbomFormat)
+	}
+}
+
+// attachSBOM generates the -sbom document describing out and attaches it to
+// manifest as a referrer.
+func attachSBOM(name string, manifest *Manifest, out *GeneratedChart, created time.Time) error {
+	var (
+		doc       interface{}
+		mediaType string
+	)
+	switch *sbomFormat {
+	case "spdx":
+		doc, mediaType = spdxDocument(name, manifest, out, created), spdxMediaType
+	case "cyclonedx":
+		doc, mediaType = cycloneDXDocument(manifest, out, created), cycloneDXMediaType
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if err := store.Put(digest, data); err != nil {
+		return err
+	}
+
+	return addReferrer(name, manifest, &Manifest{
+		ArtifactType: mediaType,
+		Config:       emptyConfig,
+		Layers:       []Layer{{MediaType: mediaType, Digest: digest, Size: len(data)}},
+		Annotations: map[string]string{
+			"org.opencontainers.image.created": created.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxFile struct {
+	FileName  string         `json:"fileName"`
+	SPDXID    string         `json:"SPDXID"`
+	Checksums []spdxChecksum `json:"checksums"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+// spdxDocument describes the chart as an SPDX 2.3 package containing its
+// files.
+func spdxDocument(name string, manifest *Manifest, out *GeneratedChart, created time.Time) map[string]interface{} {
+	const packageID = "SPDXRef-Package-chart"
+
+	var (
+		files         []spdxFile
+		relationships = []spdxRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", packageID}}
+		sha1s         []string
+	)
+	for i, f := range out.Files {
+		id := fmt.Sprintf("SPDXRef-File-%d", i)
+		sum1 := fmt.Sprintf("%x", sha1.Sum(f.Data))
+		sha1s = append(sha1s, sum1)
+		files = append(files, spdxFile{
+			FileName: "./" + f.Name,
+			SPDXID:   id,
+			Checksums: []spdxChecksum{
+				{"SHA1", sum1},
+				{"SHA256", fmt.Sprintf("%x", sha256.Sum256(f.Data))},
+			},
+		})
+		relationships = append(relationships, spdxRelationship{packageID, "CONTAINS", id})
+	}
+
+	// The verification code is the SHA1 of the sorted, concatenated SHA1s
+	// of the package's files.
+	sort.Strings(sha1s)
+	verificationCode := fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(sha1s, ""))))
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              out.Chart.Name + "-" + out.Chart.Version,
+		"documentNamespace": fmt.Sprintf("https://spdx.org/spdxdocs/virtual-helm/%s/%s", name, strings.TrimPrefix(manifest.digest, "sha256:")),
+		"creationInfo": map[string]interface{}{
+			"created":  created.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: virtual-helm-" + versionInfo().Version},
+		},
+		"packages": []map[string]interface{}{{
+			"name":             out.Chart.Name,
+			"SPDXID":           packageID,
+			"versionInfo":      out.Chart.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    true,
+			"packageVerificationCode": map[string]string{
+				"packageVerificationCodeValue": verificationCode,
+			},
+			"checksums": []spdxChecksum{{"SHA256", strings.TrimPrefix(manifest.Layers[0].Digest, "sha256:")}},
+		}},
+		"files":         files,
+		"relationships": relationships,
+	}
+}
+
+// cycloneDXDocument describes the chart as a CycloneDX 1.5 application
+// component made of file components.
+func cycloneDXDocument(manifest *Manifest, out *GeneratedChart, created time.Time) map[string]interface{} {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+
+	components := make([]map[string]interface{}, 0, len(out.Files))
+	for _, f := range out.Files {
+		components = append(components, map[string]interface{}{
+			"type":   "file",
+			"name":   f.Name,
+			"hashes": []hash{{"SHA-256", fmt.Sprintf("%x", sha256.Sum256(f.Data))}},
+		})
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(manifest.digest)).String(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": created.UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": "virtual-helm", "version": versionInfo().Version}},
+			},
+			"component": map[string]interface{}{
+				"type":    "application",
+				"name":    out.Chart.Name,
+				"version": out.Chart.Version,
+				"hashes":  []hash{{"SHA-256", strings.TrimPrefix(manifest.Layers[0].Digest, "sha256:")}},
+			},
+		},
+		"components": components,
+	}
+}
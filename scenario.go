@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"sigs.k8s.io/yaml"
+)
+
+var scenarioFile = flag.String("scenario", "", "YAML scenario of the requests clients are expected to send, in states of steps with scripted responses; unexpected requests fail with 500 and an error is logged, and the server exits with status 1 if any were received or the scenario did not reach a final state (disabled when empty)")
+
+// Scenario scripts the requests a client is expected to send:
+//
+//	start: login
+//	final: [done]
+//	states:
+//	  login:
+//	    - request: GET /v2/
+//	      response: {status: 401, headers: {WWW-Authenticate: Basic realm="test"}}
+//	      next: pull
+//	  pull:
+//	    - request: GET /v2/charts/*/manifests/*
+//	      times: 1
+//	      next: done
+//	  done: []
+//
+// Requests are matched against the steps of the current state in order;
+// steps without a response are served by the registry.
+type Scenario struct {
+	// Start is the initial state, start when empty.
+	Start string `json:"start"`
+	// Final are the states the scenario may end in; any state when empty.
+	Final  []string                  `json:"final"`
+	States map[string][]ScenarioStep `json:"states"`
+}
+
+// ScenarioStep is a request expected in a state.
+type ScenarioStep struct {
+	// Request is "method path", where either may be a pattern.
+	Request  string            `json:"request"`
+	Response *ScenarioResponse `json:"response,omitempty"`
+	// Times limits how many requests the step matches; 0 for no limit.
+	Times int `json:"times,omitempty"`
+	// Next is the state to move to after the step matched.
+	Next string `json:"next,omitempty"`
+
+	method, path string
+}
+
+// ScenarioResponse is a scripted response.
+type ScenarioResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// ScenarioStatus is the progress through the -scenario.
+type ScenarioStatus struct {
+	State      string   `json:"state"`
+	Complete   bool     `json:"complete"`
+	Unexpected []string `json:"unexpected"`
+}
+
+// scenario holds the -scenario and its progress; it is nil when disabled.
+var scenario *scenarioState
+
+type scenarioState struct {
+	Scenario
+
+	mu         sync.Mutex
+	state      string
+	matched    map[*ScenarioStep]int
+	unexpected []string
+}
+
+// initScenario loads the -scenario.
+func initScenario() error {
+	if *scenarioFile == "" {
+		return nil
+	}
+	if *replayFile != "" {
+		return errors.New("-scenario and -replay cannot be combined")
+	}
+
+	data, err := os.ReadFile(*scenarioFile)
+	if err != nil {
+		return err
+	}
+	s := &scenarioState{}
+	if err := yaml.UnmarshalStrict(data, &s.Scenario); err != nil {
+		return fmt.Errorf("invalid scenario %s: %w", *scenarioFile, err)
+	}
+	if s.Start == "" {
+		s.Start = "start"
+	}
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("invalid scenario %s: %w", *scenarioFile, err)
+	}
+	s.reset()
+
+	scenario = s
+	return nil
+}
+
+// validate checks the states steps move to exist and parses their requests.
+func (s *scenarioState) validate() error {
+	for _, name := range append([]string{s.Start}, s.Final...) {
+		if _, ok := s.States[name]; !ok {
+			return fmt.Errorf("unknown state %q", name)
+		}
+	}
+
+	for name, steps := range s.States {
+		for i := range steps {
+			step := &steps[i]
+			method, p, ok := strings.Cut(strings.TrimSpace(step.Request), " ")
+			p = strings.TrimSpace(p)
+			if !ok || p == "" {
+				return fmt.Errorf("state %s: invalid request %q: expected method path", name, step.Request)
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("state %s: invalid request %q: %w", name, step.Request, err)
+			}
+			step.method, step.path = strings.ToUpper(method), p
+
+			if _, ok := s.States[step.Next]; step.Next != "" && !ok {
+				return fmt.Errorf("state %s: unknown next state %q", name, step.Next)
+			}
+			if step.Response != nil && (step.Response.Status < 100 || step.Response.Status > 599) {
+				return fmt.Errorf("state %s: invalid response status %d", name, step.Response.Status)
+			}
+			if step.Times < 0 {
+				return fmt.Errorf("state %s: invalid times %d", name, step.Times)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *scenarioState) reset() {
+	s.state = s.Start
+	s.matched = make(map[*ScenarioStep]int)
+	s.unexpected = nil
+}
+
+// step moves the scenario on with r, returning the step it matched.
+func (s *scenarioState) step(r *http.Request) (*ScenarioStep, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.state
+	steps := s.States[state]
+	for i := range steps {
+		step := &steps[i]
+		if step.method != "*" && step.method != r.Method {
+			continue
+		}
+		if ok, _ := path.Match(step.path, r.URL.Path); !ok {
+			continue
+		}
+		if step.Times > 0 && s.matched[step] >= step.Times {
+			continue
+		}
+
+		s.matched[step]++
+		if step.Next != "" {
+			s.state = step.Next
+		}
+		return step, state, true
+	}
+
+	s.unexpected = append(s.unexpected, fmt.Sprintf("%s %s in state %s", r.Method, r.URL.RequestURI(), state))
+	return nil, state, false
+}
+
+func (s *scenarioState) status() ScenarioStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	complete := len(s.Final) == 0 || containsString(s.Final, s.state)
+	return ScenarioStatus{State: s.state, Complete: complete, Unexpected: append([]string{}, s.unexpected...)}
+}
+
+// scenarioMiddleware enforces the -scenario, answering requests with their
+// scripted responses and failing unexpected ones.
+func scenarioMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		step, state, ok := scenario.step(r)
+		if !ok {
+			logger(r.Context()).Error("unexpected request in scenario", "state", state)
+			writeError(w, http.StatusInternalServerError, ErrCodeUnknown, "unexpected request in scenario state "+state, map[string]string{"method": r.Method, "path": r.URL.Path})
+			return
+		}
+		if step.Next != "" && step.Next != state {
+			logger(r.Context()).Info("scenario moved", "from", state, "to", step.Next)
+		}
+
+		if step.Response == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for name, value := range step.Response.Headers {
+			w.Header().Set(name, value)
+		}
+		w.WriteHeader(step.Response.Status)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(step.Response.Body))
+		}
+	})
+}
+
+// scenarioFailed reports, with an error log, whether the -scenario got
+// unexpected requests or did not complete.
+func scenarioFailed() bool {
+	if scenario == nil {
+		return false
+	}
+
+	status := scenario.status()
+	if len(status.Unexpected) > 0 {
+		slog.Error("scenario got unexpected requests", "requests", status.Unexpected)
+	}
+	if !status.Complete {
+		slog.Error("scenario did not complete", "state", status.State, "final", scenario.Final)
+	}
+
+	return len(status.Unexpected) > 0 || !status.Complete
+}
+
+// registerScenarioRoutes adds the -scenario progress to the admin API.
+func registerScenarioRoutes(admin *mux.Router) {
+	admin.HandleFunc("/scenario", handleAdminScenario).Methods("GET")
+	admin.HandleFunc("/scenario", handleAdminResetScenario).Methods("DELETE")
+}
+
+func handleAdminScenario(w http.ResponseWriter, r *http.Request) {
+	if scenario == nil {
+		writeError(w, http.StatusNotFound, ErrCodeUnknown, "no -scenario loaded", nil)
+		return
+	}
+
+	writeAdminJSON(w, scenario.status())
+}
+
+// handleAdminResetScenario starts the scenario over.
+func handleAdminResetScenario(w http.ResponseWriter, r *http.Request) {
+	if scenario == nil {
+		writeError(w, http.StatusNotFound, ErrCodeUnknown, "no -scenario loaded", nil)
+		return
+	}
+
+	scenario.mu.Lock()
+	scenario.reset()
+	scenario.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
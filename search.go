@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/gorilla/mux"
+)
+
+var searchAPI = flag.Bool("search-api", false, "serve a JSON search API over the listed charts at /api/search")
+
+// SearchResult describes a chart matching a search.
+type SearchResult struct {
+	Repository string `json:"repository"`
+	Reference  string `json:"reference"`
+	Digest     string `json:"digest"`
+	Chart      Chart  `json:"chart"`
+}
+
+// registerSearchRoutes adds the search API to r when -search-api is set.
+func registerSearchRoutes(r *mux.Router) {
+	if !*searchAPI {
+		return
+	}
+
+	r.HandleFunc("/api/search", handleSearch).Methods("GET")
+}
+
+// handleSearch lists the known charts matching every given query parameter:
+// q, a case-insensitive substring of the repository name or description;
+// keyword, an exact Chart.yaml keyword; and version, a semver range such as
+// ">=1.2 <2".
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.ToLower(query.Get("q"))
+	keyword := query.Get("keyword")
+
+	var constraint *semver.Constraints
+	if v := query.Get("version"); v != "" {
+		var err error
+		if constraint, err = semver.NewConstraint(v); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeUnknown, "invalid version range", err.Error())
+			return
+		}
+	}
+
+	results := []SearchResult{}
+	for _, ref := range visibleCharts(r.Context()) {
+		nameMatches := q == "" || strings.Contains(strings.ToLower(ref.Name), q)
+
+		manifest, chart, err := describeChart(r.Context(), ref)
+		if err != nil {
+			logger(r.Context()).Warn("leaving chart out of search", "name", ref.Name, "reference", ref.Reference, "error", err)
+			continue
+		}
+
+		if !nameMatches && !strings.Contains(strings.ToLower(chart.Description), q) {
+			continue
+		}
+		if keyword != "" && !containsString(chart.Keywords, keyword) {
+			continue
+		}
+		if constraint != nil {
+			v, err := semver.NewVersion(chart.Version)
+			if err != nil || !constraint.Check(v) {
+				continue
+			}
+		}
+
+		results = append(results, SearchResult{
+			Repository: ref.Name,
+			Reference:  ref.Reference,
+			Digest:     manifest.digest,
+			Chart:      chart,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
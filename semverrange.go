@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/gorilla/mux"
+)
+
+var tagRanges = flag.Bool("tag-ranges", false, "resolve pulled tags that are semver ranges, such as ^1.2, ~1.2.3, 1.x or >=1.0 <2 (URL-encoded), to the highest known version matching them, and serve /api/versions?name=...&range=... for clients without range support")
+
+// rangeTag parses reference as a semver range. Plain versions and tags that
+// use none of the range operators are not ranges.
+func rangeTag(reference string) (*semver.Constraints, bool) {
+	if !strings.ContainsAny(reference, "^~<>=*xX|, ") {
+		return nil, false
+	}
+	if _, ok := referenceVersion(reference); ok {
+		return nil, false
+	}
+	constraint, err := semver.NewConstraint(reference)
+	if err != nil {
+		return nil, false
+	}
+
+	return constraint, true
+}
+
+// matchingVersions returns the known tags of name that are versions within
+// constraint, highest first.
+func matchingVersions(ctx context.Context, name string, constraint *semver.Constraints) []string {
+	type version struct {
+		tag     string
+		version *semver.Version
+	}
+	var versions []version
+	for _, ref := range visibleCharts(ctx) {
+		if ref.Name != name {
+			continue
+		}
+		v, ok := referenceVersion(ref.Reference)
+		if !ok {
+			continue
+		}
+		// Valid since referenceVersion parsed it.
+		sv := semver.MustParse(v)
+		if constraint.Check(sv) {
+			versions = append(versions, version{tag: ref.Reference, version: sv})
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].version.GreaterThan(versions[j].version) })
+
+	tags := make([]string, len(versions))
+	for i, v := range versions {
+		tags[i] = v.tag
+	}
+	return tags
+}
+
+// registerVersionRoutes adds the range resolution API to r when -tag-ranges
+// is set.
+func registerVersionRoutes(r *mux.Router) {
+	if !*tagRanges {
+		return
+	}
+
+	r.HandleFunc("/api/versions", handleVersions).Methods("GET")
+}
+
+// VersionsResult is the response of the range resolution API.
+type VersionsResult struct {
+	Name     string   `json:"name"`
+	Range    string   `json:"range"`
+	Version  string   `json:"version"`
+	Versions []string `json:"versions"`
+}
+
+// handleVersions resolves the range query parameter against the known
+// versions of the repository given by name and returns the best match and
+// every matching version.
+func handleVersions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := rewriteName(query.Get("name"))
+	if !validName(name) {
+		writeError(w, http.StatusBadRequest, ErrCodeNameInvalid, "invalid repository name", name)
+		return
+	}
+	constraint, err := semver.NewConstraint(query.Get("range"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnknown, "invalid version range", err.Error())
+		return
+	}
+
+	result := VersionsResult{Name: name, Range: query.Get("range"), Versions: matchingVersions(r.Context(), name, constraint)}
+	if len(result.Versions) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "no known version matches the range", result.Range)
+		return
+	}
+	result.Version = result.Versions[0]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
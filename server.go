@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+var (
+	shutdownGrace     = flag.Duration("shutdown-grace", 30*time.Second, "how long to wait for in-flight requests to finish when shutting down")
+	readTimeout       = flag.Duration("read-timeout", 5*time.Minute, "maximum duration for reading an entire request, including the body")
+	readHeaderTimeout = flag.Duration("read-header-timeout", 10*time.Second, "maximum duration for reading request headers")
+	writeTimeout      = flag.Duration("write-timeout", 5*time.Minute, "maximum duration before timing out writes of a response")
+	idleTimeout       = flag.Duration("idle-timeout", 2*time.Minute, "maximum time to wait for the next request on a keep-alive connection")
+	http2Enabled      = flag.Bool("http2", true, "negotiate HTTP/2 with TLS clients")
+	h2cEnabled        = flag.Bool("h2c", false, "accept HTTP/2 without TLS (h2c prior knowledge) on plain HTTP listeners")
+)
+
+// newServer returns an http.Server for handler with the configured timeouts
+// and protocols.
+func newServer(handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(*http2Enabled)
+	protocols.SetUnencryptedHTTP2(*h2cEnabled)
+
+	tlsConfig := serverTLS
+	if tlsConfig != nil && !*http2Enabled {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = slices.DeleteFunc(tlsConfig.NextProtos, func(p string) bool { return p == "h2" })
+	}
+
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		TLSConfig:         tlsConfig,
+		Protocols:         protocols,
+	}
+}
+
+// serve runs srv on listeners until ctx is cancelled, then stops accepting
+// connections, drains in-flight requests within the shutdown grace period and
+// closes the store.
+func serve(ctx context.Context, srv *http.Server, listeners []listener) error {
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		slog.Info("starting server", "addr", ln.addr, "tls", ln.tls)
+		go func() {
+			if ln.tls {
+				errs <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			errs <- srv.Serve(ln)
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		srv.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "grace", *shutdownGrace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownGrace)
+	defer cancel()
+
+	shutdownErr := srv.Shutdown(shutdownCtx)
+	for range listeners {
+		if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	if shutdownErr != nil {
+		return shutdownErr
+	}
+
+	return store.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	slsaProvenance = flag.Bool("slsa-provenance", false, "attach an in-toto SLSA provenance attestation to every generated chart as a referrer, signed as a DSSE envelope when -cosign-key is set")
+	slsaBuilderID  = flag.String("slsa-builder-id", "https://github.com/cdelautour/virtual-helm", "builder.id recorded in SLSA provenance attestations")
+)
+
+const (
+	inTotoMediaType   = "application/vnd.in-toto+json"
+	dsseMediaType     = "application/vnd.dsse.envelope.v1+json"
+	slsaBuildType     = "https://github.com/cdelautour/virtual-helm/generate@v1"
+	slsaPredicateType = "https://slsa.dev/provenance/v1"
+)
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// inTotoStatement is an in-toto v1 statement carrying a SLSA v1 provenance
+// predicate.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     interface{}     `json:"predicate"`
+}
+
+// attachProvenance records how manifest was generated for req as a SLSA
+// provenance attestation referring to it.
+func attachProvenance(name string, manifest *Manifest, req ChartRequest, reference string, started time.Time, finished time.Time) error {
+	statement := inTotoStatement{
+		Type: "https://in-toto.io/Statement/v1",
+		Subject: []inTotoSubject{{
+			Name:   name,
+			Digest: map[string]string{"sha256": strings.TrimPrefix(manifest.digest, "sha256:")},
+		}},
+		PredicateType: slsaPredicateType,
+		Predicate: map[string]interface{}{
+			"buildDefinition": map[string]interface{}{
+				"buildType": slsaBuildType,
+				"externalParameters": map[string]interface{}{
+					"name":       name,
+					"reference":  reference,
+					"parameters": req.Parameters,
+				},
+				"internalParameters": map[string]interface{}{
+					"generator": generatorNameFor(name),
+				},
+			},
+			"runDetails": map[string]interface{}{
+				"builder": map[string]interface{}{
+					"id":      *slsaBuilderID,
+					"version": map[string]string{"virtual-helm": versionInfo().Version},
+				},
+				"metadata": map[string]interface{}{
+					"startedOn":  started.UTC().Format(time.RFC3339),
+					"finishedOn": finished.UTC().Format(time.RFC3339),
+				},
+				"byproducts": []map[string]interface{}{{
+					"name":   "chart",
+					"digest": map[string]string{"sha256": strings.TrimPrefix(manifest.Layers[0].Digest, "sha256:")},
+				}},
+			},
+		},
+	}
+
+	data, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+
+	mediaType := inTotoMediaType
+	if cosignSigner != nil {
+		if data, err = dsseEnvelope(inTotoMediaType, data); err != nil {
+			return err
+		}
+		mediaType = dsseMediaType
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if err := store.Put(digest, data); err != nil {
+		return err
+	}
+
+	return addReferrer(name, manifest, &Manifest{
+		ArtifactType: inTotoMediaType,
+		Config:       emptyConfig,
+		Layers: []Layer{{
+			MediaType:   mediaType,
+			Digest:      digest,
+			Size:        len(data),
+			Annotations: map[string]string{"in-toto.io/predicate-type": slsaPredicateType},
+		}},
+		Annotations: map[string]string{
+			"org.opencontainers.image.created": finished.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// dsseEnvelope signs payload with the cosign key as a DSSE envelope.
+func dsseEnvelope(payloadType string, payload []byte) ([]byte, error) {
+	// DSSE signs the pre-authentication encoding of the type and payload.
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+	sum := sha256.Sum256([]byte(pae))
+	signature, err := cosignSigner.Sign(randReader, sum[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"payloadType": payloadType,
+		"payload":     base64.StdEncoding.EncodeToString(payload),
+		"signatures":  []map[string]string{{"sig": base64.StdEncoding.EncodeToString(signature)}},
+	})
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var restoreFile = flag.String("restore", "", "snapshot file, as written by `virtual-helm snapshot`, to restore the registry state from at startup")
+
+const snapshotStateFile = "virtual-helm-snapshot.json"
+
+// snapshotState records where the manifests of a snapshot belong. The
+// snapshot itself is an OCI layout tarball holding every manifest and blob,
+// so it can also be read by tools such as oras. Blob upload sessions in
+// progress are not saved; clients whose session is lost start the upload
+// again.
+type snapshotState struct {
+	// Cache, Imported and Pushed map name:reference keys to manifest
+	// digests.
+	Cache    map[string]string `json:"cache"`
+	Imported map[string]string `json:"imported"`
+	Pushed   map[string]string `json:"pushed"`
+	// Referrers maps repositories to the digests of their attached artifacts.
+	Referrers map[string][]string `json:"referrers"`
+	Uploads   []snapshotUpload    `json:"uploads"`
+}
+
+// snapshotUpload is a chart archive uploaded through the ChartMuseum API.
+type snapshotUpload struct {
+	Digest  string    `json:"digest"`
+	Created time.Time `json:"created"`
+}
+
+// SnapshotResult counts what a snapshot restored.
+type SnapshotResult struct {
+	Manifests int `json:"manifests"`
+	Uploads   int `json:"uploads"`
+	Blobs     int `json:"blobs"`
+}
+
+// writeSnapshot writes the generated, imported, pushed and uploaded charts
+// and the artifacts attached to them to w.
+func writeSnapshot(w io.Writer) error {
+	lw, err := newOCILayoutWriter(w)
+	if err != nil {
+		return err
+	}
+
+	state := snapshotState{
+		Cache:     make(map[string]string),
+		Imported:  make(map[string]string),
+		Pushed:    make(map[string]string),
+		Referrers: make(map[string][]string),
+	}
+	tagged := make(map[string]bool)
+
+	cached := generated.Entries()
+	for _, key := range sortedKeys(cached) {
+		manifest := cached[key]
+		state.Cache[key] = manifest.digest
+		tagged[key] = true
+		if err := lw.manifest(key, manifest); err != nil {
+			return err
+		}
+	}
+
+	writeManifests := func(byRef map[string]*Manifest, digests map[string]string) error {
+		for _, key := range sortedKeys(byRef) {
+			manifest := byRef[key]
+			digests[key] = manifest.digest
+
+			_, reference, _ := strings.Cut(key, ":")
+			ref := ""
+			if reference != manifest.digest && !tagged[key] {
+				ref = key
+				tagged[key] = true
+			}
+			if err := lw.manifest(ref, manifest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	imported.RLock()
+	importedByRef := maps.Clone(imported.byRef)
+	imported.RUnlock()
+	if err := writeManifests(importedByRef, state.Imported); err != nil {
+		return err
+	}
+
+	pushed.RLock()
+	pushedByRef := maps.Clone(pushed.byRef)
+	pushed.RUnlock()
+	if err := writeManifests(pushedByRef, state.Pushed); err != nil {
+		return err
+	}
+
+	referrers.RLock()
+	var artifacts []*Manifest
+	for key, list := range referrers.bySubject {
+		name, _, _ := strings.Cut(key, "@")
+		for _, artifact := range list {
+			state.Referrers[name] = append(state.Referrers[name], artifact.digest)
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	referrers.RUnlock()
+	for _, artifact := range artifacts {
+		if lw.written[artifact.digest] {
+			continue
+		}
+		if err := lw.manifest("", artifact); err != nil {
+			return err
+		}
+	}
+
+	uploadedCharts.mu.RLock()
+	for _, versions := range uploadedCharts.charts {
+		for _, upload := range versions {
+			state.Uploads = append(state.Uploads, snapshotUpload{Digest: upload.digest, Created: upload.created})
+		}
+	}
+	uploadedCharts.mu.RUnlock()
+	sort.Slice(state.Uploads, func(i, j int) bool { return state.Uploads[i].Created.Before(state.Uploads[j].Created) })
+	for _, upload := range state.Uploads {
+		if err := lw.storedBlob(upload.Digest); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := lw.file(snapshotStateFile, data); err != nil {
+		return err
+	}
+
+	return lw.Close()
+}
+
+func sortedKeys(m map[string]*Manifest) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// restoreSnapshot replaces the generated, imported, pushed and uploaded
+// charts and attached artifacts with those of the snapshot read through
+// readFile.
+func restoreSnapshot(readFile func(name string) ([]byte, error)) (SnapshotResult, error) {
+	var result SnapshotResult
+
+	data, err := readFile(snapshotStateFile)
+	if err != nil {
+		return result, fmt.Errorf("not a virtual-helm snapshot: %w", err)
+	}
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return result, fmt.Errorf("parsing %s: %w", snapshotStateFile, err)
+	}
+
+	// Load everything before touching the current state, so a broken
+	// snapshot leaves it intact.
+	im := &importer{readFile: readFile}
+	manifests := make(map[string]*Manifest)
+	load := func(digest string) (*Manifest, error) {
+		if manifest, ok := manifests[digest]; ok {
+			return manifest, nil
+		}
+		content, err := im.blob(digest)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := im.manifest(content)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", digest, err)
+		}
+		manifests[digest] = manifest
+		return manifest, nil
+	}
+
+	// Pushed manifests may be indexes or lack a mediaType, which the
+	// descriptors of the layout's index then give.
+	mediaTypes := make(map[string]string)
+	if data, err := readFile("index.json"); err == nil {
+		var index Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return result, fmt.Errorf("parsing index.json: %w", err)
+		}
+		for _, m := range index.Manifests {
+			mediaTypes[m.Digest] = m.MediaType
+		}
+	}
+	loadPushed := func(digest string) (*Manifest, error) {
+		if manifest, ok := manifests[digest]; ok {
+			return manifest, nil
+		}
+		content, err := im.blob(digest)
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", digest, err)
+		}
+		if manifest.MediaType == "" {
+			manifest.MediaType = mediaTypes[digest]
+		}
+		manifest.content = content
+		manifest.digest = digest
+		if !isIndexMediaType(manifest.MediaType) {
+			for _, blob := range manifestBlobs(&manifest) {
+				if _, err := im.blob(blob); err != nil {
+					return nil, fmt.Errorf("manifest %s: %w", digest, err)
+				}
+			}
+		}
+		manifests[digest] = &manifest
+		return &manifest, nil
+	}
+
+	cache := make(map[string]*Manifest)
+	for key, digest := range state.Cache {
+		if cache[key], err = load(digest); err != nil {
+			return result, err
+		}
+	}
+	importedByRef := make(map[string]*Manifest)
+	for key, digest := range state.Imported {
+		if importedByRef[key], err = load(digest); err != nil {
+			return result, err
+		}
+	}
+	pushedByRef := make(map[string]*Manifest)
+	for key, digest := range state.Pushed {
+		if pushedByRef[key], err = loadPushed(digest); err != nil {
+			return result, err
+		}
+	}
+	artifacts := make(map[string][]*Manifest)
+	for name, digests := range state.Referrers {
+		for _, digest := range digests {
+			artifact, err := loadPushed(digest)
+			if err != nil {
+				return result, err
+			}
+			if artifact.Subject == nil {
+				return result, fmt.Errorf("referrer %s has no subject", digest)
+			}
+			artifacts[name] = append(artifacts[name], artifact)
+		}
+	}
+	uploads := make(map[string]map[string]*uploadedChart)
+	for _, u := range state.Uploads {
+		archive, err := im.blob(u.Digest)
+		if err != nil {
+			return result, err
+		}
+		upload, err := parseChartArchive(archive)
+		if err != nil {
+			return result, err
+		}
+		upload.created = u.Created
+		if uploads[upload.chart.Name] == nil {
+			uploads[upload.chart.Name] = make(map[string]*uploadedChart)
+		}
+		uploads[upload.chart.Name][upload.chart.Version] = upload
+		result.Uploads++
+	}
+
+	generated.Purge()
+	for _, key := range sortedKeys(cache) {
+		generated.Add(key, cache[key])
+	}
+
+	imported.Lock()
+	imported.byRef = importedByRef
+	imported.Unlock()
+
+	pushed.Lock()
+	pushed.byRef = pushedByRef
+	pushed.Unlock()
+
+	// The referrers are restored as saved, since pushed artifacts of the
+	// same type do not replace each other as generated ones do.
+	referrers.Lock()
+	referrers.bySubject = make(map[string][]*Manifest)
+	for name, list := range artifacts {
+		for _, artifact := range list {
+			key := referrerKey(name, artifact.Subject.Digest)
+			referrers.bySubject[key] = append(referrers.bySubject[key], artifact)
+		}
+	}
+	referrers.Unlock()
+
+	uploadedCharts.mu.Lock()
+	uploadedCharts.charts = uploads
+	uploadedCharts.mu.Unlock()
+
+	result.Manifests = len(manifests)
+	result.Blobs = im.result.Blobs
+	return result, nil
+}
+
+// initRestore restores the -restore snapshot.
+func initRestore() error {
+	if *restoreFile == "" {
+		return nil
+	}
+
+	readFile, err := openImport(*restoreFile)
+	if err != nil {
+		return err
+	}
+	result, err := restoreSnapshot(readFile)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", *restoreFile, err)
+	}
+
+	slog.Info("restored snapshot", "file", *restoreFile, "manifests", result.Manifests, "uploads", result.Uploads, "blobs", result.Blobs)
+	return nil
+}
+
+func handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-tar")
+	if err := writeSnapshot(w); err != nil {
+		// The status is already sent, so the truncated tarball is all the
+		// client sees.
+		logger(r.Context()).Error("writing snapshot failed", "error", err)
+	}
+}
+
+func handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	readFile, err := readTarball(bytes.NewReader(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "failed to read tarball", err.Error())
+		return
+	}
+
+	result, err := restoreSnapshot(readFile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "restore failed", err.Error())
+		return
+	}
+
+	logger(r.Context()).Info("restored snapshot", "manifests", result.Manifests, "uploads", result.Uploads, "blobs", result.Blobs)
+	writeAdminJSON(w, result)
+}
+
+// runSnapshot implements `virtual-helm snapshot [flags]`.
+func runSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: virtual-helm snapshot [flags]\n\nSaves the state of a running server, to be restored with `virtual-helm restore` or -restore.")
+		fs.PrintDefaults()
+	}
+	client := adminClientFlags(fs)
+	output := fs.String("o", "", "file to write the snapshot to (standard output when empty)")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	return c.do("GET", "/admin/snapshot", nil, out)
+}
+
+// runRestore implements `virtual-helm restore [flags] file`.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: virtual-helm restore [flags] file\n\nReplaces the state of a running server with a snapshot.")
+		fs.PrintDefaults()
+	}
+	client := adminClientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var result SnapshotResult
+	if err := c.do("POST", "/admin/snapshot", f, &result); err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %d manifests, %d uploaded charts, %d blobs\n", result.Manifests, result.Uploads, result.Blobs)
+	return nil
+}
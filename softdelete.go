@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var deleteRetention = flag.Duration("delete-retention", 24*time.Hour, "how long deleted manifests and tags are kept for restoring with the admin API before garbage collection drops them and deletes the blobs no other manifest references; 0 deletes right away and never collects blobs")
+
+// DeletedManifest is a soft-deleted pushed manifest or the deleted tags of
+// one.
+type DeletedManifest struct {
+	Name   string   `json:"name"`
+	Digest string   `json:"digest"`
+	Tags   []string `json:"tags"`
+	// ManifestDeleted is false when only tags were deleted and the manifest
+	// is still served by digest.
+	ManifestDeleted bool      `json:"manifestDeleted"`
+	DeletedAt       time.Time `json:"deletedAt"`
+
+	manifest *Manifest
+}
+
+// trash holds the soft-deleted manifests by name@digest.
+var trash = struct {
+	sync.Mutex
+	byKey map[string]*DeletedManifest
+}{byKey: make(map[string]*DeletedManifest)}
+
+// initSoftDelete starts collecting the deleted manifests past the
+// -delete-retention.
+func initSoftDelete() {
+	if *deleteRetention == 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(min(*deleteRetention, time.Minute)) {
+			collectDeleted(false)
+		}
+	}()
+}
+
+// softDelete keeps the deleted tags of manifest in name, and the manifest
+// itself when manifestDeleted, for restoring until the -delete-retention
+// has passed.
+func softDelete(name string, manifest *Manifest, tags []string, manifestDeleted bool) {
+	if *deleteRetention == 0 {
+		return
+	}
+
+	trash.Lock()
+	defer trash.Unlock()
+
+	key := referrerKey(name, manifest.digest)
+	entry, ok := trash.byKey[key]
+	if !ok {
+		entry = &DeletedManifest{Name: name, Digest: manifest.digest, Tags: []string{}, manifest: manifest}
+		trash.byKey[key] = entry
+	}
+	for _, tag := range tags {
+		if !slices.Contains(entry.Tags, tag) {
+			entry.Tags = append(entry.Tags, tag)
+		}
+	}
+	entry.ManifestDeleted = entry.ManifestDeleted || manifestDeleted
+	entry.DeletedAt = now().UTC()
+}
+
+// GCResult reports what a garbage collection removed.
+type GCResult struct {
+	Manifests    int `json:"manifests"`
+	BlobsDeleted int `json:"blobsDeleted"`
+}
+
+// collectDeleted drops the deleted manifests past the -delete-retention, or
+// all of them, and deletes the blobs they referenced that no served or
+// still restorable manifest references.
+func collectDeleted(all bool) GCResult {
+	trash.Lock()
+	defer trash.Unlock()
+
+	var expired []*DeletedManifest
+	for key, entry := range trash.byKey {
+		if all || now().Sub(entry.DeletedAt) >= *deleteRetention {
+			expired = append(expired, entry)
+			delete(trash.byKey, key)
+		}
+	}
+	if len(expired) == 0 {
+		return GCResult{}
+	}
+
+	inUse := blobsInUse()
+	for _, entry := range trash.byKey {
+		useBlobs(inUse, entry.manifest)
+	}
+
+	result := GCResult{Manifests: len(expired)}
+	for _, entry := range expired {
+		if !entry.ManifestDeleted {
+			continue
+		}
+		for _, digest := range manifestBlobs(entry.manifest) {
+			if digest == "" || inUse[digest] {
+				continue
+			}
+			if err := store.Delete(digest); err != nil {
+				slog.Warn("failed to delete blob", "digest", digest, "error", err)
+				continue
+			}
+			inUse[digest] = true
+			result.BlobsDeleted++
+		}
+	}
+
+	slog.Info("collected deleted manifests", "manifests", result.Manifests, "blobs_deleted", result.BlobsDeleted)
+	return result
+}
+
+// registerSoftDeleteRoutes adds listing, restoring and collecting deleted
+// manifests to the admin API.
+func registerSoftDeleteRoutes(admin *mux.Router) {
+	admin.HandleFunc("/deleted", handleAdminDeleted).Methods("GET")
+	admin.HandleFunc("/repositories/{name:.+}/manifests/{digest}/restore", handleAdminRestoreManifest).Methods("POST")
+	admin.HandleFunc("/gc", handleAdminGC).Methods("POST")
+}
+
+func handleAdminDeleted(w http.ResponseWriter, r *http.Request) {
+	trash.Lock()
+	defer trash.Unlock()
+
+	deleted := []DeletedManifest{}
+	for _, entry := range trash.byKey {
+		deleted = append(deleted, *entry)
+	}
+	slices.SortFunc(deleted, func(a, b DeletedManifest) int { return a.DeletedAt.Compare(b.DeletedAt) })
+
+	writeAdminJSON(w, deleted)
+}
+
+// handleAdminRestoreManifest serves a soft-deleted manifest and its deleted
+// tags again, unless a tag has been pushed elsewhere since.
+func handleAdminRestoreManifest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := referrerKey(vars["name"], vars["digest"])
+
+	trash.Lock()
+	defer trash.Unlock()
+
+	entry, ok := trash.byKey[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "no deleted manifest to restore", vars["digest"])
+		return
+	}
+
+	pushed.Lock()
+	for _, tag := range entry.Tags {
+		if current, ok := pushed.byRef[cacheKey(entry.Name, tag)]; ok && current.digest != entry.Digest {
+			pushed.Unlock()
+			writeError(w, http.StatusConflict, ErrCodeDenied, "tag was pushed again since it was deleted", map[string]string{"tag": tag, "digest": current.digest})
+			return
+		}
+	}
+	for _, tag := range entry.Tags {
+		pushed.byRef[cacheKey(entry.Name, tag)] = entry.manifest
+	}
+	pushed.byRef[cacheKey(entry.Name, entry.Digest)] = entry.manifest
+	pushed.Unlock()
+	delete(trash.byKey, key)
+
+	if entry.ManifestDeleted && entry.manifest.Subject != nil {
+		addPushedReferrer(entry.Name, entry.manifest)
+	}
+	for _, tag := range entry.Tags {
+		recordTag(entry.Name, tag, entry.Digest)
+	}
+
+	logger(r.Context()).Info("restored manifest", "name", entry.Name, "digest", entry.Digest, "tags", entry.Tags)
+	audit(r, AuditEvent{Action: "restore", User: "admin", Repository: entry.Name, Digest: entry.Digest, Status: http.StatusOK})
+	writeAdminJSON(w, entry)
+}
+
+// handleAdminGC collects the deleted manifests past the -delete-retention
+// right away, or all of them with all=true.
+func handleAdminGC(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, collectDeleted(r.URL.Query().Get("all") == "true"))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	sopsDecrypt = flag.Bool("sops", false, "decrypt SOPS-encrypted YAML and JSON files at the root of generated charts, such as an encrypted values.yaml, with the sops binary; it finds age, KMS and GPG keys as usual, for example from SOPS_AGE_KEY_FILE")
+	sopsBinary  = flag.String("sops-binary", "sops", "sops binary run by -sops")
+)
+
+// initSOPS checks that the sops binary can be run.
+func initSOPS() error {
+	if !*sopsDecrypt {
+		return nil
+	}
+
+	if _, err := exec.LookPath(*sopsBinary); err != nil {
+		return fmt.Errorf("-sops: %w", err)
+	}
+	return nil
+}
+
+// decryptSOPSFiles replaces the SOPS-encrypted files at the root of the
+// chart with their plaintext.
+func decryptSOPSFiles(ctx context.Context, out *GeneratedChart) error {
+	if !*sopsDecrypt {
+		return nil
+	}
+
+	for i, f := range out.Files {
+		format := strings.TrimPrefix(path.Ext(f.Name), ".")
+		if format == "yml" {
+			format = "yaml"
+		}
+		if format != "yaml" && format != "json" || strings.Count(f.Name, "/") > 1 || !sopsEncrypted(f.Data) {
+			continue
+		}
+
+		data, err := runSOPS(ctx, format, f.Data)
+		if err != nil {
+			return fmt.Errorf("decrypting %s: %w", f.Name, err)
+		}
+		out.Files[i].Data = data
+	}
+
+	return nil
+}
+
+// sopsEncrypted reports whether data is a YAML or JSON document with SOPS
+// metadata.
+func sopsEncrypted(data []byte) bool {
+	if !bytes.Contains(data, []byte("sops")) {
+		return false
+	}
+
+	var doc struct {
+		SOPS *struct {
+			MAC string `json:"mac"`
+		} `json:"sops"`
+	}
+	return yaml.Unmarshal(data, &doc) == nil && doc.SOPS != nil && doc.SOPS.MAC != ""
+}
+
+// runSOPS decrypts data in format with the sops binary.
+func runSOPS(ctx context.Context, format string, data []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, *sopsBinary, "--decrypt", "--input-type", format, "--output-type", format, "/dev/stdin")
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"go.starlark.net/starlark"
+	starlarkjson "go.starlark.net/starlarkjson"
+	"go.starlark.net/syntax"
+)
+
+var (
+	starlarkScript   = flag.String("starlark-script", "", "Starlark script served by the \"starlark\" generator")
+	starlarkMaxSteps = flag.Uint64("starlark-max-steps", 10_000_000, "maximum execution steps for a single Starlark generation")
+)
+
+func init() {
+	generators["starlark"] = starlarkGenerator{}
+}
+
+// starlarkGenerator runs -starlark-script for every request. The script must
+// define
+//
+//	def generate(name, reference, params):
+//	    return {"chart": {...}, "files": {"path": "content", ...}}
+//
+// where "chart" overrides Chart.yaml fields and "files" holds the chart files
+// by path relative to the chart root. The script is re-read on every
+// generation so edits apply without a restart.
+type starlarkGenerator struct{}
+
+type starlarkResult struct {
+	Chart map[string]interface{} `json:"chart"`
+	Files map[string]string      `json:"files"`
+}
+
+func (starlarkGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	if *starlarkScript == "" {
+		return nil, errors.New("the starlark generator requires -starlark-script")
+	}
+
+	src, err := os.ReadFile(*starlarkScript)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := &starlark.Thread{Name: req.Name + ":" + req.Reference}
+	thread.SetMaxExecutionSteps(*starlarkMaxSteps)
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	predeclared := starlark.StringDict{"json": starlarkjson.Module}
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, *starlarkScript, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+
+	generate, ok := globals["generate"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not define a generate function", *starlarkScript)
+	}
+
+	params := starlark.NewDict(len(req.Parameters))
+	for k, v := range req.Parameters {
+		params.SetKey(starlark.String(k), starlark.String(v))
+	}
+
+	ret, err := starlark.Call(thread, generate, starlark.Tuple{starlark.String(req.Name), starlark.String(req.Reference), params}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round-trip through JSON to convert Starlark values to Go.
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{ret}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result starlarkResult
+	if err := json.Unmarshal([]byte(encoded.(starlark.String)), &result); err != nil {
+		return nil, fmt.Errorf("generate returned an invalid result: %w", err)
+	}
+
+	chart, err := chartWithOverrides(req, result.Chart)
+	if err != nil {
+		return nil, fmt.Errorf("generate returned %w", err)
+	}
+
+	files := make([]ChartFile, 0, len(result.Files))
+	for name, content := range result.Files {
+		files = append(files, ChartFile{Name: name, Data: []byte(content)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	files, err = withChartYaml(files, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
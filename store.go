@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// Store holds content-addressed blobs.
+type Store interface {
+	Put(digest string, blob []byte) error
+	Get(digest string) ([]byte, bool, error)
+	// Delete removes a blob; deleting a missing blob is not an error.
+	Delete(digest string) error
+	// Writer returns a writer for a new blob whose digest is only known
+	// once all of its content has been written.
+	Writer() (BlobWriter, error)
+	// Stats returns the number of stored blobs and their total size in bytes.
+	Stats() (count int, size int)
+	// Ping reports whether the backend is reachable.
+	Ping() error
+	// Close flushes any pending writes and releases the backend.
+	Close() error
+}
+
+// BlobWriter streams a blob into a Store.
+type BlobWriter interface {
+	io.Writer
+	// Commit stores the written content under digest.
+	Commit(digest string) error
+	// Cancel discards the written content.
+	Cancel() error
+}
+
+var store Store = newMemoryStore()
+
+type memoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{blobs: make(map[string][]byte)}
+}
+
+func (s *memoryStore) Put(digest string, blob []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[digest] = blob
+	return nil
+}
+
+func (s *memoryStore) Get(digest string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	blob, ok := s.blobs[digest]
+	return blob, ok, nil
+}
+
+func (s *memoryStore) Delete(digest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, digest)
+	return nil
+}
+
+func (s *memoryStore) Writer() (BlobWriter, error) {
+	return &memoryBlobWriter{store: s, buf: getBuffer()}, nil
+}
+
+func (s *memoryStore) Stats() (count int, size int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, blob := range s.blobs {
+		size += len(blob)
+	}
+
+	return len(s.blobs), size
+}
+
+func (s *memoryStore) Ping() error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+type memoryBlobWriter struct {
+	store *memoryStore
+	buf   *bytes.Buffer
+}
+
+func (w *memoryBlobWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Commit copies the content out of the pooled buffer so the buffer can be
+// reused once the blob is stored. A blob already stored under digest is
+// kept, as it has the same content.
+func (w *memoryBlobWriter) Commit(digest string) error {
+	defer putBuffer(w.buf)
+	if _, ok, _ := w.store.Get(digest); ok {
+		return nil
+	}
+	return w.store.Put(digest, bytes.Clone(w.buf.Bytes()))
+}
+
+func (w *memoryBlobWriter) Cancel() error {
+	putBuffer(w.buf)
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// contextWriter fails writes once ctx is done, stopping the work of
+// producing what is written.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
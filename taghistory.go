@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TagEvent records that a tag pointed at a digest from a time on. The digest
+// is empty from when the tag was deleted.
+type TagEvent struct {
+	Digest string    `json:"digest"`
+	Time   time.Time `json:"time"`
+}
+
+// tagHistory holds the events of tags by name:tag, oldest first.
+var tagHistory = struct {
+	sync.Mutex
+	byTag map[string][]TagEvent
+}{byTag: make(map[string][]TagEvent)}
+
+// recordTag records that name:tag points at digest, or was deleted when
+// digest is empty, unless it already did. Tags are recorded as pushed,
+// deleted and served, so the digests generated tags resolved to are kept as
+// well.
+func recordTag(name string, tag string, digest string) {
+	key := cacheKey(name, tag)
+
+	tagHistory.Lock()
+	defer tagHistory.Unlock()
+
+	events := tagHistory.byTag[key]
+	if len(events) > 0 && events[len(events)-1].Digest == digest || len(events) == 0 && digest == "" {
+		return
+	}
+	tagHistory.byTag[key] = append(events, TagEvent{Digest: digest, Time: now().UTC()})
+}
+
+// registerTagHistoryRoutes adds the tag history to the admin API.
+func registerTagHistoryRoutes(admin *mux.Router) {
+	admin.HandleFunc("/repositories/{name:.+}/tags/{tag}/history", handleAdminTagHistory).Methods("GET")
+}
+
+// handleAdminTagHistory lists the events of a tag or, with an RFC 3339 at
+// query parameter, the event in effect at that time.
+func handleAdminTagHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	tagHistory.Lock()
+	events := append([]TagEvent{}, tagHistory.byTag[cacheKey(vars["name"], vars["tag"])]...)
+	tagHistory.Unlock()
+
+	if len(events) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "tag has no history", vars["tag"])
+		return
+	}
+
+	at := r.URL.Query().Get("at")
+	if at == "" {
+		writeAdminJSON(w, events)
+		return
+	}
+
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "invalid time, expected RFC 3339", at)
+		return
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if !events[i].Time.After(t) {
+			writeAdminJSON(w, events[i])
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "tag did not exist at that time", at)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"path"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var tagParams = flag.Bool("tag-params", false, "parse values encoded in tags as <tag>-<key>.<value>-..., e.g. myapp-replicas.3-env.prod, and inject them into values.yaml")
+
+var paramKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseTagParameters splits a reference into its base tag and the parameters
+// encoded after it. A reference that is a version, such as 1.2.3-rc.1, or
+// whose suffixes are not all key.value pairs is returned unchanged with no
+// parameters.
+func parseTagParameters(reference string) (string, map[string]string) {
+	if !*tagParams {
+		return reference, nil
+	}
+	if _, ok := referenceVersion(reference); ok {
+		return reference, nil
+	}
+
+	segments := strings.Split(reference, "-")
+	if len(segments) < 2 {
+		return reference, nil
+	}
+
+	params := make(map[string]string)
+	for _, segment := range segments[1:] {
+		key, value, ok := strings.Cut(segment, ".")
+		if !ok || !paramKeyRegexp.MatchString(key) {
+			return reference, nil
+		}
+		params[key] = value
+	}
+
+	return segments[0], params
+}
+
+// injectValues sets params as top-level keys of the chart's values.yaml,
+// creating the file if the chart has none. Values are parsed as YAML scalars
+// so numbers and booleans keep their types.
+func injectValues(out *GeneratedChart, params map[string]string) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	return updateValues(out, func(values map[string]interface{}) {
+		for key, raw := range params {
+			var value interface{}
+			if err := yaml.Unmarshal([]byte(raw), &value); err != nil || value == nil {
+				value = raw
+			}
+			values[key] = value
+		}
+	})
+}
+
+// updateValues applies update to the chart's values.yaml, creating the file
+// if the chart has none.
+func updateValues(out *GeneratedChart, update func(values map[string]interface{})) error {
+	index := -1
+	for i, f := range out.Files {
+		if path.Base(f.Name) == "values.yaml" && strings.Count(f.Name, "/") <= 1 {
+			index = i
+			break
+		}
+	}
+
+	values := make(map[string]interface{})
+	if index >= 0 {
+		if err := yaml.Unmarshal(out.Files[index].Data, &values); err != nil {
+			return err
+		}
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+	}
+
+	update(values)
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	if index >= 0 {
+		out.Files[index].Data = data
+		return nil
+	}
+
+	out.Files = append(out.Files, ChartFile{Name: chartFilePrefix(out) + "values.yaml", Data: data})
+	return nil
+}
+
+// chartFilePrefix returns the directory prefix the chart's files are
+// packaged under, derived from the location of its Chart.yaml.
+func chartFilePrefix(out *GeneratedChart) string {
+	for _, f := range out.Files {
+		if path.Base(f.Name) == "Chart.yaml" && strings.Count(f.Name, "/") <= 1 {
+			return strings.TrimSuffix(f.Name, "Chart.yaml")
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var templateDir = flag.String("template-dir", "", "directory of chart files served by the \"template\" generator; files ending in .tmpl are rendered as Go templates")
+
+const templateSuffix = ".tmpl"
+
+func init() {
+	generators["template"] = templateGenerator{}
+}
+
+// TemplateData is available to chart file templates.
+type TemplateData struct {
+	Name       string
+	Reference  string
+	Version    string
+	AppVersion string
+	// Config holds the server's configuration flags by name.
+	Config map[string]string
+}
+
+// templateGenerator renders the files of -template-dir for every request.
+// Files ending in .tmpl are executed as Go templates and packaged without the
+// suffix; other files, including helm templates, are copied verbatim. When no
+// Chart.yaml is present one is generated.
+type templateGenerator struct{}
+
+func (templateGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	if *templateDir == "" {
+		return nil, errors.New("the template generator requires -template-dir")
+	}
+
+	files, err := readDirFiles(*templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	chart := defaultChart(req)
+	data := TemplateData{
+		Name:       req.Name,
+		Reference:  req.Reference,
+		Version:    chart.Version,
+		AppVersion: chart.AppVersion,
+		Config:     flagValues(),
+	}
+
+	for i, f := range files {
+		if strings.HasSuffix(f.Name, templateSuffix) {
+			rendered, err := renderTemplate(f.Name, f.Data, data)
+			if err != nil {
+				return nil, err
+			}
+
+			f.Name = strings.TrimSuffix(f.Name, templateSuffix)
+			f.Data = rendered
+			files[i] = f
+		}
+	}
+
+	files, err = withChartYaml(files, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+func renderTemplate(name string, text []byte, data TemplateData) ([]byte, error) {
+	tpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("rendering template %s: %w", name, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// flagValues returns the current value of every command line flag.
+func flagValues() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+
+	return values
+}
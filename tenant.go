@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	tenantFlags       stringList
+	tenantPolicyFlags stringList
+	tenantQuotaFlags  stringList
+)
+
+func init() {
+	flag.Var(&tenantFlags, "tenant", "tenant owning the repositories below the first path segment of the same name; when set, only repositories of tenants are served and blobs are only served to repositories of the tenant that stores them (repeatable)")
+	flag.Var(&tenantPolicyFlags, "tenant-policy", "policy file, in the format of -policy, that replaces -policy for the repositories of a tenant, as tenant=file (repeatable)")
+	flag.Var(&tenantQuotaFlags, "tenant-quota", "maximum size in bytes of the blobs a tenant stores, as tenant=bytes; manifests that would exceed it are refused (repeatable)")
+}
+
+// tenant is a namespace of repositories with its own storage and policy.
+type tenant struct {
+	name string
+	// policy replaces the -policy for the tenant's repositories when set.
+	policy []policyRule
+	// quota limits the bytes of blobs in use when positive.
+	quota int64
+
+	mu    sync.Mutex
+	blobs map[string]int64
+	used  int64
+}
+
+// tenants holds the -tenant tenants by name; it is nil when repository names
+// are not split into tenants.
+var tenants map[string]*tenant
+
+var errTenantQuotaExceeded = errors.New("tenant storage quota exceeded")
+
+// initTenants sets up the -tenant tenants with their policies and quotas.
+func initTenants() error {
+	if len(tenantFlags) == 0 {
+		if len(tenantPolicyFlags) > 0 || len(tenantQuotaFlags) > 0 {
+			return errors.New("-tenant-policy and -tenant-quota require -tenant")
+		}
+		return nil
+	}
+
+	tenants = make(map[string]*tenant)
+	for _, name := range tenantFlags {
+		if strings.Contains(name, "/") || !validName(name) {
+			return fmt.Errorf("invalid tenant %q: expected a single repository name segment", name)
+		}
+		tenants[name] = &tenant{name: name, blobs: make(map[string]int64)}
+	}
+
+	lookup := func(f string, what string) (*tenant, string, error) {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			return nil, "", fmt.Errorf("invalid tenant %s %q: expected tenant=%s", what, f, what)
+		}
+		t, ok := tenants[name]
+		if !ok {
+			return nil, "", fmt.Errorf("invalid tenant %s %q: unknown tenant %q", what, f, name)
+		}
+		return t, value, nil
+	}
+
+	for _, f := range tenantPolicyFlags {
+		t, file, err := lookup(f, "policy")
+		if err != nil {
+			return err
+		}
+		if !authEnabled() {
+			return errors.New("-tenant-policy requires authentication, such as -htpasswd")
+		}
+		if t.policy, err = loadPolicy(file); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range tenantQuotaFlags {
+		t, value, err := lookup(f, "bytes")
+		if err != nil {
+			return err
+		}
+		if t.quota, err = strconv.ParseInt(value, 10, 64); err != nil || t.quota <= 0 {
+			return fmt.Errorf("invalid tenant quota %q: expected a positive number of bytes", f)
+		}
+	}
+
+	return nil
+}
+
+// tenantOf returns the tenant of a repository, or nil.
+func tenantOf(name string) *tenant {
+	if tenants == nil {
+		return nil
+	}
+
+	first, _, _ := strings.Cut(name, "/")
+	return tenants[first]
+}
+
+// claimTenantBlobs records the blobs of a manifest served from or pushed to
+// a repository as stored by its tenant, refusing it when they exceed the
+// tenant's quota.
+func claimTenantBlobs(name string, manifest *Manifest) error {
+	blobs := map[string]int64{manifest.Config.Digest: int64(manifest.Config.Size)}
+	for _, l := range manifest.Layers {
+		blobs[l.Digest] = int64(l.Size)
+	}
+
+	return claimTenantBlobSizes(name, blobs)
+}
+
+// claimTenantBlob records a blob uploaded or mounted to a repository as
+// stored by its tenant, refusing it when it exceeds the tenant's quota.
+func claimTenantBlob(name string, digest string, size int64) error {
+	return claimTenantBlobSizes(name, map[string]int64{digest: size})
+}
+
+func claimTenantBlobSizes(name string, blobs map[string]int64) error {
+	t := tenantOf(name)
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var added int64
+	for digest, size := range blobs {
+		if _, ok := t.blobs[digest]; !ok {
+			added += size
+		}
+	}
+	if t.quota > 0 && t.used+added > t.quota {
+		return errTenantQuotaExceeded
+	}
+
+	for digest, size := range blobs {
+		t.blobs[digest] = size
+	}
+	t.used += added
+	return nil
+}
+
+// tenantHasBlob reports whether a blob may be served from a repository: when
+// it belongs to a tenant, the tenant must have stored the blob.
+func tenantHasBlob(name string, digest string) bool {
+	t := tenantOf(name)
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.blobs[digest]
+	return ok
+}
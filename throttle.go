@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	downloadRate              = flag.Int("throttle-download", 0, "limit of the bytes per second served from blobs, shared by all clients (unlimited when 0)")
+	downloadRatePerConnection = flag.Int("throttle-download-per-connection", 0, "limit of the bytes per second served from blobs to each request (unlimited when 0)")
+	uploadRate                = flag.Int("throttle-upload", 0, "limit of the bytes per second read from blob uploads, shared by all clients (unlimited when 0)")
+	uploadRatePerConnection   = flag.Int("throttle-upload-per-connection", 0, "limit of the bytes per second read from each blob upload (unlimited when 0)")
+)
+
+// throttleChunk is the most bytes transferred per limiter wait, so
+// throttled transfers progress smoothly rather than in bursts.
+const throttleChunk = 32 << 10
+
+// The shared limiters of -throttle-download and -throttle-upload; nil when
+// unlimited.
+var downloadLimiter, uploadLimiter *rate.Limiter
+
+// newByteLimiter returns a limiter of bytesPerSecond, nil when it is 0.
+func newByteLimiter(bytesPerSecond int) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), min(bytesPerSecond, throttleChunk))
+}
+
+// throttleEnabled reports whether blob transfers are throttled.
+func throttleEnabled() bool {
+	return *downloadRate > 0 || *downloadRatePerConnection > 0 || *uploadRate > 0 || *uploadRatePerConnection > 0
+}
+
+// initThrottle sets up the shared transfer limiters.
+func initThrottle() {
+	downloadLimiter = newByteLimiter(*downloadRate)
+	uploadLimiter = newByteLimiter(*uploadRate)
+}
+
+// throttleMiddleware limits the throughput of blob downloads and uploads.
+func throttleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, _, ok := requestEndpoint(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case endpoint == "blob" && r.Method == http.MethodGet:
+			limiters := activeLimiters(downloadLimiter, newByteLimiter(*downloadRatePerConnection))
+			if len(limiters) > 0 {
+				w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}
+			}
+		case endpoint == "upload":
+			limiters := activeLimiters(uploadLimiter, newByteLimiter(*uploadRatePerConnection))
+			if len(limiters) > 0 {
+				r.Body = &throttledReader{ReadCloser: r.Body, ctx: r.Context(), limiters: limiters}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func activeLimiters(limiters ...*rate.Limiter) []*rate.Limiter {
+	var active []*rate.Limiter
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+
+	return active
+}
+
+// waitAll waits until every limiter allows n bytes.
+func waitAll(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, min(n, l.Burst())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chunkSize is the most bytes transferred between waits on limiters.
+func chunkSize(limiters []*rate.Limiter) int {
+	size := throttleChunk
+	for _, l := range limiters {
+		size = min(size, l.Burst())
+	}
+
+	return size
+}
+
+// throttledWriter writes the response no faster than its limiters allow.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	chunk := chunkSize(w.limiters)
+	for len(p) > 0 {
+		n := min(len(p), chunk)
+		if err := waitAll(w.ctx, w.limiters, n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttledReader reads the request body no faster than its limiters allow.
+type throttledReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	p = p[:min(len(p), chunkSize(r.limiters))]
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := waitAll(r.ctx, r.limiters, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	tlsCertFile     = flag.String("tls-cert", "", "PEM certificate chain to serve HTTPS with, for clients that require TLS registries (plain HTTP when empty)")
+	tlsKeyFile      = flag.String("tls-key", "", "PEM private key of -tls-cert")
+	tlsClientCAFile = flag.String("tls-client-ca", "", "PEM bundle of the CAs that verify client certificates; enables client certificate authentication and requires -tls-cert or -acme-host")
+	tlsClientAuth   = flag.String("tls-client-auth", "require", "with -tls-client-ca, whether clients must present a certificate (require) or may authenticate otherwise (optional)")
+)
+
+var clientCertIdentityFlags stringList
+
+func init() {
+	flag.Var(&clientCertIdentityFlags, "tls-client-identity", "user that client certificates with a matching subject authenticate as, as pattern=user against the subject such as CN=ci,O=Example; the common name is used otherwise (repeatable)")
+}
+
+// serverTLS is the TLS configuration of the server; it is nil when serving
+// plain HTTP.
+var serverTLS *tls.Config
+
+// serverCert is the loaded -tls-cert, replaced when it is reloaded.
+var serverCert atomic.Pointer[tls.Certificate]
+
+// clientCertIdentities map certificate subjects to users, first match wins.
+var clientCertIdentities []clientCertIdentity
+
+type clientCertIdentity struct {
+	pattern string
+	user    string
+}
+
+// initTLS loads the -tls-cert, or sets up ACME for the -acme-host names,
+// and the client CAs. It must run after the other authentication methods are
+// initialized.
+func initTLS() error {
+	switch {
+	case len(acmeHosts) > 0:
+		config, err := initACME()
+		if err != nil {
+			return err
+		}
+		serverTLS = config
+	case *tlsCertFile != "":
+		cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading -tls-cert: %w", err)
+		}
+		serverCert.Store(&cert)
+		serverTLS = &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return serverCert.Load(), nil
+			},
+			MinVersion: tls.VersionTLS12,
+		}
+	case *tlsClientCAFile != "":
+		return errors.New("-tls-client-ca requires -tls-cert or -acme-host")
+	default:
+		return nil
+	}
+
+	if *tlsClientCAFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(*tlsClientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates in %s", *tlsClientCAFile)
+	}
+	serverTLS.ClientCAs = pool
+
+	switch *tlsClientAuth {
+	case "require":
+		serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		if !authEnabled() {
+			return errors.New("-tls-client-auth optional requires another authentication method, such as -htpasswd")
+		}
+		serverTLS.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("invalid -tls-client-auth %q: expected require or optional", *tlsClientAuth)
+	}
+
+	clientCertIdentities, err = parseClientCertIdentities(clientCertIdentityFlags)
+	return err
+}
+
+// parseClientCertIdentities parses pattern=user certificate identities.
+func parseClientCertIdentities(flags stringList) ([]clientCertIdentity, error) {
+	var identities []clientCertIdentity
+	for _, f := range flags {
+		// Subjects contain '=' themselves, so the user follows the last one.
+		i := strings.LastIndex(f, "=")
+		pattern, user := f[:max(i, 0)], f[i+1:]
+		if i < 0 || user == "" {
+			return nil, fmt.Errorf("invalid client certificate identity %q: expected pattern=user", f)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid client certificate identity %q: %w", f, err)
+		}
+		identities = append(identities, clientCertIdentity{pattern: pattern, user: user})
+	}
+
+	return identities, nil
+}
+
+// clientCertAuthEnabled reports whether clients authenticate with
+// certificates.
+func clientCertAuthEnabled() bool {
+	return serverTLS != nil && serverTLS.ClientCAs != nil
+}
+
+// clientCertRequired reports whether every client presents a verified
+// certificate, so no other credentials are needed.
+func clientCertRequired() bool {
+	return clientCertAuthEnabled() && serverTLS.ClientAuth == tls.RequireAndVerifyClientCert
+}
+
+// baseURL returns the URL clients reached the server at, for URLs pointing
+// back at it. Behind a -trusted-proxy, that is the scheme and host in the
+// X-Forwarded-Proto and X-Forwarded-Host headers.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromTrustedProxy(r) {
+		if proto := firstForwarded(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+
+	return scheme + "://" + requestHost(r)
+}
+
+// requestHost returns the host clients sent r to, as forwarded by any
+// -trusted-proxy.
+func requestHost(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if host := firstForwarded(r, "X-Forwarded-Host"); host != "" {
+			return host
+		}
+	}
+
+	return r.Host
+}
+
+// checkClientCert returns the identity of the verified client certificate
+// of a request.
+func checkClientCert(r *http.Request) (*Identity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+
+	reloadMu.RLock()
+	identities := clientCertIdentities
+	reloadMu.RUnlock()
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	for _, m := range identities {
+		if ok, _ := path.Match(m.pattern, subject.String()); ok {
+			return &Identity{Name: m.user}, true
+		}
+	}
+
+	return &Identity{Name: subject.CommonName}, true
+}
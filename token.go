@@ -0,0 +1,415 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+var (
+	tokenRealm   = flag.String("token-realm", "", "URL of the token service clients are sent to by Bearer challenges, defaulting to the built-in /token service; enables bearer token authentication")
+	tokenService = flag.String("token-service", "virtual-helm", "service name of Bearer challenges, required as the audience of tokens")
+	tokenIssuer  = flag.String("token-issuer", "", "issuer required of bearer tokens (any issuer when empty)")
+	tokenKeyFile = flag.String("token-key", "", "PEM file of the public keys or certificates that verify bearer tokens")
+
+	tokenSigningKeyFile = flag.String("token-signing-key", "", "PEM private key the built-in /token service signs tokens with; enables the service and bearer authentication (disabled when empty)")
+	tokenTTL            = flag.Duration("token-ttl", 5*time.Minute, "lifetime of tokens issued by the built-in /token service")
+	tokenAnonymousPull  = flag.Bool("token-anonymous-pull", false, "let the built-in /token service issue pull tokens to clients without credentials")
+)
+
+// tokenKeys verify bearer tokens; bearer authentication is enabled when
+// -token-realm or -token-signing-key is set.
+var tokenKeys jwt.VerificationKeySet
+
+// tokenMethods are the signing algorithms accepted for bearer tokens.
+var tokenMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// initTokenAuth loads the keys verifying bearer tokens, including the key of
+// the built-in token service.
+func initTokenAuth() error {
+	if *tokenRealm == "" && tokenSigner == nil {
+		return nil
+	}
+	if *tokenKeyFile == "" && tokenSigner == nil {
+		return errors.New("-token-realm requires -token-key or -token-signing-key")
+	}
+
+	var keys []jwt.VerificationKey
+	if tokenSigner != nil {
+		keys = append(keys, tokenSigner.Public())
+	}
+	if *tokenKeyFile != "" {
+		fileKeys, err := loadPublicKeys(*tokenKeyFile)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	tokenKeys = jwt.VerificationKeySet{Keys: keys}
+	return nil
+}
+
+// loadPublicKeys reads every public key and certificate in a PEM file.
+func loadPublicKeys(file string) ([]jwt.VerificationKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []jwt.VerificationKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", file, err)
+			}
+			key = cert.PublicKey
+		case "PUBLIC KEY":
+			if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", file, err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, file)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys in %s", file)
+	}
+
+	return keys, nil
+}
+
+// tokenAuthEnabled reports whether registry requests need bearer tokens.
+func tokenAuthEnabled() bool {
+	return tokenKeys.Keys != nil
+}
+
+// ResourceActions is a resource and the actions a token grants on it, as
+// in the access claim of Docker registry tokens.
+type ResourceActions struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// tokenClaims are the claims of registry bearer tokens.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Access []ResourceActions `json:"access,omitempty"`
+}
+
+// checkBearerToken validates the bearer token of a request.
+func checkBearerToken(r *http.Request) (*Identity, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errors.New("no bearer token")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(tokenMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(*tokenService),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithTimeFunc(now),
+	}
+	if *tokenIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(*tokenIssuer))
+	}
+
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return tokenKeys, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{Name: claims.Subject, Access: claims.Access}, nil
+}
+
+// Registry API paths naming a repository, to derive challenge scopes from.
+var repositoryPathRegexp = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|tags|referrers)/`)
+
+// requestScope returns the token scope a request needs, empty when it needs
+// no particular access.
+func requestScope(r *http.Request) string {
+	name, ok := requestRepository(r)
+	if !ok {
+		return ""
+	}
+
+	return "repository:" + name + ":" + strings.Join(requestActions(r), ",")
+}
+
+// Chart endpoints outside the registry API naming a repository: Helm
+// repository downloads, ChartMuseum charts and version range lookups.
+var (
+	helmDownloadPathRegexp = regexp.MustCompile(`^/charts/(.+)/([^/]+)$`)
+	museumChartPathRegexp  = regexp.MustCompile(`^/api/charts/([^/]+)(/[^/]+)?$`)
+)
+
+// requestRepository returns the repository a request names: that of a
+// registry API request, or of a chart endpoint that names one in its path
+// or, for /api/versions, its query. Names are rewritten by -rewrite-name as
+// the handlers rewrite them, so the repository authorized is the one served.
+func requestRepository(r *http.Request) (string, bool) {
+	if match := repositoryPathRegexp.FindStringSubmatch(r.URL.Path); match != nil {
+		return rewriteName(match[1]), true
+	}
+	if match := helmDownloadPathRegexp.FindStringSubmatch(r.URL.Path); match != nil {
+		if file := match[2]; file != "index.yaml" && file != "artifacthub-repo.yml" {
+			return rewriteName(match[1]), true
+		}
+	}
+	if match := museumChartPathRegexp.FindStringSubmatch(r.URL.Path); match != nil {
+		return match[1], true
+	}
+	if r.URL.Path == "/api/versions" {
+		if name := r.URL.Query().Get("name"); name != "" {
+			return rewriteName(name), true
+		}
+	}
+
+	return "", false
+}
+
+// requestActions returns the actions a request performs on its repository.
+func requestActions(r *http.Request) []string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return []string{"pull"}
+	case http.MethodDelete:
+		return []string{"delete"}
+	default:
+		return []string{"pull", "push"}
+	}
+}
+
+// bearerChallenge sends clients to the token service for the scope of r.
+func bearerChallenge(w http.ResponseWriter, r *http.Request, bearerErr string) {
+	challenge := fmt.Sprintf("Bearer realm=%q,service=%q", tokenRealmFor(r), *tokenService)
+	if scope := requestScope(r); scope != "" {
+		challenge += fmt.Sprintf(",scope=%q", scope)
+	}
+	if bearerErr != "" {
+		challenge += fmt.Sprintf(",error=%q", bearerErr)
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "authentication required", nil)
+}
+
+// tokenSigner signs the tokens of the built-in token service; it is nil when
+// the service is disabled.
+var (
+	tokenSigner        crypto.Signer
+	tokenSigningMethod jwt.SigningMethod
+)
+
+// initTokenService loads the -token-signing-key and trusts its tokens. It
+// must run before initTokenAuth.
+func initTokenService() error {
+	if *tokenSigningKeyFile == "" {
+		return nil
+	}
+	if !htpasswdEnabled() && !*tokenAnonymousPull {
+		return errors.New("-token-signing-key requires -htpasswd or -token-anonymous-pull")
+	}
+
+	signer, err := loadPEMSigner(*tokenSigningKeyFile)
+	if err != nil {
+		return err
+	}
+	switch key := signer.(type) {
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			tokenSigningMethod = jwt.SigningMethodES256
+		case 384:
+			tokenSigningMethod = jwt.SigningMethodES384
+		case 521:
+			tokenSigningMethod = jwt.SigningMethodES512
+		default:
+			return fmt.Errorf("unsupported curve %s in %s", key.Curve.Params().Name, *tokenSigningKeyFile)
+		}
+	case *rsa.PrivateKey:
+		tokenSigningMethod = jwt.SigningMethodRS256
+	}
+
+	tokenSigner = signer
+	return nil
+}
+
+// tokenRealmFor returns the realm of Bearer challenges: -token-realm, or the
+// built-in token service as reached by r.
+func tokenRealmFor(r *http.Request) string {
+	if *tokenRealm != "" {
+		return *tokenRealm
+	}
+
+	return baseURL(r) + "/token"
+}
+
+// TokenResponse is the response of the token service.
+type TokenResponse struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+// parseScopes parses token scopes such as repository:name:pull,push.
+func parseScopes(scopes []string) []ResourceActions {
+	var access []ResourceActions
+	for _, field := range scopes {
+		for _, scope := range strings.Fields(field) {
+			i := strings.Index(scope, ":")
+			j := strings.LastIndex(scope, ":")
+			if i < 0 || i == j {
+				continue
+			}
+			access = append(access, ResourceActions{
+				Type:    scope[:i],
+				Name:    scope[i+1 : j],
+				Actions: strings.Split(scope[j+1:], ","),
+			})
+		}
+	}
+
+	return access
+}
+
+// grantAccess returns the part of the requested access id is given.
+// Authenticated users get everything they ask for that the -policy allows;
+// anonymous clients only pull access to repositories.
+func grantAccess(id *Identity, requested []ResourceActions) []ResourceActions {
+	granted := []ResourceActions{}
+	for _, ra := range requested {
+		if id != nil {
+			if actions := policyAllows(id.Name, ra); len(actions) > 0 {
+				granted = append(granted, ResourceActions{Type: ra.Type, Name: ra.Name, Actions: actions})
+			}
+			continue
+		}
+		if ra.Type == "repository" && containsString(ra.Actions, "pull") {
+			granted = append(granted, ResourceActions{Type: ra.Type, Name: ra.Name, Actions: []string{"pull"}})
+		}
+	}
+
+	return granted
+}
+
+// handleToken implements the Docker registry token service, issuing tokens
+// to clients presenting -htpasswd credentials as Basic auth or, for
+// POST, as an OAuth2 password grant.
+func handleToken(w http.ResponseWriter, r *http.Request) {
+	var scopes []string
+	var id *Identity
+	var hasCredentials bool
+
+	switch r.Method {
+	case http.MethodGet:
+		_, _, hasCredentials = r.BasicAuth()
+		if hasCredentials && htpasswdEnabled() {
+			id, _ = checkBasicAuth(r)
+		}
+		scopes = r.URL.Query()["scope"]
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "invalid token request", err.Error())
+			return
+		}
+		if grant := r.PostForm.Get("grant_type"); grant != "password" {
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "unsupported grant type", grant)
+			return
+		}
+		hasCredentials = true
+		r.SetBasicAuth(r.PostForm.Get("username"), r.PostForm.Get("password"))
+		if htpasswdEnabled() {
+			id, _ = checkBasicAuth(r)
+		}
+		scopes = r.PostForm["scope"]
+	}
+
+	if service := r.FormValue("service"); service != "" && service != *tokenService {
+		writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "unknown service", service)
+		return
+	}
+	if id == nil && (hasCredentials || !*tokenAnonymousPull) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "authentication required", nil)
+		return
+	}
+
+	issued := now()
+	subject := ""
+	if id != nil {
+		subject = id.Name
+	}
+	issuer := *tokenIssuer
+	if issuer == "" {
+		issuer = *tokenService
+	}
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{*tokenService},
+			ExpiresAt: jwt.NewNumericDate(issued.Add(*tokenTTL)),
+			NotBefore: jwt.NewNumericDate(issued),
+			IssuedAt:  jwt.NewNumericDate(issued),
+			ID:        newUUID(),
+		},
+		Access: grantAccess(id, parseScopes(scopes)),
+	}
+
+	token, err := jwt.NewWithClaims(tokenSigningMethod, claims).SignedString(tokenSigner)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+
+	logger(r.Context()).Info("issued token", "subject", subject, "access", claims.Access)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:       token,
+		AccessToken: token,
+		ExpiresIn:   int(tokenTTL.Seconds()),
+		IssuedAt:    issued.UTC(),
+	})
+}
+
+// registerTokenRoutes adds the built-in token service to r when
+// -token-signing-key is set.
+func registerTokenRoutes(r *mux.Router) {
+	if tokenSigner == nil {
+		return
+	}
+
+	r.HandleFunc("/token", handleToken).Methods("GET", "POST")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var serveUI = flag.Bool("ui", false, "serve a web UI for browsing the listed charts at /ui/")
+
+//go:embed ui
+var uiFiles embed.FS
+
+// registerUIRoutes adds the web UI to r when -ui is set.
+func registerUIRoutes(r *mux.Router) {
+	if !*serveUI {
+		return
+	}
+
+	static, _ := fs.Sub(uiFiles, "ui")
+	r.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	r.HandleFunc("/ui/api/charts", handleUICharts).Methods("GET")
+	r.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", http.FileServer(http.FS(static)))).Methods("GET", "HEAD")
+}
+
+// handleUICharts lists the known charts for the UI's navigation. The UI
+// itself fetches manifests and blobs through the registry API.
+func handleUICharts(w http.ResponseWriter, r *http.Request) {
+	type chart struct {
+		Name      string `json:"name"`
+		Reference string `json:"reference"`
+	}
+
+	charts := []chart{}
+	for _, ref := range visibleCharts(r.Context()) {
+		charts = append(charts, chart{Name: ref.Name, Reference: ref.Reference})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(charts)
+}
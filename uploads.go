@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	chunkMinLength = flag.Int("upload-chunk-min-length", 0, "minimum size in bytes of the PATCH chunks of blob uploads, advertised as OCI-Chunk-Min-Length; the final chunk sent with PUT may be smaller (none when 0)")
+	chunkMaxLength = flag.Int("upload-chunk-max-length", 0, "maximum size in bytes of the PATCH chunks of blob uploads, advertised as OCI-Chunk-Max-Length (none when 0)")
+	uploadTTL      = flag.Duration("upload-ttl", time.Hour, "how long a blob upload session is kept without requests before it is discarded (forever when 0)")
+	maxUploads     = flag.Int("max-uploads", 1000, "maximum number of blob upload sessions in progress at once; starting more fails with 429 (unlimited when 0)")
+)
+
+// initUploads checks the -upload-chunk flags and starts discarding the
+// upload sessions idle for longer than the -upload-ttl.
+func initUploads() error {
+	if *chunkMinLength < 0 || *chunkMaxLength < 0 || *chunkMaxLength > 0 && *chunkMinLength > *chunkMaxLength {
+		return fmt.Errorf("invalid -upload-chunk-min-length %d or -upload-chunk-max-length %d", *chunkMinLength, *chunkMaxLength)
+	}
+	if *uploadTTL < 0 || *maxUploads < 0 {
+		return fmt.Errorf("invalid -upload-ttl %v or -max-uploads %d", *uploadTTL, *maxUploads)
+	}
+
+	if *uploadTTL > 0 {
+		go func() {
+			for range time.Tick(min(*uploadTTL, time.Minute)) {
+				expireUploads()
+			}
+		}()
+	}
+
+	return nil
+}
+
+// upload is a blob upload session, holding the content received so far.
+type upload struct {
+	name string
+	// used is when the session was last requested, guarded by uploads.
+	used time.Time
+
+	mu   sync.Mutex
+	data bytes.Buffer
+}
+
+// uploads holds the upload sessions in progress by UUID.
+var uploads = struct {
+	sync.Mutex
+	byID map[string]*upload
+}{byID: make(map[string]*upload)}
+
+// uploadSession returns the upload session of a request to name, writing
+// BLOB_UPLOAD_UNKNOWN when there is none.
+func uploadSession(w http.ResponseWriter, r *http.Request, name string) (*upload, string, bool) {
+	id := mux.Vars(r)["uuid"]
+
+	uploads.Lock()
+	u, ok := uploads.byID[id]
+	if ok && u.name == name {
+		u.used = time.Now()
+	}
+	uploads.Unlock()
+	if !ok || u.name != name {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUploadUnknown, "blob upload unknown to registry", id)
+		return nil, "", false
+	}
+
+	return u, id, true
+}
+
+// expireUploads discards the upload sessions idle for longer than the
+// -upload-ttl.
+func expireUploads() {
+	uploads.Lock()
+	defer uploads.Unlock()
+
+	for id, u := range uploads.byID {
+		if time.Since(u.used) > *uploadTTL {
+			delete(uploads.byID, id)
+			slog.Debug("discarded idle upload", "name", u.name, "id", id)
+		}
+	}
+}
+
+// writeUploadHeaders sets the location and received range of an upload.
+func writeUploadHeaders(w http.ResponseWriter, r *http.Request, name string, id string, size int) {
+	w.Header().Set("Location", baseURL(r)+"/v2/"+name+"/blobs/uploads/"+id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.Header().Set("Docker-Upload-UUID", id)
+	if *chunkMinLength > 0 {
+		w.Header().Set("OCI-Chunk-Min-Length", strconv.Itoa(*chunkMinLength))
+	}
+	if *chunkMaxLength > 0 {
+		w.Header().Set("OCI-Chunk-Max-Length", strconv.Itoa(*chunkMaxLength))
+	}
+}
+
+// handleStartUpload starts an upload session, mounts the blob given by
+// mount from the repository in from when it is stored there and the client
+// may pull from it, or stores the body as a monolithic upload when the
+// digest is given. Mounts that cannot be made fall back to an upload
+// session, as the spec allows.
+func handleStartUpload(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+
+	if digest := r.URL.Query().Get("mount"); digest != "" {
+		from := rewriteName(r.URL.Query().Get("from"))
+		if blob, ok, err := store.Get(digest); ok && err == nil && tenantHasBlob(from, digest) && mayPull(r.Context(), from) {
+			if err := claimTenantBlob(name, digest, int64(len(blob))); err != nil {
+				writeError(w, http.StatusForbidden, ErrCodeDenied, err.Error(), name)
+				return
+			}
+			logger(r.Context()).Debug("mounted blob", "name", name, "from", from, "digest", digest)
+			w.Header().Set("Location", baseURL(r)+"/v2/"+name+"/blobs/"+digest)
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+
+	if r.URL.Query().Has("digest") {
+		body, ok := readBody(w, r)
+		if ok {
+			storeUpload(w, r, name, body)
+		}
+		return
+	}
+
+	id := newUUID()
+	uploads.Lock()
+	if *maxUploads > 0 && len(uploads.byID) >= *maxUploads {
+		uploads.Unlock()
+		writeError(w, http.StatusTooManyRequests, ErrCodeTooManyRequests, "too many uploads in progress", *maxUploads)
+		return
+	}
+	uploads.byID[id] = &upload{name: name, used: time.Now()}
+	uploads.Unlock()
+
+	writeUploadHeaders(w, r, name, id, 0)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleGetUpload reports the progress of an upload.
+func handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+	u, id, ok := uploadSession(w, r, name)
+	if !ok {
+		return
+	}
+
+	u.mu.Lock()
+	size := u.data.Len()
+	u.mu.Unlock()
+
+	writeUploadHeaders(w, r, name, id, size)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePatchUpload appends a chunk to an upload. A Content-Range must start
+// where the content received so far ends, and the chunk must be within the
+// advertised chunk lengths.
+func handlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+	u, id, ok := uploadSession(w, r, name)
+	if !ok {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+		start, _, _ := strings.Cut(contentRange, "-")
+		if offset, err := strconv.Atoi(start); err != nil || offset != u.data.Len() {
+			writeUploadHeaders(w, r, name, id, u.data.Len())
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, ErrCodeBlobUploadInvalid, "chunk does not continue the upload", contentRange)
+			return
+		}
+	}
+	if len(body) < *chunkMinLength || *chunkMaxLength > 0 && len(body) > *chunkMaxLength {
+		writeUploadHeaders(w, r, name, id, u.data.Len())
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, ErrCodeBlobUploadInvalid, "chunk length outside the advertised bounds", map[string]int{"length": len(body), "min": *chunkMinLength, "max": *chunkMaxLength})
+		return
+	}
+	if !checkUploadQuota(w, name, u.data.Len()+len(body)) {
+		return
+	}
+	if !checkUploadSize(w, u.data.Len()+len(body)) {
+		return
+	}
+	u.data.Write(body)
+
+	logger(r.Context()).Debug("received upload chunk", "name", name, "size", len(body))
+	writeUploadHeaders(w, r, name, id, u.data.Len())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlePutUpload completes an upload with any final chunk and stores the
+// blob once its content matches the digest.
+func handlePutUpload(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+	u, id, ok := uploadSession(w, r, name)
+	if !ok {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !checkUploadSize(w, u.data.Len()+len(body)) {
+		return
+	}
+	received := u.data.Len()
+	u.data.Write(body)
+
+	if !storeUpload(w, r, name, bytes.Clone(u.data.Bytes())) {
+		u.data.Truncate(received)
+		return
+	}
+
+	uploads.Lock()
+	delete(uploads.byID, id)
+	uploads.Unlock()
+}
+
+// checkUploadSize rejects an upload growing to size bytes beyond the
+// -max-body-size with 413 SIZE_INVALID, since each chunk is only limited on
+// its own.
+func checkUploadSize(w http.ResponseWriter, size int) bool {
+	if int64(size) > *maxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodeSizeInvalid, "upload exceeds the maximum size", *maxBodySize)
+		return false
+	}
+
+	return true
+}
+
+// storeUpload stores the uploaded content of a blob once it matches the
+// digest query parameter and writes 201 Created, or writes the error and
+// returns false.
+func storeUpload(w http.ResponseWriter, r *http.Request, name string, data []byte) bool {
+	digest := r.URL.Query().Get("digest")
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); digest != actual {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, "provided digest did not match uploaded content", map[string]string{"digest": digest, "actual": actual})
+		return false
+	}
+	if !checkUploadQuota(w, name, len(data)) {
+		return false
+	}
+	if err := claimTenantBlob(name, digest, int64(len(data))); err != nil {
+		writeError(w, http.StatusForbidden, ErrCodeDenied, err.Error(), name)
+		return false
+	}
+	if err := store.Put(digest, data); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return false
+	}
+
+	logger(r.Context()).Debug("received upload", "name", name, "digest", digest, "size", len(data))
+	w.Header().Set("Location", baseURL(r)+"/v2/"+name+"/blobs/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+	return true
+}
+
+// handleDeleteUpload cancels an upload.
+func handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return
+	}
+	_, id, ok := uploadSession(w, r, name)
+	if !ok {
+		return
+	}
+
+	uploads.Lock()
+	delete(uploads.byID, id)
+	uploads.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
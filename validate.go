@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/lint"
+	"helm.sh/helm/v3/pkg/lint/support"
+)
+
+var (
+	validateCharts = flag.Bool("validate-charts", false, "load and lint every generated chart with the Helm SDK, failing the request when it is invalid")
+	renderCharts   = flag.Bool("render-charts", false, "render every generated chart with its default values, as helm template does, failing the request when a template does not render")
+)
+
+// chartValidationError lists the problems that make a generated chart
+// invalid.
+type chartValidationError struct {
+	problems []string
+}
+
+func (e *chartValidationError) Error() string {
+	return "generated chart is invalid: " + strings.Join(e.problems, "; ")
+}
+
+// validateChart loads archive the way helm install does and runs helm lint
+// over it, returning a *chartValidationError for charts with lint errors.
+func validateChart(archive []byte) error {
+	chart, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return &chartValidationError{problems: []string{err.Error()}}
+	}
+
+	// The lint rules read the chart from disk.
+	dir, err := os.MkdirTemp("", "virtual-helm-lint-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := chartutil.Expand(dir, bytes.NewReader(archive)); err != nil {
+		return err
+	}
+
+	linter := lint.All(filepath.Join(dir, chart.Name()), nil, "default", false)
+
+	var problems []string
+	for _, m := range linter.Messages {
+		if m.Severity >= support.ErrorSev {
+			problems = append(problems, m.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return &chartValidationError{problems: problems}
+	}
+
+	return nil
+}
+
+// renderChart renders archive with its default values the way helm template
+// does, returning a *chartValidationError when rendering fails.
+func renderChart(archive []byte) error {
+	chart, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return &chartValidationError{problems: []string{err.Error()}}
+	}
+
+	// Library charts cannot be rendered on their own.
+	if chart.Metadata.Type == "library" {
+		return nil
+	}
+
+	values, err := chartutil.ToRenderValues(chart, nil, chartutil.ReleaseOptions{
+		Name:      "virtual-helm",
+		Namespace: "default",
+		Revision:  1,
+		IsInstall: true,
+	}, chartutil.DefaultCapabilities)
+	if err != nil {
+		return &chartValidationError{problems: []string{err.Error()}}
+	}
+
+	if _, err := engine.Render(chart, values); err != nil {
+		return &chartValidationError{problems: []string{err.Error()}}
+	}
+
+	return nil
+}
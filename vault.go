@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	vaultAddr      = flag.String("vault-addr", os.Getenv("VAULT_ADDR"), "address of the Vault server values.yaml strings of the form vault:<path>#<key> are resolved from at pull time, such as vault:secret/data/app#password for a KV v2 secret; without #<key> the whole secret is inserted (disabled when empty)")
+	vaultTokenFile = flag.String("vault-token-file", "", "file holding the Vault token; VAULT_TOKEN is used when empty")
+	vaultNamespace = flag.String("vault-namespace", os.Getenv("VAULT_NAMESPACE"), "Vault Enterprise namespace of the secrets")
+	vaultCacheTTL  = flag.Duration("vault-cache-ttl", 5*time.Minute, "how long secrets without a lease are cached; leased secrets are cached for their lease and renewed when renewable")
+)
+
+const vaultPrefix = "vault:"
+
+// vaultSecret is a cached secret and its lease. The times change as the
+// lease is renewed and are guarded by vault.mu.
+type vaultSecret struct {
+	data      map[string]interface{}
+	leaseID   string
+	renewable bool
+	fetched   time.Time
+	expires   time.Time
+}
+
+// vault reads secrets from -vault-addr, caching them by path.
+var vault = struct {
+	client *http.Client
+	token  string
+
+	mu      sync.Mutex
+	secrets map[string]*vaultSecret
+
+	// renewals collapses the concurrent renewals of a lease by path.
+	renewals singleflight.Group
+}{client: &http.Client{Timeout: 10 * time.Second}, secrets: make(map[string]*vaultSecret)}
+
+// initVault reads the Vault token.
+func initVault() error {
+	if *vaultAddr == "" {
+		return nil
+	}
+
+	vault.token = os.Getenv("VAULT_TOKEN")
+	if *vaultTokenFile != "" {
+		token, err := os.ReadFile(*vaultTokenFile)
+		if err != nil {
+			return err
+		}
+		vault.token = strings.TrimSpace(string(token))
+	}
+	if vault.token == "" {
+		return fmt.Errorf("-vault-addr requires -vault-token-file or VAULT_TOKEN")
+	}
+
+	return nil
+}
+
+// injectVaultValues replaces the vault:<path>#<key> strings of the chart's
+// values.yaml with the secrets they refer to.
+func injectVaultValues(ctx context.Context, out *GeneratedChart) error {
+	if *vaultAddr == "" {
+		return nil
+	}
+	// Only rewrite values.yaml when it refers to Vault, since the rewrite
+	// reformats it.
+	found := false
+	for _, f := range out.Files {
+		if path.Base(f.Name) == "values.yaml" && strings.Count(f.Name, "/") <= 1 && bytes.Contains(f.Data, []byte(vaultPrefix)) {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	var err error
+	var resolve func(v interface{}) interface{}
+	resolve = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				v[key] = resolve(value)
+			}
+		case []interface{}:
+			for i, value := range v {
+				v[i] = resolve(value)
+			}
+		case string:
+			if ref, ok := strings.CutPrefix(v, vaultPrefix); ok && err == nil {
+				var value interface{}
+				value, err = readVaultValue(ctx, ref)
+				return value
+			}
+		}
+		return v
+	}
+	if updateErr := updateValues(out, func(values map[string]interface{}) { resolve(values) }); updateErr != nil {
+		return updateErr
+	}
+
+	return err
+}
+
+// readVaultValue returns the key of the secret at path given as path#key,
+// or the whole secret without a key.
+func readVaultValue(ctx context.Context, ref string) (interface{}, error) {
+	secretPath, key, hasKey := strings.Cut(ref, "#")
+	data, err := readVaultSecret(ctx, strings.Trim(secretPath, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s%s from Vault: %w", vaultPrefix, ref, err)
+	}
+	if !hasKey {
+		return data, nil
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return nil, fmt.Errorf("reading %s%s from Vault: secret has no key %q", vaultPrefix, ref, key)
+	}
+	return value, nil
+}
+
+// readVaultSecret returns the data of the secret at secretPath from the
+// cache, renewing its lease once half of it has passed, or from Vault.
+func readVaultSecret(ctx context.Context, secretPath string) (map[string]interface{}, error) {
+	var fetched, expires time.Time
+	vault.mu.Lock()
+	secret, ok := vault.secrets[secretPath]
+	if ok {
+		fetched, expires = secret.fetched, secret.expires
+	}
+	vault.mu.Unlock()
+
+	t := time.Now()
+	if ok && t.Before(expires) {
+		if secret.renewable && t.Sub(fetched) > expires.Sub(fetched)/2 {
+			_, err, _ := vault.renewals.Do(secretPath, func() (interface{}, error) {
+				return nil, renewVaultLease(ctx, secret, fetched)
+			})
+			if err != nil {
+				slog.Warn("failed to renew Vault lease", "path", secretPath, "error", err)
+			}
+		}
+		return secret.data, nil
+	}
+
+	var resp struct {
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int                    `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := vaultRequest(ctx, http.MethodGet, "/v1/"+secretPath, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	data := resp.Data
+	// KV v2 nests the secret under data, next to its metadata.
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+
+	secret = &vaultSecret{data: data, leaseID: resp.LeaseID, renewable: resp.Renewable, fetched: t, expires: t.Add(*vaultCacheTTL)}
+	if resp.LeaseDuration > 0 {
+		secret.expires = t.Add(time.Duration(resp.LeaseDuration) * time.Second)
+	}
+	vault.mu.Lock()
+	vault.secrets[secretPath] = secret
+	vault.mu.Unlock()
+
+	slog.Debug("read secret from Vault", "path", secretPath, "lease_duration", resp.LeaseDuration)
+	return data, nil
+}
+
+// renewVaultLease extends the lease of secret fetched at fetched, unless it
+// was renewed since.
+func renewVaultLease(ctx context.Context, secret *vaultSecret, fetched time.Time) error {
+	vault.mu.Lock()
+	renewed := !secret.fetched.Equal(fetched)
+	vault.mu.Unlock()
+	if renewed {
+		return nil
+	}
+
+	var resp struct {
+		LeaseDuration int `json:"lease_duration"`
+	}
+	body, _ := json.Marshal(map[string]string{"lease_id": secret.leaseID})
+	if err := vaultRequest(ctx, http.MethodPut, "/v1/sys/leases/renew", body, &resp); err != nil {
+		return err
+	}
+
+	t := time.Now()
+	vault.mu.Lock()
+	secret.fetched = t
+	secret.expires = t.Add(time.Duration(resp.LeaseDuration) * time.Second)
+	vault.mu.Unlock()
+	return nil
+}
+
+// vaultRequest sends a request to the Vault API and decodes the JSON
+// response into out.
+func vaultRequest(ctx context.Context, method string, apiPath string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(*vaultAddr, "/")+apiPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", vault.token)
+	if *vaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", *vaultNamespace)
+	}
+
+	resp, err := vault.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, apiPath, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
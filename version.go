@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = ""
+)
+
+var showVersion = flag.Bool("version", false, "print version information and exit")
+
+// specFeatures lists the OCI distribution spec capabilities of the running
+// instance, so automation can detect what it supports. With -read-only,
+// nothing that changes the registry is.
+func specFeatures() []string {
+	features := []string{"pull", "tag-pagination", "referrers"}
+	if !*readOnly {
+		features = append(features, "push", "chunked-upload", "cross-repository-mount", "manifest-delete", "blob-delete")
+	}
+
+	return features
+}
+
+type VersionInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	GoVersion string   `json:"goVersion"`
+	Features  []string `json:"features"`
+}
+
+func versionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		Features:  specFeatures(),
+	}
+
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range bi.Settings {
+				if s.Key == "vcs.revision" {
+					info.Commit = s.Value
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+func (v VersionInfo) String() string {
+	s := fmt.Sprintf("virtual-helm %s (%s)", v.Version, v.GoVersion)
+	if v.Commit != "" {
+		s += " commit " + v.Commit
+	}
+
+	return s
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	e := json.NewEncoder(w)
+	e.Encode(versionInfo())
+}
+
+// runVersion implements `virtual-helm version [-json]`.
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the version information as JSON")
+	fs.Parse(args)
+
+	if *asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(versionInfo())
+	}
+
+	fmt.Println(versionInfo())
+	return nil
+}
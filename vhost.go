@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var virtualHostFlags stringList
+
+func init() {
+	flag.Var(&virtualHostFlags, "virtual-host", "serve requests for a Host from a separate repository namespace, as host[=namespace]; the namespace defaults to the host name and prefixes every repository name, so -generator and other pattern flags can configure each host (repeatable)")
+}
+
+// virtualHosts maps Host names, without port, to their namespaces.
+var virtualHosts map[string]string
+
+// initVirtualHosts parses the -virtual-host flags.
+func initVirtualHosts() error {
+	if len(virtualHostFlags) == 0 {
+		return nil
+	}
+
+	virtualHosts = make(map[string]string)
+	for _, f := range virtualHostFlags {
+		host, namespace, ok := strings.Cut(f, "=")
+		if !ok {
+			namespace = host
+		}
+		host = strings.ToLower(host)
+		if host == "" || !validName(namespace) {
+			return fmt.Errorf("invalid virtual host %q: expected host[=namespace] with a valid repository namespace", f)
+		}
+		if _, ok := virtualHosts[host]; ok {
+			return fmt.Errorf("invalid virtual host %q: duplicate host", f)
+		}
+
+		virtualHosts[host] = namespace
+	}
+
+	return nil
+}
+
+// virtualHostKey is the context key of the namespace of a virtual host's
+// request.
+type virtualHostKey struct{}
+
+// virtualHostMiddleware moves the registry API requests of each virtual host
+// into its namespace, so /v2/web/... on charts-a.local is served as
+// /v2/charts-a.local/web/..., and so does the from repository of blob mounts.
+// Requests for other hosts are served unchanged but cannot reach the
+// namespaces of virtual hosts.
+func virtualHostMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/v2/")
+		if !ok || rest == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host := requestHost(r)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		query := r.URL.Query()
+		from := query.Get("from")
+		namespace, ok := virtualHosts[strings.ToLower(host)]
+		if !ok {
+			for _, namespace := range virtualHosts {
+				if strings.HasPrefix(rest, namespace+"/") {
+					writeError(w, http.StatusNotFound, ErrCodeNameUnknown, "repository name not known to registry", nil)
+					return
+				}
+				// Mounting from a virtual host's namespace fails, so the
+				// upload falls back to a session.
+				if strings.HasPrefix(from, namespace+"/") {
+					query.Del("from")
+					r = r.Clone(r.Context())
+					r.URL.RawQuery = query.Encode()
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := r.Clone(context.WithValue(r.Context(), virtualHostKey{}, namespace))
+		r2.URL.Path = "/v2/" + namespace + "/" + rest
+		r2.URL.RawPath = ""
+		if from != "" {
+			query.Set("from", namespace+"/"+from)
+			r2.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(&virtualHostWriter{ResponseWriter: w, namespace: namespace}, r2)
+	})
+}
+
+// clientName returns the repository name as the client of a request knows
+// it, without the namespace of its virtual host, for names in responses.
+func clientName(r *http.Request, name string) string {
+	if namespace, ok := r.Context().Value(virtualHostKey{}).(string); ok {
+		return strings.TrimPrefix(name, namespace+"/")
+	}
+
+	return name
+}
+
+// virtualHostWriter removes the namespace from the Location headers of a
+// virtual host's responses.
+type virtualHostWriter struct {
+	http.ResponseWriter
+	namespace string
+}
+
+func (w *virtualHostWriter) WriteHeader(status int) {
+	if location := w.Header().Get("Location"); location != "" {
+		w.Header().Set("Location", strings.Replace(location, "/v2/"+w.namespace+"/", "/v2/", 1))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *virtualHostWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withVirtualHost serves charts-a.local from the namespace a for the test.
+func withVirtualHost(t *testing.T) http.Header {
+	t.Helper()
+
+	virtualHosts = map[string]string{"charts-a.local": "a"}
+	t.Cleanup(func() { virtualHosts = nil })
+
+	return http.Header{"Host": {"charts-a.local"}, "Accept": {manifestMediaType}}
+}
+
+// pullTags pulls the tags of name on the virtual host, so they are listed.
+func pullTags(t *testing.T, srv *httptest.Server, header http.Header, name string, tags ...string) {
+	t.Helper()
+
+	for _, tag := range tags {
+		if resp, body := fetch(t, srv, http.MethodGet, "/v2/"+name+"/manifests/"+tag, header); resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s:%s: %s: %s", name, tag, resp.Status, body)
+		}
+	}
+}
+
+func TestVirtualHostTagsListName(t *testing.T) {
+	header := withVirtualHost(t)
+	srv := newTestServer(t)
+	pullTags(t, srv, header, "web", "1.0.0")
+
+	resp, body := fetch(t, srv, http.MethodGet, "/v2/web/tags/list", header)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET tags: %s: %s", resp.Status, body)
+	}
+	var list TagList
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Name != "web" {
+		t.Errorf("tags list name = %q, want web", list.Name)
+	}
+}
+
+func TestVirtualHostTagsListLink(t *testing.T) {
+	header := withVirtualHost(t)
+	srv := newTestServer(t)
+	pullTags(t, srv, header, "web", "1.0.0", "2.0.0")
+
+	resp, body := fetch(t, srv, http.MethodGet, "/v2/web/tags/list?n=1", header)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET tags: %s: %s", resp.Status, body)
+	}
+	if link, want := resp.Header.Get("Link"), `</v2/web/tags/list?last=1.0.0&n=1>; rel="next"`; link != want {
+		t.Errorf("Link = %s, want %s", link, want)
+	}
+}
+
+func TestVirtualHostMountFrom(t *testing.T) {
+	header := withVirtualHost(t)
+	// The tenants isolate blobs, so that mounting from web only succeeds
+	// when from is taken in the namespace a, where the blob was uploaded.
+	tenants = map[string]*tenant{
+		"a":   {name: "a", blobs: make(map[string]int64)},
+		"web": {name: "web", blobs: make(map[string]int64)},
+	}
+	t.Cleanup(func() { tenants = nil })
+	srv := newTestServer(t)
+
+	blob := []byte("virtual host mount")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	post := func(path string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = header.Get("Host")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post("/v2/web/blobs/uploads/?digest="+digest, blob); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload: %s", resp.Status)
+	}
+	resp := post("/v2/api/blobs/uploads/?mount="+digest+"&from=web", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("mount: %s, want 201 Created", resp.Status)
+	}
+	if location, want := resp.Header.Get("Location"), "/v2/api/blobs/"+digest; location != srv.URL+want && location != "http://charts-a.local"+want {
+		t.Errorf("Location = %s, want %s", location, want)
+	}
+}
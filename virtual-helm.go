@@ -1,22 +1,29 @@
 package main
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
-	"io/ioutil"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
-var blobs = make(map[string][]byte)
-
 type Config struct {
 	MediaType string `json:"mediaType"`
 	Digest    string `json:"digest"`
@@ -24,195 +31,952 @@ type Config struct {
 }
 
 type Layer struct {
-	MediaType string `json:"mediaType"`
-	Digest    string `json:"digest"`
-	Size      int    `json:"size"`
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int               `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
 type Manifest struct {
-	SchemaVersion int     `json:"schemaVersion"`
-	Config        Config  `json:"config"`
-	Layers        []Layer `json:"layers"`
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Config            `json:"config"`
+	Layers        []Layer           `json:"layers"`
+	Subject       *Layer            `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+
+	// content holds the manifest as served and digest its digest, fixed by
+	// encode so signatures over the digest stay valid.
+	content []byte
+	digest  string
 }
 
-type Chart struct {
-	ApiVersion  string `json:"apiVersion"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Type        string `json:"type"`
-	Version     string `json:"version"`
-	AppVersion  string `json:"appVersion"`
-}
+const manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+// Media types of generated chart manifests and their blobs.
+var (
+	chartManifestMediaType = flag.String("chart-manifest-media-type", manifestMediaType, "media type of generated chart manifests")
+	chartConfigMediaType   = flag.String("chart-config-media-type", "application/vnd.cncf.helm.config.v1+json", "media type of the config blob of generated charts")
+	chartContentMediaType  = flag.String("chart-content-media-type", "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "media type of the content layer of generated charts")
+)
 
-func getChart(name string, reference string) ([]byte, error) {
-	chart := Chart{
-		ApiVersion:  "v2",
-		Name:        name,
-		Description: "A dynamically generated chart",
-		Type:        "application",
-		Version:     "0.1.0",
-		AppVersion:  time.Now().Format(time.RFC822),
+// encode serializes the manifest and records its digest.
+func (m *Manifest) encode() error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
 	}
 
-	return json.Marshal(chart)
+	m.content = content
+	m.digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	return nil
 }
 
-func getChartContent(name string, reference string) ([]byte, error) {
-	content := []byte("Hello helm!")
-	tarballBuf := new(bytes.Buffer)
-	tarball := tar.NewWriter(tarballBuf)
-
-	header := &tar.Header{
-		Typeflag: tar.TypeReg,
-		Name:     "README.md",
-		Size:     int64(len(content)),
-		Mode:     0644,
-	}
-	err := tarball.WriteHeader(header)
+// generateChart builds the chart for name:reference, stores its config and
+// content blobs and returns the manifest describing them.
+func generateChart(ctx context.Context, name string, reference string) (*Manifest, error) {
+	release, err := acquireGeneration(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	slog.Debug("generating manifest", "name", name, "reference", reference)
+	start := time.Now()
+	generatedAt := now()
 
-	c := bytes.NewReader(content)
-	fmt.Println(c.Size())
-	_, err = io.Copy(tarball, c)
+	ctx, span := tracer.Start(ctx, "generateChart", trace.WithAttributes(
+		attribute.String("chart.name", name),
+		attribute.String("chart.reference", reference),
+	))
+	defer span.End()
+
+	base, params := parseTagParameters(reference)
+	created := createdTime(name, base, generatedAt)
+	out, err := resolveChart(ctx, ChartRequest{Name: name, Reference: base, Parameters: params}, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("Tar size: ", tarballBuf.Len(), " bytes")
-	fmt.Println(string(tarballBuf.Bytes()))
-
-	tarball.Flush()
-	tarball.Close() // Must write footer before returning the buffer
-
-	gzBuffer := new(bytes.Buffer)
-	gz := gzip.NewWriter(gzBuffer)
-
-	io.Copy(gz, tarballBuf)
-
-	gz.Close()
-	return gzBuffer.Bytes(), nil
-}
-
-func writeManifest(w http.ResponseWriter, name string, reference string) error {
-	fmt.Println("Manifest")
-
-	chart, err := getChart(name, reference)
-	if err != nil {
-		return err
+	if err := decryptSOPSFiles(ctx, out); err != nil {
+		return nil, err
+	}
+	if err := injectValues(out, params); err != nil {
+		return nil, err
 	}
+	if err := injectImageValues(out, name); err != nil {
+		return nil, err
+	}
+	if err := injectVaultValues(ctx, out); err != nil {
+		return nil, err
+	}
+	addChartIcon(out, name)
 
-	chartTar, err := getChartContent(name, reference)
+	chart, err := json.Marshal(out.Chart)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	h := sha256.New()
 	h.Write(chart)
 	digest := fmt.Sprintf("sha256:%x", h.Sum(nil))
-	blobs[digest] = chart
+	if err := store.Put(digest, chart); err != nil {
+		return nil, err
+	}
+
+	// The content layer is streamed through the hash straight into the
+	// store rather than being buffered and copied between stages.
+	bw, err := store.Writer()
+	if err != nil {
+		return nil, err
+	}
 
 	h.Reset()
-	h.Write(chartTar)
+	counter := &countingWriter{}
+	writers := []io.Writer{bw, h, counter}
+
+	// Validation needs the whole archive, so it is only buffered when
+	// enabled.
+	var archive *bytes.Buffer
+	if *validateCharts || *renderCharts {
+		archive = getBuffer()
+		defer putBuffer(archive)
+		writers = append(writers, archive)
+	}
+
+	err = writeChartArchive(ctx, io.MultiWriter(writers...), out.Files, !*uncompressedLayers)
+	if err != nil {
+		bw.Cancel()
+		return nil, err
+	}
+
+	if *validateCharts {
+		if err := validateChart(archive.Bytes()); err != nil {
+			bw.Cancel()
+			return nil, err
+		}
+	}
+
+	if *renderCharts {
+		if err := renderChart(archive.Bytes()); err != nil {
+			bw.Cancel()
+			return nil, err
+		}
+	}
 
 	chartContentDigest := fmt.Sprintf("sha256:%x", h.Sum(nil))
-	blobs[chartContentDigest] = chartTar
+	if err := bw.Commit(chartContentDigest); err != nil {
+		return nil, err
+	}
+	slog.Debug("generated chart content", "name", name, "reference", reference, "size", counter.n)
+
+	layers := []Layer{{
+		MediaType: contentMediaType(),
+		Digest:    chartContentDigest,
+		Size:      int(counter.n),
+	}}
+
+	if signingKey != nil {
+		prov, err := provenanceFile(out.Chart, chartContentDigest[len("sha256:"):], created)
+		if err != nil {
+			return nil, fmt.Errorf("signing provenance: %w", err)
+		}
+
+		provDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(prov))
+		if err := store.Put(provDigest, prov); err != nil {
+			return nil, err
+		}
+		layers = append(layers, Layer{MediaType: provenanceMediaType, Digest: provDigest, Size: len(prov)})
+	}
+	generationDuration.WithLabelValues(generatorNameFor(name)).Observe(time.Since(start).Seconds())
 
-	manifest := Manifest{
+	manifest := &Manifest{
 		SchemaVersion: 2,
+		MediaType:     *chartManifestMediaType,
 		Config: Config{
-			MediaType: "application/vnd.cncf.helm.config.v1+json",
+			MediaType: *chartConfigMediaType,
 			Digest:    digest,
 			Size:      len(chart),
 		},
-		Layers: []Layer{{
-			MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
-			Digest:    chartContentDigest,
-			Size:      len(chartTar),
-		}},
+		Layers:      layers,
+		Annotations: annotationsFor(out.Chart, created),
+	}
+	if err := manifest.encode(); err != nil {
+		return nil, err
 	}
 
-	w.Header().Add("content-type", "application/vnd.oci.image.manifest.v1+json")
-	w.Header().Add("Docker-Content-Digest", manifest.Config.Digest)
-	w.WriteHeader(http.StatusOK)
+	if cosignSigner != nil {
+		if err := cosignSign(name, manifest, created); err != nil {
+			return nil, fmt.Errorf("signing manifest: %w", err)
+		}
+	}
+
+	if notationSigner != nil {
+		if err := notationSign(name, manifest, created); err != nil {
+			return nil, fmt.Errorf("signing manifest with notation: %w", err)
+		}
+	}
+
+	if *sbomFormat != "" {
+		if err := attachSBOM(name, manifest, out, created); err != nil {
+			return nil, fmt.Errorf("generating SBOM: %w", err)
+		}
+	}
+
+	if *slsaProvenance {
+		req := ChartRequest{Name: name, Reference: base, Parameters: params}
+		if err := attachProvenance(name, manifest, req, reference, generatedAt, now()); err != nil {
+			return nil, fmt.Errorf("attesting provenance: %w", err)
+		}
+	}
+
+	return manifest, nil
+}
+
+// resolveManifest returns the manifest name:reference refers to: a pushed
+// manifest, a stored referrer such as a signature, an imported manifest, the
+// target of the -latest-policy, the highest known version within a
+// -tag-ranges range, a generated chart or one pulled from the upstream
+// registry.
+func resolveManifest(ctx context.Context, name string, reference string) (*Manifest, error) {
+	if manifest, ok := pushedManifest(name, reference); ok {
+		return manifest, nil
+	}
+	if manifest, ok := referrerManifest(name, reference); ok {
+		return manifest, nil
+	}
+	if manifest, ok := importedManifest(name, reference); ok {
+		return manifest, nil
+	}
+	if reference == "latest" {
+		manifest, ok, err := latestManifest(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return manifest, nil
+		}
+	}
+	if constraint, ok := rangeTag(reference); ok && *tagRanges {
+		versions := matchingVersions(ctx, name, constraint)
+		if len(versions) == 0 {
+			return nil, errReferenceNotFound
+		}
+		return resolveManifest(ctx, name, versions[0])
+	}
+	if *conformance {
+		return nil, errReferenceNotFound
+	}
 
-	e := json.NewEncoder(w)
-	e.Encode(manifest)
+	return generateOrProxy(ctx, name, reference)
+}
+
+// writeManifestHeaders sets the headers describing manifest.
+func writeManifestHeaders(w http.ResponseWriter, manifest *Manifest) {
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = manifestMediaType
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", manifest.digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifest.content)))
+}
+
+func writeManifest(w http.ResponseWriter, r *http.Request, name string, reference string) error {
+	manifest, err := resolveManifest(r.Context(), name, reference)
+	if err != nil {
+		return err
+	}
+	if err := claimTenantBlobs(name, manifest); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(reference, "sha256:") {
+		recordTag(name, reference, manifest.digest)
+	}
+	setCacheHeaders(w, manifestCachePolicy(reference))
+	if notModified(w, r, manifest.digest) {
+		return nil
+	}
+	writeManifestHeaders(w, manifest)
+	w.WriteHeader(http.StatusOK)
+	w.Write(manifest.content)
 
 	return nil
 }
 
-func writeBlob(w http.ResponseWriter, name string, digest string) error {
-	blob, ok := blobs[digest]
+func writeBlob(w http.ResponseWriter, r *http.Request, name string, digest string) error {
+	blob, ok, err := store.Get(digest)
+	if err != nil {
+		return err
+	}
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, "blob unknown to registry", nil)
+		return nil
+	}
+	setCacheHeaders(w, blobCache)
+	if notModified(w, r, digest) {
 		return nil
 	}
 
-	fmt.Printf("blob size: %d\n", len(blob))
-	w.Write(blob)
+	slog.Debug("serving blob", "name", name, "digest", digest, "size", len(blob))
+	writeBlobHeaders(w, digest, len(blob))
+	// ServeContent answers Range requests with 206 Partial Content, so that
+	// clients can resume interrupted downloads.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
 	return nil
 }
 
-func handleV2(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("%s %s\n", r.Method, r.URL)
-	if r.Method == "POST" {
-		w.Header().Add("Location", "http://localhost:5000/v2/blobs/put/"+uuid.NewString())
-		w.WriteHeader(http.StatusAccepted)
+// writeBlobHeaders sets the headers describing a blob of size bytes. The
+// Content-Type defaults to application/octet-stream, as blobs are served by
+// digest without their media type, unless the caller set it already.
+func writeBlobHeaders(w http.ResponseWriter, digest string, size int) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
+func newRouter() http.Handler {
+	r := mux.NewRouter()
+	r.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+
+	r.Use(routeSpanMiddleware, metricsMiddleware, digestMiddleware)
+
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	r.HandleFunc("/healthz", handleHealthz).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", handleReadyz).Methods("GET", "HEAD")
+	r.HandleFunc("/version", handleVersion).Methods("GET")
+	r.HandleFunc("/v2/", handleBase).Methods("GET", "HEAD")
+	r.HandleFunc("/v2/{name:.+}/manifests/{reference}", handleGetManifest).Methods("GET")
+	r.HandleFunc("/v2/{name:.+}/manifests/{reference}", handleHeadManifest).Methods("HEAD")
+	r.HandleFunc("/v2/{name:.+}/manifests/{reference}", handlePutManifest).Methods("PUT")
+	r.HandleFunc("/v2/{name:.+}/manifests/{reference}", handleDeleteManifest).Methods("DELETE")
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/", handleStartUpload).Methods("POST")
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/{uuid}", handleGetUpload).Methods("GET")
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/{uuid}", handlePatchUpload).Methods("PATCH")
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/{uuid}", handlePutUpload).Methods("PUT")
+	r.HandleFunc("/v2/{name:.+}/blobs/uploads/{uuid}", handleDeleteUpload).Methods("DELETE")
+	r.HandleFunc("/v2/{name:.+}/blobs/{digest}", handleGetBlob).Methods("GET")
+	r.HandleFunc("/v2/{name:.+}/blobs/{digest}", handleHead).Methods("HEAD")
+	r.HandleFunc("/v2/{name:.+}/blobs/{digest}", handleDeleteBlob).Methods("DELETE")
+	r.HandleFunc("/v2/{name:.+}/tags/list", handleTagsList).Methods("GET")
+	r.HandleFunc("/v2/{name:.+}/referrers/{digest}", handleGetReferrers).Methods("GET")
+	registerHelmRepoRoutes(r)
+	registerChartMuseumRoutes(r)
+	registerSearchRoutes(r)
+	registerVersionRoutes(r)
+	registerChartIconRoutes(r)
+	registerAdminRoutes(r)
+	registerTokenRoutes(r)
+	registerUIRoutes(r)
+
+	return r
+}
+
+// newHandler wraps the registry router in the middlewares enabled by the
+// command line flags.
+func newHandler() (http.Handler, error) {
+	middlewares := []Middleware{tracingMiddleware, requestIDMiddleware, recoveryMiddleware, loggingMiddleware}
+
+	accessLog, err := openAccessLog()
+	if err != nil {
+		return nil, err
+	}
+	if accessLog != nil {
+		middlewares = append(middlewares, accessLog)
+	}
+
+	if *grpcAddr != "" {
+		middlewares = append(middlewares, requestEventsMiddleware)
+	}
+
+	recording, err := openRecording()
+	if err != nil {
+		return nil, err
+	}
+	if recording != nil {
+		middlewares = append(middlewares, recording)
+	}
+
+	if scenario != nil {
+		middlewares = append(middlewares, scenarioMiddleware)
+	}
+
+	if len(latencyRules) > 0 {
+		middlewares = append(middlewares, latencyMiddleware)
+	}
+
+	if throttleEnabled() {
+		middlewares = append(middlewares, throttleMiddleware)
+	}
+
+	if faultsEnabled() {
+		middlewares = append(middlewares, faultMiddleware)
+	}
+
+	if *chaosPercent > 0 {
+		middlewares = append(middlewares, chaosMiddleware)
+	}
+
+	if registryHeadersEnabled() {
+		middlewares = append(middlewares, registryHeadersMiddleware)
+	}
+
+	if activeProfile != nil {
+		middlewares = append(middlewares, registryProfileMiddleware)
+	}
+
+	if len(corsOrigins) > 0 {
+		middlewares = append(middlewares, corsMiddleware)
+	}
+
+	if allowedClients != nil || deniedClients != nil {
+		middlewares = append(middlewares, ipFilterMiddleware)
+	}
+
+	if *readOnly {
+		middlewares = append(middlewares, readOnlyMiddleware)
+	}
+
+	// Virtual hosts move requests into their namespace before they are
+	// authorized, so policies name the repositories actually served.
+	if virtualHosts != nil {
+		middlewares = append(middlewares, virtualHostMiddleware)
+	}
+
+	// Failed authentications are limited by address ahead of
+	// authentication, and the other requests after it, so authenticated
+	// clients are limited by user rather than by address.
+	if *rateLimit > 0 && authEnabled() {
+		middlewares = append(middlewares, newRateLimiter(*rateLimit, *rateBurst).authFailureMiddleware)
+	}
+
+	if authEnabled() {
+		middlewares = append(middlewares, authMiddleware, identityQuotaMiddleware)
+	}
+
+	if *rateLimit > 0 {
+		middlewares = append(middlewares, newRateLimiter(*rateLimit, *rateBurst).middleware)
+	}
+
+	if auditLog != nil || notifyEndpoints != nil {
+		middlewares = append(middlewares, operationsMiddleware)
+	}
+
+	if adminToken != nil {
+		middlewares = append(middlewares, countersMiddleware)
+	}
+
+	replay, err := loadReplay()
+	if err != nil {
+		return nil, err
+	}
+	if replay != nil {
+		return chain(replay, middlewares...), nil
+	}
+
+	return chain(newRouter(), middlewares...), nil
+}
+
+// repoName extracts, rewrites and validates the repository name of a
+// request, writing a NAME_INVALID error and returning false when it does not
+// conform.
+func repoName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := rewriteName(mux.Vars(r)["name"])
+	if !validName(name) {
+		writeError(w, http.StatusBadRequest, ErrCodeNameInvalid, "invalid repository name", name)
+		return "", false
+	}
+
+	return name, true
+}
+
+// servedRepoName is repoName for endpoints that serve generated content,
+// additionally rejecting names outside the allowlist with NAME_UNKNOWN.
+func servedRepoName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, ok := repoName(w, r)
+	if !ok {
+		return "", false
+	}
+
+	if !servableName(name) {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, "repository name not known to registry", name)
+		return "", false
+	}
+
+	return name, true
+}
+
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func handleBase(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleHead(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
+		return
+	}
+	digest := mux.Vars(r)["digest"]
+	if !tenantHasBlob(name, digest) {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, "blob unknown to registry", nil)
+		return
+	}
+	if err := ensureProxiedBlob(r.Context(), name, digest); err != nil {
+		writeError(w, http.StatusBadGateway, ErrCodeUnknown, "failed to pull blob from upstream", err.Error())
+		return
+	}
+	blob, ok, err := store.Get(digest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, "blob unknown to registry", nil)
 		return
 	}
 
-	if r.Method == "PUT" {
-		digest := r.URL.Query().Get("digest")
-		w.Header().Add("location", "https://localhost:5000/v2/blobs/"+digest)
-		w.Header().Add("Docker-Content-Digest", digest)
-		w.WriteHeader(http.StatusCreated)
-		body, _ := ioutil.ReadAll(r.Body)
-		fmt.Printf("\n\n%s\n\n", body)
+	setCacheHeaders(w, blobCache)
+	if notModified(w, r, digest) {
 		return
 	}
+	writeBlobHeaders(w, digest, len(blob))
+	w.WriteHeader(http.StatusOK)
+}
 
-	if r.Method == "HEAD" {
-		w.WriteHeader(http.StatusOK)
+func handleHeadManifest(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
 		return
 	}
 
-	tokens := strings.Split(r.URL.Path, "/")
-	if len(tokens) < 3 {
-		w.WriteHeader(http.StatusBadRequest)
+	reference := mux.Vars(r)["reference"]
+	manifest, err := resolveManifest(r.Context(), name, reference)
+	if err == nil {
+		err = claimTenantBlobs(name, manifest)
+	}
+	if err != nil {
+		writeGenerationError(w, name, err)
 		return
 	}
 
-	refOrDigest := tokens[len(tokens)-1]
-	objType := tokens[len(tokens)-2]
-	name := strings.Join(tokens[2:len(tokens)-2], "/")
+	if !strings.HasPrefix(reference, "sha256:") {
+		recordTag(name, reference, manifest.digest)
+	}
+	setCacheHeaders(w, manifestCachePolicy(reference))
+	if notModified(w, r, manifest.digest) {
+		return
+	}
+	writeManifestHeaders(w, manifest)
+	w.WriteHeader(http.StatusOK)
+}
 
-	var err error
-	switch objType {
-	case "manifests":
-		fmt.Printf("Accept header: %s\n", r.Header.Get("Accept"))
-		err = writeManifest(w, name, refOrDigest)
-	case "blobs":
-		err = writeBlob(w, name, refOrDigest)
-	default:
-		err = fmt.Errorf("unknown request type: %s", objType)
+func handleGetManifest(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
+		return
 	}
 
+	logger(r.Context()).Debug("manifest requested", "name", name, "accept", r.Header.Get("Accept"))
+	reference := mux.Vars(r)["reference"]
+	err := writeManifest(w, r, name, reference)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(err.Error()))
+		writeGenerationError(w, name, err)
+		return
 	}
+	countChartPull(name, reference)
+}
 
+// statusClientClosedRequest is the nginx status for requests the client
+// cancelled before the response was written.
+const statusClientClosedRequest = 499
+
+// writeGenerationError maps an error from chart generation to a registry
+// error response.
+func writeGenerationError(w http.ResponseWriter, name string, err error) {
+	var invalid *chartValidationError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The client went away; the status is only seen in logs and
+		// metrics.
+		w.WriteHeader(statusClientClosedRequest)
+	case errors.Is(err, errGenerationBusy):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, err.Error(), nil)
+	case errors.Is(err, errChartNotFound):
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, "repository name not known to registry", name)
+	case errors.Is(err, errReferenceNotFound):
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, "manifest unknown", nil)
+	case errors.Is(err, errTenantQuotaExceeded):
+		writeError(w, http.StatusForbidden, ErrCodeDenied, err.Error(), name)
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, "generated chart is invalid", invalid.problems)
+	default:
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+	}
+}
+
+func handleGetBlob(w http.ResponseWriter, r *http.Request) {
+	name, ok := servedRepoName(w, r)
+	if !ok {
+		return
+	}
+
+	digest := mux.Vars(r)["digest"]
+	if !tenantHasBlob(name, digest) {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, "blob unknown to registry", nil)
+		return
+	}
+	if err := ensureProxiedBlob(r.Context(), name, digest); err != nil {
+		writeError(w, http.StatusBadGateway, ErrCodeUnknown, "failed to pull blob from upstream", err.Error())
+		return
+	}
+
+	err := writeBlob(w, r, name, digest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnknown, err.Error(), nil)
+	}
 }
 
 func main() {
-	http.HandleFunc("/v2/", handleV2)
+	flag.Usage = usage
+
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			if err := command(args[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	// Without a command, the arguments are the flags of serve.
+	if err := runServe(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// initGeneration sets up everything generating charts needs: the cache and
+// store, plugins, upstreams, generator routes and the chart decorations and
+// signatures. It is shared by serve and the commands generating charts
+// offline.
+func initGeneration() error {
+	if err := initDeterministic(); err != nil {
+		return err
+	}
+
+	initGenerationLimit()
+	initCache()
+
+	if err := initCompression(); err != nil {
+		return err
+	}
+
+	if err := initLargeCharts(); err != nil {
+		return err
+	}
+
+	if err := initStore(); err != nil {
+		return err
+	}
+
+	if err := loadGoPlugins(); err != nil {
+		return err
+	}
+
+	if err := loadWasmPlugins(context.Background()); err != nil {
+		return err
+	}
+
+	if err := initNameRewrites(); err != nil {
+		return err
+	}
+
+	if err := initUpstreams(); err != nil {
+		return err
+	}
+
+	if err := initGeneratorRoutes(); err != nil {
+		return err
+	}
+
+	if err := initDependencyRules(); err != nil {
+		return err
+	}
+
+	if err := initCRDDirs(); err != nil {
+		return err
+	}
+
+	if err := initChartMetadata(); err != nil {
+		return err
+	}
+
+	if err := initChartIcons(); err != nil {
+		return err
+	}
+
+	if err := initChartTests(); err != nil {
+		return err
+	}
+
+	if err := initAppImages(); err != nil {
+		return err
+	}
+
+	if err := initLatestPolicy(); err != nil {
+		return err
+	}
+
+	if err := initVault(); err != nil {
+		return err
+	}
+
+	if err := initSOPS(); err != nil {
+		return err
+	}
+
+	if err := initManifestAnnotations(); err != nil {
+		return err
+	}
+
+	if err := initProvenance(); err != nil {
+		return err
+	}
+
+	if err := initCosign(); err != nil {
+		return err
+	}
+
+	if err := initNotation(); err != nil {
+		return err
+	}
+
+	if err := initSBOM(); err != nil {
+		return err
+	}
+
+	return generators["git"].(*gitGenerator).start()
+}
+
+// usage lists the commands and the flags of serve.
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: virtual-helm [serve] [flags]\n       virtual-helm <command> [flags] [arguments]\n\ncommands: %s\n\nRun virtual-helm <command> -h for the flags of a command. The flags of serve are:\n", strings.Join(names, ", "))
+	flag.PrintDefaults()
+}
+
+// runServe implements `virtual-helm serve [flags]`, running the registry
+// until it is interrupted. Errors are returned rather than exiting, so the
+// deferred shutdowns, such as flushing traces, run.
+func runServe(args []string) error {
+	flag.CommandLine.Parse(args)
+
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+
+	if *showVersion {
+		fmt.Println(versionInfo())
+		return nil
+	}
+
+	if err := setupLogging(os.Stderr); err != nil {
+		return fmt.Errorf("setting up logging: %w", err)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := initGeneration(); err != nil {
+		return err
+	}
+
+	if err := initRegistryProfile(); err != nil {
+		return err
+	}
+
+	if err := initRegistryHeaders(); err != nil {
+		return err
+	}
+
+	if err := initLatency(); err != nil {
+		return err
+	}
+
+	initThrottle()
+
+	if err := initChaos(); err != nil {
+		return err
+	}
+
+	if err := initFaults(); err != nil {
+		return err
+	}
+
+	if err := initScenario(); err != nil {
+		return err
+	}
+
+	if err := initUploads(); err != nil {
+		return err
+	}
+
+	if err := initImmutableTags(); err != nil {
+		return err
+	}
+
+	if err := initQuotas(); err != nil {
+		return err
+	}
+
+	if err := initIdentityQuotas(); err != nil {
+		return err
+	}
+
+	initSoftDelete()
+
+	if err := initCacheControl(); err != nil {
+		return err
+	}
+
+	if err := initIPFilter(); err != nil {
+		return err
+	}
+
+	if err := initVirtualHosts(); err != nil {
+		return err
+	}
+
+	if err := initCatalog(); err != nil {
+		return err
+	}
+
+	if err := initPregeneration(); err != nil {
+		return err
+	}
+
+	if err := initArtifactHub(); err != nil {
+		return err
+	}
 
-	fmt.Println("Starting server")
-	err := http.ListenAndServe(":5000", nil)
+	if err := initAuditLog(); err != nil {
+		return err
+	}
+
+	if err := initNotifications(); err != nil {
+		return err
+	}
+
+	if err := initAuth(); err != nil {
+		return err
+	}
+
+	if err := initTokenService(); err != nil {
+		return err
+	}
+
+	if err := initTokenAuth(); err != nil {
+		return err
+	}
+
+	if err := initOIDC(); err != nil {
+		return err
+	}
+
+	if err := initTLS(); err != nil {
+		return err
+	}
+
+	if err := initPolicy(); err != nil {
+		return err
+	}
+
+	if err := initTenants(); err != nil {
+		return err
+	}
+
+	if err := initAdmin(); err != nil {
+		return err
+	}
+
+	if err := initMirror(); err != nil {
+		return err
+	}
+
+	if err := initRestore(); err != nil {
+		return err
+	}
+
+	if err := initImports(); err != nil {
+		return err
+	}
+
+	if err := watchChartDir(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := startVirtualChartWatch(ctx); err != nil {
+		return err
+	}
+
+	if err := startConfigMapWatch(ctx); err != nil {
+		return err
+	}
+
+	if err := startReloader(ctx); err != nil {
+		return err
+	}
+
+	handler, err := newHandler()
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	startDebugServer()
+	startACMEChallengeServer()
+
+	if err := startControlServer(); err != nil {
+		return err
+	}
+
+	listeners, err := listenAll()
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	srv := newServer(handler)
+
+	if err := serve(ctx, srv, listeners); err != nil {
+		return fmt.Errorf("server stopped: %w", err)
 	}
 
+	slog.Info("server stopped")
+	if scenarioFailed() {
+		return errors.New("scenario failed")
+	}
+	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+var initTestGeneration = sync.OnceValue(initGeneration)
+
+// newTestServer serves the registry with the flags as set, after the
+// generation is initialized as serve does.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	if err := initTestGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	handler, err := newHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// fetch sends a request to srv, to the Host in header if any, and returns
+// the response with its body read.
+func fetch(t *testing.T, srv *httptest.Server, method string, path string, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Host = header.Get("Host")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestPullGeneratedManifestByDigest(t *testing.T) {
+	srv := newTestServer(t)
+	accept := http.Header{"Accept": {manifestMediaType}}
+
+	resp, byTag := fetch(t, srv, http.MethodGet, "/v2/digest-test/manifests/1.0.0", accept)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET by tag: %s: %s", resp.Status, byTag)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+
+	resp, _ = fetch(t, srv, http.MethodHead, "/v2/digest-test/manifests/"+digest, accept)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Docker-Content-Digest") != digest {
+		t.Errorf("HEAD by digest: %s, digest %s, want %s", resp.Status, resp.Header.Get("Docker-Content-Digest"), digest)
+	}
+
+	resp, byDigest := fetch(t, srv, http.MethodGet, "/v2/digest-test/manifests/"+digest, accept)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET by digest: %s: %s", resp.Status, byDigest)
+	}
+	if string(byDigest) != string(byTag) {
+		t.Errorf("manifest by digest differs from the manifest by tag:\n%s\n%s", byDigest, byTag)
+	}
+
+	unknown := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	if resp, body := fetch(t, srv, http.MethodGet, "/v2/digest-test/manifests/"+unknown, accept); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET by unknown digest: %s: %s", resp.Status, body)
+	}
+}
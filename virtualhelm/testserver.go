@@ -0,0 +1,361 @@
+// Package virtualhelm runs an in-process OCI registry serving Helm charts,
+// for Go tests of code that pulls charts:
+//
+//	srv := virtualhelm.NewTestServer(t, virtualhelm.Options{
+//		Charts: []chartgen.Result{{Chart: chartgen.Chart{Name: "app", Version: "1.0.0"}}},
+//	})
+//	// helm pull oci://<srv.Listener.Addr()>/app --version 1.0.0 --plain-http
+//	if n := srv.Pulls("app", "1.0.0"); n != 1 {
+//		t.Errorf("pulled app %d times, want 1", n)
+//	}
+//
+// The server of the virtual-helm command is in package main and cannot be
+// imported, so this package serves the pull side of the distribution API
+// itself, covering what tests of Helm clients need. It shares with the
+// command how chart archives are written and how concurrent generations of
+// a chart are collapsed into one, and differs from it in that:
+//
+//   - only GET and HEAD of /v2/, manifests, blobs and tags are served, with
+//     no pushes, referrers, catalog, ChartMuseum API or index.yaml;
+//   - there is no authentication, policy, rate limiting or fault injection;
+//   - tags are not parsed for parameters, so Request.Parameters is nil, and
+//     generated charts are kept for the life of the server;
+//   - manifests carry no annotations and Accept headers are ignored.
+package virtualhelm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cdelautour/virutal-helm/chartgen"
+	"github.com/cdelautour/virutal-helm/internal/chartarchive"
+	"github.com/cdelautour/virutal-helm/internal/shared"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	configMediaType   = "application/vnd.cncf.helm.config.v1+json"
+	contentMediaType  = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// Options configures a test server.
+type Options struct {
+	// Charts are served as <repository>/<chart name>:<chart version>. Files
+	// are archive paths such as app/values.yaml; a Chart.yaml is added from
+	// Chart when there is none.
+	Charts []chartgen.Result
+	// Repository is the prefix of the repositories charts are served under,
+	// such as charts for charts/app.
+	Repository string
+	// Generator, when set, generates the charts that are not preloaded on
+	// demand, with the repository name and tag as the request.
+	Generator chartgen.Generator
+	// TLS serves HTTPS with the httptest certificate; use the server's
+	// Client or Certificate to trust it.
+	TLS bool
+}
+
+// TestServer is a running test registry. It counts the manifests and blobs
+// pulled from it, so tests can assert a chart was pulled exactly once or no
+// layer was fetched twice.
+type TestServer struct {
+	*httptest.Server
+	registry *registry
+}
+
+// NewTestServer starts a registry serving the charts of opts and closes it
+// when the test finishes. It fails the test if a chart cannot be packaged.
+func NewTestServer(t testing.TB, opts Options) *TestServer {
+	t.Helper()
+
+	r := &registry{
+		generator: opts.Generator,
+		manifests: make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+		pulls:     make(map[string]int),
+		fetches:   make(map[string]int),
+	}
+	for _, chart := range opts.Charts {
+		name := strings.Trim(opts.Repository+"/"+chart.Chart.Name, "/")
+		if _, err := r.add(name, chart.Chart.Version, &chart); err != nil {
+			t.Fatalf("virtualhelm: packaging %s:%s: %v", name, chart.Chart.Version, err)
+		}
+	}
+
+	var srv *httptest.Server
+	if opts.TLS {
+		srv = httptest.NewTLSServer(r)
+	} else {
+		srv = httptest.NewServer(r)
+	}
+	t.Cleanup(srv.Close)
+
+	return &TestServer{Server: srv, registry: r}
+}
+
+// Pulls returns how many times the manifest of name was pulled by
+// reference, a tag or digest. HEAD requests are not counted.
+func (s *TestServer) Pulls(name string, reference string) int {
+	s.registry.mu.Lock()
+	defer s.registry.mu.Unlock()
+	return s.registry.pulls[name+":"+reference]
+}
+
+// BlobFetches returns how many times the blob with digest was fetched, from
+// any repository.
+func (s *TestServer) BlobFetches(digest string) int {
+	s.registry.mu.Lock()
+	defer s.registry.mu.Unlock()
+	return s.registry.fetches[digest]
+}
+
+// ResetCounts sets the pull and fetch counts back to zero.
+func (s *TestServer) ResetCounts() {
+	s.registry.mu.Lock()
+	defer s.registry.mu.Unlock()
+	s.registry.pulls = make(map[string]int)
+	s.registry.fetches = make(map[string]int)
+}
+
+// registry serves charts packaged as OCI artifacts.
+type registry struct {
+	generator chartgen.Generator
+	// generations collapses concurrent generations of a chart, as the
+	// command does.
+	generations shared.Group
+
+	mu sync.Mutex
+	// manifests holds the manifests by name:tag and name@digest.
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	// pulls counts manifest GETs by name:reference and fetches blob GETs
+	// by digest.
+	pulls   map[string]int
+	fetches map[string]int
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// add packages chart and serves it as name:tag, returning its manifest.
+func (r *registry) add(name string, tag string, chart *chartgen.Result) ([]byte, error) {
+	config, err := json.Marshal(chart.Chart)
+	if err != nil {
+		return nil, err
+	}
+	content, err := archive(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     manifestMediaType,
+		"config":        descriptor(configMediaType, config),
+		"layers":        []interface{}{descriptor(contentMediaType, content)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digestOf(config)] = config
+	r.blobs[digestOf(content)] = content
+	r.manifests[name+":"+tag] = manifest
+	r.manifests[name+"@"+digestOf(manifest)] = manifest
+	return manifest, nil
+}
+
+func descriptor(mediaType string, data []byte) map[string]interface{} {
+	return map[string]interface{}{"mediaType": mediaType, "digest": digestOf(data), "size": len(data)}
+}
+
+// archive packages the files of a chart, adding its Chart.yaml when missing.
+func archive(chart *chartgen.Result) ([]byte, error) {
+	files := chart.Files
+	hasChartYaml := false
+	for _, f := range files {
+		if f.Name == chart.Chart.Name+"/Chart.yaml" {
+			hasChartYaml = true
+		}
+	}
+	if !hasChartYaml {
+		meta := chart.Chart
+		if meta.ApiVersion == "" {
+			meta.ApiVersion = "v2"
+		}
+		data, err := yaml.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		files = append([]chartgen.File{{Name: chart.Chart.Name + "/Chart.yaml", Data: data}}, files...)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := chartarchive.WriteTar(tar.NewWriter(gz), files); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+var (
+	manifestPath = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+	blobPath     = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-f0-9]{64})$`)
+	tagsPath     = regexp.MustCompile(`^/v2/(.+)/tags/list$`)
+)
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "the test server is read-only")
+		return
+	}
+
+	switch p := req.URL.Path; {
+	case p == "/v2/" || p == "/v2":
+		w.WriteHeader(http.StatusOK)
+	case manifestPath.MatchString(p):
+		m := manifestPath.FindStringSubmatch(p)
+		r.serveManifest(w, req, m[1], m[2])
+	case blobPath.MatchString(p):
+		m := blobPath.FindStringSubmatch(p)
+		r.mu.Lock()
+		blob, ok := r.blobs[m[2]]
+		if ok && req.Method == http.MethodGet {
+			r.fetches[m[2]]++
+		}
+		r.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+			return
+		}
+		serve(w, req, "application/octet-stream", blob)
+	case tagsPath.MatchString(p):
+		name := tagsPath.FindStringSubmatch(p)[1]
+		tags := []string{}
+		r.mu.Lock()
+		for key := range r.manifests {
+			if tag, ok := strings.CutPrefix(key, name+":"); ok {
+				tags = append(tags, tag)
+			}
+		}
+		r.mu.Unlock()
+		sort.Strings(tags)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": tags})
+	default:
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+	}
+}
+
+func (r *registry) serveManifest(w http.ResponseWriter, req *http.Request, name string, reference string) {
+	key := name + ":" + reference
+	if strings.HasPrefix(reference, "sha256:") {
+		key = name + "@" + reference
+	}
+
+	r.mu.Lock()
+	manifest, ok := r.manifests[key]
+	r.mu.Unlock()
+
+	if !ok && r.generator != nil && !strings.HasPrefix(reference, "sha256:") {
+		var err error
+		if manifest, err = r.generate(req.Context(), name, reference); err != nil {
+			var packaging *packagingError
+			if errors.As(err, &packaging) {
+				writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			} else {
+				writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", err.Error())
+			}
+			return
+		}
+		ok = true
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown to registry")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		r.mu.Lock()
+		r.pulls[name+":"+reference]++
+		r.mu.Unlock()
+	}
+	serve(w, req, manifestMediaType, manifest)
+}
+
+// packagingError is returned by generate when a generated chart could not be
+// packaged, as opposed to not being generated.
+type packagingError struct {
+	err error
+}
+
+func (e *packagingError) Error() string {
+	return e.err.Error()
+}
+
+// generate generates name:reference and serves it, sharing the generation
+// with any concurrent pull of the same chart.
+func (r *registry) generate(ctx context.Context, name string, reference string) ([]byte, error) {
+	v, err := r.generations.Do(ctx, name+":"+reference, func(ctx context.Context) (interface{}, error) {
+		r.mu.Lock()
+		manifest, ok := r.manifests[name+":"+reference]
+		r.mu.Unlock()
+		if ok {
+			return manifest, nil
+		}
+
+		chart, err := r.generator.Generate(ctx, chartgen.Request{Name: name, Reference: reference})
+		if err != nil {
+			return nil, err
+		}
+		if manifest, err = r.add(name, reference, chart); err != nil {
+			return nil, &packagingError{err}
+		}
+		return manifest, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]byte), nil
+}
+
+func serve(w http.ResponseWriter, req *http.Request, mediaType string, data []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", digestOf(data))
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
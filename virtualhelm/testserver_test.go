@@ -0,0 +1,194 @@
+package virtualhelm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cdelautour/virutal-helm/chartgen"
+)
+
+type manifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+}
+
+// get fetches path from srv, failing the test unless it answers 200.
+func get(t *testing.T, srv *TestServer, method string, path string) []byte {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: %s: %s", method, path, resp.Status, body)
+	}
+	return body
+}
+
+// pull fetches the manifest and blobs of name:reference as helm pull does,
+// returning the manifest and the files of the chart archive.
+func pull(t *testing.T, srv *TestServer, name string, reference string) (manifest, map[string]string) {
+	t.Helper()
+
+	var m manifest
+	if err := json.Unmarshal(get(t, srv, http.MethodGet, "/v2/"+name+"/manifests/"+reference), &m); err != nil {
+		t.Fatal(err)
+	}
+	get(t, srv, http.MethodGet, "/v2/"+name+"/blobs/"+m.Config.Digest)
+	if len(m.Layers) != 1 {
+		t.Fatalf("manifest has %d layers, want 1", len(m.Layers))
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(get(t, srv, http.MethodGet, "/v2/"+name+"/blobs/"+m.Layers[0].Digest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(data)
+	}
+
+	return m, files
+}
+
+func TestPullCounts(t *testing.T) {
+	srv := NewTestServer(t, Options{
+		Repository: "charts",
+		Charts: []chartgen.Result{{
+			Chart: chartgen.Chart{Name: "app", Version: "1.0.0"},
+			Files: []chartgen.File{{Name: "app/values.yaml", Data: []byte("replicas: 1\n")}},
+		}},
+	})
+
+	m, files := pull(t, srv, "charts/app", "1.0.0")
+	if files["app/values.yaml"] != "replicas: 1\n" {
+		t.Errorf("values.yaml is %q", files["app/values.yaml"])
+	}
+	if _, ok := files["app/Chart.yaml"]; !ok {
+		t.Error("archive has no Chart.yaml")
+	}
+
+	get(t, srv, http.MethodHead, "/v2/charts/app/manifests/1.0.0")
+	get(t, srv, http.MethodGet, "/v2/charts/app/blobs/"+m.Layers[0].Digest)
+
+	if n := srv.Pulls("charts/app", "1.0.0"); n != 1 {
+		t.Errorf("Pulls = %d, want 1", n)
+	}
+	if n := srv.BlobFetches(m.Config.Digest); n != 1 {
+		t.Errorf("BlobFetches of the config = %d, want 1", n)
+	}
+	if n := srv.BlobFetches(m.Layers[0].Digest); n != 2 {
+		t.Errorf("BlobFetches of the layer = %d, want 2", n)
+	}
+
+	srv.ResetCounts()
+	if n := srv.Pulls("charts/app", "1.0.0"); n != 0 {
+		t.Errorf("Pulls after ResetCounts = %d, want 0", n)
+	}
+}
+
+type generatorFunc func(ctx context.Context, req chartgen.Request) (*chartgen.Result, error)
+
+func (f generatorFunc) Generate(ctx context.Context, req chartgen.Request) (*chartgen.Result, error) {
+	return f(ctx, req)
+}
+
+func TestGenerator(t *testing.T) {
+	generations := 0
+	srv := NewTestServer(t, Options{
+		Generator: generatorFunc(func(ctx context.Context, req chartgen.Request) (*chartgen.Result, error) {
+			generations++
+			return &chartgen.Result{Chart: chartgen.Chart{Name: req.Name, Version: req.Reference}}, nil
+		}),
+	})
+
+	pull(t, srv, "gen", "2.0.0")
+	pull(t, srv, "gen", "2.0.0")
+
+	if generations != 1 {
+		t.Errorf("generated %d times, want 1", generations)
+	}
+	if n := srv.Pulls("gen", "2.0.0"); n != 2 {
+		t.Errorf("Pulls = %d, want 2", n)
+	}
+}
+
+func TestConcurrentPullsGenerateOnce(t *testing.T) {
+	var generations atomic.Int32
+	release := make(chan struct{})
+	srv := NewTestServer(t, Options{
+		Generator: generatorFunc(func(ctx context.Context, req chartgen.Request) (*chartgen.Result, error) {
+			generations.Add(1)
+			<-release
+			return &chartgen.Result{Chart: chartgen.Chart{Name: req.Name, Version: req.Reference}}, nil
+		}),
+	})
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 2)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := srv.Client().Get(srv.URL + "/v2/gen/manifests/3.0.0")
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	// Let both pulls reach the generation before it completes.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("pull statuses %v, want 200", statuses)
+		}
+	}
+
+	if n := generations.Load(); n != 1 {
+		t.Errorf("generated %d times, want 1", n)
+	}
+	if n := srv.Pulls("gen", "3.0.0"); n != 2 {
+		t.Errorf("Pulls = %d, want 2", n)
+	}
+}
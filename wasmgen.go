@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+var wasmPluginDir = flag.String("wasm-plugin-dir", "", "directory of WASM chart generator plugins; each <name>.wasm is registered as generator <name>")
+
+// The host API imported by plugins from the "virtual_helm" module:
+//
+//	emit_file(name_ptr, name_len, data_ptr, data_len u32)
+//	set_chart(json_ptr, json_len u32)   // Chart.yaml field overrides
+//	log(msg_ptr, msg_len u32)
+//
+// Plugins export alloc(size u32) u32, used by the host to pass the request,
+// and generate(req_ptr, req_len u32) u32 which returns 0 on success. The
+// request is JSON: {"name": ..., "reference": ..., "parameters": {...}}.
+const wasmHostModule = "virtual_helm"
+
+// wasmGenerator instantiates a fresh, sandboxed module instance for every
+// generation so plugins cannot keep state between requests.
+type wasmGenerator struct {
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+type wasmRequest struct {
+	Name       string            `json:"name"`
+	Reference  string            `json:"reference"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// wasmOutput collects what a plugin emits during a single generation.
+type wasmOutput struct {
+	plugin string
+	chart  map[string]interface{}
+	files  []ChartFile
+	err    error
+}
+
+type wasmOutputKey struct{}
+
+// loadWasmPlugins compiles every plugin in -wasm-plugin-dir and registers it
+// as a generator.
+func loadWasmPlugins(ctx context.Context) error {
+	if *wasmPluginDir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(*wasmPluginDir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	_, err = runtime.NewHostModuleBuilder(wasmHostModule).
+		NewFunctionBuilder().WithFunc(wasmEmitFile).Export("emit_file").
+		NewFunctionBuilder().WithFunc(wasmSetChart).Export("set_chart").
+		NewFunctionBuilder().WithFunc(wasmLog).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), ".wasm")
+		if _, ok := generators[name]; ok {
+			return fmt.Errorf("wasm plugin %s: generator %q already exists", p, name)
+		}
+
+		code, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		compiled, err := runtime.CompileModule(ctx, code)
+		if err != nil {
+			return fmt.Errorf("compiling wasm plugin %s: %w", p, err)
+		}
+
+		generators[name] = &wasmGenerator{name: name, runtime: runtime, compiled: compiled}
+		slog.Info("loaded wasm generator plugin", "generator", name, "path", p)
+	}
+
+	return nil
+}
+
+func (g *wasmGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	out := &wasmOutput{plugin: g.name}
+	ctx = context.WithValue(ctx, wasmOutputKey{}, out)
+
+	mod, err := g.runtime.InstantiateModule(ctx, g.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stderr).
+		WithStderr(os.Stderr))
+	if err != nil {
+		return nil, fmt.Errorf("instantiating wasm plugin %s: %w", g.name, err)
+	}
+	defer mod.Close(ctx)
+
+	alloc := mod.ExportedFunction("alloc")
+	generate := mod.ExportedFunction("generate")
+	if alloc == nil || generate == nil {
+		return nil, fmt.Errorf("wasm plugin %s must export alloc and generate", g.name)
+	}
+
+	input, err := json.Marshal(wasmRequest{Name: req.Name, Reference: req.Reference, Parameters: req.Parameters})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := alloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("wasm plugin %s: alloc returned an out of range pointer", g.name)
+	}
+
+	res, err = generate.Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("wasm plugin %s: %w", g.name, err)
+	}
+	if out.err != nil {
+		return nil, out.err
+	}
+	if status := uint32(res[0]); status != 0 {
+		return nil, fmt.Errorf("wasm plugin %s: generate failed with status %d", g.name, status)
+	}
+
+	chart, err := chartWithOverrides(req, out.chart)
+	if err != nil {
+		return nil, fmt.Errorf("wasm plugin %s: %w", g.name, err)
+	}
+
+	files, err := withChartYaml(out.files, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+func wasmOutputFrom(ctx context.Context) *wasmOutput {
+	out, _ := ctx.Value(wasmOutputKey{}).(*wasmOutput)
+	return out
+}
+
+// readWasmString copies a byte range out of the module's memory.
+func readWasmString(m api.Module, ptr, size uint32) ([]byte, error) {
+	b, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		return nil, errors.New("out of range memory access")
+	}
+
+	return append([]byte(nil), b...), nil
+}
+
+func wasmEmitFile(ctx context.Context, m api.Module, namePtr, nameLen, dataPtr, dataLen uint32) {
+	out := wasmOutputFrom(ctx)
+	if out == nil || out.err != nil {
+		return
+	}
+
+	name, err := readWasmString(m, namePtr, nameLen)
+	if err != nil {
+		out.err = fmt.Errorf("wasm plugin %s: emit_file: %w", out.plugin, err)
+		return
+	}
+
+	data, err := readWasmString(m, dataPtr, dataLen)
+	if err != nil {
+		out.err = fmt.Errorf("wasm plugin %s: emit_file: %w", out.plugin, err)
+		return
+	}
+
+	out.files = append(out.files, ChartFile{Name: string(name), Data: data})
+}
+
+func wasmSetChart(ctx context.Context, m api.Module, ptr, size uint32) {
+	out := wasmOutputFrom(ctx)
+	if out == nil || out.err != nil {
+		return
+	}
+
+	data, err := readWasmString(m, ptr, size)
+	if err == nil {
+		err = json.Unmarshal(data, &out.chart)
+	}
+	if err != nil {
+		out.err = fmt.Errorf("wasm plugin %s: set_chart: %w", out.plugin, err)
+	}
+}
+
+func wasmLog(ctx context.Context, m api.Module, ptr, size uint32) {
+	out := wasmOutputFrom(ctx)
+	msg, err := readWasmString(m, ptr, size)
+	if out == nil || err != nil {
+		return
+	}
+
+	slog.Debug("wasm plugin log", "generator", out.plugin, "message", string(msg))
+}
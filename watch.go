@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchChartDir watches -chart-dir recursively and evicts cached charts whose
+// source files change, so edits are served on the next pull.
+func watchChartDir() error {
+	if *chartDir == "" {
+		return nil
+	}
+
+	root, err := filepath.Abs(*chartDir)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := addWatchTree(watcher, root); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Has(fsnotify.Create) {
+					// New directories must be watched explicitly.
+					addWatchTree(watcher, event.Name)
+				}
+
+				invalidateChartPath(root, event.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("chart directory watch error", "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// addWatchTree adds dir and all directories below it to the watcher.
+func addWatchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		return watcher.Add(p)
+	})
+}
+
+// invalidateChartPath evicts every repository the changed file could belong
+// to: any name that is a path prefix of the file relative to root.
+func invalidateChartPath(root string, changed string) {
+	rel, err := filepath.Rel(root, changed)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i <= len(segments); i++ {
+		name := strings.Join(segments[:i], "/")
+		if n := generated.RemoveRepository(name); n > 0 {
+			slog.Info("chart source changed, evicted cached charts", "name", name, "entries", n)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var (
+	webhookURL     = flag.String("webhook-url", "", "endpoint the \"webhook\" generator POSTs chart requests to")
+	webhookTimeout = flag.Duration("webhook-timeout", 10*time.Second, "timeout for a single webhook generator call")
+	webhookRetries = flag.Int("webhook-retries", 2, "how many times a failed webhook generator call is retried")
+)
+
+func init() {
+	generators["webhook"] = webhookGenerator{client: &http.Client{}}
+}
+
+// webhookGenerator delegates generation to an HTTP service. It POSTs
+//
+//	{"name": ..., "reference": ..., "parameters": {...}}
+//
+// to -webhook-url and expects {"chart": {...}, "files": {"path": "content"}}
+// back, the same shape a Starlark generate function returns. A 404 response
+// means the service has no such chart. Results are cached by the generation
+// cache like any other generator's.
+type webhookGenerator struct {
+	client *http.Client
+}
+
+type webhookRequest struct {
+	Name       string            `json:"name"`
+	Reference  string            `json:"reference"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+type webhookResponse struct {
+	Chart map[string]interface{} `json:"chart"`
+	Files map[string]string      `json:"files"`
+}
+
+func (g webhookGenerator) Generate(ctx context.Context, req ChartRequest) (*GeneratedChart, error) {
+	if *webhookURL == "" {
+		return nil, errors.New("the webhook generator requires -webhook-url")
+	}
+
+	body, err := json.Marshal(webhookRequest{Name: req.Name, Reference: req.Reference, Parameters: req.Parameters})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *webhookResponse
+	for attempt := 0; ; attempt++ {
+		resp, err = g.call(ctx, body)
+		if err == nil || errors.Is(err, errChartNotFound) || attempt >= *webhookRetries {
+			break
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt+1) * 500 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chart, err := chartWithOverrides(req, resp.Chart)
+	if err != nil {
+		return nil, fmt.Errorf("webhook returned %w", err)
+	}
+
+	files := make([]ChartFile, 0, len(resp.Files))
+	for name, content := range resp.Files {
+		files = append(files, ChartFile{Name: name, Data: []byte(content)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	files, err = withChartYaml(files, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSourceChart(files, req)
+}
+
+func (g webhookGenerator) call(ctx context.Context, body []byte) (*webhookResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, *webhookTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, *webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("content-type", "application/json")
+
+	httpResp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode == http.StatusNotFound {
+		return nil, errChartNotFound
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return nil, fmt.Errorf("webhook returned %s: %s", httpResp.Status, msg)
+	}
+
+	var resp webhookResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decoding webhook response: %w", err)
+	}
+
+	return &resp, nil
+}